@@ -73,12 +73,16 @@ class LoginController extends GetxController {
           final session = Get.isRegistered<SessionController>()
               ? Get.find<SessionController>()
               : Get.put(SessionController(), permanent: true);
+          final profile = user['profile'] as Map<String, dynamic>? ?? {};
+          final profileName = profile['display_name'] as String? ?? '';
           await session.signIn(
             newUser: SessionUser(
-              displayName:
-                  (user['full_name'] as String?) ??
-                  (user['email'] as String? ?? ""),
+              displayName: profileName.isNotEmpty
+                  ? profileName
+                  : (user['full_name'] as String?) ??
+                        (user['email'] as String? ?? ""),
               email: (user['email'] as String?) ?? '',
+              avatarUrl: profile['avatar_url'] as String? ?? '',
               plan: 'Premium',
             ),
             token: token,
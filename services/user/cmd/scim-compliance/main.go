@@ -0,0 +1,694 @@
+// Command scim-compliance runs a SCIM 2.0 compliance suite against a running
+// user service: discovery, user and group CRUD, filters, pagination,
+// projection, PATCH and error responses, as identity providers exercise
+// them. It creates its own users and groups, removes them afterwards and
+// exits non-zero when a check fails.
+//
+//	go run ./cmd/scim-compliance -url http://localhost:8081/scim/v2 -token $SCIM_TOKEN
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	schemaUser    = "urn:ietf:params:scim:schemas:core:2.0:User"
+	schemaGroup   = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	schemaPatchOp = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	schemaError   = "urn:ietf:params:scim:api:messages:2.0:Error"
+	schemaList    = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+)
+
+type obj = map[string]interface{}
+
+type response struct {
+	status int
+	header http.Header
+	body   obj
+}
+
+type client struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+func (c *client) do(method, path string, body interface{}, header ...string) (*response, error) {
+	var rd io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		rd = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, c.base+path, rd)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/scim+json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	res := &response{status: resp.StatusCode, header: resp.Header}
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(raw)) > 0 {
+		if err := json.Unmarshal(raw, &res.body); err != nil {
+			return nil, fmt.Errorf("%s %s: invalid JSON body: %v", method, path, err)
+		}
+		if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/scim+json") {
+			return nil, fmt.Errorf("%s %s: Content-Type %q, want application/scim+json", method, path, ct)
+		}
+	}
+	return res, nil
+}
+
+// expect fails unless the response has the status; error responses must be
+// SCIM error messages
+func expect(res *response, status int) error {
+	if res.status != status {
+		return fmt.Errorf("status %d, want %d: %v", res.status, status, res.body)
+	}
+	if status >= 400 && res.body != nil && !hasString(res.body["schemas"], schemaError) {
+		return fmt.Errorf("error response without the %s schema: %v", schemaError, res.body)
+	}
+	return nil
+}
+
+func hasString(v interface{}, s string) bool {
+	list, _ := v.([]interface{})
+	for _, el := range list {
+		if el == s {
+			return true
+		}
+	}
+	return false
+}
+
+// values returns the "value" of each element of a multi-valued attribute
+func values(v interface{}) []string {
+	var out []string
+	list, _ := v.([]interface{})
+	for _, el := range list {
+		if m, ok := el.(obj); ok {
+			out = append(out, fmt.Sprint(m["value"]))
+		}
+	}
+	return out
+}
+
+func contains(list []string, s string) bool {
+	for _, el := range list {
+		if el == s {
+			return true
+		}
+	}
+	return false
+}
+
+func patch(ops ...obj) obj {
+	list := make([]interface{}, len(ops))
+	for i, op := range ops {
+		list[i] = op
+	}
+	return obj{"schemas": []string{schemaPatchOp}, "Operations": list}
+}
+
+func newUser(userName, externalID, given, family string) obj {
+	return obj{
+		"schemas":    []string{schemaUser},
+		"userName":   userName,
+		"externalId": externalID,
+		"name":       obj{"givenName": given, "familyName": family},
+		"active":     true,
+		"password":   "Compliance-Passw0rd!",
+	}
+}
+
+type suite struct {
+	c       *client
+	run     string
+	users   []string
+	groups  []string
+	failed  int
+	skipped bool
+}
+
+// check runs one named check, skipping the rest once a check the others
+// depend on failed
+func (s *suite) check(name string, fn func() error) bool {
+	if s.skipped {
+		fmt.Printf("SKIP %s\n", name)
+		return false
+	}
+	if err := fn(); err != nil {
+		fmt.Printf("FAIL %s: %v\n", name, err)
+		s.failed++
+		return false
+	}
+	fmt.Printf("ok   %s\n", name)
+	return true
+}
+
+// must is check for checks whose resources the rest of the suite needs
+func (s *suite) must(name string, fn func() error) {
+	if !s.check(name, fn) {
+		s.skipped = true
+	}
+}
+
+func (s *suite) list(path, filter string, extra ...string) (*response, error) {
+	q := "?filter=" + url.QueryEscape(filter)
+	for _, e := range extra {
+		q += "&" + e
+	}
+	res, err := s.c.do("GET", path+q, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := expect(res, http.StatusOK); err != nil {
+		return nil, err
+	}
+	if !hasString(res.body["schemas"], schemaList) {
+		return nil, fmt.Errorf("list response without the %s schema", schemaList)
+	}
+	return res, nil
+}
+
+// total checks the totalResults of a list response
+func total(res *response, want int) error {
+	if n, _ := res.body["totalResults"].(float64); int(n) != want {
+		return fmt.Errorf("totalResults %v, want %d", res.body["totalResults"], want)
+	}
+	return nil
+}
+
+// checks runs the suite in order
+func (s *suite) checks() {
+	c := s.c
+	prefix := "scim-" + s.run
+	email1, email2 := prefix+"-a@example.com", prefix+"-b@example.com"
+	var id1, id2, gid string
+
+	s.check("requests without the token are rejected", func() error {
+		anon := &client{base: c.base, http: c.http}
+		res, err := anon.do("GET", "/Users", nil)
+		if err != nil {
+			return err
+		}
+		return expect(res, http.StatusUnauthorized)
+	})
+	s.check("ServiceProviderConfig advertises patch and filter", func() error {
+		res, err := c.do("GET", "/ServiceProviderConfig", nil)
+		if err != nil {
+			return err
+		}
+		if err := expect(res, http.StatusOK); err != nil {
+			return err
+		}
+		for _, f := range []string{"patch", "filter"} {
+			if m, _ := res.body[f].(obj); m["supported"] != true {
+				return fmt.Errorf("%s not supported", f)
+			}
+		}
+		return nil
+	})
+	s.check("ResourceTypes lists User and Group", func() error {
+		res, err := c.do("GET", "/ResourceTypes", nil)
+		if err != nil {
+			return err
+		}
+		if err := expect(res, http.StatusOK); err != nil {
+			return err
+		}
+		if err := total(res, 2); err != nil {
+			return err
+		}
+		res, err = c.do("GET", "/ResourceTypes/User", nil)
+		if err != nil {
+			return err
+		}
+		return expect(res, http.StatusOK)
+	})
+	s.check("Schemas describes the User schema", func() error {
+		res, err := c.do("GET", "/Schemas/"+schemaUser, nil)
+		if err != nil {
+			return err
+		}
+		if err := expect(res, http.StatusOK); err != nil {
+			return err
+		}
+		if _, ok := res.body["attributes"].([]interface{}); !ok {
+			return fmt.Errorf("no attributes")
+		}
+		return nil
+	})
+
+	s.must("create user", func() error {
+		res, err := c.do("POST", "/Users", newUser(email1, prefix+"-ext-a", "Ada", "Lovelace"))
+		if err != nil {
+			return err
+		}
+		if err := expect(res, http.StatusCreated); err != nil {
+			return err
+		}
+		id1, _ = res.body["id"].(string)
+		if id1 == "" {
+			return fmt.Errorf("no id")
+		}
+		s.users = append(s.users, id1)
+		if res.header.Get("Location") == "" {
+			return fmt.Errorf("no Location header")
+		}
+		if res.body["userName"] != email1 || res.body["active"] != true {
+			return fmt.Errorf("unexpected resource %v", res.body)
+		}
+		if _, ok := res.body["password"]; ok {
+			return fmt.Errorf("password returned")
+		}
+		return nil
+	})
+	s.must("create second user", func() error {
+		res, err := c.do("POST", "/Users", newUser(email2, prefix+"-ext-b", "Grace", "Hopper"))
+		if err != nil {
+			return err
+		}
+		if err := expect(res, http.StatusCreated); err != nil {
+			return err
+		}
+		id2, _ = res.body["id"].(string)
+		s.users = append(s.users, id2)
+		return nil
+	})
+	s.check("duplicate userName is a uniqueness conflict", func() error {
+		res, err := c.do("POST", "/Users", newUser(email1, "", "Ada", "Lovelace"))
+		if err != nil {
+			return err
+		}
+		if err := expect(res, http.StatusConflict); err != nil {
+			return err
+		}
+		if res.body["scimType"] != "uniqueness" {
+			return fmt.Errorf("scimType %v, want uniqueness", res.body["scimType"])
+		}
+		return nil
+	})
+	s.check("user without userName is rejected", func() error {
+		res, err := c.do("POST", "/Users", obj{"schemas": []string{schemaUser}})
+		if err != nil {
+			return err
+		}
+		return expect(res, http.StatusBadRequest)
+	})
+	s.check("get user", func() error {
+		res, err := c.do("GET", "/Users/"+id1, nil)
+		if err != nil {
+			return err
+		}
+		if err := expect(res, http.StatusOK); err != nil {
+			return err
+		}
+		if res.header.Get("ETag") == "" {
+			return fmt.Errorf("no ETag header")
+		}
+		name, _ := res.body["name"].(obj)
+		if name["givenName"] != "Ada" || name["familyName"] != "Lovelace" {
+			return fmt.Errorf("name %v", name)
+		}
+		if !contains(values(res.body["emails"]), email1) {
+			return fmt.Errorf("emails %v", res.body["emails"])
+		}
+		return nil
+	})
+
+	filters := []struct {
+		filter string
+		want   int
+	}{
+		{`userName eq "` + email1 + `"`, 1},
+		{`USERNAME Eq "` + email1 + `"`, 1},
+		{`externalId eq "` + prefix + `-ext-b"`, 1},
+		{`userName sw "` + prefix + `"`, 2},
+		{`userName co "` + s.run + `-a@"`, 1},
+		{`userName sw "` + prefix + `" and name.familyName eq "Hopper"`, 1},
+		{`userName eq "` + email1 + `" or userName eq "` + email2 + `"`, 2},
+		{`userName sw "` + prefix + `" and not (active eq false)`, 2},
+		{`emails[type eq "work" and value ew "` + s.run + `-b@example.com"]`, 1},
+		{`userName sw "` + prefix + `" and externalId pr`, 2},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName eq "` + email2 + `"`, 1},
+	}
+	for _, f := range filters {
+		s.check("filter Users "+f.filter, func() error {
+			res, err := s.list("/Users", f.filter)
+			if err != nil {
+				return err
+			}
+			return total(res, f.want)
+		})
+	}
+	s.check("invalid filter is rejected", func() error {
+		res, err := c.do("GET", "/Users?filter="+url.QueryEscape(`userName zz "x"`), nil)
+		if err != nil {
+			return err
+		}
+		if err := expect(res, http.StatusBadRequest); err != nil {
+			return err
+		}
+		if res.body["scimType"] != "invalidFilter" {
+			return fmt.Errorf("scimType %v, want invalidFilter", res.body["scimType"])
+		}
+		return nil
+	})
+	s.check("pagination with startIndex and count", func() error {
+		res, err := s.list("/Users", `userName sw "`+prefix+`"`, "startIndex=2", "count=1")
+		if err != nil {
+			return err
+		}
+		if err := total(res, 2); err != nil {
+			return err
+		}
+		if res.body["startIndex"] != float64(2) || res.body["itemsPerPage"] != float64(1) {
+			return fmt.Errorf("startIndex %v, itemsPerPage %v", res.body["startIndex"], res.body["itemsPerPage"])
+		}
+		page, _ := res.body["Resources"].([]interface{})
+		if len(page) != 1 || page[0].(obj)["id"] != id2 {
+			return fmt.Errorf("page %v, want user %s", page, id2)
+		}
+		return nil
+	})
+	s.check("count=0 returns only the total", func() error {
+		res, err := s.list("/Users", `userName sw "`+prefix+`"`, "count=0")
+		if err != nil {
+			return err
+		}
+		if page, _ := res.body["Resources"].([]interface{}); len(page) != 0 {
+			return fmt.Errorf("%d resources", len(page))
+		}
+		return total(res, 2)
+	})
+	s.check("attributes and excludedAttributes", func() error {
+		res, err := c.do("GET", "/Users/"+id1+"?attributes=userName", nil)
+		if err != nil {
+			return err
+		}
+		if err := expect(res, http.StatusOK); err != nil {
+			return err
+		}
+		if _, ok := res.body["name"]; ok || res.body["userName"] != email1 || res.body["id"] != id1 {
+			return fmt.Errorf("attributes=userName returned %v", res.body)
+		}
+		res, err = c.do("GET", "/Users/"+id1+"?excludedAttributes=name,emails", nil)
+		if err != nil {
+			return err
+		}
+		if _, ok := res.body["name"]; ok || res.body["userName"] != email1 {
+			return fmt.Errorf("excludedAttributes=name returned %v", res.body)
+		}
+		return nil
+	})
+
+	s.check("PATCH replace active", func() error {
+		res, err := c.do("PATCH", "/Users/"+id2, patch(obj{"op": "replace", "path": "active", "value": false}))
+		if err != nil {
+			return err
+		}
+		if err := expect(res, http.StatusOK); err != nil {
+			return err
+		}
+		if res.body["active"] != false {
+			return fmt.Errorf("active %v", res.body["active"])
+		}
+		res, err = s.list("/Users", `userName sw "`+prefix+`" and active eq false`)
+		if err != nil {
+			return err
+		}
+		return total(res, 1)
+	})
+	s.check("PATCH without a path, as Azure AD sends it", func() error {
+		res, err := c.do("PATCH", "/Users/"+id2, patch(obj{"op": "Replace", "value": obj{"active": "True", "displayName": "Amazing Grace"}}))
+		if err != nil {
+			return err
+		}
+		if err := expect(res, http.StatusOK); err != nil {
+			return err
+		}
+		if res.body["active"] != true || res.body["displayName"] != "Amazing Grace" {
+			return fmt.Errorf("unexpected resource %v", res.body)
+		}
+		return nil
+	})
+	s.check("PATCH name.givenName", func() error {
+		res, err := c.do("PATCH", "/Users/"+id1, patch(obj{"op": "replace", "path": "name.givenName", "value": "Augusta"}))
+		if err != nil {
+			return err
+		}
+		if err := expect(res, http.StatusOK); err != nil {
+			return err
+		}
+		name, _ := res.body["name"].(obj)
+		if name["givenName"] != "Augusta" || name["formatted"] != "Augusta Lovelace" {
+			return fmt.Errorf("name %v", name)
+		}
+		return nil
+	})
+	s.check("PATCH with an unknown op is rejected", func() error {
+		res, err := c.do("PATCH", "/Users/"+id1, patch(obj{"op": "move", "path": "active", "value": true}))
+		if err != nil {
+			return err
+		}
+		return expect(res, http.StatusBadRequest)
+	})
+	s.check("PUT replaces the user", func() error {
+		u := newUser(email1, prefix+"-ext-a2", "Ada", "King")
+		u["displayName"] = "Countess"
+		delete(u, "password")
+		res, err := c.do("PUT", "/Users/"+id1, u)
+		if err != nil {
+			return err
+		}
+		if err := expect(res, http.StatusOK); err != nil {
+			return err
+		}
+		if res.body["displayName"] != "Countess" || res.body["externalId"] != prefix+"-ext-a2" {
+			return fmt.Errorf("unexpected resource %v", res.body)
+		}
+		return nil
+	})
+	s.check("If-Match with a stale version fails", func() error {
+		res, err := c.do("PATCH", "/Users/"+id1, patch(obj{"op": "replace", "path": "displayName", "value": "x"}), "If-Match", `W/"0"`)
+		if err != nil {
+			return err
+		}
+		return expect(res, http.StatusPreconditionFailed)
+	})
+	s.check("unknown user is not found", func() error {
+		res, err := c.do("GET", "/Users/999999999", nil)
+		if err != nil {
+			return err
+		}
+		return expect(res, http.StatusNotFound)
+	})
+
+	group := prefix + "-group"
+	s.must("create group with a member", func() error {
+		res, err := c.do("POST", "/Groups", obj{
+			"schemas":     []string{schemaGroup},
+			"displayName": group,
+			"externalId":  prefix + "-ext-g",
+			"members":     []obj{{"value": id1}},
+		})
+		if err != nil {
+			return err
+		}
+		if err := expect(res, http.StatusCreated); err != nil {
+			return err
+		}
+		gid, _ = res.body["id"].(string)
+		s.groups = append(s.groups, gid)
+		if m := values(res.body["members"]); len(m) != 1 || m[0] != id1 {
+			return fmt.Errorf("members %v", m)
+		}
+		return nil
+	})
+	s.check("duplicate group displayName is a uniqueness conflict", func() error {
+		res, err := c.do("POST", "/Groups", obj{"schemas": []string{schemaGroup}, "displayName": group})
+		if err != nil {
+			return err
+		}
+		return expect(res, http.StatusConflict)
+	})
+	s.check("user lists its groups", func() error {
+		res, err := c.do("GET", "/Users/"+id1, nil)
+		if err != nil {
+			return err
+		}
+		if err := expect(res, http.StatusOK); err != nil {
+			return err
+		}
+		if !contains(values(res.body["groups"]), gid) {
+			return fmt.Errorf("groups %v", res.body["groups"])
+		}
+		return nil
+	})
+	s.check("PATCH add member", func() error {
+		res, err := c.do("PATCH", "/Groups/"+gid, patch(obj{"op": "add", "path": "members", "value": []obj{{"value": id2}}}))
+		if err != nil {
+			return err
+		}
+		if err := expect(res, http.StatusOK); err != nil {
+			return err
+		}
+		if m := values(res.body["members"]); len(m) != 2 {
+			return fmt.Errorf("members %v", m)
+		}
+		return nil
+	})
+	s.check("filter Groups by member", func() error {
+		res, err := s.list("/Groups", `displayName eq "`+group+`" and members[value eq "`+id2+`"]`, "excludedAttributes=members")
+		if err != nil {
+			return err
+		}
+		if err := total(res, 1); err != nil {
+			return err
+		}
+		if _, ok := res.body["Resources"].([]interface{})[0].(obj)["members"]; ok {
+			return fmt.Errorf("members returned although excluded")
+		}
+		return nil
+	})
+	s.check("PATCH remove member by filter", func() error {
+		res, err := c.do("PATCH", "/Groups/"+gid, patch(obj{"op": "remove", "path": `members[value eq "` + id1 + `"]`}))
+		if err != nil {
+			return err
+		}
+		if err := expect(res, http.StatusOK); err != nil {
+			return err
+		}
+		if m := values(res.body["members"]); len(m) != 1 || m[0] != id2 {
+			return fmt.Errorf("members %v", m)
+		}
+		return nil
+	})
+	s.check("PATCH unknown member is rejected", func() error {
+		res, err := c.do("PATCH", "/Groups/"+gid, patch(obj{"op": "add", "path": "members", "value": []obj{{"value": "999999999"}}}))
+		if err != nil {
+			return err
+		}
+		return expect(res, http.StatusBadRequest)
+	})
+	s.check("PATCH rename group", func() error {
+		group += "-renamed"
+		res, err := c.do("PATCH", "/Groups/"+gid, patch(obj{"op": "replace", "path": "displayName", "value": group}))
+		if err != nil {
+			return err
+		}
+		if err := expect(res, http.StatusOK); err != nil {
+			return err
+		}
+		list, err := s.list("/Groups", `displayName eq "`+group+`"`)
+		if err != nil {
+			return err
+		}
+		return total(list, 1)
+	})
+	s.check("PUT replaces the members", func() error {
+		res, err := c.do("PUT", "/Groups/"+gid, obj{"schemas": []string{schemaGroup}, "displayName": group, "members": []obj{{"value": id1}}})
+		if err != nil {
+			return err
+		}
+		if err := expect(res, http.StatusOK); err != nil {
+			return err
+		}
+		if m := values(res.body["members"]); len(m) != 1 || m[0] != id1 {
+			return fmt.Errorf("members %v", m)
+		}
+		return nil
+	})
+	s.check("delete group", func() error {
+		if err := s.remove("/Groups/" + gid); err != nil {
+			return err
+		}
+		s.groups = nil
+		res, err := c.do("GET", "/Groups/"+gid, nil)
+		if err != nil {
+			return err
+		}
+		return expect(res, http.StatusNotFound)
+	})
+	s.check("delete user", func() error {
+		if err := s.remove("/Users/" + id2); err != nil {
+			return err
+		}
+		s.users = s.users[:1]
+		res, err := c.do("GET", "/Users/"+id2, nil)
+		if err != nil {
+			return err
+		}
+		return expect(res, http.StatusNotFound)
+	})
+}
+
+func (s *suite) remove(path string) error {
+	res, err := s.c.do("DELETE", path, nil)
+	if err != nil {
+		return err
+	}
+	return expect(res, http.StatusNoContent)
+}
+
+// cleanup deletes what the suite created and has not deleted yet
+func (s *suite) cleanup() {
+	for _, id := range s.groups {
+		if err := s.remove("/Groups/" + id); err != nil {
+			fmt.Printf("cleanup: group %s: %v\n", id, err)
+		}
+	}
+	for _, id := range s.users {
+		if err := s.remove("/Users/" + id); err != nil {
+			fmt.Printf("cleanup: user %s: %v\n", id, err)
+		}
+	}
+}
+
+func main() {
+	base := flag.String("url", "http://localhost:8081/scim/v2", "base URL of the SCIM endpoints")
+	token := flag.String("token", os.Getenv("SCIM_TOKEN"), "SCIM bearer token, defaults to $SCIM_TOKEN")
+	flag.Parse()
+	if *token == "" {
+		fmt.Fprintln(os.Stderr, "scim-compliance: -token or SCIM_TOKEN is required")
+		os.Exit(2)
+	}
+	s := &suite{
+		c:   &client{base: strings.TrimSuffix(*base, "/"), token: *token, http: &http.Client{Timeout: 30 * time.Second}},
+		run: fmt.Sprintf("%x", time.Now().UnixNano()),
+	}
+	s.checks()
+	s.cleanup()
+	if s.failed > 0 {
+		fmt.Printf("%d checks failed\n", s.failed)
+		os.Exit(1)
+	}
+	fmt.Println("all checks passed")
+}
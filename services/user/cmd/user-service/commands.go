@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"services/user/internal"
+	"services/user/internal/backup"
+	"services/user/internal/bulk"
+	"services/user/internal/handlers"
+	"services/user/internal/migrations"
+	"services/user/internal/models"
+	"services/user/internal/store"
+)
+
+const usage = `usage: user-service [command]
+
+Without a command the HTTP service is started.
+
+Commands:
+  migrate up          apply all pending schema migrations
+  migrate down [n]    revert the latest n applied migrations (default 1)
+  migrate status      list migrations and whether they are applied
+  verify-audit        walk the audit hash chain and checkpoints, reporting the first broken link
+  reencrypt           re-encrypt fields still sealed with a key other than the active one
+  backup --out FILE [--compress] [--encrypt]
+                      write a consistent copy of the SQLite database, also while the service
+                      runs; FILE - writes to stdout. The flags default to BACKUP_COMPRESS and
+                      to whether BACKUP_PASSPHRASE is set.
+  restore --in FILE   verify a backup (FILE - reads stdin) and make it the SQLite database,
+                      moving the current one aside; stop the service first
+  import-users --in FILE [--format csv|ndjson] [--dry-run] [--batch-size N]
+                      create users from a CSV or NDJSON file (FILE - reads stdin), reporting
+                      every row that could not be imported; the format defaults to the extension
+  export-users --out FILE [--format csv|ndjson] [--password-hashes]
+                      write every user in the import format (FILE - writes to stdout)
+`
+
+// runCommand runs a CLI subcommand and returns the process exit code
+func runCommand(cfg *internal.Config, name string, args []string) int {
+	cfg.LogSQL = false
+	switch name {
+	case "migrate":
+		return migrate(cfg, args)
+	case "verify-audit":
+		return verifyAudit(cfg)
+	case "reencrypt":
+		return reencrypt(cfg)
+	case "backup":
+		return backupDB(cfg, args)
+	case "restore":
+		return restoreDB(cfg, args)
+	case "import-users":
+		return importUsers(cfg, args)
+	case "export-users":
+		return exportUsers(cfg, args)
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", name, usage)
+		return 2
+	}
+}
+
+func migrate(cfg *internal.Config, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	db, err := internal.Connect(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open database: %v\n", err)
+		return 1
+	}
+	m, err := migrations.New(db)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load migrations: %v\n", err)
+		return 1
+	}
+	switch args[0] {
+	case "up":
+		ran, err := m.Up()
+		for _, mig := range ran {
+			fmt.Printf("applied %04d_%s\n", mig.Version, mig.Name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "migrate up: %v\n", err)
+			return 1
+		}
+		if len(ran) == 0 {
+			fmt.Println("schema is up to date")
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps <= 0 {
+				fmt.Fprintf(os.Stderr, "invalid step count %q\n", args[1])
+				return 2
+			}
+		}
+		ran, err := m.Down(steps)
+		for _, mig := range ran {
+			fmt.Printf("reverted %04d_%s\n", mig.Version, mig.Name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "migrate down: %v\n", err)
+			return 1
+		}
+	case "status":
+		sts, err := m.Status()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "migrate status: %v\n", err)
+			return 1
+		}
+		for _, st := range sts {
+			state := "pending"
+			if st.Applied {
+				state = "applied " + st.AppliedAt.UTC().Format(time.RFC3339)
+			}
+			if st.Unknown {
+				state += " (unknown to this build)"
+			}
+			fmt.Printf("%04d_%-24s %s\n", st.Version, st.Name, state)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown migrate command %q\n\n%s", args[0], usage)
+		return 2
+	}
+	return 0
+}
+
+func verifyAudit(cfg *internal.Config) int {
+	repo, err := openStore(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	v, err := repo.VerifyAuditChain(context.Background(), []byte(cfg.AuditSigningKey))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "verify audit chain: %v\n", err)
+		return 1
+	}
+	switch {
+	case v.BrokenEventID != 0:
+		fmt.Printf("audit chain BROKEN at event id=%d after %d valid events: %s\n", v.BrokenEventID, v.Events, v.Reason)
+		return 1
+	case v.BrokenCheckpointID != 0:
+		fmt.Printf("audit chain BROKEN at checkpoint id=%d: %s\n", v.BrokenCheckpointID, v.Reason)
+		return 1
+	}
+	fmt.Printf("audit chain ok: events=%d, checkpoints=%d, redacted=%d, head=%s\n", v.Events, v.Checkpoints, v.Redacted, v.Head)
+	return 0
+}
+
+// reencrypt rotates the user fields, and the encrypted columns of audit
+// events, webhook deliveries and email changes, to the active field
+// encryption key. It can run while the service is up, once the service
+// uses the same keys.
+func reencrypt(cfg *internal.Config) int {
+	if cfg.FieldKeysDir == "" {
+		fmt.Fprintln(os.Stderr, "FIELD_KEYS_DIR is not set")
+		return 2
+	}
+	if err := internal.UseFieldKeys(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "load field keys: %v\n", err)
+		return 1
+	}
+	db, err := internal.OpenDB(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open database: %v\n", err)
+		return 1
+	}
+	repo := store.NewStore(db, cfg.DBQueryTimeout)
+	if err := repo.PrepareUserSearch(context.Background()); err != nil {
+		fmt.Fprintf(os.Stderr, "prepare user search: %v\n", err)
+		return 1
+	}
+	n, err := repo.ReencryptUsers(context.Background(), true)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "reencrypt after %d users: %v\n", n, err)
+		return 1
+	}
+	rows, err := repo.ReencryptColumns(context.Background(), true)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "reencrypt after %d users and %d other rows: %v\n", n, rows, err)
+		return 1
+	}
+	fmt.Printf("re-encrypted %d users and %d audit, webhook and email change rows\n", n, rows)
+	return 0
+}
+
+func backupDB(cfg *internal.Config, args []string) int {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	out := fs.String("out", "", "file to write the backup to, - for stdout")
+	compress := fs.Bool("compress", cfg.BackupCompress, "gzip the backup")
+	encrypt := fs.Bool("encrypt", cfg.BackupPassphrase != "", "encrypt the backup with BACKUP_PASSPHRASE")
+	if err := fs.Parse(args); err != nil || *out == "" {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	opts := backup.Options{Compress: *compress}
+	if *encrypt {
+		if cfg.BackupPassphrase == "" {
+			fmt.Fprintln(os.Stderr, "BACKUP_PASSPHRASE is not set")
+			return 2
+		}
+		opts.Passphrase = cfg.BackupPassphrase
+	}
+	db, err := internal.Connect(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open database: %v\n", err)
+		return 1
+	}
+	ctx := context.Background()
+	if *out == "-" {
+		err = backup.Write(ctx, db, os.Stdout, opts)
+	} else {
+		err = backup.WriteFile(ctx, db, *out, opts)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "backup: %v\n", err)
+		return 1
+	}
+	if *out != "-" {
+		fmt.Printf("database backed up to %s\n", *out)
+	}
+	return 0
+}
+
+func restoreDB(cfg *internal.Config, args []string) int {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	in := fs.String("in", "", "backup file to restore, - for stdin")
+	if err := fs.Parse(args); err != nil || *in == "" {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	if cfg.DBDriver != "sqlite" {
+		fmt.Fprintln(os.Stderr, backup.ErrNotSQLite)
+		return 2
+	}
+	var r io.Reader = os.Stdin
+	if *in != "-" {
+		f, err := os.Open(*in)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "open backup: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		r = f
+	}
+	info, err := backup.Restore(context.Background(), r, cfg.DBPath, cfg.BackupPassphrase)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "restore: %v\n", err)
+		return 1
+	}
+	fmt.Printf("restored %s at schema version %04d\n", cfg.DBPath, info.Version)
+	for _, name := range info.Pending {
+		fmt.Printf("pending migration, applied at the next start: %s\n", name)
+	}
+	if info.Previous != "" {
+		fmt.Printf("previous database moved to %s\n", info.Previous)
+	}
+	return 0
+}
+
+// openStore opens the database for commands that read or write users or
+// audit events, which need the field encryption keys the service uses
+func openStore(cfg *internal.Config) (*store.Store, error) {
+	if err := internal.UseFieldKeys(cfg); err != nil {
+		return nil, fmt.Errorf("load field keys: %w", err)
+	}
+	db, err := internal.OpenDB(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+	repo := store.NewStore(db, cfg.DBQueryTimeout)
+	if err := repo.PrepareUserSearch(context.Background()); err != nil {
+		return nil, fmt.Errorf("prepare user search: %w", err)
+	}
+	return repo, nil
+}
+
+// fileFormat is the format flag, or else the one implied by the extension of path
+func fileFormat(flagValue, path string) (string, error) {
+	if flagValue != "" {
+		return bulk.ParseFormat(flagValue)
+	}
+	if path == "-" {
+		return "", fmt.Errorf("--format is required with -")
+	}
+	return bulk.FormatOf(path)
+}
+
+func importUsers(cfg *internal.Config, args []string) int {
+	fs := flag.NewFlagSet("import-users", flag.ContinueOnError)
+	in := fs.String("in", "", "file to import, - for stdin")
+	formatFlag := fs.String("format", "", "csv or ndjson; defaults to the file extension")
+	dryRun := fs.Bool("dry-run", false, "check every row without creating users")
+	batchSize := fs.Int("batch-size", bulk.DefaultBatchSize, "rows committed per transaction")
+	if err := fs.Parse(args); err != nil || *in == "" || *batchSize <= 0 || *batchSize > bulk.MaxBatchSize {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	format, err := fileFormat(*formatFlag, *in)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	var r io.Reader = os.Stdin
+	if *in != "-" {
+		f, err := os.Open(*in)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "open file: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		r = f
+	}
+	s, err := openStore(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	rd, err := bulk.NewReader(r, format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	im := &bulk.Importer{Store: s, Created: handlers.UserCreatedWebhook}
+	opts := bulk.ImportOptions{DryRun: *dryRun, BatchSize: *batchSize}
+	opts.Audit = models.AuditEvent{Action: handlers.AuditUserImport, TargetType: "user", Detail: "import-users " + filepath.Base(*in)}
+	rep, err := im.Import(context.Background(), rd, opts)
+	for _, e := range rep.Errors {
+		fmt.Printf("line %d: %s: %s\n", e.Line, e.Email, e.Error)
+	}
+	if rep.Truncated {
+		fmt.Printf("... %d more failed rows not listed\n", rep.Failed-len(rep.Errors))
+	}
+	verb := "created"
+	if rep.DryRun {
+		verb = "would create"
+	}
+	fmt.Printf("rows=%d, %s=%d, failed=%d\n", rep.Rows, verb, rep.Created, rep.Failed)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import stopped: %v\n", err)
+		return 1
+	}
+	if rep.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func exportUsers(cfg *internal.Config, args []string) int {
+	fs := flag.NewFlagSet("export-users", flag.ContinueOnError)
+	out := fs.String("out", "", "file to write, - for stdout")
+	formatFlag := fs.String("format", "", "csv or ndjson; defaults to the file extension")
+	withPasswords := fs.Bool("password-hashes", false, "include the bcrypt password hashes")
+	if err := fs.Parse(args); err != nil || *out == "" {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	format, err := fileFormat(*formatFlag, *out)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	s, err := openStore(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	var w io.Writer = os.Stdout
+	var f *os.File
+	if *out != "-" {
+		// the file holds personal data, and possibly password hashes
+		if f, err = os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600); err != nil {
+			fmt.Fprintf(os.Stderr, "create file: %v\n", err)
+			return 1
+		}
+		w = f
+	}
+	bw, err := bulk.NewWriter(w, format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	n, err := bulk.Export(context.Background(), s, bw, *withPasswords)
+	if f != nil {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export after %d users: %v\n", n, err)
+		return 1
+	}
+	ev := &models.AuditEvent{Action: handlers.AuditUserBulkExport, TargetType: "user", Result: models.AuditSuccess, Detail: "export-users",
+		Diff: map[string]interface{}{"format": format, "password_hashes": *withPasswords, "users": n}}
+	if err := s.AppendAuditEvent(context.Background(), ev); err != nil {
+		fmt.Fprintf(os.Stderr, "append audit event: %v\n", err)
+	}
+	if *out != "-" {
+		fmt.Printf("exported %d users to %s\n", n, *out)
+	}
+	return 0
+}
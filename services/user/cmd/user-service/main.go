@@ -2,6 +2,7 @@ package main
 
 import (
 	"log"
+	"os"
 
 	"services/user/internal"
 )
@@ -9,6 +10,9 @@ import (
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.LUTC)
 	cfg := internal.NewConfigFromEnv()
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(cfg, os.Args[1], os.Args[2:]))
+	}
 	app, err := internal.NewApp(cfg)
 	if err != nil {
 		log.Fatalf("failed to create app: %v", err)
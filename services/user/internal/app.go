@@ -2,25 +2,39 @@ package internal
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	mysqldriver "github.com/go-sql-driver/mysql"
 	"github.com/rs/cors"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 
 	"services/user/internal/auth"
+	"services/user/internal/backup"
+	"services/user/internal/blob"
 	"services/user/internal/discovery"
+	"services/user/internal/export"
+	"services/user/internal/fieldcrypt"
 	"services/user/internal/handlers"
+	"services/user/internal/mailer"
+	"services/user/internal/migrations"
 	"services/user/internal/models"
 	"services/user/internal/store"
+	"services/user/internal/webhooks"
 )
 
 // App encapsulates the web server and dependencies
@@ -29,56 +43,215 @@ type App struct {
 	http *http.Server
 	db   *gorm.DB
 	ln   net.Listener
+	// cancel stops background workers started by NewApp
+	cancel context.CancelFunc
 }
 
-func NewApp(cfg *Config) (*App, error) {
-	if err := os.MkdirAll("./data", 0755); err != nil {
-		return nil, err
+// Connect opens the configured database without touching its schema
+func Connect(cfg *Config) (*gorm.DB, error) {
+	level := logger.Info
+	if !cfg.LogSQL {
+		level = logger.Warn
 	}
-	db, err := gorm.Open(sqlite.Open(cfg.DBPath), &gorm.Config{Logger: logger.Default.LogMode(logger.Info)})
+	var dialector gorm.Dialector
+	switch cfg.DBDriver {
+	case "sqlite":
+		if err := os.MkdirAll(filepath.Dir(cfg.DBPath), 0755); err != nil {
+			return nil, err
+		}
+		dialector = sqlite.Open(cfg.DBPath)
+	case "postgres":
+		dialector = postgres.Open(cfg.DBDSN)
+	case "mysql":
+		dsn, err := mysqldriver.ParseDSN(cfg.DBDSN)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DB_DSN: %w", err)
+		}
+		// timestamps are scanned into time.Time and migrations hold several statements
+		dsn.ParseTime = true
+		dsn.MultiStatements = true
+		dialector = mysql.Open(dsn.FormatDSN())
+	default:
+		return nil, fmt.Errorf("unsupported DB_DRIVER %q (want sqlite, postgres or mysql)", cfg.DBDriver)
+	}
+	db, err := gorm.Open(dialector, &gorm.Config{Logger: logger.Default.LogMode(level), TranslateError: true})
 	if err != nil {
 		return nil, err
 	}
-	// perform auto-migrations
-	if err := db.AutoMigrate(&models.User{}, &models.Role{}, &models.UserRole{}); err != nil {
-		log.Printf("error running auto-migration: %v", err)
+	sqlDB, err := db.DB()
+	if err != nil {
 		return nil, err
 	}
-	log.Printf("database migrated, path=%s", cfg.DBPath)
+	if cfg.DBMaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(cfg.DBMaxOpenConns)
+	}
+	if cfg.DBMaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(cfg.DBMaxIdleConns)
+	}
+	if cfg.DBConnMaxLifetime > 0 {
+		sqlDB.SetConnMaxLifetime(cfg.DBConnMaxLifetime)
+	}
+	return db, nil
+}
 
-	repo := store.NewStore(db)
-	jwtManager := auth.NewJWTManager(cfg.JWTSecret)
-	h := handlers.NewHandler(repo, jwtManager)
-	// Ensure a default admin user exists
-	if u, err := repo.GetUserByEmail("admin@local"); err != nil {
-		log.Printf("default admin not found, creating admin=admin@local")
-		admin := &models.User{Email: "admin@local", FullName: "Administrator"}
-		admin.SetPassword("admin")
-		if err := repo.CreateUser(admin); err != nil {
-			log.Printf("failed to create default admin: %v", err)
-		} else {
-			log.Printf("default admin created: id=%d", admin.ID)
+// OpenDB opens the configured database and makes sure its schema is current:
+// pending migrations are applied in "auto" mode, while "verify" mode refuses
+// to continue until they have been applied with the migrate command.
+func OpenDB(cfg *Config) (*gorm.DB, error) {
+	db, err := Connect(cfg)
+	if err != nil {
+		return nil, err
+	}
+	m, err := migrations.New(db)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Migrations == "verify" {
+		if err := m.Check(); err != nil {
+			log.Printf("schema check failed: %v", err)
+			return nil, err
 		}
-		// ensure admin role exists
-		if _, err := repo.GetRoleByName("admin"); err != nil {
-			repo.CreateRole(&models.Role{Name: "admin"})
+		log.Printf("database schema is current, driver=%s", cfg.DBDriver)
+		return db, nil
+	}
+	ran, err := m.Up()
+	for _, mig := range ran {
+		log.Printf("applied migration %04d_%s", mig.Version, mig.Name)
+	}
+	if err != nil {
+		log.Printf("error running migrations: %v", err)
+		return nil, err
+	}
+	if err := m.Check(); err != nil {
+		return nil, err
+	}
+	log.Printf("database migrated, driver=%s", cfg.DBDriver)
+	return db, nil
+}
+
+// bootstrapAdmin ensures a default admin user exists. The user, the admin
+// role and the assignment are created in one transaction, so a failure
+// never leaves an admin account without the admin role.
+func bootstrapAdmin(ctx context.Context, repo *store.Store) error {
+	u, err := repo.GetUserByEmail(ctx, "admin@local")
+	if err == nil {
+		log.Printf("default admin exists: id=%d, email=%s", u.ID, u.Email)
+		return nil
+	}
+	if !errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("look up default admin: %w", err)
+	}
+	log.Printf("default admin not found, creating admin=admin@local")
+	admin := &models.User{Email: "admin@local", FullName: "Administrator"}
+	if err := admin.SetPassword("admin"); err != nil {
+		return fmt.Errorf("create default admin: %w", err)
+	}
+	err = repo.WithTx(ctx, func(tx store.Repository) error {
+		if err := tx.CreateUser(ctx, admin); err != nil {
+			return err
 		}
-		if r, _ := repo.GetRoleByName("admin"); r != nil {
-			if err := repo.AssignRoleToUser(admin.ID, r.ID); err != nil {
-				log.Printf("failed to assign admin role to default admin: %v", err)
-			} else {
-				log.Printf("assigned admin role to default admin id=%d", admin.ID)
-			}
+		role, err := tx.EnsureRole(ctx, "admin")
+		if err != nil {
+			return err
 		}
-	} else {
-		log.Printf("default admin exists: id=%d, email=%s", u.ID, u.Email)
+		return tx.AssignRoleToUser(ctx, admin.ID, role.ID)
+	})
+	if err != nil {
+		return fmt.Errorf("create default admin: %w", err)
 	}
+	log.Printf("default admin created with admin role: id=%d", admin.ID)
+	return nil
+}
 
+func NewApp(cfg *Config) (*App, error) {
+	if err := UseFieldKeys(cfg); err != nil {
+		return nil, err
+	}
+	db, err := OpenDB(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	repo := store.NewStore(db, cfg.DBQueryTimeout)
+	if n, err := repo.BackfillAuditChain(context.Background()); err != nil {
+		return nil, err
+	} else if n > 0 {
+		log.Printf("audit chain backfilled: events=%d", n)
+	}
+	// before users are rewritten, so the search index never sees ciphertext
+	if err := repo.PrepareUserSearch(context.Background()); err != nil {
+		return nil, err
+	}
+	if n, err := repo.ReencryptUsers(context.Background(), false); err != nil {
+		return nil, err
+	} else if n > 0 {
+		log.Printf("user fields backfilled: users=%d, encrypted=%t", n, fieldcrypt.Enabled())
+	}
+	if n, err := repo.ReencryptColumns(context.Background(), false); err != nil {
+		return nil, err
+	} else if n > 0 {
+		log.Printf("audit, webhook and email change fields encrypted: rows=%d", n)
+	}
+	if err := bootstrapAdmin(context.Background(), repo); err != nil {
+		return nil, err
+	}
+	bg, cancel := context.WithCancel(context.Background())
+	if cfg.AuditCheckpointInterval > 0 {
+		go runAuditCheckpoints(bg, repo, []byte(cfg.AuditSigningKey), cfg.AuditCheckpointInterval)
+	}
+	if cfg.BackupDir != "" {
+		if cfg.DBDriver == "sqlite" {
+			go runBackups(bg, db, cfg)
+		} else {
+			log.Printf("scheduled backups disabled: BACKUP_DIR only applies to sqlite, driver=%s", cfg.DBDriver)
+		}
+	}
+	go webhooks.NewDispatcher(repo, webhooks.Options{PollInterval: cfg.WebhookPollInterval, MaxAttempts: cfg.WebhookMaxAttempts}).Run(bg)
+	jwtManager := auth.NewJWTManager(cfg.JWTSecret)
+	var mail mailer.Mailer = mailer.Log{}
+	if cfg.SMTPAddr != "" {
+		mail = &mailer.SMTP{Addr: cfg.SMTPAddr, From: cfg.SMTPFrom, Username: cfg.SMTPUsername, Password: cfg.SMTPPassword}
+	}
+	blobs, err := openBlobStore(cfg)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	media := &blob.Signer{Key: []byte(cfg.MediaSigningKey), BaseURL: cfg.PublicURL, Prefix: "/media/", TTL: cfg.MediaURLTTL}
+	exporter := export.NewWorker(repo, blobs, handlers.ExportSections(repo, blobs), export.Options{TTL: cfg.ExportTTL})
+	go exporter.Run(bg)
+	if cfg.DeletedUserRetention > 0 {
+		go runUserPurge(bg, repo, blobs, cfg.DeletedUserRetention)
+	}
+	h := handlers.NewHandler(repo, jwtManager, handlers.Options{Mailer: mail, EmailChangeTTL: cfg.EmailChangeTTL, EmailConfirmURL: cfg.EmailConfirmURL, Blobs: blobs, Media: media, AvatarMaxBytes: cfg.AvatarMaxBytes, Exporter: exporter, DeletionGrace: cfg.AccountDeletionGrace})
+	go runAccountDeletions(bg, h)
 	r := chi.NewRouter()
 	log.Printf("registering routes and middleware")
 	r.Use(middleware.Logger)
+	// cancels the request context, and with it any running query, at the
+	// deadline. Event streams are long-lived and exempt; bulk imports and
+	// exports and media downloads get the longer transfer timeout.
+	r.Use(func(next http.Handler) http.Handler {
+		timed, transfer := next, next
+		if cfg.RequestTimeout > 0 {
+			timed = middleware.Timeout(cfg.RequestTimeout)(next)
+		}
+		if cfg.TransferTimeout > 0 {
+			transfer = middleware.Timeout(cfg.TransferTimeout)(next)
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case isStream(r):
+				next.ServeHTTP(w, r)
+			case isTransfer(r):
+				transfer.ServeHTTP(w, r)
+			default:
+				timed.ServeHTTP(w, r)
+			}
+		})
+	})
 	// allow CORS for development (adjust as needed in production)
-	c := cors.New(cors.Options{AllowCredentials: true, AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}, AllowedHeaders: []string{"*"}})
+	c := cors.New(cors.Options{AllowCredentials: true, AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}, AllowedHeaders: []string{"*"}, ExposedHeaders: []string{"ETag"}})
 	r.Use(c.Handler)
 	// public endpoints
 	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -90,19 +263,85 @@ func NewApp(cfg *Config) (*App, error) {
 	// auth
 	r.Post("/auth/register", h.Register)
 	r.Post("/auth/login", h.Login)
-	log.Printf("registered routes POST /auth/register, POST /auth/login")
+	r.Post("/auth/email/confirm", h.ConfirmEmailChange)
+	log.Printf("registered routes POST /auth/register, POST /auth/login, POST /auth/email/confirm")
+	// media is authorized by the signature in its URL
+	r.Get("/media/*", h.GetMedia)
 
 	// apply auth middleware
 	r.Route("/api", func(r chi.Router) {
-		r.Use(handlers.AuthMiddleware(jwtManager))
+		r.Use(handlers.AuthMiddleware(jwtManager, repo))
+		r.Post("/me/email", h.RequestEmailChange)
+		r.Get("/me/profile", h.GetProfile)
+		r.Patch("/me/profile", h.PatchProfile)
+		r.Post("/me/avatar", h.UploadAvatar)
+		r.Delete("/me/avatar", h.DeleteAvatar)
+		r.Delete("/me", h.DeleteMe)
+		r.Post("/me/export", h.RequestExport)
+		r.Get("/me/export/{id}", h.GetExport)
+		r.Get("/me/settings", h.GetSettings)
+		r.Put("/me/settings", h.PutSettings)
+		r.Get("/me/settings/events", h.SettingsEvents)
 		r.Get("/users", h.ListUsers)
+		r.Get("/users/deleted", h.ListDeletedUsers)
+		r.Get("/users/search", h.SearchUsers)
+		r.Post("/users/import", h.ImportUsers)
+		r.Get("/users/export", h.ExportUsers)
+		r.Post("/users/{id}/restore", h.RestoreUser)
+		r.Delete("/users/{id}/purge", h.PurgeUser)
 		r.Get("/users/{id}", h.GetUser)
 		r.Put("/users/{id}", h.UpdateUser)
+		r.Patch("/users/{id}", h.PatchUser)
+		r.Get("/users/{id}/profile", h.GetProfile)
+		r.Patch("/users/{id}/profile", h.PatchProfile)
+		r.Delete("/users/{id}/avatar", h.DeleteAvatar)
 		r.Delete("/users/{id}", h.DeleteUser)
 		r.Post("/roles", h.CreateRole)
 		r.Post("/users/{id}/roles", h.AssignRole)
+		r.Post("/users/{id}/impersonate", h.Impersonate)
+		r.Get("/groups", h.ListGroups)
+		r.Post("/groups", h.CreateGroup)
+		r.Get("/groups/{id}", h.GetGroup)
+		r.Put("/groups/{id}", h.UpdateGroup)
+		r.Delete("/groups/{id}", h.DeleteGroup)
+		r.Get("/groups/{id}/members", h.ListGroupMembers)
+		r.Post("/groups/{id}/members", h.AddGroupMembers)
+		r.Delete("/groups/{id}/members/{userID}", h.RemoveGroupMember)
+		r.Post("/groups/{id}/roles", h.AssignGroupRole)
+		r.Delete("/groups/{id}/roles/{roleName}", h.RemoveGroupRole)
+		r.Get("/audit", h.ListAudit)
+		r.Get("/webhooks", h.ListWebhooks)
+		r.Post("/webhooks", h.CreateWebhook)
+		r.Get("/webhooks/{id}", h.GetWebhook)
+		r.Put("/webhooks/{id}", h.UpdateWebhook)
+		r.Delete("/webhooks/{id}", h.DeleteWebhook)
+		r.Get("/webhooks/{id}/deliveries", h.ListWebhookDeliveries)
+		r.Post("/webhooks/{id}/deliveries/{deliveryID}/retry", h.RetryWebhookDelivery)
 	})
-	log.Printf("registered /api endpoints (users, roles)")
+	log.Printf("registered /api endpoints (users, roles, groups, audit, webhooks)")
+	if cfg.SCIMToken != "" {
+		r.Route("/scim/v2", func(r chi.Router) {
+			r.Use(handlers.SCIMAuth(cfg.SCIMToken))
+			r.Get("/ServiceProviderConfig", h.SCIMServiceProviderConfig)
+			r.Get("/ResourceTypes", h.SCIMResourceTypes)
+			r.Get("/ResourceTypes/{id}", h.SCIMResourceType)
+			r.Get("/Schemas", h.SCIMSchemas)
+			r.Get("/Schemas/{id}", h.SCIMSchema)
+			r.Get("/Users", h.SCIMListUsers)
+			r.Post("/Users", h.SCIMCreateUser)
+			r.Get("/Users/{id}", h.SCIMGetUser)
+			r.Put("/Users/{id}", h.SCIMReplaceUser)
+			r.Patch("/Users/{id}", h.SCIMPatchUser)
+			r.Delete("/Users/{id}", h.SCIMDeleteUser)
+			r.Get("/Groups", h.SCIMListGroups)
+			r.Post("/Groups", h.SCIMCreateGroup)
+			r.Get("/Groups/{id}", h.SCIMGetGroup)
+			r.Put("/Groups/{id}", h.SCIMReplaceGroup)
+			r.Patch("/Groups/{id}", h.SCIMPatchGroup)
+			r.Delete("/Groups/{id}", h.SCIMDeleteGroup)
+		})
+		log.Printf("registered /scim/v2 endpoints (Users, Groups)")
+	}
 
 	hs := &http.Server{
 		Addr:    cfg.ListenAddr,
@@ -133,11 +372,163 @@ func NewApp(cfg *Config) (*App, error) {
 			}
 		}
 		// background discovery responder
-		ctx := context.Background()
-		discovery.StartDiscovery(ctx, discovery.Options{MulticastAddr: cfg.DiscoveryAddr, ServiceName: "user-service", ServicePort: port, Enabled: cfg.DiscoveryEnabled})
+		discovery.StartDiscovery(bg, discovery.Options{MulticastAddr: cfg.DiscoveryAddr, ServiceName: "user-service", ServicePort: port, Enabled: cfg.DiscoveryEnabled})
+	}
+
+	return &App{cfg: cfg, http: hs, db: db, cancel: cancel}, nil
+}
+
+// openBlobStore opens the configured store for uploaded avatars
+func openBlobStore(cfg *Config) (blob.Store, error) {
+	switch cfg.BlobStore {
+	case "local":
+		log.Printf("blob store: local, dir=%s", cfg.BlobDir)
+		return &blob.Local{Dir: cfg.BlobDir}, nil
+	case "s3":
+		if cfg.S3Endpoint == "" || cfg.S3Bucket == "" {
+			return nil, errors.New("BLOB_STORE=s3 needs S3_ENDPOINT and S3_BUCKET")
+		}
+		log.Printf("blob store: s3, endpoint=%s, bucket=%s", cfg.S3Endpoint, cfg.S3Bucket)
+		return &blob.S3{Endpoint: cfg.S3Endpoint, Region: cfg.S3Region, Bucket: cfg.S3Bucket, AccessKey: cfg.S3AccessKey, SecretKey: cfg.S3SecretKey, PathStyle: cfg.S3PathStyle, Client: &http.Client{Timeout: time.Minute}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported BLOB_STORE %q (want local or s3)", cfg.BlobStore)
+	}
+}
+
+// runUserPurge permanently removes users soft deleted longer than retention
+// ago, and their avatars and exports from blobs, checking once an hour
+func runUserPurge(ctx context.Context, repo *store.Store, blobs blob.Store, retention time.Duration) {
+	t := time.NewTicker(time.Hour)
+	defer t.Stop()
+	for {
+		n, purged, err := repo.PurgeDeletedUsers(ctx, time.Now().Add(-retention))
+		handlers.DeletePurgedBlobs(ctx, blobs, purged)
+		if err != nil {
+			log.Printf("deleted user purge failed: %v", err)
+		} else if n > 0 {
+			ev := &models.AuditEvent{Action: handlers.AuditUserPurge, TargetType: "user", Result: models.AuditSuccess, Detail: "retention expired", Diff: map[string]interface{}{"count": n, "retention": retention.String()}}
+			if err := repo.AppendAuditEvent(ctx, ev); err != nil {
+				log.Printf("failed to append audit event: action=%s, err=%v", ev.Action, err)
+			}
+			log.Printf("purged deleted users past retention: count=%d, retention=%s", n, retention)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
 	}
+}
 
-	return &App{cfg: cfg, http: hs, db: db}, nil
+// isStream reports whether r opens a long-lived event stream, which no
+// timeout applies to
+func isStream(r *http.Request) bool {
+	return r.Method == http.MethodGet && r.URL.Path == "/api/me/settings/events"
+}
+
+// isTransfer reports whether r moves bulk data and so is subject to
+// TransferTimeout rather than RequestTimeout
+func isTransfer(r *http.Request) bool {
+	switch r.URL.Path {
+	case "/api/users/import", "/api/users/export":
+		return true
+	}
+	return strings.HasPrefix(r.URL.Path, "/media/")
+}
+
+// UseFieldKeys loads the field encryption keyring, if one is configured,
+// for the encrypted columns of every database opened afterwards
+func UseFieldKeys(cfg *Config) error {
+	if cfg.FieldKeysDir == "" {
+		fieldcrypt.Use(nil)
+		return nil
+	}
+	kr, err := fieldcrypt.LoadKeyring(cfg.FieldKeysDir, cfg.FieldKeyID)
+	if err != nil {
+		return err
+	}
+	log.Printf("field encryption enabled: keys=%s, active=%s", cfg.FieldKeysDir, kr.Active())
+	fieldcrypt.Use(kr)
+	return nil
+}
+
+// runBackups backs up the SQLite database into cfg.BackupDir every
+// cfg.BackupInterval and keeps the newest cfg.BackupKeep backups. The first
+// one is due an interval after the newest backup already there, so restarts
+// do not postpone it.
+func runBackups(ctx context.Context, db *gorm.DB, cfg *Config) {
+	opts := backup.Options{Compress: cfg.BackupCompress, Passphrase: cfg.BackupPassphrase}
+	if err := os.MkdirAll(cfg.BackupDir, 0700); err != nil {
+		log.Printf("scheduled backups disabled: %v", err)
+		return
+	}
+	var wait time.Duration
+	if paths, err := backup.List(cfg.BackupDir); err == nil && len(paths) > 0 {
+		if st, err := os.Stat(paths[len(paths)-1]); err == nil {
+			wait = max(time.Until(st.ModTime().Add(cfg.BackupInterval)), 0)
+		}
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		path := filepath.Join(cfg.BackupDir, backup.FileName(time.Now(), opts))
+		if err := backup.WriteFile(ctx, db, path, opts); err != nil {
+			log.Printf("scheduled backup failed: %v", err)
+		} else {
+			log.Printf("database backed up: path=%s", path)
+			removed, err := backup.Prune(cfg.BackupDir, cfg.BackupKeep)
+			if err != nil {
+				log.Printf("prune backups failed: %v", err)
+			}
+			for _, p := range removed {
+				log.Printf("old backup removed: path=%s", p)
+			}
+		}
+		t.Reset(cfg.BackupInterval)
+	}
+}
+
+// runAccountDeletions anonymizes accounts whose deletion grace period is over,
+// checking every ten minutes
+func runAccountDeletions(ctx context.Context, h *handlers.Handler) {
+	t := time.NewTicker(10 * time.Minute)
+	defer t.Stop()
+	for {
+		if n, err := h.DeleteDueAccounts(ctx); err != nil {
+			log.Printf("account deletion run failed: %v", err)
+		} else if n > 0 {
+			log.Printf("deleted accounts past their grace period: count=%d", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// runAuditCheckpoints periodically signs the head of the audit chain until ctx is cancelled
+func runAuditCheckpoints(ctx context.Context, repo *store.Store, key []byte, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			cp, err := repo.CheckpointAudit(ctx, key)
+			if err != nil {
+				log.Printf("audit checkpoint failed: %v", err)
+			} else if cp != nil {
+				log.Printf("audit checkpoint signed: event=%d, hash=%s", cp.EventID, cp.Hash)
+			}
+		}
+	}
 }
 
 func (a *App) ListenAndServe() error {
@@ -162,6 +553,7 @@ func (a *App) ListenAndServe() error {
 }
 
 func (a *App) Shutdown(ctx context.Context) error {
+	a.cancel()
 	if a.ln != nil {
 		_ = a.ln.Close()
 	}
@@ -11,13 +11,32 @@ var (
 	ErrTokenExpired = errors.New("token expired or invalid")
 )
 
+// ImpersonationTTL bounds the lifetime of tokens issued to an admin acting as another user
+const ImpersonationTTL = 15 * time.Minute
+
 type Claims struct {
 	UserID uint     `json:"user_id"`
 	Email  string   `json:"email"`
 	Roles  []string `json:"roles"`
+	// TokenVersion is the user's token version when the token was issued;
+	// the token is revoked once the user's has moved on
+	TokenVersion uint `json:"tv,omitempty"`
+	// Act names the admin acting on behalf of UserID (RFC 8693 actor claim)
+	Act *Actor `json:"act,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// Actor identifies the real principal behind an impersonated token
+type Actor struct {
+	UserID uint   `json:"user_id"`
+	Email  string `json:"email"`
+}
+
+// Impersonated reports whether the token was issued through impersonation
+func (c *Claims) Impersonated() bool {
+	return c.Act != nil
+}
+
 type JWTManager struct {
 	secret string
 	ttl    time.Duration
@@ -27,15 +46,21 @@ func NewJWTManager(secret string) *JWTManager {
 	return &JWTManager{secret: secret, ttl: time.Hour * 24}
 }
 
-func (j *JWTManager) Generate(userID uint, email string, roles []string) (string, error) {
-	claims := &Claims{
-		UserID: userID,
-		Email:  email,
-		Roles:  roles,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(j.ttl)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
+func (j *JWTManager) Generate(userID uint, email string, tokenVersion uint, roles []string) (string, error) {
+	return j.sign(&Claims{UserID: userID, Email: email, TokenVersion: tokenVersion, Roles: roles}, time.Now().Add(j.ttl))
+}
+
+// GenerateImpersonation issues a short-lived token for userID carrying an act claim for the admin
+func (j *JWTManager) GenerateImpersonation(userID uint, email string, tokenVersion uint, roles []string, actor Actor) (string, time.Time, error) {
+	exp := time.Now().Add(ImpersonationTTL)
+	token, err := j.sign(&Claims{UserID: userID, Email: email, TokenVersion: tokenVersion, Roles: roles, Act: &actor}, exp)
+	return token, exp, err
+}
+
+func (j *JWTManager) sign(claims *Claims, exp time.Time) (string, error) {
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(exp),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString([]byte(j.secret))
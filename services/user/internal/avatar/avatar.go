@@ -0,0 +1,99 @@
+// Package avatar turns uploaded pictures into square JPEG thumbnails. Images
+// are decoded and encoded again from their pixels, which drops EXIF and any
+// other metadata, such as camera GPS positions, that the upload carried.
+package avatar
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	stddraw "image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// ContentType is the type of every thumbnail
+const ContentType = "image/jpeg"
+
+const (
+	// MaxDimension bounds the width and height of uploads
+	MaxDimension = 8192
+	// maxPixels bounds the decoded size of uploads, about 100 MB of RGBA
+	maxPixels   = 25_000_000
+	jpegQuality = 85
+)
+
+// Sizes are the edge lengths, in pixels, of the thumbnails made of each upload
+var Sizes = []int{64, 256, 512}
+
+// accepted maps the sniffed content types of uploads to image formats
+var accepted = map[string]string{"image/jpeg": "jpeg", "image/png": "png", "image/gif": "gif", "image/webp": "webp"}
+
+var (
+	ErrUnsupportedType = errors.New("unsupported image type (want JPEG, PNG, GIF or WebP)")
+	ErrTooLarge        = fmt.Errorf("image exceeds %dx%d pixels", MaxDimension, MaxDimension)
+	ErrInvalidImage    = errors.New("invalid image")
+)
+
+// Thumbnail is an encoded square JPEG
+type Thumbnail struct {
+	Size int
+	Data []byte
+}
+
+// Process checks that data is an image of a supported type and size, judged
+// by its content rather than its declared type, and returns a thumbnail for
+// each of Sizes. The picture is turned upright according to its EXIF
+// orientation and centre-cropped to a square.
+func Process(data []byte) ([]Thumbnail, error) {
+	format, ok := accepted[http.DetectContentType(data)]
+	if !ok {
+		return nil, ErrUnsupportedType
+	}
+	cfg, decoded, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || decoded != format {
+		return nil, ErrInvalidImage
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, ErrInvalidImage
+	}
+	if cfg.Width > MaxDimension || cfg.Height > MaxDimension || cfg.Width*cfg.Height > maxPixels {
+		return nil, ErrTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	if format == "jpeg" {
+		img = orient(img, exifOrientation(data))
+	}
+	src := squareCrop(img.Bounds())
+	thumbs := make([]Thumbnail, 0, len(Sizes))
+	for _, size := range Sizes {
+		dst := image.NewRGBA(image.Rect(0, 0, size, size))
+		// transparent pixels would turn black in JPEG
+		stddraw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, stddraw.Src)
+		draw.CatmullRom.Scale(dst, dst.Bounds(), img, src, draw.Over, nil)
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return nil, err
+		}
+		thumbs = append(thumbs, Thumbnail{Size: size, Data: buf.Bytes()})
+	}
+	return thumbs, nil
+}
+
+// squareCrop returns the largest centred square within b
+func squareCrop(b image.Rectangle) image.Rectangle {
+	side := min(b.Dx(), b.Dy())
+	x := b.Min.X + (b.Dx()-side)/2
+	y := b.Min.Y + (b.Dy()-side)/2
+	return image.Rect(x, y, x+side, y+side)
+}
@@ -0,0 +1,299 @@
+// Package backup copies the SQLite database while the service runs and
+// restores such copies. A backup is a snapshot taken with VACUUM INTO,
+// optionally gzip compressed and then encrypted with a passphrase.
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"services/user/internal/migrations"
+)
+
+var (
+	// ErrNotSQLite is returned when backing up a database of another driver
+	ErrNotSQLite = errors.New("backup: only SQLite databases can be backed up; use the database's own tools")
+	// ErrEncrypted means a backup is encrypted but no passphrase was given
+	ErrEncrypted = errors.New("backup: backup is encrypted, a passphrase is required")
+	// ErrInvalid means the input is not a backup of this service's database
+	ErrInvalid = errors.New("backup: not a user service database")
+)
+
+// sqliteHeader starts every SQLite database file
+const sqliteHeader = "SQLite format 3\x00"
+
+// Options selects how a backup is encoded
+type Options struct {
+	// Compress gzips the snapshot
+	Compress bool
+	// Passphrase, when set, encrypts the backup with a key derived from it
+	Passphrase string
+}
+
+// FileName is the name of a backup taken at t: user-<UTC time>.db, with .gz
+// when compressed and .enc when encrypted. Names sort by time.
+func FileName(t time.Time, opts Options) string {
+	name := "user-" + t.UTC().Format("20060102T150405Z") + ".db"
+	if opts.Compress {
+		name += ".gz"
+	}
+	if opts.Passphrase != "" {
+		name += ".enc"
+	}
+	return name
+}
+
+// Write takes a consistent snapshot of db, which may be in use, and writes it
+// to w encoded as opts asks
+func Write(ctx context.Context, db *gorm.DB, w io.Writer, opts Options) error {
+	if db.Dialector.Name() != "sqlite" {
+		return ErrNotSQLite
+	}
+	dir, err := os.MkdirTemp("", "user-backup-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	snap := filepath.Join(dir, "snapshot.db")
+	if err := db.WithContext(ctx).Exec("VACUUM INTO ?", snap).Error; err != nil {
+		return fmt.Errorf("snapshot database: %w", err)
+	}
+	f, err := os.Open(snap)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return encode(w, f, opts)
+}
+
+func encode(w io.Writer, r io.Reader, opts Options) error {
+	out := w
+	var ew *encryptWriter
+	if opts.Passphrase != "" {
+		var err error
+		if ew, err = newEncryptWriter(w, opts.Passphrase); err != nil {
+			return err
+		}
+		out = ew
+	}
+	var zw *gzip.Writer
+	if opts.Compress {
+		zw = gzip.NewWriter(out)
+		out = zw
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		return err
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return err
+		}
+	}
+	if ew != nil {
+		return ew.Close()
+	}
+	return nil
+}
+
+// WriteFile writes a backup of db to path, which only appears once complete
+func WriteFile(ctx context.Context, db *gorm.DB, path string, opts Options) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-"+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	err = Write(ctx, db, f, opts)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Info describes a verified backup
+type Info struct {
+	// Version is the latest migration applied to the backed up database
+	Version int
+	// Pending lists the migrations of this build the backup lacks; they are
+	// applied when the service starts
+	Pending []string
+	// Previous is where the database the backup replaced was moved, if any
+	Previous string
+}
+
+// Restore decodes the backup read from r, whose compression and encryption
+// are detected, verifies it and makes it the database at dbPath. The current
+// database, if any, is moved aside to dbPath.pre-restore. The service must
+// not be running.
+func Restore(ctx context.Context, r io.Reader, dbPath, passphrase string) (*Info, error) {
+	src, err := decode(bufio.NewReader(r), passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dbPath), ".restore-*.db")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, src)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := Verify(ctx, tmp.Name())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(dbPath); err == nil {
+		info.Previous = dbPath + ".pre-restore"
+		if err := os.Rename(dbPath, info.Previous); err != nil {
+			return nil, err
+		}
+	}
+	// a journal left next to the old database would be applied to the new one
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		if _, err := os.Stat(dbPath + suffix); err == nil {
+			if err := os.Rename(dbPath+suffix, dbPath+".pre-restore"+suffix); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := os.Rename(tmp.Name(), dbPath); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// decode undoes the encryption and compression of a backup
+func decode(r *bufio.Reader, passphrase string) (io.Reader, error) {
+	src := r
+	if head, _ := r.Peek(len(magic)); string(head) == magic {
+		if passphrase == "" {
+			return nil, ErrEncrypted
+		}
+		d, err := newDecryptReader(r, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		src = bufio.NewReader(d)
+	}
+	if head, _ := src.Peek(2); bytes.Equal(head, []byte{0x1f, 0x8b}) {
+		return gzip.NewReader(src)
+	}
+	return src, nil
+}
+
+// Verify checks that the SQLite file at path is intact and that its schema
+// is one this build can run, and reports its schema version
+func Verify(ctx context.Context, path string) (*Info, error) {
+	head := make([]byte, len(sqliteHeader))
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.ReadFull(f, head)
+	f.Close()
+	if err != nil || string(head) != sqliteHeader {
+		return nil, ErrInvalid
+	}
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		return nil, err
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+	db = db.WithContext(ctx)
+	var problems []string
+	if err := db.Raw("PRAGMA integrity_check").Scan(&problems).Error; err != nil {
+		return nil, err
+	}
+	if len(problems) != 1 || problems[0] != "ok" {
+		return nil, fmt.Errorf("backup: integrity check failed: %s", strings.Join(problems, "; "))
+	}
+	if !db.Migrator().HasTable("schema_migrations") {
+		return nil, ErrInvalid
+	}
+	m, err := migrations.New(db)
+	if err != nil {
+		return nil, err
+	}
+	sts, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+	info := &Info{}
+	var unknown []string
+	for _, st := range sts {
+		name := fmt.Sprintf("%04d_%s", st.Version, st.Name)
+		switch {
+		case st.Unknown:
+			unknown = append(unknown, name)
+		case !st.Applied:
+			info.Pending = append(info.Pending, name)
+		case st.Version > info.Version:
+			info.Version = st.Version
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("%w: backup has migrations newer than this build: %s", migrations.ErrSchemaMismatch, strings.Join(unknown, ", "))
+	}
+	if info.Version == 0 {
+		return nil, ErrInvalid
+	}
+	return info, nil
+}
+
+// List returns the backups in dir named by FileName, oldest first
+func List(dir string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "user-*.db*"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// Prune removes all but the newest keep backups in dir and returns the
+// removed paths
+func Prune(dir string, keep int) ([]string, error) {
+	paths, err := List(dir)
+	if err != nil || len(paths) <= keep {
+		return nil, err
+	}
+	var removed []string
+	for _, p := range paths[:len(paths)-keep] {
+		if err := os.Remove(p); err != nil {
+			return removed, err
+		}
+		removed = append(removed, p)
+	}
+	return removed, nil
+}
@@ -0,0 +1,139 @@
+package backup_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"services/user/internal/backup"
+	"services/user/internal/migrations"
+	"services/user/internal/models"
+)
+
+// recordSize is the size of a full chunk of an encrypted backup, with its
+// flag, length and GCM tag
+const recordSize = 5 + 64<<10 + 16
+
+func openDB(t *testing.T, path string) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("open %s: %v", path, err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// backupOf returns a backup of a migrated database holding one user
+func backupOf(t *testing.T, opts backup.Options) []byte {
+	t.Helper()
+	db := openDB(t, filepath.Join(t.TempDir(), "user.db"))
+	m, err := migrations.New(db)
+	if err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+	if _, err := m.Up(); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if err := db.Create(&models.User{Email: "kept@example.com"}).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := backup.Write(context.Background(), db, &buf, opts); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, opts := range []backup.Options{{}, {Compress: true}, {Passphrase: "correct horse"}, {Compress: true, Passphrase: "correct horse"}} {
+		data := backupOf(t, opts)
+		if opts.Passphrase != "" && bytes.Contains(data, []byte("kept@example.com")) {
+			t.Errorf("backup with %+v holds the plaintext", opts)
+		}
+		dbPath := filepath.Join(t.TempDir(), "data", "user.db")
+		info, err := backup.Restore(context.Background(), bytes.NewReader(data), dbPath, opts.Passphrase)
+		if err != nil {
+			t.Fatalf("Restore of a backup with %+v: %v", opts, err)
+		}
+		if info.Version == 0 || len(info.Pending) != 0 || info.Previous != "" {
+			t.Errorf("Restore of a backup with %+v = %+v; want the latest version and no previous database", opts, info)
+		}
+		var n int64
+		if err := openDB(t, dbPath).Model(&models.User{}).Where("email = ?", "kept@example.com").Count(&n).Error; err != nil || n != 1 {
+			t.Errorf("users restored from a backup with %+v = %d, %v; want 1", opts, n, err)
+		}
+	}
+}
+
+func TestRestoreReplacesDatabase(t *testing.T) {
+	data := backupOf(t, backup.Options{Passphrase: "correct horse"})
+	dbPath := filepath.Join(t.TempDir(), "user.db")
+	if err := os.WriteFile(dbPath, []byte("old"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	info, err := backup.Restore(context.Background(), bytes.NewReader(data), dbPath, "correct horse")
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if old, err := os.ReadFile(info.Previous); err != nil || string(old) != "old" {
+		t.Errorf("previous database at %q = %q, %v; want the old one", info.Previous, old, err)
+	}
+}
+
+func TestRestoreFailures(t *testing.T) {
+	data := backupOf(t, backup.Options{Passphrase: "correct horse"})
+	if len(data) < 2*recordSize {
+		t.Fatalf("backup is %d bytes; want more than one chunk", len(data))
+	}
+	header := len("USRBAK1\n") + 16
+	tests := []struct {
+		name       string
+		data       []byte
+		passphrase string
+		want       error
+	}{
+		{"wrong passphrase", data, "wrong horse", backup.ErrPassphrase},
+		{"no passphrase", data, "", backup.ErrEncrypted},
+		{"truncated header", data[:header-4], "correct horse", backup.ErrPassphrase},
+		{"header only", data[:header], "correct horse", backup.ErrPassphrase},
+		{"truncated chunk", data[:header+recordSize/2], "correct horse", backup.ErrPassphrase},
+		{"truncated after a chunk", data[:header+recordSize], "correct horse", backup.ErrPassphrase},
+		{"last byte cut", data[:len(data)-1], "correct horse", backup.ErrPassphrase},
+		{"data appended", append(append([]byte(nil), data...), 0), "correct horse", backup.ErrPassphrase},
+		{"flipped byte", flip(data, header+recordSize+100), "correct horse", backup.ErrPassphrase},
+		{"not a backup", []byte("hello"), "", backup.ErrInvalid},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dbPath := filepath.Join(t.TempDir(), "user.db")
+			if err := os.WriteFile(dbPath, []byte("old"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := backup.Restore(context.Background(), bytes.NewReader(tc.data), dbPath, tc.passphrase); !errors.Is(err, tc.want) {
+				t.Errorf("Restore error = %v; want %v", err, tc.want)
+			}
+			if old, err := os.ReadFile(dbPath); err != nil || string(old) != "old" {
+				t.Errorf("database after a failed restore = %q, %v; want it untouched", old, err)
+			}
+		})
+	}
+}
+
+// flip returns a copy of data with the byte at i changed
+func flip(data []byte, i int) []byte {
+	c := append([]byte(nil), data...)
+	c[i] ^= 1
+	return c
+}
@@ -0,0 +1,175 @@
+package backup
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encrypted backups start with magic and a random salt, followed by records
+// of a flag byte, the big-endian length of the sealed chunk and the chunk.
+// Each chunk is AES-256-GCM sealed under the key derived from the passphrase
+// and salt, with its sequence number as nonce and the flag as additional
+// data; the flag marks the last chunk, so a truncated backup is detected.
+const (
+	magic     = "USRBAK1\n"
+	saltSize  = 16
+	chunkSize = 64 << 10
+
+	flagMore  = 0
+	flagFinal = 1
+)
+
+// ErrPassphrase means an encrypted backup could not be opened with the
+// passphrase, or was corrupted or truncated
+var ErrPassphrase = errors.New("backup: wrong passphrase or corrupt backup")
+
+func deriveKey(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(aead cipher.AEAD, seq uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], seq)
+	return nonce
+}
+
+// encryptWriter seals everything written to it in chunks; Close writes the
+// final chunk but does not close the underlying writer
+type encryptWriter struct {
+	w    io.Writer
+	aead cipher.AEAD
+	buf  []byte
+	seq  uint64
+}
+
+func newEncryptWriter(w io.Writer, passphrase string) (*encryptWriter, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(w, magic); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(salt); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, buf: make([]byte, 0, chunkSize)}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if len(e.buf) == chunkSize {
+			if err := e.flush(flagMore); err != nil {
+				return n - len(p), err
+			}
+		}
+		k := copy(e.buf[len(e.buf):chunkSize], p)
+		e.buf = e.buf[:len(e.buf)+k]
+		p = p[k:]
+	}
+	return n, nil
+}
+
+func (e *encryptWriter) flush(flag byte) error {
+	sealed := e.aead.Seal(nil, chunkNonce(e.aead, e.seq), e.buf, []byte{flag})
+	e.seq++
+	e.buf = e.buf[:0]
+	var hdr [5]byte
+	hdr[0] = flag
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(sealed)))
+	if _, err := e.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+func (e *encryptWriter) Close() error {
+	return e.flush(flagFinal)
+}
+
+// decryptReader opens the chunks written by encryptWriter
+type decryptReader struct {
+	r    *bufio.Reader
+	aead cipher.AEAD
+	buf  []byte
+	seq  uint64
+	done bool
+}
+
+// newDecryptReader reads the header of an encrypted backup from r, which is
+// positioned at its magic
+func newDecryptReader(r *bufio.Reader, passphrase string) (*decryptReader, error) {
+	hdr := make([]byte, len(magic)+saltSize)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, ErrPassphrase
+	}
+	aead, err := deriveKey(passphrase, hdr[len(magic):])
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{r: r, aead: aead}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+func (d *decryptReader) next() error {
+	var hdr [5]byte
+	if _, err := io.ReadFull(d.r, hdr[:]); err != nil {
+		return ErrPassphrase
+	}
+	size := binary.BigEndian.Uint32(hdr[1:])
+	if size > chunkSize+uint32(d.aead.Overhead()) {
+		return ErrPassphrase
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		return ErrPassphrase
+	}
+	pt, err := d.aead.Open(sealed[:0], chunkNonce(d.aead, d.seq), sealed, hdr[:1])
+	if err != nil {
+		return ErrPassphrase
+	}
+	d.seq++
+	d.buf = pt
+	if hdr[0] == flagFinal {
+		d.done = true
+		if _, err := d.r.Peek(1); err != io.EOF {
+			return fmt.Errorf("%w: data after the last chunk", ErrPassphrase)
+		}
+	}
+	return nil
+}
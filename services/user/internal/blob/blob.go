@@ -0,0 +1,104 @@
+// Package blob stores binary objects such as avatar images behind a small
+// interface, with implementations for the local filesystem and S3-compatible
+// object stores.
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotFound is returned for keys that hold no object
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps objects under slash-separated keys like avatars/12/ab/256.jpg
+type Store interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get returns the object and its content type; the caller closes it
+	Get(ctx context.Context, key string) (io.ReadCloser, string, error)
+	// Delete removes an object; deleting a missing object is not an error
+	Delete(ctx context.Context, key string) error
+}
+
+// ValidKey reports whether key is a clean relative path that cannot escape
+// the store, e.g. through "..".
+func ValidKey(key string) bool {
+	return key != "" && !strings.HasPrefix(key, "/") && path.Clean(key) == key && !strings.HasPrefix(key, "../") && key != ".." && !strings.ContainsAny(key, "\\\x00")
+}
+
+// Local stores objects as files below Dir. The content type is derived from
+// the key's extension.
+type Local struct {
+	Dir string
+}
+
+func (l *Local) file(key string) (string, error) {
+	if !ValidKey(key) {
+		return "", errors.New("invalid blob key")
+	}
+	return filepath.Join(l.Dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes to a temporary file and renames it into place, so readers
+// never see a partial object
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	name, err := l.file(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, string, error) {
+	name, err := l.file(key)
+	if err != nil {
+		return nil, "", err
+	}
+	f, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, "", ErrNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	ct := mime.TypeByExtension(path.Ext(key))
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	return f, ct, nil
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	name, err := l.file(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
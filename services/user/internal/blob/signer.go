@@ -0,0 +1,58 @@
+package blob
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidSignature is returned for media URLs that were tampered with or expired
+var ErrInvalidSignature = errors.New("invalid or expired signature")
+
+// Signer issues and verifies time-limited URLs for objects, so they can be
+// fetched without a bearer token, e.g. from an <img> tag
+type Signer struct {
+	Key []byte
+	// BaseURL is prepended to the signed paths; empty yields relative URLs
+	BaseURL string
+	// Prefix is the path the objects are served under, e.g. /media/
+	Prefix string
+	// TTL is how long a URL stays valid, at least; expiries are rounded up to
+	// a multiple of TTL so the same object gets the same URL for a while and
+	// stays cacheable by clients
+	TTL time.Duration
+}
+
+func (s *Signer) sign(key string, expires int64) string {
+	m := hmac.New(sha256.New, s.Key)
+	m.Write([]byte(key + "\n" + strconv.FormatInt(expires, 10)))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+// URL returns a signed URL for key that is valid for between TTL and twice TTL
+func (s *Signer) URL(key string, now time.Time) string {
+	ttl := int64(s.TTL / time.Second)
+	if ttl <= 0 {
+		ttl = 3600
+	}
+	expires := (now.Unix()/ttl + 2) * ttl
+	q := url.Values{"expires": {strconv.FormatInt(expires, 10)}, "sig": {s.sign(key, expires)}}
+	return strings.TrimSuffix(s.BaseURL, "/") + s.Prefix + key + "?" + q.Encode()
+}
+
+// Verify checks the expires and sig parameters of a request for key
+func (s *Signer) Verify(key string, q url.Values, now time.Time) error {
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil || now.Unix() > expires {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(q.Get("sig")), []byte(s.sign(key, expires))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+// Package broadcast fans messages out to the live sessions of a user, such as
+// the settings event streams of their devices. It is in-process: sessions
+// connected to another replica are not reached.
+package broadcast
+
+import "sync"
+
+// subscriptionBuffer is how many messages a slow subscriber may fall behind
+// before further messages are dropped for it
+const subscriptionBuffer = 16
+
+// Hub keeps the subscriptions of every user
+type Hub struct {
+	mu   sync.Mutex
+	subs map[uint]map[*Subscription]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: map[uint]map[*Subscription]struct{}{}}
+}
+
+// Subscription receives the messages published to one user
+type Subscription struct {
+	// C delivers the messages; it is closed by Close
+	C       <-chan []byte
+	c       chan []byte
+	hub     *Hub
+	userID  uint
+	session string
+}
+
+// Subscribe registers a session of userID. session identifies the device so
+// that its own changes are not echoed back; it may be empty.
+func (h *Hub) Subscribe(userID uint, session string) *Subscription {
+	c := make(chan []byte, subscriptionBuffer)
+	s := &Subscription{C: c, c: c, hub: h, userID: userID, session: session}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs[userID] == nil {
+		h.subs[userID] = map[*Subscription]struct{}{}
+	}
+	h.subs[userID][s] = struct{}{}
+	return s
+}
+
+// Close unregisters the subscription and closes C
+func (s *Subscription) Close() {
+	h := s.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[s.userID][s]; !ok {
+		return
+	}
+	delete(h.subs[s.userID], s)
+	if len(h.subs[s.userID]) == 0 {
+		delete(h.subs, s.userID)
+	}
+	close(s.c)
+}
+
+// Publish sends msg to every session of userID except exceptSession. It
+// never blocks: subscribers whose buffer is full miss the message.
+func (h *Hub) Publish(userID uint, exceptSession string, msg []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs[userID] {
+		if exceptSession != "" && s.session == exceptSession {
+			continue
+		}
+		select {
+		case s.c <- msg:
+		default:
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package bulk
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"services/user/internal/models"
+	"services/user/internal/store"
+)
+
+const (
+	// DefaultBatchSize is how many rows an import commits together by default
+	DefaultBatchSize = 100
+	// MaxBatchSize bounds the rows of one import transaction
+	MaxBatchSize = 1000
+	// maxRowErrors bounds the errors a report lists; all are still counted
+	maxRowErrors = 1000
+	// exportBatch is how many users Export reads per query
+	exportBatch = 500
+)
+
+// ImportOptions control an import
+type ImportOptions struct {
+	// DryRun validates every row and creates the users in transactions that
+	// are rolled back, so the report shows what an import would do
+	DryRun bool
+	// BatchSize is how many rows are committed together; defaults to
+	// DefaultBatchSize
+	BatchSize int
+	// Audit is copied into the event recorded with every committed batch;
+	// set the action and actor
+	Audit models.AuditEvent
+}
+
+// RowError is a row that was not imported
+type RowError struct {
+	Line  int    `json:"line"`
+	Email string `json:"email,omitempty"`
+	Error string `json:"error"`
+}
+
+// Report is the outcome of an import
+type Report struct {
+	DryRun bool `json:"dry_run"`
+	// Rows counts the records read
+	Rows int `json:"rows"`
+	// Created counts the users created, or that would be in a dry run
+	Created int `json:"created"`
+	// Failed counts the rows that were not imported
+	Failed int        `json:"failed"`
+	Errors []RowError `json:"errors"`
+	// Truncated is set when more rows failed than Errors lists
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+func (rep *Report) fail(row *Row, msg string) {
+	rep.Failed++
+	if len(rep.Errors) == maxRowErrors {
+		rep.Truncated = true
+		return
+	}
+	rep.Errors = append(rep.Errors, RowError{Line: row.Line, Email: row.Email, Error: msg})
+}
+
+// Importer creates the users of a file
+type Importer struct {
+	Store store.Repository
+	// Created, when set, runs in the transaction of each created user with
+	// their role names, e.g. to queue a webhook
+	Created func(ctx context.Context, tx store.Repository, u *models.User, roles []string) error
+}
+
+// rowError is a problem with one row that does not stop the import
+type rowError string
+
+func (e rowError) Error() string { return string(e) }
+
+// errDryRun rolls back the transaction of a dry run batch
+var errDryRun = errors.New("dry run")
+
+// Import reads every row of r and creates a user for each valid one. Rows
+// are checked before they are written: the email must be well-formed and
+// not repeat an earlier row, a password hash must be bcrypt, and the roles,
+// which default to "user", must exist. Valid rows are created in batches,
+// each committed in one transaction together with an audit event; a row
+// that still fails, e.g. because its email is already registered, is rolled
+// back to a savepoint and reported without failing its batch.
+//
+// A returned error means the import stopped, for instance because the file
+// is malformed or the database failed; the report then covers the rows read
+// so far, and batches committed before stay committed.
+func (im *Importer) Import(ctx context.Context, r *Reader, opts ImportOptions) (*Report, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.BatchSize > MaxBatchSize {
+		opts.BatchSize = MaxBatchSize
+	}
+	rep := &Report{DryRun: opts.DryRun, Errors: []RowError{}}
+	err := im.run(ctx, r, opts, rep)
+	// rows failing at insert are reported after those failing the checks
+	sort.SliceStable(rep.Errors, func(i, j int) bool { return rep.Errors[i].Line < rep.Errors[j].Line })
+	return rep, err
+}
+
+func (im *Importer) run(ctx context.Context, r *Reader, opts ImportOptions, rep *Report) error {
+	seen := map[string]int{}
+	roles := map[string]uint{}
+	var batch []*Row
+	for {
+		row, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		rep.Rows++
+		if msg := check(row, seen); msg != "" {
+			rep.fail(row, msg)
+			continue
+		}
+		seen[row.Email] = row.Line
+		if batch = append(batch, row); len(batch) == opts.BatchSize {
+			if err := im.commit(ctx, batch, roles, opts, rep); err != nil {
+				return err
+			}
+			batch = nil
+		}
+	}
+	if len(batch) > 0 {
+		return im.commit(ctx, batch, roles, opts, rep)
+	}
+	return nil
+}
+
+// check validates a row on its own and against the rows before it
+func check(row *Row, seen map[string]int) string {
+	switch {
+	case row.Err != nil:
+		return row.Err.Error()
+	case row.Email == "":
+		return "email is required"
+	case !models.ValidEmail(row.Email):
+		return "invalid email"
+	case seen[row.Email] != 0:
+		return "duplicate of the row on line " + strconv.Itoa(seen[row.Email])
+	}
+	if row.PasswordHash != "" {
+		if _, err := bcrypt.Cost([]byte(row.PasswordHash)); err != nil {
+			return "password_hash is not a bcrypt hash"
+		}
+	}
+	return ""
+}
+
+// commit creates the users of one batch in a transaction
+func (im *Importer) commit(ctx context.Context, batch []*Row, roles map[string]uint, opts ImportOptions, rep *Report) error {
+	if opts.DryRun {
+		// roles created on demand by a dry run are rolled back with it
+		roles = map[string]uint{}
+	}
+	ev := opts.Audit
+	ids := []uint{}
+	var failed []*Row
+	var msgs []string
+	fn := func(tx store.Repository) error {
+		ids, failed, msgs = ids[:0], failed[:0], msgs[:0]
+		for _, row := range batch {
+			var u *models.User
+			err := tx.WithTx(ctx, func(tx store.Repository) error {
+				var err error
+				u, err = im.create(ctx, tx, row, roles)
+				return err
+			})
+			var rerr rowError
+			switch {
+			case errors.As(err, &rerr):
+				failed, msgs = append(failed, row), append(msgs, rerr.Error())
+			case errors.Is(err, gorm.ErrDuplicatedKey):
+				failed, msgs = append(failed, row), append(msgs, "email already registered")
+			case err != nil:
+				return err
+			default:
+				ids = append(ids, u.ID)
+			}
+		}
+		ev.Diff = map[string]interface{}{"created": len(ids), "user_ids": ids}
+		if opts.DryRun {
+			return errDryRun
+		}
+		return nil
+	}
+	var err error
+	if opts.DryRun {
+		if err = im.Store.WithTx(ctx, fn); errors.Is(err, errDryRun) {
+			err = nil
+		}
+	} else {
+		err = im.Store.Audited(ctx, &ev, fn)
+	}
+	if err != nil {
+		return err
+	}
+	rep.Created += len(ids)
+	for i, row := range failed {
+		rep.fail(row, msgs[i])
+	}
+	return nil
+}
+
+// create inserts the user of a row and assigns their roles
+func (im *Importer) create(ctx context.Context, tx store.Repository, row *Row, roles map[string]uint) (*models.User, error) {
+	names := row.Roles
+	if len(names) == 0 {
+		names = []string{"user"}
+	}
+	// roles looked up, or created, here are only cached once the row has
+	// succeeded, as its savepoint may be rolled back
+	found := map[string]uint{}
+	ids := make([]uint, 0, len(names))
+	for _, name := range names {
+		id, ok := roles[name]
+		if !ok {
+			var role *models.Role
+			var err error
+			if name == "user" {
+				// the default role is created on demand, as at registration
+				role, err = tx.EnsureRole(ctx, name)
+			} else {
+				role, err = tx.GetRoleByName(ctx, name)
+			}
+			if errors.Is(err, store.ErrNotFound) {
+				return nil, rowError("unknown role " + name)
+			}
+			if err != nil {
+				return nil, err
+			}
+			id = role.ID
+			found[name] = id
+		}
+		ids = append(ids, id)
+	}
+	u := &models.User{Email: row.Email, FullName: row.FullName, Password: row.PasswordHash}
+	if err := tx.CreateUser(ctx, u); err != nil {
+		return nil, err
+	}
+	assigned := map[uint]bool{}
+	for _, id := range ids {
+		if assigned[id] {
+			continue
+		}
+		assigned[id] = true
+		if err := tx.AssignRoleToUser(ctx, u.ID, id); err != nil {
+			return nil, err
+		}
+	}
+	if im.Created != nil {
+		if err := im.Created(ctx, tx, u, names); err != nil {
+			return nil, err
+		}
+	}
+	for name, id := range found {
+		roles[name] = id
+	}
+	return u, nil
+}
+
+// Export writes every user that is not deleted, with the roles assigned to
+// them directly, to w in ID order and returns how many it wrote. Password
+// hashes are only written with withPasswords.
+func Export(ctx context.Context, s store.Repository, w *Writer, withPasswords bool) (int, error) {
+	n := 0
+	f := store.UserFilter{Sort: store.UserSortID, Limit: exportBatch}
+	for {
+		users, err := s.ListUsers(ctx, f)
+		if err != nil || len(users) == 0 {
+			return n, err
+		}
+		ids := make([]uint, len(users))
+		for i, u := range users {
+			ids[i] = u.ID
+		}
+		roles, err := s.GetDirectRolesForUsers(ctx, ids)
+		if err != nil {
+			return n, err
+		}
+		for _, u := range users {
+			rec := Record{Email: u.Email, FullName: u.FullName, Roles: []string{}}
+			for _, r := range roles[u.ID] {
+				rec.Roles = append(rec.Roles, r.Name)
+			}
+			if withPasswords {
+				rec.PasswordHash = u.Password
+			}
+			if err := w.Write(rec); err != nil {
+				return n, err
+			}
+			n++
+		}
+		if err := w.Flush(); err != nil {
+			return n, err
+		}
+		f.After = &store.UserCursor{Sort: store.UserSortID, ID: users[len(users)-1].ID}
+	}
+}
@@ -0,0 +1,228 @@
+// Package bulk imports users from and exports them to CSV or NDJSON files,
+// so a whole team can be onboarded, or moved between deployments, at once.
+// Both directions use the same record layout, so an export can be imported
+// again.
+package bulk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// File formats
+const (
+	// FormatCSV has a header row naming the columns email, full_name, roles
+	// and password_hash in any order; only email is required. Roles are
+	// separated by semicolons.
+	FormatCSV = "csv"
+	// FormatNDJSON has one JSON object per line
+	FormatNDJSON = "ndjson"
+)
+
+// columns lists the CSV columns in the order exports write them
+var columns = []string{"email", "full_name", "roles", "password_hash"}
+
+// ErrFormat is returned for an unknown format or a file that cannot be read
+// as the format at all, such as a CSV file without an email column
+var ErrFormat = errors.New("bulk: invalid file format")
+
+// Record is one user of a file
+type Record struct {
+	Email    string   `json:"email"`
+	FullName string   `json:"full_name,omitempty"`
+	Roles    []string `json:"roles,omitempty"`
+	// PasswordHash is a bcrypt hash; without one the user cannot log in
+	// until an admin sets a password
+	PasswordHash string `json:"password_hash,omitempty"`
+}
+
+// ParseFormat validates a format name
+func ParseFormat(s string) (string, error) {
+	switch strings.ToLower(s) {
+	case FormatCSV:
+		return FormatCSV, nil
+	case FormatNDJSON, "jsonl":
+		return FormatNDJSON, nil
+	}
+	return "", fmt.Errorf("%w: unknown format %q, want csv or ndjson", ErrFormat, s)
+}
+
+// FormatOf guesses the format of a file from its extension
+func FormatOf(path string) (string, error) {
+	return ParseFormat(strings.TrimPrefix(filepath.Ext(path), "."))
+}
+
+// ContentType is the media type of format
+func ContentType(format string) string {
+	if format == FormatCSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/x-ndjson"
+}
+
+// Row is a record read from a file with the line it starts on. Err is set
+// when the line could not be decoded; the rest of the file can still be read.
+type Row struct {
+	Line int
+	Record
+	Err error
+}
+
+// Reader reads the records of a file
+type Reader struct {
+	csv    *csv.Reader
+	index  map[string]int
+	lines  *bufio.Reader
+	lineNo int
+}
+
+// NewReader returns a Reader for a file in format. A CSV header is read and
+// checked right away.
+func NewReader(r io.Reader, format string) (*Reader, error) {
+	if format == FormatNDJSON {
+		return &Reader{lines: bufio.NewReader(r)}, nil
+	}
+	if format != FormatCSV {
+		return nil, fmt.Errorf("%w: unknown format %q", ErrFormat, format)
+	}
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: empty file", ErrFormat)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	index := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if !validColumn(name) {
+			return nil, fmt.Errorf("%w: unknown column %q, want %s", ErrFormat, name, strings.Join(columns, ", "))
+		}
+		if _, dup := index[name]; dup {
+			return nil, fmt.Errorf("%w: duplicate column %q", ErrFormat, name)
+		}
+		index[name] = i
+	}
+	if _, ok := index["email"]; !ok {
+		return nil, fmt.Errorf("%w: missing email column", ErrFormat)
+	}
+	return &Reader{csv: cr, index: index}, nil
+}
+
+func validColumn(name string) bool {
+	for _, c := range columns {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns the next row, or io.EOF after the last one. Other errors
+// mean the file cannot be read any further.
+func (r *Reader) Next() (*Row, error) {
+	if r.csv != nil {
+		return r.nextCSV()
+	}
+	return r.nextNDJSON()
+}
+
+func (r *Reader) nextCSV() (*Row, error) {
+	fields, err := r.csv.Read()
+	if err != nil {
+		var perr *csv.ParseError
+		if errors.As(err, &perr) {
+			// a broken quote leaves the reader out of step with the rows
+			return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+		}
+		return nil, err
+	}
+	line, _ := r.csv.FieldPos(0)
+	if len(fields) > len(r.index) {
+		return &Row{Line: line, Err: fmt.Errorf("%d fields, header has %d", len(fields), len(r.index))}, nil
+	}
+	get := func(name string) string {
+		if i, ok := r.index[name]; ok && i < len(fields) {
+			return strings.TrimSpace(fields[i])
+		}
+		return ""
+	}
+	row := &Row{Line: line, Record: Record{Email: get("email"), FullName: get("full_name"), PasswordHash: get("password_hash")}}
+	for _, name := range strings.Split(get("roles"), ";") {
+		if name = strings.TrimSpace(name); name != "" {
+			row.Roles = append(row.Roles, name)
+		}
+	}
+	return row, nil
+}
+
+func (r *Reader) nextNDJSON() (*Row, error) {
+	for {
+		raw, err := r.lines.ReadBytes('\n')
+		if len(raw) == 0 && err != nil {
+			return nil, err
+		}
+		r.lineNo++
+		raw = bytes.TrimSpace(raw)
+		if len(raw) == 0 {
+			continue
+		}
+		row := &Row{Line: r.lineNo}
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&row.Record); err != nil {
+			row.Err = fmt.Errorf("invalid JSON: %v", err)
+		} else if dec.More() {
+			row.Err = errors.New("invalid JSON: more than one value on the line")
+		}
+		return row, nil
+	}
+}
+
+// Writer writes records in a format
+type Writer struct {
+	csv *csv.Writer
+	enc *json.Encoder
+}
+
+// NewWriter returns a Writer for format; a CSV header is written first
+func NewWriter(w io.Writer, format string) (*Writer, error) {
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(columns); err != nil {
+			return nil, err
+		}
+		return &Writer{csv: cw}, nil
+	case FormatNDJSON:
+		return &Writer{enc: json.NewEncoder(w)}, nil
+	}
+	return nil, fmt.Errorf("%w: unknown format %q", ErrFormat, format)
+}
+
+// Write writes one record
+func (w *Writer) Write(rec Record) error {
+	if w.csv != nil {
+		return w.csv.Write([]string{rec.Email, rec.FullName, strings.Join(rec.Roles, ";"), rec.PasswordHash})
+	}
+	return w.enc.Encode(rec)
+}
+
+// Flush writes out buffered records
+func (w *Writer) Flush() error {
+	if w.csv != nil {
+		w.csv.Flush()
+		return w.csv.Error()
+	}
+	return nil
+}
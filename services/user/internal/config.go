@@ -1,17 +1,107 @@
 package internal
 
 import (
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/hex"
 	"log"
 	"os"
+	"strconv"
+	"time"
 )
 
 // Config holds application configuration
 type Config struct {
-	DBPath           string
+	// DBDriver selects the database backend: sqlite, postgres or mysql
+	DBDriver string
+	// DBDSN is the connection string for postgres and mysql; sqlite uses DBPath
+	DBDSN             string
+	DBPath            string
+	DBMaxOpenConns    int
+	DBMaxIdleConns    int
+	DBConnMaxLifetime time.Duration
+	// DBQueryTimeout bounds every database query; 0 disables it
+	DBQueryTimeout time.Duration
+	// RequestTimeout is the deadline of every HTTP request, including its queries;
+	// TransferTimeout replaces it for bulk imports and exports and media
+	// downloads, which move more data. 0 disables either.
+	RequestTimeout   time.Duration
+	TransferTimeout  time.Duration
 	JWTSecret        string
 	ListenAddr       string
 	DiscoveryEnabled bool
 	DiscoveryAddr    string
+	// Migrations is "auto" to apply pending migrations on startup or "verify"
+	// to refuse to start while the schema is behind
+	Migrations string
+	// LogSQL logs every SQL statement; CLI commands turn it off to keep output readable
+	LogSQL bool
+	// AuditSigningKey signs audit chain checkpoints; by default it is
+	// derived from JWTSecret
+	AuditSigningKey string
+	// AuditCheckpointInterval is how often the audit chain head is signed; 0 disables checkpoints
+	AuditCheckpointInterval time.Duration
+	// WebhookPollInterval is how often queued webhook deliveries are attempted
+	WebhookPollInterval time.Duration
+	// WebhookMaxAttempts is the number of attempts before a delivery is dead-lettered
+	WebhookMaxAttempts int
+	// DeletedUserRetention is how long soft-deleted users are kept before
+	// being purged; 0 keeps them until purged by an admin
+	DeletedUserRetention time.Duration
+	// SMTPAddr is the host:port of the relay for outgoing email; empty logs
+	// emails instead of sending them
+	SMTPAddr     string
+	SMTPFrom     string
+	SMTPUsername string
+	SMTPPassword string
+	// EmailChangeTTL is how long an email change can be confirmed
+	EmailChangeTTL time.Duration
+	// EmailConfirmURL is the page linked from email change confirmations
+	EmailConfirmURL string
+	// BlobStore selects where uploaded avatars are kept: local or s3
+	BlobStore string
+	// BlobDir is the directory of the local blob store
+	BlobDir     string
+	S3Endpoint  string
+	S3Region    string
+	S3Bucket    string
+	S3AccessKey string
+	S3SecretKey string
+	// S3PathStyle addresses the bucket in the path, as MinIO needs
+	S3PathStyle bool
+	// MediaSigningKey signs the URLs avatars are served under; by default it
+	// is derived from JWTSecret
+	MediaSigningKey string
+	// MediaURLTTL is how long signed media URLs stay valid, at least
+	MediaURLTTL time.Duration
+	// PublicURL, if set, makes signed media URLs absolute, e.g. https://users.example.com
+	PublicURL string
+	// AvatarMaxBytes bounds the size of avatar uploads
+	AvatarMaxBytes int64
+	// ExportTTL is how long a finished data export can be downloaded
+	ExportTTL time.Duration
+	// AccountDeletionGrace is how long after a user asks to delete their
+	// account it is anonymized; logging in before then cancels the deletion
+	AccountDeletionGrace time.Duration
+	// FieldKeysDir holds the keys that encrypt personal data at rest; empty
+	// disables field encryption
+	FieldKeysDir string
+	// FieldKeyID names the key new values are encrypted with; empty selects
+	// the greatest key ID in FieldKeysDir
+	FieldKeyID string
+	// BackupDir receives scheduled SQLite backups; empty disables them
+	BackupDir string
+	// BackupInterval is how often a scheduled backup is taken
+	BackupInterval time.Duration
+	// BackupKeep is how many scheduled backups are kept
+	BackupKeep int
+	// BackupCompress gzips backups
+	BackupCompress bool
+	// BackupPassphrase, if set, encrypts backups and decrypts them on restore
+	BackupPassphrase string
+	// SCIMToken is the bearer token identity providers provision users with
+	// under /scim/v2; empty disables SCIM
+	SCIMToken string
 }
 
 func NewConfigFromEnv() *Config {
@@ -39,6 +129,98 @@ func NewConfigFromEnv() *Config {
 	if discAddr == "" {
 		discAddr = "239.255.255.250:9999"
 	}
-	log.Printf("config: DBPath=%s, Listen=%s", db, addr)
-	return &Config{DBPath: db, JWTSecret: jwt, ListenAddr: addr, DiscoveryEnabled: discoveryEnabled, DiscoveryAddr: discAddr}
+	auditKey := os.Getenv("AUDIT_SIGNING_KEY")
+	if auditKey == "" {
+		auditKey = deriveKey(jwt, "audit checkpoints")
+	}
+	checkpointEvery := envDuration("AUDIT_CHECKPOINT_INTERVAL", time.Hour)
+	webhookPoll := envDuration("WEBHOOK_POLL_INTERVAL", 5*time.Second)
+	if webhookPoll <= 0 {
+		webhookPoll = 5 * time.Second
+	}
+	webhookAttempts := envInt("WEBHOOK_MAX_ATTEMPTS", 8)
+	if webhookAttempts <= 0 {
+		webhookAttempts = 8
+	}
+	driver := os.Getenv("DB_DRIVER")
+	if driver == "" {
+		driver = "sqlite"
+	}
+	retention := envDuration("DELETED_USER_RETENTION", 0)
+	queryTimeout := envDuration("DB_QUERY_TIMEOUT", 5*time.Second)
+	requestTimeout := envDuration("REQUEST_TIMEOUT", 30*time.Second)
+	transferTimeout := envDuration("TRANSFER_TIMEOUT", 10*time.Minute)
+	smtpFrom := os.Getenv("SMTP_FROM")
+	if smtpFrom == "" {
+		smtpFrom = "no-reply@localhost"
+	}
+	blobStore := os.Getenv("BLOB_STORE")
+	if blobStore == "" {
+		blobStore = "local"
+	}
+	blobDir := os.Getenv("BLOB_DIR")
+	if blobDir == "" {
+		blobDir = "./data/blobs"
+	}
+	s3Region := os.Getenv("S3_REGION")
+	if s3Region == "" {
+		s3Region = "us-east-1"
+	}
+	mediaKey := os.Getenv("MEDIA_SIGNING_KEY")
+	if mediaKey == "" {
+		mediaKey = deriveKey(jwt, "media urls")
+	}
+	pathStyle := os.Getenv("S3_PATH_STYLE")
+	backupInterval := envDuration("BACKUP_INTERVAL", 24*time.Hour)
+	if backupInterval <= 0 {
+		backupInterval = 24 * time.Hour
+	}
+	backupKeep := envInt("BACKUP_KEEP", 7)
+	if backupKeep <= 0 {
+		backupKeep = 7
+	}
+	backupCompress := os.Getenv("BACKUP_COMPRESS") != "false" && os.Getenv("BACKUP_COMPRESS") != "0"
+	migrations := os.Getenv("DB_MIGRATIONS")
+	if migrations != "verify" {
+		migrations = "auto"
+	}
+	log.Printf("config: DBDriver=%s, DBPath=%s, Listen=%s, Migrations=%s, BlobStore=%s", driver, db, addr, migrations, blobStore)
+	return &Config{DBDriver: driver, DBDSN: os.Getenv("DB_DSN"), DBPath: db, DBMaxOpenConns: envInt("DB_MAX_OPEN_CONNS", 0), DBMaxIdleConns: envInt("DB_MAX_IDLE_CONNS", 0), DBConnMaxLifetime: envDuration("DB_CONN_MAX_LIFETIME", 0), DBQueryTimeout: queryTimeout, RequestTimeout: requestTimeout, TransferTimeout: transferTimeout, JWTSecret: jwt, ListenAddr: addr, DiscoveryEnabled: discoveryEnabled, DiscoveryAddr: discAddr, Migrations: migrations, LogSQL: true, AuditSigningKey: auditKey, AuditCheckpointInterval: checkpointEvery, WebhookPollInterval: webhookPoll, WebhookMaxAttempts: webhookAttempts, DeletedUserRetention: retention, SMTPAddr: os.Getenv("SMTP_ADDR"), SMTPFrom: smtpFrom, SMTPUsername: os.Getenv("SMTP_USERNAME"), SMTPPassword: os.Getenv("SMTP_PASSWORD"), EmailChangeTTL: envDuration("EMAIL_CHANGE_TTL", 24*time.Hour), EmailConfirmURL: os.Getenv("EMAIL_CONFIRM_URL"), BlobStore: blobStore, BlobDir: blobDir, S3Endpoint: os.Getenv("S3_ENDPOINT"), S3Region: s3Region, S3Bucket: os.Getenv("S3_BUCKET"), S3AccessKey: os.Getenv("S3_ACCESS_KEY"), S3SecretKey: os.Getenv("S3_SECRET_KEY"), S3PathStyle: pathStyle == "true" || pathStyle == "1" || pathStyle == "yes", MediaSigningKey: mediaKey, MediaURLTTL: envDuration("MEDIA_URL_TTL", time.Hour), PublicURL: os.Getenv("PUBLIC_URL"), AvatarMaxBytes: int64(envInt("AVATAR_MAX_BYTES", 5<<20)), ExportTTL: envDuration("EXPORT_TTL", 24*time.Hour), AccountDeletionGrace: envDuration("ACCOUNT_DELETION_GRACE", 14*24*time.Hour), FieldKeysDir: os.Getenv("FIELD_KEYS_DIR"), FieldKeyID: os.Getenv("FIELD_KEY_ID"), BackupDir: os.Getenv("BACKUP_DIR"), BackupInterval: backupInterval, BackupKeep: backupKeep, BackupCompress: backupCompress, BackupPassphrase: os.Getenv("BACKUP_PASSPHRASE"), SCIMToken: os.Getenv("SCIM_TOKEN")}
+}
+
+// deriveKey derives the key for purpose from secret with HKDF-SHA256, so
+// neither the secret nor a key for another purpose can be recovered from it
+// and a signature made with one key is never valid under another
+func deriveKey(secret, purpose string) string {
+	// fails only for keys longer than 255 hashes
+	key, _ := hkdf.Key(sha256.New, []byte(secret), nil, "user-service "+purpose, sha256.Size)
+	return hex.EncodeToString(key)
+}
+
+// envDuration reads a time.Duration such as "90s" from name, falling back to def
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("config: invalid %s=%q, using %s", name, v, def)
+		return def
+	}
+	return d
+}
+
+// envInt reads an integer from name, falling back to def
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("config: invalid %s=%q, using %d", name, v, def)
+		return def
+	}
+	return n
 }
@@ -0,0 +1,244 @@
+// Package export builds archives of everything held about a user, as
+// requested under the GDPR right of access. Jobs are queued in the database
+// and picked up by a background Worker, which writes a ZIP of JSON files to
+// blob storage.
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"services/user/internal/blob"
+	"services/user/internal/models"
+	"services/user/internal/store"
+)
+
+// FormatVersion is written to the archive manifest; bump it when the layout changes
+const FormatVersion = 1
+
+// ErrSkip is returned by a section with nothing to add for the user
+var ErrSkip = errors.New("nothing to export")
+
+// Section is one file of the archive. Data held by other services, such as
+// todos, notes and events, is added to exports by registering a section for
+// it with the Worker.
+type Section struct {
+	// Name is the file name inside the archive, e.g. profile.json
+	Name string
+	// Collect writes the user's data to w, or returns ErrSkip
+	Collect func(ctx context.Context, u *models.User, w io.Writer) error
+}
+
+// JSON is a Section that writes the value returned by collect as indented JSON
+func JSON(name string, collect func(ctx context.Context, u *models.User) (interface{}, error)) Section {
+	return Section{Name: name, Collect: func(ctx context.Context, u *models.User, w io.Writer) error {
+		v, err := collect(ctx, u)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}}
+}
+
+type Options struct {
+	PollInterval time.Duration // how often queued jobs and expired archives are looked for
+	TTL          time.Duration // how long a finished archive can be downloaded
+	MaxAttempts  int           // attempts before a job is marked failed
+	// StaleAfter is how long a running job may go without progress before
+	// another worker takes it over
+	StaleAfter time.Duration
+}
+
+// Worker builds queued exports
+type Worker struct {
+	store    *store.Store
+	blobs    blob.Store
+	sections []Section
+	opts     Options
+	wake     chan struct{}
+}
+
+func NewWorker(s *store.Store, blobs blob.Store, sections []Section, opts Options) *Worker {
+	if opts.PollInterval == 0 {
+		opts.PollInterval = 5 * time.Second
+	}
+	if opts.TTL == 0 {
+		opts.TTL = 24 * time.Hour
+	}
+	if opts.MaxAttempts == 0 {
+		opts.MaxAttempts = 3
+	}
+	if opts.StaleAfter == 0 {
+		opts.StaleAfter = 10 * time.Minute
+	}
+	return &Worker{store: s, blobs: blobs, sections: sections, opts: opts, wake: make(chan struct{}, 1)}
+}
+
+// TTL is how long finished archives can be downloaded
+func (w *Worker) TTL() time.Duration {
+	return w.opts.TTL
+}
+
+// Wake makes Run look for queued jobs now rather than at the next poll
+func (w *Worker) Wake() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run processes jobs until ctx is cancelled
+func (w *Worker) Run(ctx context.Context) {
+	log.Printf("export worker started: poll=%s, ttl=%s, sections=%d", w.opts.PollInterval, w.opts.TTL, len(w.sections))
+	t := time.NewTicker(w.opts.PollInterval)
+	defer t.Stop()
+	for {
+		w.RunOnce(ctx)
+		select {
+		case <-ctx.Done():
+			log.Printf("export worker shutting down")
+			return
+		case <-t.C:
+		case <-w.wake:
+		}
+	}
+}
+
+// RunOnce builds every queued export and deletes expired archives
+func (w *Worker) RunOnce(ctx context.Context) {
+	for ctx.Err() == nil {
+		j, err := w.store.ClaimExportJob(ctx, time.Now().Add(-w.opts.StaleAfter))
+		if errors.Is(err, store.ErrNotFound) {
+			break
+		}
+		if err != nil {
+			log.Printf("export worker: failed to claim job: %v", err)
+			return
+		}
+		w.process(ctx, j)
+	}
+	w.expire(ctx)
+}
+
+func (w *Worker) process(ctx context.Context, j *models.ExportJob) {
+	log.Printf("export started: job=%d, userID=%d, attempt=%d", j.ID, j.UserID, j.Attempts)
+	err := w.build(ctx, j)
+	if err == nil {
+		return
+	}
+	j.Error = err.Error()
+	if j.Attempts >= w.opts.MaxAttempts || errors.Is(err, store.ErrNotFound) {
+		j.Status = models.ExportFailed
+		now := time.Now()
+		j.FinishedAt = &now
+	} else {
+		j.Status = models.ExportPending
+	}
+	log.Printf("export failed: job=%d, userID=%d, status=%s, err=%v", j.ID, j.UserID, j.Status, err)
+	if err := w.store.UpdateExportJob(context.WithoutCancel(ctx), j); err != nil {
+		log.Printf("export worker: failed to update job %d: %v", j.ID, err)
+	}
+}
+
+// build writes the archive of j's user, reporting progress after each section
+func (w *Worker) build(ctx context.Context, j *models.ExportJob) error {
+	u, err := w.store.GetUserByID(ctx, j.UserID)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	now := time.Now().UTC()
+	files := []string{}
+	for i, s := range w.sections {
+		var part bytes.Buffer
+		err := s.Collect(ctx, u, &part)
+		if errors.Is(err, ErrSkip) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", s.Name, err)
+		}
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: s.Name, Method: zip.Deflate, Modified: now})
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(part.Bytes()); err != nil {
+			return err
+		}
+		files = append(files, s.Name)
+		// the upload is the last step
+		j.Progress = (i + 1) * 100 / (len(w.sections) + 1)
+		if err := w.store.UpdateExportJob(ctx, j); err != nil {
+			return err
+		}
+	}
+	manifest, _ := json.MarshalIndent(map[string]interface{}{"format_version": FormatVersion, "user_id": u.ID, "generated_at": now, "files": files}, "", "  ")
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: "manifest.json", Method: zip.Deflate, Modified: now})
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(manifest); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	key := fmt.Sprintf("exports/%d/%s/user-%d-export.zip", u.ID, hex.EncodeToString(b), u.ID)
+	if err := w.blobs.Put(ctx, key, bytes.NewReader(buf.Bytes()), int64(buf.Len()), "application/zip"); err != nil {
+		return err
+	}
+	finished := time.Now()
+	expires := finished.Add(w.opts.TTL)
+	j.Status = models.ExportDone
+	j.Progress = 100
+	j.Error = ""
+	j.BlobKey = key
+	j.Size = int64(buf.Len())
+	j.FinishedAt = &finished
+	j.ExpiresAt = &expires
+	if err := w.store.UpdateExportJob(ctx, j); err != nil {
+		w.blobs.Delete(context.WithoutCancel(ctx), key)
+		return err
+	}
+	log.Printf("export done: job=%d, userID=%d, files=%d, bytes=%d", j.ID, j.UserID, len(files)+1, j.Size)
+	return nil
+}
+
+// expire deletes archives past their download window
+func (w *Worker) expire(ctx context.Context) {
+	js, err := w.store.ExpiredExportJobs(ctx, time.Now(), 100)
+	if err != nil {
+		log.Printf("export worker: failed to load expired jobs: %v", err)
+		return
+	}
+	for i := range js {
+		j := &js[i]
+		if err := w.blobs.Delete(ctx, j.BlobKey); err != nil {
+			log.Printf("export worker: failed to delete archive of job %d: %v", j.ID, err)
+			continue
+		}
+		j.Status = models.ExportExpired
+		j.BlobKey = ""
+		if err := w.store.UpdateExportJob(ctx, j); err != nil {
+			log.Printf("export worker: failed to update job %d: %v", j.ID, err)
+			continue
+		}
+		log.Printf("export expired: job=%d, userID=%d", j.ID, j.UserID)
+	}
+}
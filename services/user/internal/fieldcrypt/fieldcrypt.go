@@ -0,0 +1,267 @@
+// Package fieldcrypt encrypts selected database columns at rest.
+//
+// Values are sealed with envelope encryption: each one gets a fresh AES-256
+// data key, which is itself encrypted ("wrapped") with the active key of a
+// Keyring. A sealed value reads enc:v1:<key id>:<base64 payload>, so values
+// written under older keys stay readable while those keys are kept, and
+// rows still sealed with them can be found and re-encrypted. The column name
+// is bound to every value as additional data, so a value cannot be moved to
+// another column.
+//
+// Encrypted columns cannot be compared in SQL. A blind index, an HMAC of the
+// plaintext under a separate key that never rotates, supports exact lookups.
+package fieldcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	// Prefix starts every sealed value
+	Prefix = "enc:v1:"
+	// IndexKeyFile is the name of the blind index key in a key directory
+	IndexKeyFile = "index.key"
+
+	keySize = 32
+)
+
+var (
+	// ErrUnknownKey means a value was sealed with a key missing from the keyring
+	ErrUnknownKey = errors.New("fieldcrypt: value sealed with unknown key")
+	// ErrNoKeyring means a sealed value was read while encryption is disabled
+	ErrNoKeyring = errors.New("fieldcrypt: sealed value but no keyring loaded")
+	// ErrCorrupt means a sealed value is malformed or fails authentication
+	ErrCorrupt = errors.New("fieldcrypt: corrupt sealed value")
+)
+
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Keyring holds the keys that wrap data keys, by ID, and the blind index key
+type Keyring struct {
+	keys   map[string][]byte
+	active string
+	index  []byte
+}
+
+// LoadKeyring reads a key directory: every <id>.key file holds a base64
+// encoded 32-byte key, and index.key the blind index key. New values are
+// sealed with the key called active, or with the greatest ID when active is
+// empty, so adding a key with a later name rotates to it.
+func LoadKeyring(dir, active string) (*Keyring, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.key"))
+	if err != nil {
+		return nil, err
+	}
+	k := &Keyring{keys: map[string][]byte{}}
+	var ids []string
+	for _, p := range paths {
+		key, err := readKey(p)
+		if err != nil {
+			return nil, err
+		}
+		name := filepath.Base(p)
+		if name == IndexKeyFile {
+			k.index = key
+			continue
+		}
+		id := strings.TrimSuffix(name, ".key")
+		if !keyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("fieldcrypt: invalid key id %q", id)
+		}
+		k.keys[id] = key
+		ids = append(ids, id)
+	}
+	if k.index == nil {
+		return nil, fmt.Errorf("fieldcrypt: %s missing from %s", IndexKeyFile, dir)
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("fieldcrypt: no keys in %s", dir)
+	}
+	sort.Strings(ids)
+	k.active = ids[len(ids)-1]
+	if active != "" {
+		if _, ok := k.keys[active]; !ok {
+			return nil, fmt.Errorf("fieldcrypt: active key %q not found in %s", active, dir)
+		}
+		k.active = active
+	}
+	return k, nil
+}
+
+func readKey(path string) ([]byte, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil || len(key) != keySize {
+		return nil, fmt.Errorf("fieldcrypt: %s must hold %d base64 encoded bytes", path, keySize)
+	}
+	return key, nil
+}
+
+// Active returns the ID of the key new values are sealed with
+func (k *Keyring) Active() string {
+	return k.active
+}
+
+// Seal encrypts plaintext for column under a fresh data key wrapped with the
+// active key
+func (k *Keyring) Seal(column, plaintext string) (string, error) {
+	dek := make([]byte, keySize)
+	if _, err := rand.Read(dek); err != nil {
+		return "", err
+	}
+	wrapped, err := seal(k.keys[k.active], dek, column)
+	if err != nil {
+		return "", err
+	}
+	ct, err := seal(dek, []byte(plaintext), column)
+	if err != nil {
+		return "", err
+	}
+	return Prefix + k.active + ":" + base64.RawStdEncoding.EncodeToString(append(wrapped, ct...)), nil
+}
+
+// Open decrypts a value sealed for column with any key of the keyring
+func (k *Keyring) Open(column, value string) (string, error) {
+	id, payload, ok := strings.Cut(strings.TrimPrefix(value, Prefix), ":")
+	if !ok || !strings.HasPrefix(value, Prefix) {
+		return "", ErrCorrupt
+	}
+	kek, ok := k.keys[id]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownKey, id)
+	}
+	raw, err := base64.RawStdEncoding.DecodeString(payload)
+	// the wrapped data key is a nonce, the key and a tag
+	wrappedSize := 12 + keySize + 16
+	if err != nil || len(raw) < wrappedSize {
+		return "", ErrCorrupt
+	}
+	dek, err := open(kek, raw[:wrappedSize], column)
+	if err != nil {
+		return "", err
+	}
+	pt, err := open(dek, raw[wrappedSize:], column)
+	if err != nil {
+		return "", err
+	}
+	return string(pt), nil
+}
+
+// BlindIndex returns the hex HMAC-SHA256 of s under the index key
+func (k *Keyring) BlindIndex(s string) string {
+	return blindIndex(k.index, s)
+}
+
+func blindIndex(key []byte, s string) string {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(s))
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// seal returns nonce || AES-GCM(key, pt) with column as additional data
+func seal(key, pt []byte, column string) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, pt, []byte(column)), nil
+}
+
+func open(key, sealed []byte, column string) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, ErrCorrupt
+	}
+	pt, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(column))
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	return pt, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// current is the keyring used by the encrypted serializer; GORM serializers
+// are registered globally, so the keyring is too
+var current atomic.Pointer[Keyring]
+
+// Use makes k the keyring of the encrypted serializer and of the package
+// functions. With nil, encryption is disabled: values are written in
+// plaintext, and blind indexes are computed without a key.
+func Use(k *Keyring) {
+	current.Store(k)
+}
+
+// Enabled reports whether a keyring is in use
+func Enabled() bool {
+	return current.Load() != nil
+}
+
+// SealedPrefix is how values written now begin: the prefix with the active
+// key ID, or "" while encryption is disabled
+func SealedPrefix() string {
+	if k := current.Load(); k != nil {
+		return Prefix + k.active + ":"
+	}
+	return ""
+}
+
+// Seal encrypts plaintext for column with the current keyring. Empty values
+// stay empty, and without a keyring plaintext is returned as is.
+func Seal(column, plaintext string) (string, error) {
+	k := current.Load()
+	if k == nil || plaintext == "" {
+		return plaintext, nil
+	}
+	return k.Seal(column, plaintext)
+}
+
+// Open decrypts a value of column, returning values that are not sealed as is
+func Open(column, value string) (string, error) {
+	if !strings.HasPrefix(value, Prefix) {
+		return value, nil
+	}
+	k := current.Load()
+	if k == nil {
+		return "", ErrNoKeyring
+	}
+	return k.Open(column, value)
+}
+
+// BlindIndex returns the blind index of s under the current keyring
+func BlindIndex(s string) string {
+	if k := current.Load(); k != nil {
+		return k.BlindIndex(s)
+	}
+	return blindIndex(nil, s)
+}
@@ -0,0 +1,220 @@
+package fieldcrypt_test
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"services/user/internal/fieldcrypt"
+)
+
+// writeKeys writes a random key for each name to dir
+func writeKeys(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		key := make([]byte, 32)
+		rand.Read(key)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func load(t *testing.T, dir, active string) *fieldcrypt.Keyring {
+	t.Helper()
+	kr, err := fieldcrypt.LoadKeyring(dir, active)
+	if err != nil {
+		t.Fatalf("LoadKeyring: %v", err)
+	}
+	return kr
+}
+
+func TestSealOpenRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	writeKeys(t, dir, fieldcrypt.IndexKeyFile, "k1.key")
+	kr := load(t, dir, "")
+
+	for _, pt := range []string{"alice@example.com", "", "ünïcödé ✓", strings.Repeat("x", 4096)} {
+		sealed, err := kr.Seal("email", pt)
+		if err != nil {
+			t.Fatalf("Seal(%q): %v", pt, err)
+		}
+		if !strings.HasPrefix(sealed, fieldcrypt.Prefix+"k1:") {
+			t.Errorf("Seal(%q) = %s; want the k1 prefix", pt, sealed)
+		}
+		if pt != "" && strings.Contains(sealed, pt) {
+			t.Errorf("Seal(%q) = %s holds the plaintext", pt, sealed)
+		}
+		got, err := kr.Open("email", sealed)
+		if err != nil || got != pt {
+			t.Errorf("Open(Seal(%q)) = %q, %v", pt, got, err)
+		}
+	}
+	a, _ := kr.Seal("email", "same")
+	b, _ := kr.Seal("email", "same")
+	if a == b {
+		t.Errorf("sealing a value twice gave the same output %s", a)
+	}
+}
+
+func TestOpenFailures(t *testing.T) {
+	dir := t.TempDir()
+	writeKeys(t, dir, fieldcrypt.IndexKeyFile, "k1.key")
+	kr := load(t, dir, "")
+	sealed, err := kr.Seal("email", "alice@example.com")
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	// a key of the same ID with other bytes
+	otherDir := t.TempDir()
+	writeKeys(t, otherDir, fieldcrypt.IndexKeyFile, "k1.key")
+	other := load(t, otherDir, "")
+	if _, err := other.Open("email", sealed); !errors.Is(err, fieldcrypt.ErrCorrupt) {
+		t.Errorf("Open with the wrong key error = %v; want ErrCorrupt", err)
+	}
+	unknownDir := t.TempDir()
+	writeKeys(t, unknownDir, fieldcrypt.IndexKeyFile, "k2.key")
+	if _, err := load(t, unknownDir, "").Open("email", sealed); !errors.Is(err, fieldcrypt.ErrUnknownKey) {
+		t.Errorf("Open without the key error = %v; want ErrUnknownKey", err)
+	}
+
+	// the column is authenticated along with the value
+	if _, err := kr.Open("full_name", sealed); !errors.Is(err, fieldcrypt.ErrCorrupt) {
+		t.Errorf("Open as another column error = %v; want ErrCorrupt", err)
+	}
+	payload := sealed[len(fieldcrypt.Prefix+"k1:"):]
+	raw, err := base64.RawStdEncoding.DecodeString(payload)
+	if err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	for _, i := range []int{0, 20, len(raw) - 1} {
+		tampered := append([]byte(nil), raw...)
+		tampered[i] ^= 1
+		v := fieldcrypt.Prefix + "k1:" + base64.RawStdEncoding.EncodeToString(tampered)
+		if _, err := kr.Open("email", v); !errors.Is(err, fieldcrypt.ErrCorrupt) {
+			t.Errorf("Open with byte %d flipped error = %v; want ErrCorrupt", i, err)
+		}
+	}
+	for _, v := range []string{
+		sealed[:len(sealed)-4],
+		fieldcrypt.Prefix + "k1:" + base64.RawStdEncoding.EncodeToString(raw[:40]),
+		fieldcrypt.Prefix + "k1:not base64!",
+		fieldcrypt.Prefix + "k1",
+		"alice@example.com",
+	} {
+		if _, err := kr.Open("email", v); !errors.Is(err, fieldcrypt.ErrCorrupt) {
+			t.Errorf("Open(%q) error = %v; want ErrCorrupt", v, err)
+		}
+	}
+}
+
+func TestRotation(t *testing.T) {
+	dir := t.TempDir()
+	writeKeys(t, dir, fieldcrypt.IndexKeyFile, "k1.key")
+	before := load(t, dir, "")
+	old, _ := before.Seal("email", "alice@example.com")
+
+	writeKeys(t, dir, "k2.key")
+	kr := load(t, dir, "")
+	if kr.Active() != "k2" {
+		t.Errorf("Active after adding k2 = %s; want k2", kr.Active())
+	}
+	sealed, _ := kr.Seal("email", "bob@example.com")
+	if !strings.HasPrefix(sealed, fieldcrypt.Prefix+"k2:") {
+		t.Errorf("Seal after rotation = %s; want the k2 prefix", sealed)
+	}
+	if got, err := kr.Open("email", old); err != nil || got != "alice@example.com" {
+		t.Errorf("Open of a value sealed with k1 = %q, %v", got, err)
+	}
+	if _, err := before.Open("email", sealed); !errors.Is(err, fieldcrypt.ErrUnknownKey) {
+		t.Errorf("Open of a k2 value without k2 error = %v; want ErrUnknownKey", err)
+	}
+
+	if pinned := load(t, dir, "k1"); pinned.Active() != "k1" {
+		t.Errorf("Active with k1 chosen = %s; want k1", pinned.Active())
+	}
+	if _, err := fieldcrypt.LoadKeyring(dir, "k3"); err == nil {
+		t.Error("LoadKeyring with a missing active key succeeded")
+	}
+}
+
+func TestLoadKeyringErrors(t *testing.T) {
+	noIndex := t.TempDir()
+	writeKeys(t, noIndex, "k1.key")
+	noKeys := t.TempDir()
+	writeKeys(t, noKeys, fieldcrypt.IndexKeyFile)
+	short := t.TempDir()
+	writeKeys(t, short, fieldcrypt.IndexKeyFile)
+	os.WriteFile(filepath.Join(short, "k1.key"), []byte(base64.StdEncoding.EncodeToString([]byte("too short"))), 0o600)
+	badID := t.TempDir()
+	writeKeys(t, badID, fieldcrypt.IndexKeyFile, "k 1.key")
+	for name, dir := range map[string]string{"no index key": noIndex, "no keys": noKeys, "short key": short, "bad key id": badID} {
+		if _, err := fieldcrypt.LoadKeyring(dir, ""); err == nil {
+			t.Errorf("LoadKeyring with %s succeeded", name)
+		}
+	}
+}
+
+func TestBlindIndexStable(t *testing.T) {
+	dir := t.TempDir()
+	writeKeys(t, dir, fieldcrypt.IndexKeyFile, "k1.key")
+	idx := load(t, dir, "").BlindIndex("alice@example.com")
+	if len(idx) != 64 {
+		t.Errorf("BlindIndex = %s; want 64 hex digits", idx)
+	}
+	// rotating the keys that wrap data leaves the index alone
+	writeKeys(t, dir, "k2.key")
+	kr := load(t, dir, "")
+	if got := kr.BlindIndex("alice@example.com"); got != idx {
+		t.Errorf("BlindIndex after rotation = %s; want %s", got, idx)
+	}
+	if kr.BlindIndex("Alice@example.com") == idx || kr.BlindIndex("bob@example.com") == idx {
+		t.Error("BlindIndex of another value is the same")
+	}
+	otherDir := t.TempDir()
+	writeKeys(t, otherDir, fieldcrypt.IndexKeyFile, "k1.key")
+	if load(t, otherDir, "").BlindIndex("alice@example.com") == idx {
+		t.Error("BlindIndex under another index key is the same")
+	}
+}
+
+func TestCurrentKeyring(t *testing.T) {
+	t.Cleanup(func() { fieldcrypt.Use(nil) })
+	fieldcrypt.Use(nil)
+	if fieldcrypt.Enabled() || fieldcrypt.SealedPrefix() != "" {
+		t.Errorf("without a keyring Enabled = %v, SealedPrefix = %q; want false and empty", fieldcrypt.Enabled(), fieldcrypt.SealedPrefix())
+	}
+	if v, err := fieldcrypt.Seal("email", "alice@example.com"); err != nil || v != "alice@example.com" {
+		t.Errorf("Seal without a keyring = %q, %v; want the plaintext", v, err)
+	}
+
+	dir := t.TempDir()
+	writeKeys(t, dir, fieldcrypt.IndexKeyFile, "k1.key", "k2.key")
+	kr := load(t, dir, "")
+	fieldcrypt.Use(kr)
+	if !fieldcrypt.Enabled() || fieldcrypt.SealedPrefix() != fieldcrypt.Prefix+"k2:" {
+		t.Errorf("with a keyring Enabled = %v, SealedPrefix = %q; want true and the k2 prefix", fieldcrypt.Enabled(), fieldcrypt.SealedPrefix())
+	}
+	sealed, err := fieldcrypt.Seal("email", "alice@example.com")
+	if err != nil || !strings.HasPrefix(sealed, fieldcrypt.SealedPrefix()) {
+		t.Errorf("Seal = %q, %v; want it to start with SealedPrefix", sealed, err)
+	}
+	if v, _ := fieldcrypt.Seal("email", ""); v != "" {
+		t.Errorf("Seal of an empty value = %q; want it empty", v)
+	}
+	if v, err := fieldcrypt.Open("email", "plain@example.com"); err != nil || v != "plain@example.com" {
+		t.Errorf("Open of a plaintext value = %q, %v; want it as is", v, err)
+	}
+	if fieldcrypt.BlindIndex("alice@example.com") != kr.BlindIndex("alice@example.com") {
+		t.Error("BlindIndex differs from the keyring's")
+	}
+
+	fieldcrypt.Use(nil)
+	if _, err := fieldcrypt.Open("email", sealed); !errors.Is(err, fieldcrypt.ErrNoKeyring) {
+		t.Errorf("Open of a sealed value without a keyring error = %v; want ErrNoKeyring", err)
+	}
+}
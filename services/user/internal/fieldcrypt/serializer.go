@@ -0,0 +1,88 @@
+package fieldcrypt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm/schema"
+)
+
+func init() {
+	schema.RegisterSerializer("encrypted", Serializer{})
+	schema.RegisterSerializer("encryptedjson", JSONSerializer{})
+}
+
+// Serializer seals string fields tagged gorm:"serializer:encrypted" with the
+// current keyring on write and opens them on read. Plaintext values, as
+// written while encryption was disabled, are read as they are.
+type Serializer struct{}
+
+// Scan implements schema.SerializerInterface
+func (Serializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var raw string
+	switch v := dbValue.(type) {
+	case nil:
+	case string:
+		raw = v
+	case []byte:
+		raw = string(v)
+	default:
+		return fmt.Errorf("fieldcrypt: unsupported value %T for %s", dbValue, field.Name)
+	}
+	pt, err := Open(field.DBName, raw)
+	if err != nil {
+		return fmt.Errorf("%s: %w", field.DBName, err)
+	}
+	field.ReflectValueOf(ctx, dst).SetString(pt)
+	return nil
+}
+
+// Value implements schema.SerializerValuerInterface
+func (Serializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	s, ok := fieldValue.(string)
+	if !ok {
+		return nil, fmt.Errorf("fieldcrypt: %s is not a string", field.Name)
+	}
+	return Seal(field.DBName, s)
+}
+
+// JSONSerializer stores fields tagged gorm:"serializer:encryptedjson" as
+// JSON, sealed like those of Serializer. Nil values are stored as NULL.
+type JSONSerializer struct{}
+
+// Scan implements schema.SerializerInterface
+func (JSONSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var raw string
+	switch v := dbValue.(type) {
+	case nil:
+	case string:
+		raw = v
+	case []byte:
+		raw = string(v)
+	default:
+		return fmt.Errorf("fieldcrypt: unsupported value %T for %s", dbValue, field.Name)
+	}
+	pt, err := Open(field.DBName, raw)
+	if err != nil {
+		return fmt.Errorf("%s: %w", field.DBName, err)
+	}
+	v := reflect.New(field.FieldType)
+	if pt != "" {
+		if err := json.Unmarshal([]byte(pt), v.Interface()); err != nil {
+			return fmt.Errorf("%s: %w", field.DBName, err)
+		}
+	}
+	field.ReflectValueOf(ctx, dst).Set(v.Elem())
+	return nil
+}
+
+// Value implements schema.SerializerValuerInterface
+func (JSONSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	b, err := json.Marshal(fieldValue)
+	if err != nil || string(b) == "null" {
+		return nil, err
+	}
+	return Seal(field.DBName, string(b))
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"services/user/internal/mailer"
+	"services/user/internal/models"
+	"services/user/internal/store"
+)
+
+// accountDeletionBatch bounds how many accounts one DeleteDueAccounts run erases
+const accountDeletionBatch = 100
+
+// DeleteAccountReq confirms a self-deletion with the current password
+type DeleteAccountReq struct {
+	Password string `json:"password"`
+}
+
+// DeleteMe schedules the deletion of the caller's account after the
+// configured grace period. Logging in before then cancels it; afterwards
+// DeleteDueAccounts erases the account's personal data.
+func (h *Handler) DeleteMe(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := GetClaims(r)
+	var req DeleteAccountReq
+	if err := parseBody(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	ev := newAuditEvent(r, AuditUserDeleteRequest, "user", claims.UserID)
+	if claims.Impersonated() {
+		log.Printf("account deletion denied: impersonation, actor=%d, target=%d", claims.Act.UserID, claims.UserID)
+		ev.Detail = "account deletion under impersonation"
+		h.audit(ctx, ev, models.AuditDenied)
+		writeError(w, http.StatusForbidden, "not allowed while impersonating")
+		return
+	}
+	u, err := h.users.GetUserByID(ctx, claims.UserID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if !u.CheckPassword(req.Password) {
+		log.Printf("account deletion denied: bad password, userID=%d", u.ID)
+		ev.Detail = "invalid password"
+		h.audit(ctx, ev, models.AuditDenied)
+		writeError(w, http.StatusForbidden, "invalid password")
+		return
+	}
+	if u.DeletionDueAt == nil {
+		due := time.Now().Add(h.opts.DeletionGrace).UTC()
+		u.DeletionDueAt = &due
+		ev.Diff = map[string]interface{}{"deletion_due_at": due}
+		err = h.store.Audited(ctx, ev, func(tx store.Repository) error {
+			return tx.UpdateUser(ctx, u)
+		})
+		if err != nil {
+			log.Printf("schedule account deletion failed: userID=%d, err=%v", u.ID, err)
+			writeError(w, http.StatusInternalServerError, "failed to schedule deletion")
+			return
+		}
+		body := fmt.Sprintf("Your account will be deleted on %s, as you asked.\n\nTo keep it, log in before then and the deletion is cancelled.\n", u.DeletionDueAt.Format("2006-01-02 15:04 MST"))
+		if err := h.opts.Mailer.Send(ctx, mailer.Message{To: u.Email, Subject: "Your account is scheduled for deletion", Body: body}); err != nil {
+			log.Printf("account deletion notice not sent: userID=%d, err=%v", u.ID, err)
+		}
+		log.Printf("account deletion scheduled: userID=%d, due=%s", u.ID, u.DeletionDueAt.Format(time.RFC3339))
+	}
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{"status": "deletion_scheduled", "deletion_due_at": u.DeletionDueAt})
+}
+
+// cancelDeletion clears a pending self-deletion of u on login
+func (h *Handler) cancelDeletion(r *http.Request, u *models.User) error {
+	ctx := r.Context()
+	ev := newAuditEvent(r, AuditUserDeleteCancel, "user", u.ID)
+	uid := u.ID
+	ev.ActorID = &uid
+	ev.ActorEmail = u.Email
+	ev.Detail = "logged in during grace period"
+	due := u.DeletionDueAt
+	u.DeletionDueAt = nil
+	err := h.store.Audited(ctx, ev, func(tx store.Repository) error {
+		return tx.UpdateUser(ctx, u)
+	})
+	if err != nil {
+		u.DeletionDueAt = due
+		return err
+	}
+	log.Printf("account deletion cancelled: userID=%d", u.ID)
+	return nil
+}
+
+// DeleteDueAccounts erases the accounts whose deletion grace period is over:
+// their email, name, profile and password are replaced, data they own is
+// deleted, and the user is soft deleted. Their personal data is erased from
+// audit events and webhook deliveries too, except from audit events older
+// than the personal digest, which the chain does not allow changing;
+// ListAudit hides it instead. It returns how many accounts were erased.
+func (h *Handler) DeleteDueAccounts(ctx context.Context) (int, error) {
+	us, err := h.store.DueUserDeletions(ctx, time.Now(), accountDeletionBatch)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for i := range us {
+		u := &us[i]
+		ev := &models.AuditEvent{Action: AuditUserAnonymize, TargetType: "user", TargetID: strconv.FormatUint(uint64(u.ID), 10), Detail: "deletion grace period expired"}
+		var exports []string
+		err := h.store.Audited(ctx, ev, func(tx store.Repository) error {
+			var err error
+			if exports, err = tx.AnonymizeUser(ctx, u.ID); err != nil {
+				return err
+			}
+			return tx.EnqueueWebhookEvent(ctx, models.EventUserDeleted, u.ID, map[string]interface{}{"id": u.ID, "anonymized": true})
+		})
+		if err != nil {
+			log.Printf("account deletion failed: userID=%d, err=%v", u.ID, err)
+			continue
+		}
+		if u.AvatarKey != "" {
+			h.deleteAvatar(ctx, u.AvatarKey)
+		}
+		for _, key := range exports {
+			if err := h.opts.Blobs.Delete(ctx, key); err != nil {
+				log.Printf("delete export blob failed: key=%s, err=%v", key, err)
+			}
+		}
+		log.Printf("account deleted and anonymized: userID=%d", u.ID)
+		n++
+	}
+	return n, nil
+}
+
+// redactAudit hides the personal data of anonymized and purged users in evs:
+// what identifies them as an actor, and the diffs of changes made to them.
+// It is what protects events recorded before their personal data was
+// digested, which the store could not erase.
+func (h *Handler) redactAudit(ctx context.Context, evs []models.AuditEvent) error {
+	var ids []uint
+	for _, ev := range evs {
+		if ev.ActorID != nil {
+			ids = append(ids, *ev.ActorID)
+		}
+		if ev.TargetType == "user" {
+			if id, err := strconv.ParseUint(ev.TargetID, 10, 64); err == nil && id != 0 {
+				ids = append(ids, uint(id))
+			}
+		}
+	}
+	gone, err := h.store.RedactedUserIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for i := range evs {
+		ev := &evs[i]
+		if ev.ActorID != nil && gone[*ev.ActorID] {
+			ev.ActorEmail, ev.IP, ev.UserAgent, ev.Diff = "", "", "", nil
+		}
+		if id, err := strconv.ParseUint(ev.TargetID, 10, 64); err == nil && ev.TargetType == "user" && gone[uint(id)] {
+			ev.Diff = nil
+			if ev.ActorID == nil {
+				// anonymous events about a user, like failed logins, carry their email
+				ev.ActorEmail, ev.IP, ev.UserAgent = "", "", ""
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"services/user/internal/avatar"
+	"services/user/internal/blob"
+	"services/user/internal/models"
+	"services/user/internal/store"
+)
+
+const (
+	// avatarDefaultSize is the thumbnail shown as the profile's avatar_url
+	avatarDefaultSize = 256
+	// multipartOverhead allows for the multipart headers and boundaries
+	// around the uploaded file
+	multipartOverhead = 64 << 10
+)
+
+// profileView is a profile as served to clients. With an uploaded avatar,
+// avatar_url is a signed link to its default thumbnail and avatars links
+// every thumbnail by size.
+type profileView struct {
+	models.Profile
+	Avatars map[string]string `json:"avatars,omitempty"`
+}
+
+func (h *Handler) profileView(u *models.User) profileView {
+	v := profileView{Profile: u.Profile}
+	if u.AvatarKey == "" {
+		return v
+	}
+	now := time.Now()
+	v.Avatars = map[string]string{}
+	for _, size := range avatar.Sizes {
+		v.Avatars[strconv.Itoa(size)] = h.opts.Media.URL(avatarObject(u.AvatarKey, size), now)
+	}
+	v.AvatarURL = v.Avatars[strconv.Itoa(avatarDefaultSize)]
+	return v
+}
+
+// avatarObject is the blob key of one thumbnail of the avatar stored under key
+func avatarObject(key string, size int) string {
+	return fmt.Sprintf("%s/%d.jpg", key, size)
+}
+
+// newAvatarKey returns a fresh blob prefix for an upload by userID. Every
+// upload gets its own, so signed URLs of a replaced avatar never serve the
+// new picture from a stale cache.
+func newAvatarKey(userID uint) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("avatars/%d/%s", userID, hex.EncodeToString(b)), nil
+}
+
+// deleteAvatar removes the thumbnails stored under key. It is best effort:
+// failures leave orphaned objects behind and are only logged.
+func (h *Handler) deleteAvatar(ctx context.Context, key string) {
+	DeleteAvatarBlobs(ctx, h.opts.Blobs, key)
+}
+
+// DeleteAvatarBlobs removes the thumbnails of the avatar stored under key
+// from blobs, as deleteAvatar does
+func DeleteAvatarBlobs(ctx context.Context, blobs blob.Store, key string) {
+	ctx = context.WithoutCancel(ctx)
+	for _, size := range avatar.Sizes {
+		if err := blobs.Delete(ctx, avatarObject(key, size)); err != nil {
+			log.Printf("delete avatar blob failed: key=%s, size=%d, err=%v", key, size, err)
+		}
+	}
+}
+
+// DeletePurgedBlobs removes the avatars and export archives of purged users
+// from blobs. Like deleteAvatar it is best effort.
+func DeletePurgedBlobs(ctx context.Context, blobs blob.Store, purged store.PurgedBlobs) {
+	for _, key := range purged.AvatarKeys {
+		DeleteAvatarBlobs(ctx, blobs, key)
+	}
+	ctx = context.WithoutCancel(ctx)
+	for _, key := range purged.ExportKeys {
+		if err := blobs.Delete(ctx, key); err != nil {
+			log.Printf("delete export blob failed: key=%s, err=%v", key, err)
+		}
+	}
+}
+
+// readAvatarUpload returns the "avatar" file of a multipart/form-data
+// request, or an HTTP status and error if it is missing or exceeds max bytes
+func readAvatarUpload(w http.ResponseWriter, r *http.Request, max int64) ([]byte, int, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, max+multipartOverhead)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, http.StatusUnsupportedMediaType, errors.New("expected multipart/form-data")
+	}
+	tooLarge := fmt.Errorf("avatar exceeds %d bytes", max)
+	for {
+		part, err := mr.NextPart()
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			return nil, http.StatusRequestEntityTooLarge, tooLarge
+		}
+		if err == io.EOF {
+			return nil, http.StatusBadRequest, errors.New("missing avatar file")
+		}
+		if err != nil {
+			return nil, http.StatusBadRequest, errors.New("invalid multipart body")
+		}
+		if part.FormName() != "avatar" {
+			part.Close()
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(part, max+1))
+		if errors.As(err, &mbe) || int64(len(data)) > max {
+			return nil, http.StatusRequestEntityTooLarge, tooLarge
+		}
+		if err != nil {
+			return nil, http.StatusBadRequest, errors.New("invalid multipart body")
+		}
+		return data, 0, nil
+	}
+}
+
+// UploadAvatar replaces the caller's avatar with the image in the "avatar"
+// field of a multipart/form-data body. The image type is sniffed from its
+// content, and the picture is re-encoded into square JPEG thumbnails without
+// any of the original metadata. If-Match is honoured when given.
+func (h *Handler) UploadAvatar(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := GetClaims(r)
+	data, status, err := readAvatarUpload(w, r, h.opts.AvatarMaxBytes)
+	if err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+	thumbs, err := avatar.Process(data)
+	switch {
+	case errors.Is(err, avatar.ErrUnsupportedType):
+		writeError(w, http.StatusUnsupportedMediaType, err.Error())
+		return
+	case errors.Is(err, avatar.ErrTooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	case errors.Is(err, avatar.ErrInvalidImage):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case err != nil:
+		log.Printf("process avatar failed: userID=%d, err=%v", claims.UserID, err)
+		writeError(w, http.StatusInternalServerError, "failed to process avatar")
+		return
+	}
+	u, err := h.users.GetUserByID(ctx, claims.UserID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Header.Get("If-Match") != "" && !checkIfMatch(w, r, u.Version) {
+		return
+	}
+	key, err := newAvatarKey(u.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to store avatar")
+		return
+	}
+	for _, t := range thumbs {
+		if err := h.opts.Blobs.Put(ctx, avatarObject(key, t.Size), bytes.NewReader(t.Data), int64(len(t.Data)), avatar.ContentType); err != nil {
+			log.Printf("store avatar failed: userID=%d, key=%s, err=%v", u.ID, key, err)
+			h.deleteAvatar(ctx, key)
+			writeError(w, http.StatusInternalServerError, "failed to store avatar")
+			return
+		}
+	}
+	oldKey := u.AvatarKey
+	u.AvatarKey = key
+	// the upload replaces any external avatar link
+	u.Profile.AvatarURL = ""
+	ev := newAuditEvent(r, AuditUserUpdate, "user", u.ID)
+	ev.Diff = map[string]interface{}{"avatar": "uploaded"}
+	err = h.store.Audited(ctx, ev, func(tx store.Repository) error {
+		if err := tx.UpdateUser(ctx, u); err != nil {
+			return err
+		}
+		return enqueueUserEvent(ctx, tx, models.EventUserUpdated, u.ID)
+	})
+	if err != nil {
+		h.deleteAvatar(ctx, key)
+	}
+	switch {
+	case errors.Is(err, store.ErrVersionConflict):
+		writeError(w, http.StatusPreconditionFailed, "user has been modified")
+		return
+	case errors.Is(err, store.ErrNotFound):
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	case err != nil:
+		log.Printf("upload avatar failed: userID=%d, err=%v", u.ID, err)
+		writeError(w, http.StatusInternalServerError, "failed to update avatar")
+		return
+	}
+	if oldKey != "" {
+		h.deleteAvatar(ctx, oldKey)
+	}
+	log.Printf("avatar uploaded: userID=%d, key=%s, bytes=%d", u.ID, key, len(data))
+	w.Header().Set("ETag", etag(u.Version))
+	writeJSON(w, http.StatusOK, h.profileView(u))
+}
+
+// DeleteAvatar removes the uploaded avatar of the caller (/api/me/avatar)
+// or, for admins, of {id}. Deleting a missing avatar succeeds.
+func (h *Handler) DeleteAvatar(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, ok := profileUserID(w, r)
+	if !ok {
+		return
+	}
+	u, err := h.users.GetUserByID(ctx, id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if u.AvatarKey == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	oldKey := u.AvatarKey
+	u.AvatarKey = ""
+	ev := newAuditEvent(r, AuditUserUpdate, "user", u.ID)
+	ev.Diff = map[string]interface{}{"avatar": "removed"}
+	err = h.store.Audited(ctx, ev, func(tx store.Repository) error {
+		if err := tx.UpdateUser(ctx, u); err != nil {
+			return err
+		}
+		return enqueueUserEvent(ctx, tx, models.EventUserUpdated, u.ID)
+	})
+	if err != nil {
+		log.Printf("delete avatar failed: userID=%d, err=%v", u.ID, err)
+		writeError(w, http.StatusInternalServerError, "failed to delete avatar")
+		return
+	}
+	h.deleteAvatar(ctx, oldKey)
+	log.Printf("avatar deleted: userID=%d, requestedBy=%d", u.ID, GetClaims(r).UserID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetMedia serves a stored object through a signed URL, as issued for
+// avatars and data exports. No bearer token is needed, so the URLs work in
+// <img> tags; anything but images is sent as a download.
+func (h *Handler) GetMedia(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "*")
+	q := r.URL.Query()
+	now := time.Now()
+	if !blob.ValidKey(key) || h.opts.Media.Verify(key, q, now) != nil {
+		writeError(w, http.StatusForbidden, "invalid or expired signature")
+		return
+	}
+	rc, contentType, err := h.opts.Blobs.Get(r.Context(), key)
+	if errors.Is(err, blob.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		log.Printf("get media failed: key=%s, err=%v", key, err)
+		writeError(w, http.StatusInternalServerError, "failed to load media")
+		return
+	}
+	defer rc.Close()
+	// objects never change under a key, so they may be cached for as long as the URL is valid
+	expires, _ := strconv.ParseInt(q.Get("expires"), 10, 64)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d, immutable", expires-now.Unix()))
+	if !strings.HasPrefix(contentType, "image/") {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(key)}))
+	}
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, rc)
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"services/user/internal/bulk"
+	"services/user/internal/models"
+	"services/user/internal/store"
+)
+
+// maxImportBytes bounds an uploaded import file; import larger files with
+// the import-users command
+const maxImportBytes = 32 << 20
+
+// UserCreatedWebhook queues user.created for a user created outside of
+// Register, such as by an import
+func UserCreatedWebhook(ctx context.Context, tx store.Repository, u *models.User, roles []string) error {
+	return tx.EnqueueWebhookEvent(ctx, models.EventUserCreated, u.ID, webhookUser(u, roles))
+}
+
+// bulkFormat is the format named by the format parameter, or else implied
+// by the Content-Type of an upload
+func bulkFormat(r *http.Request) (string, error) {
+	if f := r.URL.Query().Get("format"); f != "" {
+		return bulk.ParseFormat(f)
+	}
+	if r.Method == http.MethodGet {
+		return bulk.FormatCSV, nil
+	}
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mt {
+	case "text/csv":
+		return bulk.FormatCSV, nil
+	case "application/x-ndjson", "application/jsonl":
+		return bulk.FormatNDJSON, nil
+	}
+	return "", errors.New("set format=csv or format=ndjson, or a text/csv or application/x-ndjson Content-Type")
+}
+
+// ImportUsers creates users from the CSV or NDJSON file in the request body
+// (admin only). With dry_run=true nothing is written. Rows that cannot be
+// imported are listed in the report, which is returned with 200 even when
+// some rows failed; see bulk.Importer.Import.
+func (h *Handler) ImportUsers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "admin only")
+		return
+	}
+	format, err := bulkFormat(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	q := r.URL.Query()
+	opts := bulk.ImportOptions{DryRun: q.Get("dry_run") == "true"}
+	if v := q.Get("batch_size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > bulk.MaxBatchSize {
+			writeError(w, http.StatusBadRequest, "batch_size must be between 1 and "+strconv.Itoa(bulk.MaxBatchSize))
+			return
+		}
+		opts.BatchSize = n
+	}
+	opts.Audit = *newAuditEvent(r, AuditUserImport, "user", 0)
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+	in, err := bulk.NewReader(r.Body, format)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	im := &bulk.Importer{Store: h.store, Created: UserCreatedWebhook}
+	rep, err := im.Import(ctx, in, opts)
+	if err != nil {
+		// batches committed before the error are kept, so the report is
+		// returned along with it
+		status := http.StatusInternalServerError
+		var mbe *http.MaxBytesError
+		switch {
+		case errors.As(err, &mbe):
+			status = http.StatusRequestEntityTooLarge
+		case errors.Is(err, bulk.ErrFormat):
+			status = http.StatusBadRequest
+		}
+		log.Printf("import users stopped: rows=%d, created=%d, err=%v", rep.Rows, rep.Created, err)
+		writeJSON(w, status, map[string]interface{}{"error": err.Error(), "report": rep})
+		return
+	}
+	log.Printf("import users: dry_run=%t, rows=%d, created=%d, failed=%d", rep.DryRun, rep.Rows, rep.Created, rep.Failed)
+	writeJSON(w, http.StatusOK, rep)
+}
+
+// ExportUsers streams every user in the import format (admin only). Password
+// hashes are included with password_hashes=true, which is refused while
+// impersonating.
+func (h *Handler) ExportUsers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "admin only")
+		return
+	}
+	format, err := bulkFormat(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	withPasswords := r.URL.Query().Get("password_hashes") == "true"
+	ev := newAuditEvent(r, AuditUserBulkExport, "user", 0)
+	ev.Diff = map[string]interface{}{"format": format, "password_hashes": withPasswords}
+	if withPasswords && GetClaims(r).Impersonated() {
+		ev.Detail = "password hash export under impersonation"
+		h.audit(ctx, ev, models.AuditDenied)
+		writeError(w, http.StatusForbidden, "not allowed while impersonating")
+		return
+	}
+	w.Header().Set("Content-Type", bulk.ContentType(format))
+	w.Header().Set("Content-Disposition", `attachment; filename="users.`+format+`"`)
+	out, err := bulk.NewWriter(w, format)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to export users")
+		return
+	}
+	// the status is sent with the first rows, so a failure can only cut the
+	// stream short
+	n, err := bulk.Export(ctx, h.store, out, withPasswords)
+	ev.Diff["users"] = n
+	if err != nil {
+		log.Printf("export users failed after %d users: %v", n, err)
+		ev.Detail = err.Error()
+		h.audit(ctx, ev, models.AuditFailure)
+		return
+	}
+	h.audit(ctx, ev, models.AuditSuccess)
+}
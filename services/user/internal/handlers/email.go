@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"services/user/internal/mailer"
+	"services/user/internal/models"
+	"services/user/internal/store"
+)
+
+// ChangeEmailReq starts an email change
+type ChangeEmailReq struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// RequestEmailChange starts changing the caller's email. The current password
+// is required; a confirmation token is sent to the new address and a notice
+// to the current one. The email only changes once ConfirmEmailChange is
+// called with the token.
+func (h *Handler) RequestEmailChange(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := GetClaims(r)
+	var req ChangeEmailReq
+	if err := parseBody(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	newEmail := strings.TrimSpace(req.Email)
+	ev := newAuditEvent(r, AuditEmailChangeRequest, "user", claims.UserID)
+	if claims.Impersonated() {
+		log.Printf("email change denied: impersonation, actor=%d, target=%d", claims.Act.UserID, claims.UserID)
+		ev.Detail = "email change under impersonation"
+		h.audit(ctx, ev, models.AuditDenied)
+		writeError(w, http.StatusForbidden, "not allowed while impersonating")
+		return
+	}
+	if !models.ValidEmail(newEmail) {
+		writeError(w, http.StatusBadRequest, "invalid email")
+		return
+	}
+	u, err := h.users.GetUserByID(ctx, claims.UserID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if !u.CheckPassword(req.Password) {
+		log.Printf("email change denied: wrong password, userID=%d, remote=%s", u.ID, r.RemoteAddr)
+		ev.Detail = "wrong password"
+		h.audit(ctx, ev, models.AuditDenied)
+		writeError(w, http.StatusForbidden, "invalid password")
+		return
+	}
+	if newEmail == u.Email {
+		writeError(w, http.StatusBadRequest, "email unchanged")
+		return
+	}
+	if _, err := h.users.GetUserByEmail(ctx, newEmail); err == nil {
+		writeError(w, http.StatusConflict, "email already registered")
+		return
+	}
+	ec, err := h.startEmailChange(ctx, ev, u, newEmail)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.Printf("email change requested: userID=%d", u.ID)
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{"status": "confirmation sent", "expires_at": ec.ExpiresAt})
+}
+
+// startEmailChange records a pending change of u's email to newEmail,
+// audited as ev, and mails the confirmation token to the new address and a
+// notice to the current one. Its errors are fit for the response.
+func (h *Handler) startEmailChange(ctx context.Context, ev *models.AuditEvent, u *models.User, newEmail string) (*models.EmailChange, error) {
+	ev.Diff = map[string]interface{}{"email": map[string]string{"old": u.Email, "new": newEmail}}
+	var ec *models.EmailChange
+	var token string
+	err := h.store.Audited(ctx, ev, func(tx store.Repository) error {
+		var err error
+		ec, token, err = tx.CreateEmailChange(ctx, u.ID, newEmail, h.opts.EmailChangeTTL)
+		return err
+	})
+	if err != nil {
+		log.Printf("email change failed: userID=%d, err=%v", u.ID, err)
+		return nil, errors.New("failed to start email change")
+	}
+	body := fmt.Sprintf("Someone asked to change the email of your account to this address.\n\nConfirm the change with this token before %s:\n\n%s\n",
+		ec.ExpiresAt.UTC().Format("2006-01-02 15:04 MST"), token)
+	if h.opts.EmailConfirmURL != "" {
+		body += "\nor open " + h.opts.EmailConfirmURL + "?token=" + url.QueryEscape(token) + "\n"
+	}
+	body += "\nIf you did not ask for this, ignore this email.\n"
+	if err := h.opts.Mailer.Send(ctx, mailer.Message{To: newEmail, Subject: "Confirm your new email address", Body: body}); err != nil {
+		log.Printf("email change confirmation not sent: userID=%d, err=%v", u.ID, err)
+		return nil, errors.New("failed to send confirmation email")
+	}
+	notice := fmt.Sprintf("A change of your account email to %s was requested. It takes effect once confirmed from the new address.\n\nIf this was not you, change your password now.\n", newEmail)
+	if err := h.opts.Mailer.Send(ctx, mailer.Message{To: u.Email, Subject: "Your email address is being changed", Body: notice}); err != nil {
+		log.Printf("email change notice not sent: userID=%d, err=%v", u.ID, err)
+	}
+	return ec, nil
+}
+
+// ConfirmEmailReq carries the token sent to the new address
+type ConfirmEmailReq struct {
+	Token string `json:"token"`
+}
+
+// ConfirmEmailChange applies a pending email change. It is public since the
+// token proves control of the new address; tokens issued for the old email
+// stop working once it succeeds.
+func (h *Handler) ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req ConfirmEmailReq
+	if err := parseBody(r, &req); err != nil || req.Token == "" {
+		writeError(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	ec, err := h.store.GetEmailChangeByToken(ctx, req.Token)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Printf("email change lookup failed: err=%v", err)
+		}
+		writeError(w, http.StatusBadRequest, "invalid or expired token")
+		return
+	}
+	ev := newAuditEvent(r, AuditEmailChange, "user", ec.UserID)
+	uid := ec.UserID
+	ev.ActorID = &uid
+	var u *models.User
+	err = h.store.Audited(ctx, ev, func(tx store.Repository) error {
+		var err error
+		if u, err = tx.GetUserByID(ctx, ec.UserID); err != nil {
+			return err
+		}
+		ev.ActorEmail = u.Email
+		ev.Diff = map[string]interface{}{"email": map[string]string{"old": u.Email, "new": ec.NewEmail}}
+		u.SetEmail(ec.NewEmail)
+		if err := tx.UpdateUser(ctx, u); err != nil {
+			return err
+		}
+		if err := tx.DeleteEmailChanges(ctx, u.ID); err != nil {
+			return err
+		}
+		return enqueueUserEvent(ctx, tx, models.EventUserUpdated, u.ID)
+	})
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		writeError(w, http.StatusBadRequest, "invalid or expired token")
+		return
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		writeError(w, http.StatusConflict, "email already registered")
+		return
+	case err != nil:
+		log.Printf("email change confirmation failed: userID=%d, err=%v", ec.UserID, err)
+		writeError(w, http.StatusInternalServerError, "failed to change email")
+		return
+	}
+	log.Printf("email changed: userID=%d", u.ID)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"id": u.ID, "email": u.Email, "version": u.Version})
+}
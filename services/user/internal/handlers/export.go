@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"services/user/internal/avatar"
+	"services/user/internal/blob"
+	"services/user/internal/export"
+	"services/user/internal/models"
+	"services/user/internal/store"
+)
+
+// exportAuditPage is how many audit events are read per query while exporting
+const exportAuditPage = 500
+
+// ExportSections are the files of a user's data export: their account,
+// profile, settings, roles and groups, login sessions, the audit events
+// they caused or that concern them, and their uploaded avatar
+func ExportSections(s store.Repository, blobs blob.Store) []export.Section {
+	return []export.Section{
+		export.JSON("account.json", func(ctx context.Context, u *models.User) (interface{}, error) {
+			return map[string]interface{}{"id": u.ID, "email": u.Email, "full_name": u.FullName, "created_at": u.CreatedAt, "updated_at": u.UpdatedAt, "version": u.Version}, nil
+		}),
+		export.JSON("profile.json", func(ctx context.Context, u *models.User) (interface{}, error) {
+			return u.Profile, nil
+		}),
+		export.JSON("settings.json", func(ctx context.Context, u *models.User) (interface{}, error) {
+			us, err := s.GetUserSettings(ctx, u.ID)
+			if err != nil {
+				return nil, err
+			}
+			return settingsView(us), nil
+		}),
+		export.JSON("roles.json", func(ctx context.Context, u *models.User) (interface{}, error) {
+			direct, err := s.GetDirectUserRoles(ctx, u.ID)
+			if err != nil {
+				return nil, err
+			}
+			effective, err := s.GetUserRoles(ctx, u.ID)
+			if err != nil {
+				return nil, err
+			}
+			groups, err := s.GetUserGroups(ctx, u.ID)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{"roles": namesOfRoles(direct), "effective_roles": namesOfRoles(effective), "groups": groups}, nil
+		}),
+		export.JSON("sessions.json", func(ctx context.Context, u *models.User) (interface{}, error) {
+			evs, err := exportAuditEvents(ctx, s, store.AuditFilter{Action: AuditUserLogin, TargetType: "user", TargetID: strconv.FormatUint(uint64(u.ID), 10)})
+			if err != nil {
+				return nil, err
+			}
+			sessions := make([]map[string]interface{}, 0, len(evs))
+			for _, ev := range evs {
+				sessions = append(sessions, map[string]interface{}{"logged_in_at": ev.CreatedAt, "ip": ev.IP, "user_agent": ev.UserAgent})
+			}
+			return sessions, nil
+		}),
+		export.JSON("audit.json", func(ctx context.Context, u *models.User) (interface{}, error) {
+			byUser, err := exportAuditEvents(ctx, s, store.AuditFilter{ActorID: u.ID})
+			if err != nil {
+				return nil, err
+			}
+			aboutUser, err := exportAuditEvents(ctx, s, store.AuditFilter{TargetType: "user", TargetID: strconv.FormatUint(uint64(u.ID), 10)})
+			if err != nil {
+				return nil, err
+			}
+			seen := map[uint]bool{}
+			evs := make([]models.AuditEvent, 0, len(byUser)+len(aboutUser))
+			for _, ev := range append(byUser, aboutUser...) {
+				if !seen[ev.ID] {
+					seen[ev.ID] = true
+					evs = append(evs, ev)
+				}
+			}
+			sort.Slice(evs, func(i, j int) bool { return evs[i].ID > evs[j].ID })
+			return evs, nil
+		}),
+		{Name: "avatar.jpg", Collect: func(ctx context.Context, u *models.User, w io.Writer) error {
+			if u.AvatarKey == "" {
+				return export.ErrSkip
+			}
+			rc, _, err := blobs.Get(ctx, avatarObject(u.AvatarKey, avatar.Sizes[len(avatar.Sizes)-1]))
+			if errors.Is(err, blob.ErrNotFound) {
+				return export.ErrSkip
+			}
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+			_, err = io.Copy(w, rc)
+			return err
+		}},
+	}
+}
+
+func namesOfRoles(roles []models.Role) []string {
+	names := make([]string, 0, len(roles))
+	for _, r := range roles {
+		names = append(names, r.Name)
+	}
+	return names
+}
+
+// exportAuditEvents reads every audit event matching f, newest first
+func exportAuditEvents(ctx context.Context, s store.AuditRepository, f store.AuditFilter) ([]models.AuditEvent, error) {
+	var all []models.AuditEvent
+	f.Limit = exportAuditPage
+	for {
+		evs, err := s.ListAuditEvents(ctx, f)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, evs...)
+		if len(evs) < exportAuditPage {
+			return all, nil
+		}
+		f.Before = evs[len(evs)-1].ID
+	}
+}
+
+// exportView renders an export job, with a signed download link once it is done
+func (h *Handler) exportView(j *models.ExportJob) map[string]interface{} {
+	v := map[string]interface{}{"id": j.ID, "status": j.Status, "progress": j.Progress, "created_at": j.CreatedAt, "finished_at": j.FinishedAt, "expires_at": j.ExpiresAt}
+	if j.Error != "" && j.Status == models.ExportFailed {
+		v["error"] = "export failed"
+	}
+	if j.Status == models.ExportDone && j.BlobKey != "" {
+		v["size"] = j.Size
+		v["download_url"] = h.opts.Media.URL(j.BlobKey, time.Now())
+	}
+	return v
+}
+
+// RequestExport queues an export of everything held about the caller. It
+// answers 202 with the job, to be polled at its Location; a caller with an
+// export already in progress gets that one instead.
+func (h *Handler) RequestExport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := GetClaims(r)
+	ev := newAuditEvent(r, AuditUserExport, "user", claims.UserID)
+	if claims.Impersonated() {
+		log.Printf("export denied: impersonation, actor=%d, target=%d", claims.Act.UserID, claims.UserID)
+		ev.Detail = "export under impersonation"
+		h.audit(ctx, ev, models.AuditDenied)
+		writeError(w, http.StatusForbidden, "not allowed while impersonating")
+		return
+	}
+	j, err := h.store.GetActiveExportJob(ctx, claims.UserID)
+	if err == nil {
+		w.Header().Set("Location", "/api/me/export/"+strconv.FormatUint(uint64(j.ID), 10))
+		writeJSON(w, http.StatusAccepted, h.exportView(j))
+		return
+	}
+	if !errors.Is(err, store.ErrNotFound) {
+		writeError(w, http.StatusInternalServerError, "failed to load exports")
+		return
+	}
+	j = &models.ExportJob{UserID: claims.UserID, Status: models.ExportPending}
+	err = h.store.Audited(ctx, ev, func(tx store.Repository) error {
+		if err := tx.CreateExportJob(ctx, j); err != nil {
+			return err
+		}
+		ev.Diff = map[string]interface{}{"job_id": j.ID}
+		return nil
+	})
+	if err != nil {
+		log.Printf("request export failed: userID=%d, err=%v", claims.UserID, err)
+		writeError(w, http.StatusInternalServerError, "failed to queue export")
+		return
+	}
+	if h.opts.Exporter != nil {
+		h.opts.Exporter.Wake()
+	}
+	log.Printf("export requested: userID=%d, job=%d", claims.UserID, j.ID)
+	w.Header().Set("Location", "/api/me/export/"+strconv.FormatUint(uint64(j.ID), 10))
+	writeJSON(w, http.StatusAccepted, h.exportView(j))
+}
+
+// GetExport reports the progress of one of the caller's exports and, once it
+// is done, links the archive
+func (h *Handler) GetExport(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	j, err := h.store.GetExportJob(r.Context(), GetClaims(r).UserID, uint(id))
+	if errors.Is(err, store.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load export")
+		return
+	}
+	writeJSON(w, http.StatusOK, h.exportView(j))
+}
@@ -0,0 +1,375 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"services/user/internal/models"
+	"services/user/internal/store"
+)
+
+// GroupReq is used to create or update a group
+type GroupReq struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// groupView renders a group with its granted roles
+func (h *Handler) groupView(ctx context.Context, g *models.Group) map[string]interface{} {
+	roles, _ := h.store.GetGroupRoles(ctx, g.ID)
+	names := []string{}
+	for _, rr := range roles {
+		names = append(names, rr.Name)
+	}
+	return map[string]interface{}{"id": g.ID, "name": g.Name, "description": g.Description, "roles": names}
+}
+
+// groupFromURL loads the group referenced by the {id} URL param, writing an error response if it fails
+func (h *Handler) groupFromURL(w http.ResponseWriter, r *http.Request) (*models.Group, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid group id")
+		return nil, false
+	}
+	g, err := h.store.GetGroupByID(r.Context(), uint(id))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "group not found")
+		return nil, false
+	}
+	return g, true
+}
+
+// ListGroups - admin only
+func (h *Handler) ListGroups(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "admin only")
+		return
+	}
+	gs, err := h.store.ListGroups(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	out := make([]map[string]interface{}, 0, len(gs))
+	for i := range gs {
+		out = append(out, h.groupView(r.Context(), &gs[i]))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// CreateGroup - admin only
+func (h *Handler) CreateGroup(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "admin only")
+		return
+	}
+	var req GroupReq
+	if err := parseBody(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	claims := GetClaims(r)
+	g := &models.Group{Name: req.Name, Description: req.Description}
+	ev := newAuditEvent(r, AuditGroupCreate, "group", 0)
+	ev.Diff = map[string]interface{}{"name": g.Name, "description": g.Description}
+	err := h.store.Audited(ctx, ev, func(tx store.Repository) error {
+		if err := tx.CreateGroup(ctx, g); err != nil {
+			return err
+		}
+		ev.TargetID = strconv.FormatUint(uint64(g.ID), 10)
+		return nil
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if claims != nil {
+		log.Printf("create group success: id=%d, name=%s, requestedBy=%d", g.ID, g.Name, claims.UserID)
+	}
+	writeJSON(w, http.StatusCreated, h.groupView(ctx, g))
+}
+
+// GetGroup - admin only
+func (h *Handler) GetGroup(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "admin only")
+		return
+	}
+	g, ok := h.groupFromURL(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, h.groupView(r.Context(), g))
+}
+
+// UpdateGroup - admin only
+func (h *Handler) UpdateGroup(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "admin only")
+		return
+	}
+	g, ok := h.groupFromURL(w, r)
+	if !ok {
+		return
+	}
+	var req GroupReq
+	if err := parseBody(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid")
+		return
+	}
+	diff := map[string]interface{}{}
+	if name := strings.TrimSpace(req.Name); name != "" && name != g.Name {
+		diff["name"] = map[string]string{"old": g.Name, "new": name}
+		g.Name = name
+	}
+	if req.Description != "" && req.Description != g.Description {
+		diff["description"] = map[string]string{"old": g.Description, "new": req.Description}
+		g.Description = req.Description
+	}
+	ev := newAuditEvent(r, AuditGroupUpdate, "group", g.ID)
+	ev.Diff = diff
+	if err := h.store.Audited(ctx, ev, func(tx store.Repository) error { return tx.UpdateGroup(ctx, g) }); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, h.groupView(ctx, g))
+}
+
+// DeleteGroup - admin only
+func (h *Handler) DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "admin only")
+		return
+	}
+	g, ok := h.groupFromURL(w, r)
+	if !ok {
+		return
+	}
+	ev := newAuditEvent(r, AuditGroupDelete, "group", g.ID)
+	ev.Diff = map[string]interface{}{"name": g.Name}
+	err := h.store.Audited(ctx, ev, func(tx store.Repository) error {
+		members, err := tx.GetGroupMembers(ctx, g.ID)
+		if err != nil {
+			return err
+		}
+		if err := tx.DeleteGroup(ctx, g.ID); err != nil {
+			return err
+		}
+		for _, m := range members {
+			if err := enqueueUserEvent(ctx, tx, models.EventUserRolesChanged, m.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	claims := GetClaims(r)
+	if claims != nil {
+		log.Printf("delete group success: id=%d, name=%s, requestedBy=%d", g.ID, g.Name, claims.UserID)
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// ListGroupMembers - admin only
+func (h *Handler) ListGroupMembers(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "admin only")
+		return
+	}
+	g, ok := h.groupFromURL(w, r)
+	if !ok {
+		return
+	}
+	us, err := h.store.GetGroupMembers(r.Context(), g.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	out := make([]map[string]interface{}, 0, len(us))
+	for _, u := range us {
+		out = append(out, map[string]interface{}{"id": u.ID, "email": u.Email, "full_name": u.FullName})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// GroupMemberReq adds users to a group
+type GroupMemberReq struct {
+	UserIDs []uint `json:"user_ids"`
+}
+
+// AddGroupMembers - admin only
+func (h *Handler) AddGroupMembers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "admin only")
+		return
+	}
+	g, ok := h.groupFromURL(w, r)
+	if !ok {
+		return
+	}
+	var req GroupMemberReq
+	if err := parseBody(r, &req); err != nil || len(req.UserIDs) == 0 {
+		writeError(w, http.StatusBadRequest, "invalid")
+		return
+	}
+	for _, uid := range req.UserIDs {
+		if _, err := h.users.GetUserByID(ctx, uid); err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				writeError(w, http.StatusNotFound, "user not found: "+strconv.Itoa(int(uid)))
+				return
+			}
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	claims := GetClaims(r)
+	for _, uid := range req.UserIDs {
+		ev := newAuditEvent(r, AuditGroupMemberAdd, "group", g.ID)
+		ev.Diff = map[string]interface{}{"user_id": uid}
+		err := h.store.Audited(ctx, ev, func(tx store.Repository) error {
+			if err := tx.AddUserToGroup(ctx, g.ID, uid); err != nil {
+				return err
+			}
+			return enqueueUserEvent(ctx, tx, models.EventUserRolesChanged, uid)
+		})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to add member")
+			return
+		}
+		if claims != nil {
+			log.Printf("add group member success: group=%d, user=%d, requestedBy=%d", g.ID, uid, claims.UserID)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "added"})
+}
+
+// RemoveGroupMember - admin only
+func (h *Handler) RemoveGroupMember(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "admin only")
+		return
+	}
+	g, ok := h.groupFromURL(w, r)
+	if !ok {
+		return
+	}
+	uid, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	ev := newAuditEvent(r, AuditGroupMemberRemove, "group", g.ID)
+	ev.Diff = map[string]interface{}{"user_id": uid}
+	err = h.store.Audited(ctx, ev, func(tx store.Repository) error {
+		if err := tx.RemoveUserFromGroup(ctx, g.ID, uint(uid)); err != nil {
+			return err
+		}
+		if _, err := tx.GetUserByID(ctx, uint(uid)); errors.Is(err, store.ErrNotFound) {
+			return nil
+		}
+		return enqueueUserEvent(ctx, tx, models.EventUserRolesChanged, uint(uid))
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to remove member")
+		return
+	}
+	claims := GetClaims(r)
+	if claims != nil {
+		log.Printf("remove group member success: group=%d, user=%d, requestedBy=%d", g.ID, uid, claims.UserID)
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "removed"})
+}
+
+// AssignGroupRole - admin only
+func (h *Handler) AssignGroupRole(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "admin only")
+		return
+	}
+	g, ok := h.groupFromURL(w, r)
+	if !ok {
+		return
+	}
+	var req AssignRoleReq
+	if err := parseBody(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid")
+		return
+	}
+	role, err := h.roles.GetRoleByName(ctx, req.RoleName)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "role not found")
+		return
+	}
+	ev := newAuditEvent(r, AuditGroupRoleAssign, "group", g.ID)
+	ev.Diff = map[string]interface{}{"role": role.Name}
+	err = h.store.Audited(ctx, ev, func(tx store.Repository) error {
+		if err := tx.AssignRoleToGroup(ctx, g.ID, role.ID); err != nil {
+			return err
+		}
+		return enqueueGroupRolesChanged(ctx, tx, g.ID)
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to assign role")
+		return
+	}
+	claims := GetClaims(r)
+	if claims != nil {
+		log.Printf("assign group role success: role=%s, group=%d, requestedBy=%d", role.Name, g.ID, claims.UserID)
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "assigned"})
+}
+
+// RemoveGroupRole - admin only
+func (h *Handler) RemoveGroupRole(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "admin only")
+		return
+	}
+	g, ok := h.groupFromURL(w, r)
+	if !ok {
+		return
+	}
+	role, err := h.roles.GetRoleByName(ctx, chi.URLParam(r, "roleName"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "role not found")
+		return
+	}
+	ev := newAuditEvent(r, AuditGroupRoleRemove, "group", g.ID)
+	ev.Diff = map[string]interface{}{"role": role.Name}
+	err = h.store.Audited(ctx, ev, func(tx store.Repository) error {
+		if err := tx.RemoveRoleFromGroup(ctx, g.ID, role.ID); err != nil {
+			return err
+		}
+		return enqueueGroupRolesChanged(ctx, tx, g.ID)
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to remove role")
+		return
+	}
+	claims := GetClaims(r)
+	if claims != nil {
+		log.Printf("remove group role success: role=%s, group=%d, requestedBy=%d", role.Name, g.ID, claims.UserID)
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "removed"})
+}
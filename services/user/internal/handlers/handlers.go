@@ -3,25 +3,71 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
 
 	"services/user/internal/auth"
+	"services/user/internal/blob"
+	"services/user/internal/broadcast"
+	"services/user/internal/export"
+	"services/user/internal/mailer"
 	"services/user/internal/models"
 	"services/user/internal/store"
 )
 
 type Handler struct {
-	store *store.Store
+	// store runs audited transactions and whatever users and roles do not cover
+	store store.Repository
+	users store.UserRepository
+	roles store.RoleRepository
 	jwt   *auth.JWTManager
+	opts  Options
+	// settingsHub pushes settings changes to the user's other sessions
+	settingsHub *broadcast.Hub
 }
 
-func NewHandler(s *store.Store, jwt *auth.JWTManager) *Handler {
-	return &Handler{store: s, jwt: jwt}
+// Options configures the handlers beyond their store and JWT manager
+type Options struct {
+	// Mailer sends confirmation and notice emails; nil logs them
+	Mailer mailer.Mailer
+	// EmailChangeTTL is how long an email change can be confirmed
+	EmailChangeTTL time.Duration
+	// EmailConfirmURL, if set, is linked from the confirmation email with the
+	// token appended as the token query parameter
+	EmailConfirmURL string
+	// Blobs stores uploaded avatars
+	Blobs blob.Store
+	// Media signs the URLs that stored objects are served under
+	Media *blob.Signer
+	// AvatarMaxBytes bounds the size of avatar uploads
+	AvatarMaxBytes int64
+	// Exporter builds data exports; it is woken when one is requested
+	Exporter *export.Worker
+	// DeletionGrace is how long after asking for it an account is deleted
+	DeletionGrace time.Duration
+}
+
+func NewHandler(s store.Repository, jwt *auth.JWTManager, opts Options) *Handler {
+	if opts.Mailer == nil {
+		opts.Mailer = mailer.Log{}
+	}
+	if opts.EmailChangeTTL <= 0 {
+		opts.EmailChangeTTL = 24 * time.Hour
+	}
+	if opts.AvatarMaxBytes <= 0 {
+		opts.AvatarMaxBytes = 5 << 20
+	}
+	if opts.DeletionGrace <= 0 {
+		opts.DeletionGrace = 14 * 24 * time.Hour
+	}
+	return &Handler{store: s, users: s, roles: s, jwt: jwt, opts: opts, settingsHub: broadcast.NewHub()}
 }
 
 func writeJSON(w http.ResponseWriter, code int, v interface{}) {
@@ -38,6 +84,31 @@ func parseBody(r *http.Request, v interface{}) error {
 	return json.NewDecoder(r.Body).Decode(v)
 }
 
+// etag is the entity tag of a versioned resource, such as a user, at version v
+func etag(v uint) string {
+	return `"` + strconv.FormatUint(uint64(v), 10) + `"`
+}
+
+// checkIfMatch requires the request's If-Match header to name the user's
+// current version. It writes 428 when the header is missing and 412 when no
+// tag matches, and reports whether the update may go ahead.
+func checkIfMatch(w http.ResponseWriter, r *http.Request, version uint) bool {
+	h := r.Header.Get("If-Match")
+	if h == "" {
+		writeError(w, http.StatusPreconditionRequired, "If-Match header required")
+		return false
+	}
+	for _, tag := range strings.Split(h, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag(version) {
+			return true
+		}
+	}
+	w.Header().Set("ETag", etag(version))
+	writeError(w, http.StatusPreconditionFailed, "user has been modified")
+	return false
+}
+
 // Register request
 type RegisterRequest struct {
 	Email    string `json:"email"`
@@ -46,6 +117,7 @@ type RegisterRequest struct {
 }
 
 func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	var req RegisterRequest
 	if err := parseBody(r, &req); err != nil {
 		writeError(w, http.StatusBadRequest, "invalid payload")
@@ -57,19 +129,32 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusInternalServerError, "failed to set password")
 		return
 	}
-	if err := h.store.CreateUser(u); err != nil {
-		writeError(w, http.StatusInternalServerError, err.Error())
+	ev := newAuditEvent(r, AuditUserRegister, "user", 0)
+	ev.Diff = map[string]interface{}{"email": u.Email, "full_name": u.FullName}
+	// the user, their default role and the webhook are committed together
+	err := h.store.Audited(ctx, ev, func(tx store.Repository) error {
+		if err := tx.CreateUser(ctx, u); err != nil {
+			return err
+		}
+		ev.TargetID = strconv.FormatUint(uint64(u.ID), 10)
+		role, err := tx.EnsureRole(ctx, "user")
+		if err != nil {
+			return err
+		}
+		if err := tx.AssignRoleToUser(ctx, u.ID, role.ID); err != nil {
+			return err
+		}
+		return tx.EnqueueWebhookEvent(ctx, models.EventUserCreated, u.ID, webhookUser(u, []string{role.Name}))
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		writeError(w, http.StatusConflict, "email already registered")
 		return
 	}
-	// assign default role
-	role, err := h.store.GetRoleByName("user")
 	if err != nil {
-		// create role
-		roleObj := &models.Role{Name: "user"}
-		h.store.CreateRole(roleObj)
-		role, _ = h.store.GetRoleByName("user")
+		log.Printf("register failed: email=%s, err=%v", u.Email, err)
+		writeError(w, http.StatusInternalServerError, "failed to register user")
+		return
 	}
-	h.store.AssignRoleToUser(u.ID, role.ID)
 	writeJSON(w, http.StatusCreated, map[string]interface{}{"id": u.ID, "email": u.Email})
 	log.Printf("register success: userID=%d, email=%s, remote=%s", u.ID, u.Email, r.RemoteAddr)
 }
@@ -81,34 +166,70 @@ type LoginRequest struct {
 }
 
 func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	var req LoginRequest
 	if err := parseBody(r, &req); err != nil {
 		writeError(w, http.StatusBadRequest, "invalid payload")
 		return
 	}
 	log.Printf("login attempt: email=%s, remote=%s", req.Email, r.RemoteAddr)
-	u, err := h.store.GetUserByEmail(strings.TrimSpace(req.Email))
+	u, err := h.users.GetUserByEmail(ctx, strings.TrimSpace(req.Email))
 	if err != nil {
 		log.Printf("login failed: user not found email=%s, remote=%s", strings.TrimSpace(req.Email), r.RemoteAddr)
+		ev := newAuditEvent(r, AuditUserLoginFailed, "user", 0)
+		ev.ActorEmail = strings.TrimSpace(req.Email)
+		ev.Detail = "unknown email"
+		h.audit(ctx, ev, models.AuditFailure)
 		writeError(w, http.StatusUnauthorized, "invalid credentials")
 		return
 	}
 	if !u.CheckPassword(req.Password) {
 		log.Printf("login failed: bad password for email=%s, remote=%s", req.Email, r.RemoteAddr)
+		ev := newAuditEvent(r, AuditUserLoginFailed, "user", u.ID)
+		ev.ActorEmail = u.Email
+		ev.Detail = "bad password"
+		h.audit(ctx, ev, models.AuditFailure)
 		writeError(w, http.StatusUnauthorized, "invalid credentials")
 		return
 	}
-	roles, _ := h.store.GetUserRoles(u.ID)
+	if u.DisabledAt != nil {
+		log.Printf("login denied: account disabled userID=%d, remote=%s", u.ID, r.RemoteAddr)
+		ev := newAuditEvent(r, AuditUserLoginFailed, "user", u.ID)
+		ev.ActorEmail = u.Email
+		ev.Detail = "account disabled"
+		h.audit(ctx, ev, models.AuditDenied)
+		writeError(w, http.StatusForbidden, "account disabled")
+		return
+	}
+	// logging in during the grace period keeps the account
+	cancelled := u.DeletionDueAt != nil
+	if cancelled {
+		if err := h.cancelDeletion(r, u); err != nil {
+			log.Printf("cancel account deletion failed: userID=%d, err=%v", u.ID, err)
+			writeError(w, http.StatusInternalServerError, "failed to cancel account deletion")
+			return
+		}
+	}
+	roles, _ := h.roles.GetUserRoles(ctx, u.ID)
 	var roleNames []string
 	for _, r := range roles {
 		roleNames = append(roleNames, r.Name)
 	}
-	token, err := h.jwt.Generate(u.ID, u.Email, roleNames)
+	token, err := h.jwt.Generate(u.ID, u.Email, u.TokenVersion, roleNames)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to generate token")
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]interface{}{"token": token, "user": map[string]interface{}{"id": u.ID, "email": u.Email, "full_name": u.FullName}})
+	ev := newAuditEvent(r, AuditUserLogin, "user", u.ID)
+	uid := u.ID
+	ev.ActorID = &uid
+	ev.ActorEmail = u.Email
+	h.audit(ctx, ev, models.AuditSuccess)
+	resp := map[string]interface{}{"token": token, "user": map[string]interface{}{"id": u.ID, "email": u.Email, "full_name": u.FullName, "profile": h.profileView(u)}}
+	if cancelled {
+		resp["deletion_cancelled"] = true
+	}
+	writeJSON(w, http.StatusOK, resp)
 	log.Printf("login success: userID=%d, email=%s, remote=%s", u.ID, u.Email, r.RemoteAddr)
 }
 
@@ -122,8 +243,10 @@ func hasRole(roles []string, name string) bool {
 	return false
 }
 
-// AuthMiddleware extracts the user claims and sets them in context
-func AuthMiddleware(jwt *auth.JWTManager) func(http.Handler) http.Handler {
+// AuthMiddleware extracts the user claims and sets them in context. Tokens
+// of users that no longer exist, or whose email has changed since the token
+// was issued, are rejected.
+func AuthMiddleware(jwt *auth.JWTManager, users store.UserRepository) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			auth := r.Header.Get("Authorization")
@@ -144,6 +267,25 @@ func AuthMiddleware(jwt *auth.JWTManager) func(http.Handler) http.Handler {
 				writeError(w, http.StatusUnauthorized, "invalid token")
 				return
 			}
+			u, err := users.GetUserByID(r.Context(), claims.UserID)
+			if err != nil && !errors.Is(err, store.ErrNotFound) {
+				log.Printf("token user lookup failed: userID=%d, err=%v", claims.UserID, err)
+				writeError(w, http.StatusInternalServerError, "failed to verify token")
+				return
+			}
+			// tokens of disabled users, and those issued before an email
+			// change, are no longer honoured. The token version catches
+			// changes that were later undone; tokens issued before it was
+			// introduced carry none and are checked by their email alone.
+			if err != nil || u.Email != claims.Email || u.TokenVersion != claims.TokenVersion || u.DisabledAt != nil {
+				log.Printf("revoked token: userID=%d, email=%s, remote=%s", claims.UserID, claims.Email, r.RemoteAddr)
+				writeError(w, http.StatusUnauthorized, "token revoked")
+				return
+			}
+			if claims.Impersonated() {
+				log.Printf("impersonated request: actor=%d, actorEmail=%s, subject=%d, method=%s, path=%s, remote=%s", claims.Act.UserID, claims.Act.Email, claims.UserID, r.Method, r.URL.Path, r.RemoteAddr)
+				w.Header().Set("X-Impersonated-By", strconv.FormatUint(uint64(claims.Act.UserID), 10))
+			}
 			// store claims in ctx
 			ctx := r.Context()
 			ctx = contextWithClaims(ctx, claims)
@@ -179,33 +321,116 @@ func isAdmin(r *http.Request) bool {
 	return false
 }
 
-// ListUsers - admin only
+// Page sizes of GET /api/users
+const (
+	defaultUserPageLimit = 50
+	maxUserPageLimit     = 200
+)
+
+// ListUsers - admin only. Optional query params: q (email or name substring),
+// role, created_after (RFC 3339), sort (id, created_at or email, prefix "-"
+// for descending) and cursor/limit keyset pagination. The response carries
+// the total number of matching users and next_cursor while more remain.
 func (h *Handler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	h.listUsers(w, r, false)
+}
+
+// ListDeletedUsers - admin only. Lists soft-deleted users with the query
+// params of ListUsers.
+func (h *Handler) ListDeletedUsers(w http.ResponseWriter, r *http.Request) {
+	h.listUsers(w, r, true)
+}
+
+func (h *Handler) listUsers(w http.ResponseWriter, r *http.Request, deleted bool) {
 	if !isAdmin(r) {
 		writeError(w, http.StatusForbidden, "admin only")
 		return
 	}
+	ctx := r.Context()
 	claims := GetClaims(r)
 	if claims != nil {
-		log.Printf("list users requested by userID=%d", claims.UserID)
+		log.Printf("list users requested by userID=%d, deleted=%t", claims.UserID, deleted)
+	}
+	q := r.URL.Query()
+	f := store.UserFilter{Query: strings.TrimSpace(q.Get("q")), Role: q.Get("role"), Deleted: deleted, Sort: store.UserSortID, Limit: defaultUserPageLimit}
+	if v := q.Get("sort"); v != "" {
+		if !store.ValidUserSort(v) {
+			writeError(w, http.StatusBadRequest, "invalid sort")
+			return
+		}
+		f.Sort = v
+	}
+	if v := q.Get("cursor"); v != "" {
+		c, err := store.ParseUserCursor(f.Sort, v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+		f.After = c
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		if n > maxUserPageLimit {
+			n = maxUserPageLimit
+		}
+		f.Limit = n
+	}
+	if v := q.Get("created_after"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid created_after")
+			return
+		}
+		f.CreatedAfter = t
+	}
+	total, err := h.users.CountUsers(ctx, f)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// fetch one extra row to know whether another page exists
+	limit := f.Limit
+	f.Limit++
+	us, err := h.users.ListUsers(ctx, f)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	next := ""
+	if len(us) > limit {
+		us = us[:limit]
+		next = store.NewUserCursor(f.Sort, &us[len(us)-1])
+	}
+	ids := make([]uint, 0, len(us))
+	for _, u := range us {
+		ids = append(ids, u.ID)
 	}
-	us, err := h.store.ListUsers()
+	roles, err := h.roles.GetRolesForUsers(ctx, ids)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	// attach roles
 	out := make([]map[string]interface{}, 0, len(us))
 	for _, u := range us {
-		roles, _ := h.store.GetUserRoles(u.ID)
-		var names []string
-		for _, rr := range roles {
+		names := []string{}
+		for _, rr := range roles[u.ID] {
 			names = append(names, rr.Name)
 		}
-		out = append(out, map[string]interface{}{"id": u.ID, "email": u.Email, "full_name": u.FullName, "roles": names})
+		item := map[string]interface{}{"id": u.ID, "email": u.Email, "full_name": u.FullName, "created_at": u.CreatedAt, "roles": names}
+		if u.DeletedAt.Valid {
+			item["deleted_at"] = u.DeletedAt.Time
+		}
+		if u.DisabledAt != nil {
+			item["disabled_at"] = u.DisabledAt
+		}
+		out = append(out, item)
 	}
-	writeJSON(w, http.StatusOK, out)
-	log.Printf("list users returned: count=%d", len(out))
+	writeJSON(w, http.StatusOK, map[string]interface{}{"users": out, "next_cursor": next, "total": total})
+	log.Printf("list users returned: count=%d, total=%d", len(out), total)
 }
 
 // GetUser
@@ -217,7 +442,7 @@ func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusForbidden, "forbidden")
 		return
 	}
-	u, err := h.store.GetUserByID(uint(id))
+	u, err := h.users.GetUserByID(r.Context(), uint(id))
 	if err != nil {
 		writeError(w, http.StatusNotFound, "not found")
 		return
@@ -225,12 +450,17 @@ func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
 	if claims != nil {
 		log.Printf("get user: requestedBy=%d, target=%d", claims.UserID, id)
 	}
-	roles, _ := h.store.GetUserRoles(u.ID)
+	roles, _ := h.roles.GetUserRoles(r.Context(), u.ID)
 	var names []string
 	for _, rr := range roles {
 		names = append(names, rr.Name)
 	}
-	writeJSON(w, http.StatusOK, map[string]interface{}{"id": u.ID, "email": u.Email, "full_name": u.FullName, "roles": names})
+	resp := map[string]interface{}{"id": u.ID, "email": u.Email, "full_name": u.FullName, "roles": names, "profile": h.profileView(u), "version": u.Version}
+	if u.DisabledAt != nil {
+		resp["disabled_at"] = u.DisabledAt
+	}
+	w.Header().Set("ETag", etag(u.Version))
+	writeJSON(w, http.StatusOK, resp)
 }
 
 // UpdateUser
@@ -240,6 +470,7 @@ type UpdateUserReq struct {
 }
 
 func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	idStr := chi.URLParam(r, "id")
 	id, _ := strconv.Atoi(idStr)
 	claims := GetClaims(r)
@@ -253,23 +484,61 @@ func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "invalid")
 		return
 	}
-	u, err := h.store.GetUserByID(uint(id))
+	if req.Password != "" && claims.Impersonated() {
+		log.Printf("update user denied: password change under impersonation, actor=%d, target=%d", claims.Act.UserID, id)
+		ev := newAuditEvent(r, AuditUserUpdate, "user", uint(id))
+		ev.Detail = "password change under impersonation"
+		h.audit(ctx, ev, models.AuditDenied)
+		writeError(w, http.StatusForbidden, "not allowed while impersonating")
+		return
+	}
+	u, err := h.users.GetUserByID(ctx, uint(id))
 	if err != nil {
 		writeError(w, http.StatusNotFound, "not found")
 		return
 	}
-	if req.FullName != "" {
+	if !checkIfMatch(w, r, u.Version) {
+		return
+	}
+	diff := map[string]interface{}{}
+	if req.FullName != "" && req.FullName != u.FullName {
+		diff["full_name"] = map[string]string{"old": u.FullName, "new": req.FullName}
 		u.FullName = req.FullName
 	}
 	if req.Password != "" {
-		u.SetPassword(req.Password)
+		if err := u.SetPassword(req.Password); err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to set password")
+			return
+		}
+		diff["password"] = "changed"
+	}
+	ev := newAuditEvent(r, AuditUserUpdate, "user", u.ID)
+	ev.Diff = diff
+	err = h.store.Audited(ctx, ev, func(tx store.Repository) error {
+		if err := tx.UpdateUser(ctx, u); err != nil {
+			return err
+		}
+		return enqueueUserEvent(ctx, tx, models.EventUserUpdated, u.ID)
+	})
+	if errors.Is(err, store.ErrVersionConflict) {
+		writeError(w, http.StatusPreconditionFailed, "user has been modified")
+		return
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "not found")
+		return
 	}
-	h.store.UpdateUser(u)
-	writeJSON(w, http.StatusOK, map[string]interface{}{"id": u.ID, "email": u.Email})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to update user")
+		return
+	}
+	w.Header().Set("ETag", etag(u.Version))
+	writeJSON(w, http.StatusOK, map[string]interface{}{"id": u.ID, "email": u.Email, "version": u.Version})
 }
 
 // DeleteUser
 func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	if !isAdmin(r) {
 		writeError(w, http.StatusForbidden, "admin only")
 		return
@@ -277,11 +546,103 @@ func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, _ := strconv.Atoi(idStr)
 	log.Printf("delete user attempt: requestedBy admin, target=%d", id)
-	_ = h.store.DeleteUser(uint(id))
+	ev := newAuditEvent(r, AuditUserDelete, "user", uint(id))
+	err := h.store.Audited(ctx, ev, func(tx store.Repository) error {
+		u, err := tx.GetUserByID(ctx, uint(id))
+		if errors.Is(err, store.ErrNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := tx.DeleteUser(ctx, u.ID); err != nil {
+			return err
+		}
+		return tx.EnqueueWebhookEvent(ctx, models.EventUserDeleted, u.ID, map[string]interface{}{"id": u.ID, "email": u.Email})
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to delete user")
+		return
+	}
 	log.Printf("deleted user: id=%d", id)
 	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
 }
 
+// RestoreUser - admin only. Undeletes a soft-deleted user; fails with 409 if
+// their email has been registered again in the meantime.
+func (h *Handler) RestoreUser(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "admin only")
+		return
+	}
+	ctx := r.Context()
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	ev := newAuditEvent(r, AuditUserRestore, "user", uint(id))
+	err = h.store.Audited(ctx, ev, func(tx store.Repository) error {
+		if err := tx.RestoreUser(ctx, uint(id)); err != nil {
+			return err
+		}
+		return enqueueUserEvent(ctx, tx, models.EventUserRestored, uint(id))
+	})
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		writeError(w, http.StatusNotFound, "deleted user not found")
+		return
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		writeError(w, http.StatusConflict, "email is in use by another user")
+		return
+	case err != nil:
+		log.Printf("restore user failed: id=%d, err=%v", id, err)
+		writeError(w, http.StatusInternalServerError, "failed to restore user")
+		return
+	}
+	log.Printf("restored user: id=%d", id)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "restored"})
+}
+
+// PurgeUser - admin only. Permanently removes a soft-deleted user; live
+// users have to be deleted first. Audit events about the user are kept,
+// without their personal data.
+func (h *Handler) PurgeUser(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "admin only")
+		return
+	}
+	ctx := r.Context()
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	ev := newAuditEvent(r, AuditUserPurge, "user", uint(id))
+	var purged store.PurgedBlobs
+	err = h.store.Audited(ctx, ev, func(tx store.Repository) error {
+		u, err := tx.GetDeletedUser(ctx, uint(id))
+		if err != nil {
+			return err
+		}
+		if purged, err = tx.PurgeUser(ctx, u.ID); err != nil {
+			return err
+		}
+		return tx.EnqueueWebhookEvent(ctx, models.EventUserPurged, u.ID, map[string]interface{}{"id": u.ID, "email": u.Email})
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "deleted user not found")
+		return
+	}
+	if err != nil {
+		log.Printf("purge user failed: id=%d, err=%v", id, err)
+		writeError(w, http.StatusInternalServerError, "failed to purge user")
+		return
+	}
+	DeletePurgedBlobs(ctx, h.opts.Blobs, purged)
+	log.Printf("purged user: id=%d", id)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "purged"})
+}
+
 // CreateRole
 type CreateRoleReq struct {
 	Name string `json:"name"`
@@ -302,7 +663,20 @@ func (h *Handler) CreateRole(w http.ResponseWriter, r *http.Request) {
 		log.Printf("create role attempt: name=%s, requestedBy=%d", req.Name, claims.UserID)
 	}
 	role := &models.Role{Name: req.Name}
-	if err := h.store.CreateRole(role); err != nil {
+	ev := newAuditEvent(r, AuditRoleCreate, "role", 0)
+	ev.Diff = map[string]interface{}{"name": req.Name}
+	err := h.store.Audited(r.Context(), ev, func(tx store.Repository) error {
+		if err := tx.CreateRole(r.Context(), role); err != nil {
+			return err
+		}
+		ev.TargetID = strconv.FormatUint(uint64(role.ID), 10)
+		return nil
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		writeError(w, http.StatusConflict, "role already exists")
+		return
+	}
+	if err != nil {
 		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -318,18 +692,23 @@ type AssignRoleReq struct {
 }
 
 func (h *Handler) AssignRole(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	if !isAdmin(r) {
 		writeError(w, http.StatusForbidden, "admin only")
 		return
 	}
 	idStr := chi.URLParam(r, "id")
-	id, _ := strconv.Atoi(idStr)
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
 	var req AssignRoleReq
 	if err := parseBody(r, &req); err != nil {
 		writeError(w, http.StatusBadRequest, "invalid")
 		return
 	}
-	role, err := h.store.GetRoleByName(req.RoleName)
+	role, err := h.roles.GetRoleByName(ctx, req.RoleName)
 	if err != nil {
 		writeError(w, http.StatusNotFound, "role not found")
 		return
@@ -338,7 +717,23 @@ func (h *Handler) AssignRole(w http.ResponseWriter, r *http.Request) {
 	if claims != nil {
 		log.Printf("assign role attempt: role=%s, target=%d, requestedBy=%d", role.Name, id, claims.UserID)
 	}
-	if err := h.store.AssignRoleToUser(uint(id), role.ID); err != nil {
+	ev := newAuditEvent(r, AuditRoleAssign, "user", uint(id))
+	ev.Diff = map[string]interface{}{"role": role.Name}
+	err = h.store.Audited(ctx, ev, func(tx store.Repository) error {
+		if _, err := tx.GetUserByID(ctx, uint(id)); err != nil {
+			return err
+		}
+		if err := tx.AssignRoleToUser(ctx, uint(id), role.ID); err != nil {
+			return err
+		}
+		return enqueueUserEvent(ctx, tx, models.EventUserRolesChanged, uint(id))
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if err != nil {
+		log.Printf("assign role failed: role=%s, target=%d, err=%v", role.Name, id, err)
 		writeError(w, http.StatusInternalServerError, "failed to assign role")
 		return
 	}
@@ -348,6 +743,52 @@ func (h *Handler) AssignRole(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{"status": "assigned"})
 }
 
+// Impersonate - admin only. Issues a short-lived token for the target user
+// with an act claim naming the admin. Impersonated tokens cannot be used to
+// start another impersonation.
+func (h *Handler) Impersonate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "admin only")
+		return
+	}
+	claims := GetClaims(r)
+	if claims.Impersonated() {
+		writeError(w, http.StatusForbidden, "not allowed while impersonating")
+		return
+	}
+	idStr := chi.URLParam(r, "id")
+	id, _ := strconv.Atoi(idStr)
+	if uint(id) == claims.UserID {
+		writeError(w, http.StatusBadRequest, "cannot impersonate yourself")
+		return
+	}
+	u, err := h.users.GetUserByID(ctx, uint(id))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	roles, _ := h.roles.GetUserRoles(ctx, u.ID)
+	var roleNames []string
+	for _, rr := range roles {
+		roleNames = append(roleNames, rr.Name)
+	}
+	if hasRole(roleNames, "admin") {
+		writeError(w, http.StatusForbidden, "cannot impersonate an admin")
+		return
+	}
+	token, exp, err := h.jwt.GenerateImpersonation(u.ID, u.Email, u.TokenVersion, roleNames, auth.Actor{UserID: claims.UserID, Email: claims.Email})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to generate token")
+		return
+	}
+	ev := newAuditEvent(r, AuditUserImpersonate, "user", u.ID)
+	ev.Diff = map[string]interface{}{"expires_at": exp.UTC().Format(time.RFC3339)}
+	h.audit(ctx, ev, models.AuditSuccess)
+	log.Printf("impersonation started: actor=%d, actorEmail=%s, subject=%d, expires=%s, remote=%s", claims.UserID, claims.Email, u.ID, exp.UTC().Format(time.RFC3339), r.RemoteAddr)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"token": token, "expires_at": exp.UTC(), "user": map[string]interface{}{"id": u.ID, "email": u.Email, "full_name": u.FullName, "profile": h.profileView(u)}})
+}
+
 // Context claims helper
 
 type claimsContextKey struct{}
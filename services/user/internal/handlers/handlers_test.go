@@ -0,0 +1,388 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"services/user/internal/auth"
+	"services/user/internal/handlers"
+	"services/user/internal/mailer"
+	"services/user/internal/models"
+	"services/user/internal/store"
+	"services/user/internal/store/memstore"
+)
+
+// outbox is a mailer that keeps what it sends
+type outbox struct {
+	mu   sync.Mutex
+	sent []mailer.Message
+}
+
+func (o *outbox) Send(ctx context.Context, m mailer.Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.sent = append(o.sent, m)
+	return nil
+}
+
+// to returns the last message sent to addr
+func (o *outbox) to(t *testing.T, addr string) mailer.Message {
+	t.Helper()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i := len(o.sent) - 1; i >= 0; i-- {
+		if o.sent[i].To == addr {
+			return o.sent[i]
+		}
+	}
+	t.Fatalf("no mail sent to %s", addr)
+	return mailer.Message{}
+}
+
+// server routes the handlers like the app does, on an in-memory store
+type server struct {
+	http.Handler
+	store *memstore.Store
+	mail  *outbox
+}
+
+func newServer(t *testing.T) *server {
+	t.Helper()
+	s, mail := memstore.New(), &outbox{}
+	jwt := auth.NewJWTManager("test-secret")
+	h := handlers.NewHandler(s, jwt, handlers.Options{Mailer: mail})
+	r := chi.NewRouter()
+	r.Post("/auth/register", h.Register)
+	r.Post("/auth/login", h.Login)
+	r.Post("/auth/email/confirm", h.ConfirmEmailChange)
+	r.Route("/api", func(r chi.Router) {
+		r.Use(handlers.AuthMiddleware(jwt, s))
+		r.Get("/users/{id}", h.GetUser)
+		r.Put("/users/{id}", h.UpdateUser)
+		r.Patch("/users/{id}", h.PatchUser)
+	})
+	return &server{Handler: r, store: s, mail: mail}
+}
+
+// request is an HTTP request to the server; body is marshalled unless it is
+// a string
+type request struct {
+	method, path, token string
+	body                interface{}
+	header              map[string]string
+}
+
+func (srv *server) do(t *testing.T, req request) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	var body []byte
+	switch b := req.body.(type) {
+	case nil:
+	case string:
+		body = []byte(b)
+	default:
+		body, _ = json.Marshal(b)
+	}
+	r := httptest.NewRequest(req.method, req.path, bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	if req.token != "" {
+		r.Header.Set("Authorization", "Bearer "+req.token)
+	}
+	for k, v := range req.header {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	var out map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &out)
+	return w, out
+}
+
+// register signs up a user and returns their ID
+func (srv *server) register(t *testing.T, email, password string) uint {
+	t.Helper()
+	w, out := srv.do(t, request{method: "POST", path: "/auth/register", body: handlers.RegisterRequest{Email: email, Password: password, FullName: "Test User"}})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("register %s = %d %s; want 201", email, w.Code, w.Body)
+	}
+	return uint(out["id"].(float64))
+}
+
+// login returns a token for the user
+func (srv *server) login(t *testing.T, email, password string) string {
+	t.Helper()
+	w, out := srv.do(t, request{method: "POST", path: "/auth/login", body: handlers.LoginRequest{Email: email, Password: password}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("login %s = %d %s; want 200", email, w.Code, w.Body)
+	}
+	return out["token"].(string)
+}
+
+// admin registers a user holding the admin role and returns their token
+func (srv *server) admin(t *testing.T) string {
+	t.Helper()
+	ctx := context.Background()
+	id := srv.register(t, "admin@example.com", "admin-pass")
+	role, err := srv.store.EnsureRole(ctx, "admin")
+	if err != nil {
+		t.Fatalf("EnsureRole: %v", err)
+	}
+	if err := srv.store.AssignRoleToUser(ctx, id, role.ID); err != nil {
+		t.Fatalf("AssignRoleToUser: %v", err)
+	}
+	return srv.login(t, "admin@example.com", "admin-pass")
+}
+
+func userPath(id uint) string {
+	return "/api/users/" + strconv.FormatUint(uint64(id), 10)
+}
+
+// auditResults returns the results of the events recorded for action, oldest first
+func (srv *server) auditResults(t *testing.T, action string) []string {
+	t.Helper()
+	evs, err := srv.store.ListAuditEvents(context.Background(), store.AuditFilter{Action: action})
+	if err != nil {
+		t.Fatalf("ListAuditEvents: %v", err)
+	}
+	var results []string
+	for i := len(evs) - 1; i >= 0; i-- {
+		results = append(results, evs[i].Result)
+	}
+	return results
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	srv := newServer(t)
+	id := srv.register(t, "alice@example.com", "s3cret")
+
+	// the failed attempt is rolled back but audited
+	w, _ := srv.do(t, request{method: "POST", path: "/auth/register", body: handlers.RegisterRequest{Email: "alice@example.com", Password: "other"}})
+	if w.Code != http.StatusConflict {
+		t.Errorf("registering a taken email = %d; want 409", w.Code)
+	}
+	if n, _ := srv.store.CountUsers(context.Background(), store.UserFilter{}); n != 1 {
+		t.Errorf("users after the duplicate registration = %d; want 1", n)
+	}
+	if got := srv.auditResults(t, handlers.AuditUserRegister); strings.Join(got, ",") != "success,failure" {
+		t.Errorf("register audit results = %v; want [success failure]", got)
+	}
+
+	w, _ = srv.do(t, request{method: "POST", path: "/auth/login", body: handlers.LoginRequest{Email: "alice@example.com", Password: "wrong"}})
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("login with a bad password = %d; want 401", w.Code)
+	}
+	token := srv.login(t, "alice@example.com", "s3cret")
+
+	w, out := srv.do(t, request{method: "GET", path: userPath(id), token: token})
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET own user = %d %s; want 200", w.Code, w.Body)
+	}
+	if out["email"] != "alice@example.com" || out["full_name"] != "Test User" {
+		t.Errorf("user = %v; want alice's", out)
+	}
+	if roles, _ := out["roles"].([]interface{}); len(roles) != 1 || roles[0] != "user" {
+		t.Errorf("roles = %v; want [user]", out["roles"])
+	}
+	if got := w.Header().Get("ETag"); got != `"1"` {
+		t.Errorf("ETag = %s; want \"1\"", got)
+	}
+
+	if w, _ := srv.do(t, request{method: "GET", path: userPath(id)}); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET without a token = %d; want 401", w.Code)
+	}
+	other := srv.register(t, "bob@example.com", "s3cret")
+	if w, _ := srv.do(t, request{method: "GET", path: userPath(other), token: token}); w.Code != http.StatusForbidden {
+		t.Errorf("GET another user = %d; want 403", w.Code)
+	}
+}
+
+func TestUpdateUserIfMatch(t *testing.T) {
+	srv := newServer(t)
+	id := srv.register(t, "alice@example.com", "s3cret")
+	token := srv.login(t, "alice@example.com", "s3cret")
+	update := request{method: "PUT", path: userPath(id), token: token, body: handlers.UpdateUserReq{FullName: "Alice"}}
+
+	if w, _ := srv.do(t, update); w.Code != http.StatusPreconditionRequired {
+		t.Errorf("PUT without If-Match = %d; want 428", w.Code)
+	}
+
+	update.header = map[string]string{"If-Match": `"1"`}
+	w, out := srv.do(t, update)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT with the current ETag = %d %s; want 200", w.Code, w.Body)
+	}
+	if out["version"] != float64(2) || w.Header().Get("ETag") != `"2"` {
+		t.Errorf("after PUT version = %v, ETag = %s; want 2", out["version"], w.Header().Get("ETag"))
+	}
+
+	w, _ = srv.do(t, update)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("PUT with a stale ETag = %d; want 412", w.Code)
+	}
+	if got := w.Header().Get("ETag"); got != `"2"` {
+		t.Errorf("412 ETag = %s; want the current \"2\"", got)
+	}
+
+	update.header = map[string]string{"If-Match": "*"}
+	update.body = handlers.UpdateUserReq{FullName: "Alice B."}
+	if w, _ := srv.do(t, update); w.Code != http.StatusOK {
+		t.Errorf("PUT with If-Match * = %d; want 200", w.Code)
+	}
+	u, err := srv.store.GetUserByID(context.Background(), id)
+	if err != nil || u.FullName != "Alice B." || u.Version != 3 {
+		t.Errorf("stored user = %+v, %v; want full name Alice B. at version 3", u, err)
+	}
+}
+
+func TestPatchUser(t *testing.T) {
+	srv := newServer(t)
+	id := srv.register(t, "alice@example.com", "s3cret")
+	token := srv.login(t, "alice@example.com", "s3cret")
+	patch := func(contentType, ifMatch string, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		return srv.do(t, request{method: "PATCH", path: userPath(id), token: token, body: body, header: map[string]string{"Content-Type": contentType, "If-Match": ifMatch}})
+	}
+
+	w, out := patch("application/merge-patch+json", `"1"`, `{"full_name":"Alice","profile":{"display_name":"Al"}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("merge patch = %d %s; want 200", w.Code, w.Body)
+	}
+	if out["full_name"] != "Alice" || out["version"] != float64(2) {
+		t.Errorf("after merge patch = %v; want full name Alice at version 2", out)
+	}
+	if p, _ := out["profile"].(map[string]interface{}); p["display_name"] != "Al" {
+		t.Errorf("profile = %v; want display name Al", out["profile"])
+	}
+
+	w, _ = patch("application/json-patch+json", `"2"`, `[{"op":"test","path":"/full_name","value":"Bob"},{"op":"replace","path":"/full_name","value":"Carol"}]`)
+	if w.Code != http.StatusConflict {
+		t.Errorf("JSON patch with a failing test = %d; want 409", w.Code)
+	}
+	w, out = patch("application/json-patch+json", `"2"`, `[{"op":"test","path":"/full_name","value":"Alice"},{"op":"replace","path":"/full_name","value":"Carol"}]`)
+	if w.Code != http.StatusOK || out["full_name"] != "Carol" {
+		t.Errorf("JSON patch = %d %v; want 200 with full name Carol", w.Code, out)
+	}
+
+	if w, _ := patch("application/merge-patch+json", `"2"`, `{"full_name":"Dave"}`); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("patch with a stale ETag = %d; want 412", w.Code)
+	}
+	if w, _ := patch("application/merge-patch+json", "", `{"full_name":"Dave"}`); w.Code != http.StatusPreconditionRequired {
+		t.Errorf("patch without If-Match = %d; want 428", w.Code)
+	}
+
+	// roles and email are admin only
+	for _, body := range []string{`{"roles":["user","admin"]}`, `{"email":"mallory@example.com"}`} {
+		if w, _ := patch("application/merge-patch+json", `"3"`, body); w.Code != http.StatusForbidden {
+			t.Errorf("self patch %s = %d; want 403", body, w.Code)
+		}
+	}
+	if got := srv.auditResults(t, handlers.AuditUserUpdate); strings.Join(got, ",") != "success,success,denied,denied" {
+		t.Errorf("update audit results = %v; want two successes then two denials", got)
+	}
+	if w, _ := patch("text/plain", `"3"`, `{}`); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("patch as text/plain = %d; want 415", w.Code)
+	}
+}
+
+func TestAdminEmailChangeIsConfirmedFromNewAddress(t *testing.T) {
+	srv := newServer(t)
+	admin := srv.admin(t)
+	id := srv.register(t, "alice@example.com", "s3cret")
+	token := srv.login(t, "alice@example.com", "s3cret")
+
+	w, out := srv.do(t, request{method: "PATCH", path: userPath(id), token: admin, body: `{"email":"alice@new.example.com"}`,
+		header: map[string]string{"Content-Type": "application/merge-patch+json", "If-Match": `"1"`}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("admin email patch = %d %s; want 200", w.Code, w.Body)
+	}
+	if out["email"] != "alice@example.com" || out["email_change"] == nil {
+		t.Errorf("after patch = %v; want the old email and a pending change", out)
+	}
+	if got := srv.mail.to(t, "alice@example.com"); !strings.Contains(got.Body, "alice@new.example.com") {
+		t.Errorf("notice to the old address = %q; want it to name the new one", got.Body)
+	}
+
+	// the token is the line after the instruction in the confirmation mail
+	body := srv.mail.to(t, "alice@new.example.com").Body
+	lines := strings.Split(body, "\n")
+	var confirm string
+	for i, l := range lines {
+		if strings.HasPrefix(l, "Confirm the change with this token") && i+2 < len(lines) {
+			confirm = lines[i+2]
+		}
+	}
+	if confirm == "" {
+		t.Fatalf("no token in confirmation mail %q", body)
+	}
+	w, out = srv.do(t, request{method: "POST", path: "/auth/email/confirm", body: handlers.ConfirmEmailReq{Token: confirm}})
+	if w.Code != http.StatusOK || out["email"] != "alice@new.example.com" {
+		t.Fatalf("confirm = %d %v; want 200 with the new email", w.Code, out)
+	}
+	if w, _ := srv.do(t, request{method: "POST", path: "/auth/email/confirm", body: handlers.ConfirmEmailReq{Token: confirm}}); w.Code != http.StatusBadRequest {
+		t.Errorf("confirming twice = %d; want 400", w.Code)
+	}
+	if w, _ := srv.do(t, request{method: "GET", path: userPath(id), token: token}); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET with a token issued before the change = %d; want 401", w.Code)
+	}
+	srv.login(t, "alice@new.example.com", "s3cret")
+}
+
+func TestPatchRolesAsAdmin(t *testing.T) {
+	srv := newServer(t)
+	admin := srv.admin(t)
+	id := srv.register(t, "alice@example.com", "s3cret")
+	if _, err := srv.store.EnsureRole(context.Background(), "editor"); err != nil {
+		t.Fatalf("EnsureRole: %v", err)
+	}
+	wh := &models.Webhook{URL: "http://example.invalid/hook", Active: true}
+	if err := srv.store.CreateWebhook(context.Background(), wh); err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	patch := func(body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		return srv.do(t, request{method: "PATCH", path: userPath(id), token: admin, body: body,
+			header: map[string]string{"Content-Type": "application/json-patch+json", "If-Match": "*"}})
+	}
+
+	if w, _ := patch(`[{"op":"add","path":"/roles/-","value":"nosuch"}]`); w.Code != http.StatusBadRequest {
+		t.Errorf("granting an unknown role = %d; want 400", w.Code)
+	}
+	w, out := patch(`[{"op":"add","path":"/roles/-","value":"editor"}]`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("granting editor = %d %s; want 200", w.Code, w.Body)
+	}
+	if roles, _ := out["roles"].([]interface{}); len(roles) != 2 || roles[0] != "editor" || roles[1] != "user" {
+		t.Errorf("roles = %v; want [editor user]", out["roles"])
+	}
+	ds, err := srv.store.ListWebhookDeliveries(context.Background(), wh.ID, "", 10)
+	if err != nil || len(ds) != 2 || ds[0].Event != models.EventUserUpdated || ds[1].Event != models.EventUserRolesChanged {
+		t.Errorf("deliveries = %+v, %v; want roles changed then updated", ds, err)
+	}
+}
+
+func TestPatchValidatesOnlyAChangedEmail(t *testing.T) {
+	srv := newServer(t)
+	admin := srv.admin(t)
+	// stored before emails were validated
+	legacy := &models.User{Email: "legacy-account", FullName: "Legacy"}
+	if err := srv.store.CreateUser(context.Background(), legacy); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	patch := func(body string) *httptest.ResponseRecorder {
+		w, _ := srv.do(t, request{method: "PATCH", path: userPath(legacy.ID), token: admin, body: body,
+			header: map[string]string{"Content-Type": "application/merge-patch+json", "If-Match": "*"}})
+		return w
+	}
+	if w := patch(`{"full_name":"Legacy Renamed"}`); w.Code != http.StatusOK {
+		t.Errorf("patching the name of a user with an old email = %d %s; want 200", w.Code, w.Body)
+	}
+	if w := patch(`{"email":"still-not-an-email"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("patching in an invalid email = %d; want 400", w.Code)
+	}
+}
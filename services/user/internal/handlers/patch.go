@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"services/user/internal/models"
+	"services/user/internal/patch"
+	"services/user/internal/store"
+)
+
+// userPatch is the document PATCH /api/users/{id} applies patches to. Its
+// fields are the whitelist: a patch that leaves any other member in the
+// document is rejected.
+type userPatch struct {
+	FullName string `json:"full_name"`
+	// Email is not changed directly: a new one has to be confirmed from the
+	// new address, as with POST /api/me/email
+	Email string `json:"email"`
+	// Roles are the names of the roles assigned directly; roles granted
+	// through groups are managed on the group
+	Roles []string `json:"roles"`
+	// Profile is the profile as GET /api/users/{id}/profile serves it
+	Profile models.Profile `json:"profile"`
+}
+
+// userPatchSelf lists the fields users may change on their own account,
+// besides the profile fields that are not in profileAdminOnly; every other
+// field can only be changed by admins
+var userPatchSelf = map[string]bool{"full_name": true}
+
+// selfPatchable reports whether users may change field f, a member of the
+// document or profile.<field>, on their own account
+func selfPatchable(f string) bool {
+	if name, ok := strings.CutPrefix(f, "profile."); ok {
+		return !profileAdminOnly[name]
+	}
+	return userPatchSelf[f]
+}
+
+// normalize trims the email, sorts and deduplicates the roles and normalizes
+// the profile so that two documents compare equal when they describe the
+// same user
+func (p *userPatch) normalize() {
+	p.Email = strings.TrimSpace(p.Email)
+	p.Profile.Normalize()
+	if p.Roles == nil {
+		p.Roles = []string{}
+	}
+	sort.Strings(p.Roles)
+	p.Roles = slices.Compact(p.Roles)
+}
+
+// changes returns the fields that differ from old as {"old", "new"} pairs,
+// with those of the profile under "profile" as profileChanges reports them
+func (p *userPatch) changes(old *userPatch) map[string]interface{} {
+	diff := map[string]interface{}{}
+	if p.FullName != old.FullName {
+		diff["full_name"] = map[string]string{"old": old.FullName, "new": p.FullName}
+	}
+	if p.Email != old.Email {
+		diff["email"] = map[string]string{"old": old.Email, "new": p.Email}
+	}
+	if !slices.Equal(p.Roles, old.Roles) {
+		diff["roles"] = map[string][]string{"old": old.Roles, "new": p.Roles}
+	}
+	if pd := profileChanges(&old.Profile, &p.Profile); len(pd) > 0 {
+		diff["profile"] = pd
+	}
+	return diff
+}
+
+// applyPatch applies the request body to the JSON encoding of cur according
+// to its content type and decodes the result into next, rejecting members
+// next has no field for. The returned status is the one to answer with when
+// err is not nil.
+func applyPatch(r *http.Request, cur, next interface{}) (int, error) {
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	raw, _ := json.Marshal(cur)
+	var doc map[string]interface{}
+	json.Unmarshal(raw, &doc)
+	switch mt {
+	case patch.MergePatchType, "application/json", "":
+		var p map[string]interface{}
+		if err := json.Unmarshal(body, &p); err != nil || p == nil {
+			return http.StatusBadRequest, errors.New("merge patch must be a JSON object")
+		}
+		patch.Merge(doc, p)
+	case patch.JSONPatchType:
+		var ops []patch.Operation
+		if err := json.Unmarshal(body, &ops); err != nil {
+			return http.StatusBadRequest, errors.New("JSON patch must be an array of operations")
+		}
+		if err := patch.Apply(doc, ops); errors.Is(err, patch.ErrTestFailed) {
+			return http.StatusConflict, err
+		} else if err != nil {
+			return http.StatusBadRequest, err
+		}
+	default:
+		return http.StatusUnsupportedMediaType, errors.New("use " + patch.MergePatchType + " or " + patch.JSONPatchType)
+	}
+	raw, _ = json.Marshal(doc)
+	dec := json.NewDecoder(strings.NewReader(string(raw)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(next); err != nil {
+		return http.StatusBadRequest, err
+	}
+	return 0, nil
+}
+
+// PatchUser changes a user with a JSON Merge Patch or a JSON Patch. Users may
+// patch their own full name and profile but for the plan; email and roles
+// are admin only. A new email is not applied but sent a confirmation token,
+// as when users change their own. Like UpdateUser it requires If-Match with
+// the user's current ETag.
+func (h *Handler) PatchUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	claims := GetClaims(r)
+	admin := isAdmin(r)
+	if !admin && claims.UserID != uint(id) {
+		writeError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+	u, err := h.users.GetUserByID(ctx, uint(id))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if !checkIfMatch(w, r, u.Version) {
+		return
+	}
+	direct, err := h.roles.GetDirectUserRoles(ctx, u.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load roles")
+		return
+	}
+	// the patch applies to the profile as the client sees it, with the
+	// signed link of an uploaded avatar
+	cur := &userPatch{FullName: u.FullName, Email: u.Email, Profile: h.profileView(u).Profile}
+	for _, rr := range direct {
+		cur.Roles = append(cur.Roles, rr.Name)
+	}
+	cur.normalize()
+	next := &userPatch{}
+	if status, err := applyPatch(r, cur, next); err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+	oldAvatarKey := replacedAvatar(u, cur.Profile.AvatarURL, &next.Profile)
+	cur.Profile = u.Profile
+	next.normalize()
+	if err := next.Profile.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	diff := next.changes(cur)
+	if oldAvatarKey != "" {
+		pd, _ := diff["profile"].(map[string]interface{})
+		if pd == nil {
+			pd = map[string]interface{}{}
+			diff["profile"] = pd
+		}
+		pd["avatar"] = "removed"
+	}
+	fields := make([]string, 0, len(diff))
+	for f, d := range diff {
+		if f != "profile" {
+			fields = append(fields, f)
+			continue
+		}
+		for pf := range d.(map[string]interface{}) {
+			fields = append(fields, "profile."+pf)
+		}
+	}
+	sort.Strings(fields)
+	for _, f := range fields {
+		if !admin && !selfPatchable(f) {
+			log.Printf("patch user denied: field=%s, requestedBy=%d, target=%d", f, claims.UserID, id)
+			ev := newAuditEvent(r, AuditUserUpdate, "user", u.ID)
+			ev.Detail = "not allowed to change " + f
+			h.audit(ctx, ev, models.AuditDenied)
+			writeError(w, http.StatusForbidden, "not allowed to change "+f)
+			return
+		}
+	}
+	var grant, revoke []models.Role
+	if _, ok := diff["roles"]; ok {
+		for _, name := range next.Roles {
+			if slices.Contains(cur.Roles, name) {
+				continue
+			}
+			role, err := h.roles.GetRoleByName(ctx, name)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "role not found: "+name)
+				return
+			}
+			grant = append(grant, *role)
+		}
+		for _, rr := range direct {
+			if !slices.Contains(next.Roles, rr.Name) {
+				revoke = append(revoke, rr)
+			}
+		}
+	}
+	newEmail := ""
+	if _, ok := diff["email"]; ok {
+		if !models.ValidEmail(next.Email) {
+			writeError(w, http.StatusBadRequest, "invalid email")
+			return
+		}
+		if _, err := h.users.GetUserByEmail(ctx, next.Email); err == nil {
+			writeError(w, http.StatusConflict, "email already registered")
+			return
+		}
+		newEmail = next.Email
+		delete(diff, "email")
+	}
+	log.Printf("patch user attempt: fields=%v, requestedBy=%d, target=%d", fields, claims.UserID, id)
+	if len(diff) > 0 {
+		u.FullName, u.Profile = next.FullName, next.Profile
+		if oldAvatarKey != "" {
+			u.AvatarKey = ""
+		}
+		ev := newAuditEvent(r, AuditUserUpdate, "user", u.ID)
+		ev.Diff = diff
+		err = h.store.Audited(ctx, ev, func(tx store.Repository) error {
+			if err := tx.UpdateUser(ctx, u); err != nil {
+				return err
+			}
+			for _, rr := range grant {
+				if err := tx.AssignRoleToUser(ctx, u.ID, rr.ID); err != nil {
+					return err
+				}
+			}
+			for _, rr := range revoke {
+				if err := tx.RemoveRoleFromUser(ctx, u.ID, rr.ID); err != nil {
+					return err
+				}
+			}
+			if len(grant)+len(revoke) > 0 {
+				if err := enqueueUserEvent(ctx, tx, models.EventUserRolesChanged, u.ID); err != nil {
+					return err
+				}
+			}
+			return enqueueUserEvent(ctx, tx, models.EventUserUpdated, u.ID)
+		})
+	}
+	switch {
+	case errors.Is(err, store.ErrVersionConflict):
+		writeError(w, http.StatusPreconditionFailed, "user has been modified")
+		return
+	case errors.Is(err, store.ErrNotFound):
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	case err != nil:
+		log.Printf("patch user failed: target=%d, err=%v", id, err)
+		writeError(w, http.StatusInternalServerError, "failed to update user")
+		return
+	}
+	if oldAvatarKey != "" {
+		h.deleteAvatar(ctx, oldAvatarKey)
+	}
+	resp := map[string]interface{}{"id": u.ID, "email": u.Email, "full_name": u.FullName, "roles": next.Roles, "profile": h.profileView(u), "version": u.Version}
+	if newEmail != "" {
+		ec, err := h.startEmailChange(ctx, newAuditEvent(r, AuditEmailChangeRequest, "user", u.ID), u, newEmail)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		log.Printf("email change requested: userID=%d, requestedBy=%d", u.ID, claims.UserID)
+		resp["email_change"] = map[string]interface{}{"email": newEmail, "status": "confirmation sent", "expires_at": ec.ExpiresAt}
+	}
+	w.Header().Set("ETag", etag(u.Version))
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"services/user/internal/models"
+	"services/user/internal/store"
+)
+
+// profileAdminOnly lists the profile fields only admins may change, even on
+// their own account; users may change every other field themselves
+var profileAdminOnly = map[string]bool{"plan": true}
+
+// profileSensitive lists the profile fields whose values are kept out of
+// the audit log; only the fact that they changed is recorded
+var profileSensitive = map[string]bool{"phone": true, "birthday": true}
+
+// profileChanges returns the fields that differ between old and next, keyed
+// by their JSON names, as {"old", "new"} pairs or "changed" for sensitive ones
+func profileChanges(old, next *models.Profile) map[string]interface{} {
+	var a, b map[string]string
+	raw, _ := json.Marshal(old)
+	json.Unmarshal(raw, &a)
+	raw, _ = json.Marshal(next)
+	json.Unmarshal(raw, &b)
+	diff := map[string]interface{}{}
+	for k, v := range b {
+		if a[k] == v {
+			continue
+		}
+		if profileSensitive[k] {
+			diff[k] = "changed"
+		} else {
+			diff[k] = map[string]string{"old": a[k], "new": v}
+		}
+	}
+	return diff
+}
+
+// profileUserID resolves whose profile a request is about: {id} on
+// /api/users/{id}/profile and the caller on /api/me/profile. It writes an
+// error response if the caller may not access it.
+func profileUserID(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	claims := GetClaims(r)
+	idStr := chi.URLParam(r, "id")
+	if idStr == "" {
+		return claims.UserID, true
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid id")
+		return 0, false
+	}
+	if !isAdmin(r) && claims.UserID != uint(id) {
+		writeError(w, http.StatusForbidden, "forbidden")
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// replacedAvatar resolves the avatar_url of next, a patch of u's profile
+// as served with the signed link viewURL. Left alone, the signed link
+// stands for the uploaded avatar and is put back as stored; anything else
+// replaces the uploaded avatar, whose key is returned so its blobs can be
+// deleted once the change is saved.
+func replacedAvatar(u *models.User, viewURL string, next *models.Profile) string {
+	if u.AvatarKey == "" {
+		return ""
+	}
+	if next.AvatarURL == viewURL {
+		next.AvatarURL = u.Profile.AvatarURL
+		return ""
+	}
+	return u.AvatarKey
+}
+
+// GetProfile returns the profile of the caller (/api/me/profile) or, for
+// admins and the user themselves, of {id}, with the user's ETag
+func (h *Handler) GetProfile(w http.ResponseWriter, r *http.Request) {
+	id, ok := profileUserID(w, r)
+	if !ok {
+		return
+	}
+	u, err := h.users.GetUserByID(r.Context(), id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	w.Header().Set("ETag", etag(u.Version))
+	writeJSON(w, http.StatusOK, h.profileView(u))
+}
+
+// PatchProfile changes a profile with a JSON Merge Patch or a JSON Patch.
+// Every field is validated, the plan can only be changed by admins, and
+// If-Match with the user's current ETag is required.
+func (h *Handler) PatchProfile(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, ok := profileUserID(w, r)
+	if !ok {
+		return
+	}
+	claims := GetClaims(r)
+	u, err := h.users.GetUserByID(ctx, id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if !checkIfMatch(w, r, u.Version) {
+		return
+	}
+	// the patch applies to the profile as the client sees it, with the
+	// signed link of an uploaded avatar
+	view := h.profileView(u)
+	next := &models.Profile{}
+	if status, err := applyPatch(r, &view.Profile, next); err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+	oldAvatarKey := replacedAvatar(u, view.AvatarURL, next)
+	next.Normalize()
+	if err := next.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	diff := profileChanges(&u.Profile, next)
+	if oldAvatarKey != "" {
+		diff["avatar"] = "removed"
+	}
+	fields := make([]string, 0, len(diff))
+	for f := range diff {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	for _, f := range fields {
+		if profileAdminOnly[f] && !isAdmin(r) {
+			log.Printf("patch profile denied: field=%s, requestedBy=%d, target=%d", f, claims.UserID, id)
+			ev := newAuditEvent(r, AuditUserUpdate, "user", u.ID)
+			ev.Detail = "not allowed to change profile " + f
+			h.audit(ctx, ev, models.AuditDenied)
+			writeError(w, http.StatusForbidden, "not allowed to change "+f)
+			return
+		}
+	}
+	if len(diff) > 0 {
+		log.Printf("patch profile attempt: fields=%v, requestedBy=%d, target=%d", fields, claims.UserID, id)
+		u.Profile = *next
+		if oldAvatarKey != "" {
+			u.AvatarKey = ""
+		}
+		ev := newAuditEvent(r, AuditUserUpdate, "user", u.ID)
+		ev.Diff = map[string]interface{}{"profile": diff}
+		err = h.store.Audited(ctx, ev, func(tx store.Repository) error {
+			if err := tx.UpdateUser(ctx, u); err != nil {
+				return err
+			}
+			return enqueueUserEvent(ctx, tx, models.EventUserUpdated, u.ID)
+		})
+	}
+	switch {
+	case errors.Is(err, store.ErrVersionConflict):
+		writeError(w, http.StatusPreconditionFailed, "user has been modified")
+		return
+	case errors.Is(err, store.ErrNotFound):
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	case err != nil:
+		log.Printf("patch profile failed: target=%d, err=%v", id, err)
+		writeError(w, http.StatusInternalServerError, "failed to update profile")
+		return
+	}
+	if oldAvatarKey != "" {
+		h.deleteAvatar(ctx, oldAvatarKey)
+	}
+	w.Header().Set("ETag", etag(u.Version))
+	writeJSON(w, http.StatusOK, h.profileView(u))
+}
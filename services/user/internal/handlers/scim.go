@@ -0,0 +1,711 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+
+	"services/user/internal/models"
+	"services/user/internal/scim"
+	"services/user/internal/store"
+)
+
+// SCIM 2.0 provisioning (RFC 7643, RFC 7644). Users map onto users: userName
+// is the email, name.formatted the full name, displayName the profile display
+// name, and active=false disables the user. Groups map onto roles, and their
+// members onto the users holding a role directly. The protocol pieces live in
+// package scim.
+
+const (
+	defaultSCIMCount = 100
+	// scimBatch is how many users a SCIM query reads per database query
+	scimBatch = 500
+)
+
+// builtinRoles cannot be renamed or deleted through SCIM, as the service
+// relies on them
+var builtinRoles = map[string]bool{"admin": true, "user": true}
+
+// SCIMAuth admits requests bearing token, the dedicated SCIM bearer token.
+// SCIM clients act as the directory, not as a user, so user tokens are not
+// accepted.
+func SCIMAuth(token string) func(http.Handler) http.Handler {
+	want := sha256.Sum256([]byte(token))
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			sum := sha256.Sum256([]byte(got))
+			if !ok || subtle.ConstantTimeCompare(sum[:], want[:]) != 1 {
+				log.Printf("scim auth failed: remote=%s, method=%s, path=%s", r.RemoteAddr, r.Method, r.URL.Path)
+				w.Header().Set("WWW-Authenticate", `Bearer realm="scim"`)
+				writeSCIM(w, http.StatusUnauthorized, &scim.Error{Status: http.StatusUnauthorized, Detail: "invalid SCIM token"})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func writeSCIM(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", scim.ContentType)
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeSCIMError writes err as a SCIM error; errors other than *scim.Error
+// are logged and reported as internal errors
+func writeSCIMError(w http.ResponseWriter, r *http.Request, err error) {
+	var serr *scim.Error
+	if !errors.As(err, &serr) {
+		log.Printf("scim request failed: method=%s, path=%s, err=%v", r.Method, r.URL.Path, err)
+		serr = &scim.Error{Status: http.StatusInternalServerError, Detail: "internal error"}
+	}
+	writeSCIM(w, serr.Status, serr)
+}
+
+func scimNotFound(kind string) *scim.Error {
+	return &scim.Error{Status: http.StatusNotFound, Detail: kind + " not found"}
+}
+
+// scimBase is the URL the SCIM endpoints are served under, as the client
+// reached them
+func scimBase(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if p := r.Header.Get("X-Forwarded-Proto"); p == "http" || p == "https" {
+		scheme = p
+	}
+	return scheme + "://" + r.Host + "/scim/v2"
+}
+
+// newSCIMAuditEvent builds an event for a change made by the directory
+func newSCIMAuditEvent(r *http.Request, action, targetType string, targetID uint) *models.AuditEvent {
+	ev := newAuditEvent(r, action, targetType, targetID)
+	ev.Detail = "scim"
+	return ev
+}
+
+// scimID parses the id URL parameter
+func scimID(r *http.Request) (uint, bool) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	return uint(id), err == nil && id > 0
+}
+
+// scimVersion is the weak entity tag of a user at version v
+func scimVersion(v uint) string {
+	return `W/"` + strconv.FormatUint(uint64(v), 10) + `"`
+}
+
+// checkSCIMIfMatch enforces an If-Match header when the client sends one
+func checkSCIMIfMatch(r *http.Request, version uint) error {
+	h := r.Header.Get("If-Match")
+	if h == "" {
+		return nil
+	}
+	for _, tag := range strings.Split(h, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag(version) {
+			return nil
+		}
+	}
+	return &scim.Error{Status: http.StatusPreconditionFailed, Detail: "resource has been modified"}
+}
+
+// scimListParams are the query parameters of a list request
+type scimListParams struct {
+	filter     scim.Filter
+	startIndex int
+	count      int
+	attributes string
+	excluded   string
+}
+
+func parseSCIMList(r *http.Request) (*scimListParams, error) {
+	q := r.URL.Query()
+	p := &scimListParams{startIndex: 1, count: defaultSCIMCount, attributes: q.Get("attributes"), excluded: q.Get("excludedAttributes")}
+	if v := q.Get("filter"); v != "" {
+		f, err := scim.ParseFilter(v)
+		if err != nil {
+			return nil, err
+		}
+		p.filter = f
+	}
+	// out of range values are clamped, as RFC 7644 section 3.4.2.4 asks
+	if v := q.Get("startIndex"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, scim.Errorf(scim.ErrInvalidValue, "invalid startIndex")
+		}
+		p.startIndex = max(n, 1)
+	}
+	if v := q.Get("count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, scim.Errorf(scim.ErrInvalidValue, "invalid count")
+		}
+		p.count = min(max(n, 0), scim.MaxResults)
+	}
+	return p, nil
+}
+
+func (p *scimListParams) match(res map[string]interface{}) bool {
+	return p.filter == nil || p.filter.Match(res)
+}
+
+// scimRef is a member of a group, or a group of a user
+type scimRef struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+	Type    string `json:"type,omitempty"`
+}
+
+type scimName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type scimEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type"`
+	Primary bool   `json:"primary"`
+}
+
+type scimUser struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id"`
+	ExternalID  string      `json:"externalId,omitempty"`
+	UserName    string      `json:"userName"`
+	Name        scimName    `json:"name"`
+	DisplayName string      `json:"displayName,omitempty"`
+	Emails      []scimEmail `json:"emails"`
+	Active      bool        `json:"active"`
+	Groups      []scimRef   `json:"groups"`
+	Meta        scim.Meta   `json:"meta"`
+}
+
+// splitName derives the given and family name from a full name, which is
+// all the service stores: the first word and the rest
+func splitName(full string) scimName {
+	given, family, _ := strings.Cut(strings.Join(strings.Fields(full), " "), " ")
+	return scimName{Formatted: full, GivenName: given, FamilyName: family}
+}
+
+func scimUserResource(base string, u *models.User, roles []models.Role) map[string]interface{} {
+	id := strconv.FormatUint(uint64(u.ID), 10)
+	su := scimUser{
+		Schemas:     []string{scim.SchemaUser},
+		ID:          id,
+		ExternalID:  u.ExternalID,
+		UserName:    u.Email,
+		Name:        splitName(u.FullName),
+		DisplayName: u.Profile.DisplayName,
+		Emails:      []scimEmail{{Value: u.Email, Type: "work", Primary: true}},
+		Active:      u.DisabledAt == nil,
+		Groups:      []scimRef{},
+		Meta: scim.Meta{
+			ResourceType: "User",
+			Created:      u.CreatedAt.UTC().Format(time.RFC3339),
+			LastModified: u.UpdatedAt.UTC().Format(time.RFC3339),
+			Location:     base + "/Users/" + id,
+			Version:      scimVersion(u.Version),
+		},
+	}
+	for _, role := range roles {
+		rid := strconv.FormatUint(uint64(role.ID), 10)
+		su.Groups = append(su.Groups, scimRef{Value: rid, Display: role.Name, Ref: base + "/Groups/" + rid, Type: "direct"})
+	}
+	res, _ := scim.ToMap(su)
+	return res
+}
+
+// scimString reads a string attribute of a resource
+func scimString(res map[string]interface{}, name string) (string, error) {
+	v, ok := scimLookup(res, name)
+	if !ok || v == nil {
+		return "", nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", scim.Errorf(scim.ErrInvalidValue, "%s must be a string", name)
+	}
+	return strings.TrimSpace(s), nil
+}
+
+func scimLookup(res map[string]interface{}, name string) (interface{}, bool) {
+	for k, v := range res {
+		if strings.EqualFold(k, name) || strings.EqualFold(k, scim.SchemaUser+":"+name) || strings.EqualFold(k, scim.SchemaGroup+":"+name) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// applySCIMUser sets the fields of u from a User resource. prev is u's full
+// name before, used to tell whether a client changed the formatted name or
+// only its parts. It returns the password to set, if any.
+func applySCIMUser(u *models.User, res map[string]interface{}, prev string) (string, error) {
+	email, err := scimString(res, "userName")
+	if err != nil {
+		return "", err
+	}
+	if email == "" {
+		return "", scim.Errorf(scim.ErrInvalidValue, "userName is required")
+	}
+	if !models.ValidEmail(email) {
+		return "", scim.Errorf(scim.ErrInvalidValue, "userName must be an email address")
+	}
+	u.SetEmail(email)
+	if u.ExternalID, err = scimString(res, "externalId"); err != nil {
+		return "", err
+	}
+	if u.Profile.DisplayName, err = scimString(res, "displayName"); err != nil {
+		return "", err
+	}
+	var name map[string]interface{}
+	if v, ok := scimLookup(res, "name"); ok && v != nil {
+		if name, ok = v.(map[string]interface{}); !ok {
+			return "", scim.Errorf(scim.ErrInvalidValue, "name must be an object")
+		}
+	}
+	formatted, err := scimString(name, "formatted")
+	if err != nil {
+		return "", err
+	}
+	given, err := scimString(name, "givenName")
+	if err != nil {
+		return "", err
+	}
+	family, err := scimString(name, "familyName")
+	if err != nil {
+		return "", err
+	}
+	// the parts win when there is no formatted name, or when they were
+	// changed while the formatted name was left as it was
+	parts := strings.TrimSpace(given + " " + family)
+	if parts != "" && (formatted == "" || formatted == prev && parts != splitName(prev).joined()) {
+		formatted = parts
+	}
+	u.FullName = formatted
+	active := true
+	if v, ok := scimLookup(res, "active"); ok && v != nil {
+		switch v := v.(type) {
+		case bool:
+			active = v
+		case string:
+			// some providers send booleans as strings
+			if active, err = strconv.ParseBool(v); err != nil {
+				return "", scim.Errorf(scim.ErrInvalidValue, "active must be a boolean")
+			}
+		default:
+			return "", scim.Errorf(scim.ErrInvalidValue, "active must be a boolean")
+		}
+	}
+	switch {
+	case active:
+		u.DisabledAt = nil
+	case u.DisabledAt == nil:
+		now := time.Now()
+		u.DisabledAt = &now
+	}
+	u.Profile.Normalize()
+	if err := u.Profile.Validate(); err != nil {
+		return "", scim.Errorf(scim.ErrInvalidValue, "%v", err)
+	}
+	return scimString(res, "password")
+}
+
+func (n scimName) joined() string {
+	return strings.TrimSpace(n.GivenName + " " + n.FamilyName)
+}
+
+// decodeSCIM reads a resource or message from the request body
+func decodeSCIM(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return scim.Errorf(scim.ErrInvalidSyntax, "invalid JSON: %v", err)
+	}
+	return nil
+}
+
+// SCIMServiceProviderConfig serves GET /scim/v2/ServiceProviderConfig
+func (h *Handler) SCIMServiceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	writeSCIM(w, http.StatusOK, scim.ServiceProviderConfig(scimBase(r)))
+}
+
+// SCIMResourceTypes serves GET /scim/v2/ResourceTypes
+func (h *Handler) SCIMResourceTypes(w http.ResponseWriter, r *http.Request) {
+	types := scim.ResourceTypes(scimBase(r))
+	writeSCIM(w, http.StatusOK, scim.NewListResponse(types, 1, len(types)))
+}
+
+// SCIMSchemas serves GET /scim/v2/Schemas
+func (h *Handler) SCIMSchemas(w http.ResponseWriter, r *http.Request) {
+	schemas := scim.Schemas(scimBase(r))
+	writeSCIM(w, http.StatusOK, scim.NewListResponse(schemas, 1, len(schemas)))
+}
+
+// SCIMResourceType serves GET /scim/v2/ResourceTypes/{id}
+func (h *Handler) SCIMResourceType(w http.ResponseWriter, r *http.Request) {
+	writeSCIMDocument(w, r, scim.ResourceTypes(scimBase(r)), "resource type")
+}
+
+// SCIMSchema serves GET /scim/v2/Schemas/{id}
+func (h *Handler) SCIMSchema(w http.ResponseWriter, r *http.Request) {
+	writeSCIMDocument(w, r, scim.Schemas(scimBase(r)), "schema")
+}
+
+// writeSCIMDocument answers with the discovery document of the id URL
+// parameter
+func writeSCIMDocument(w http.ResponseWriter, r *http.Request, docs []interface{}, kind string) {
+	for _, d := range docs {
+		if doc := d.(map[string]interface{}); doc["id"] == chi.URLParam(r, "id") {
+			writeSCIM(w, http.StatusOK, doc)
+			return
+		}
+	}
+	writeSCIMError(w, r, scimNotFound(kind))
+}
+
+// SCIMListUsers serves GET /scim/v2/Users. The database filters, counts and
+// pages the users when it can run the filter; see store.ListSCIMUsers.
+func (h *Handler) SCIMListUsers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	p, err := parseSCIMList(r)
+	if err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	base := scimBase(r)
+	users, total, err := h.store.ListSCIMUsers(ctx, p.filter, p.startIndex-1, p.count)
+	if errors.Is(err, store.ErrUnsupportedFilter) {
+		h.scanSCIMUsers(w, r, p)
+		return
+	}
+	if err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	ids := make([]uint, len(users))
+	for i, u := range users {
+		ids[i] = u.ID
+	}
+	roles, err := h.store.GetDirectRolesForUsers(ctx, ids)
+	if err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	page := make([]interface{}, len(users))
+	for i := range users {
+		page[i] = scim.Project(scimUserResource(base, &users[i], roles[users[i].ID]), p.attributes, p.excluded)
+	}
+	writeSCIM(w, http.StatusOK, scim.NewPage(page, int(total), p.startIndex))
+}
+
+// scanSCIMUsers answers a list of users whose filter the database cannot
+// run by matching the resource of every user, narrowed down by the
+// filter's required userName, email or externalId when it has one
+func (h *Handler) scanSCIMUsers(w http.ResponseWriter, r *http.Request, p *scimListParams) {
+	ctx := r.Context()
+	f := store.UserFilter{Sort: store.UserSortID, Limit: scimBatch}
+	if v, ok := scim.EqualValue(p.filter, "userName"); ok {
+		f.Query = v
+	} else if v, ok := scim.EqualValue(p.filter, "emails.value"); ok {
+		f.Query = v
+	} else if v, ok := scim.EqualValue(p.filter, "externalId"); ok {
+		f.ExternalID = v
+	}
+	base := scimBase(r)
+	var found []interface{}
+	for {
+		users, err := h.store.ListUsers(ctx, f)
+		if err != nil {
+			writeSCIMError(w, r, err)
+			return
+		}
+		if len(users) == 0 {
+			break
+		}
+		ids := make([]uint, len(users))
+		for i, u := range users {
+			ids[i] = u.ID
+		}
+		roles, err := h.store.GetDirectRolesForUsers(ctx, ids)
+		if err != nil {
+			writeSCIMError(w, r, err)
+			return
+		}
+		for i := range users {
+			if res := scimUserResource(base, &users[i], roles[users[i].ID]); p.match(res) {
+				found = append(found, scim.Project(res, p.attributes, p.excluded))
+			}
+		}
+		f.After = &store.UserCursor{Sort: store.UserSortID, ID: users[len(users)-1].ID}
+	}
+	writeSCIM(w, http.StatusOK, scim.NewListResponse(found, p.startIndex, p.count))
+}
+
+// loadSCIMUser loads the user of the id URL parameter and its resource
+func (h *Handler) loadSCIMUser(ctx context.Context, r *http.Request) (*models.User, map[string]interface{}, error) {
+	id, ok := scimID(r)
+	if !ok {
+		return nil, nil, scimNotFound("user")
+	}
+	u, err := h.store.GetUserByID(ctx, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil, scimNotFound("user")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	roles, err := h.store.GetDirectUserRoles(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return u, scimUserResource(scimBase(r), u, roles), nil
+}
+
+// writeSCIMUser answers with the current resource of user id
+func (h *Handler) writeSCIMUser(w http.ResponseWriter, r *http.Request, code int, id uint) {
+	ctx := r.Context()
+	u, err := h.store.GetUserByID(ctx, id)
+	if err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	roles, err := h.store.GetDirectUserRoles(ctx, id)
+	if err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	res := scimUserResource(scimBase(r), u, roles)
+	w.Header().Set("ETag", scimVersion(u.Version))
+	if code == http.StatusCreated {
+		w.Header().Set("Location", res["meta"].(map[string]interface{})["location"].(string))
+	}
+	q := r.URL.Query()
+	writeSCIM(w, code, scim.Project(res, q.Get("attributes"), q.Get("excludedAttributes")))
+}
+
+// SCIMGetUser serves GET /scim/v2/Users/{id}
+func (h *Handler) SCIMGetUser(w http.ResponseWriter, r *http.Request) {
+	u, res, err := h.loadSCIMUser(r.Context(), r)
+	if err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", scimVersion(u.Version))
+	q := r.URL.Query()
+	writeSCIM(w, http.StatusOK, scim.Project(res, q.Get("attributes"), q.Get("excludedAttributes")))
+}
+
+// SCIMCreateUser serves POST /scim/v2/Users. Provisioned users get the
+// "user" role like registered ones; without a password they can only log in
+// once one is set.
+func (h *Handler) SCIMCreateUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var res map[string]interface{}
+	if err := decodeSCIM(r, &res); err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	u := &models.User{Profile: models.Profile{Plan: models.DefaultPlan}}
+	password, err := applySCIMUser(u, res, "")
+	if err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	if password != "" {
+		if err := u.SetPassword(password); err != nil {
+			writeSCIMError(w, r, err)
+			return
+		}
+	}
+	ev := newSCIMAuditEvent(r, AuditUserProvision, "user", 0)
+	ev.Diff = map[string]interface{}{"email": u.Email, "full_name": u.FullName, "external_id": u.ExternalID}
+	err = h.store.Audited(ctx, ev, func(tx store.Repository) error {
+		if err := tx.CreateUser(ctx, u); err != nil {
+			return err
+		}
+		ev.TargetID = strconv.FormatUint(uint64(u.ID), 10)
+		role, err := tx.EnsureRole(ctx, "user")
+		if err != nil {
+			return err
+		}
+		if err := tx.AssignRoleToUser(ctx, u.ID, role.ID); err != nil {
+			return err
+		}
+		return tx.EnqueueWebhookEvent(ctx, models.EventUserCreated, u.ID, webhookUser(u, []string{role.Name}))
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		writeSCIMError(w, r, &scim.Error{Status: http.StatusConflict, ScimType: scim.ErrUniqueness, Detail: "userName already exists"})
+		return
+	}
+	if err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	log.Printf("scim user provisioned: userID=%d, email=%s", u.ID, u.Email)
+	h.writeSCIMUser(w, r, http.StatusCreated, u.ID)
+}
+
+// SCIMReplaceUser serves PUT /scim/v2/Users/{id}
+func (h *Handler) SCIMReplaceUser(w http.ResponseWriter, r *http.Request) {
+	var res map[string]interface{}
+	if err := decodeSCIM(r, &res); err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	u, _, err := h.loadSCIMUser(r.Context(), r)
+	if err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	h.saveSCIMUser(w, r, u, res)
+}
+
+// SCIMPatchUser serves PATCH /scim/v2/Users/{id}
+func (h *Handler) SCIMPatchUser(w http.ResponseWriter, r *http.Request) {
+	var op scim.PatchOp
+	if err := decodeSCIM(r, &op); err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	u, res, err := h.loadSCIMUser(r.Context(), r)
+	if err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	if err := op.Apply(res); err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	h.saveSCIMUser(w, r, u, res)
+}
+
+// saveSCIMUser updates u to the resource res
+func (h *Handler) saveSCIMUser(w http.ResponseWriter, r *http.Request, u *models.User, res map[string]interface{}) {
+	ctx := r.Context()
+	if err := checkSCIMIfMatch(r, u.Version); err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	before := *u
+	password, err := applySCIMUser(u, res, before.FullName)
+	if err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	if password != "" {
+		if err := u.SetPassword(password); err != nil {
+			writeSCIMError(w, r, err)
+			return
+		}
+	}
+	diff := map[string]interface{}{}
+	if u.Email != before.Email {
+		diff["email"] = map[string]interface{}{"old": before.Email, "new": u.Email}
+	}
+	if u.FullName != before.FullName {
+		diff["full_name"] = map[string]interface{}{"old": before.FullName, "new": u.FullName}
+	}
+	if u.Profile.DisplayName != before.Profile.DisplayName {
+		diff["display_name"] = map[string]interface{}{"old": before.Profile.DisplayName, "new": u.Profile.DisplayName}
+	}
+	if u.ExternalID != before.ExternalID {
+		diff["external_id"] = map[string]interface{}{"old": before.ExternalID, "new": u.ExternalID}
+	}
+	if (u.DisabledAt == nil) != (before.DisabledAt == nil) {
+		diff["active"] = map[string]interface{}{"old": before.DisabledAt == nil, "new": u.DisabledAt == nil}
+	}
+	if password != "" {
+		diff["password"] = "changed"
+	}
+	if len(diff) > 0 {
+		ev := newSCIMAuditEvent(r, AuditUserUpdate, "user", u.ID)
+		ev.Diff = diff
+		err = h.store.Audited(ctx, ev, func(tx store.Repository) error {
+			if err := tx.UpdateUser(ctx, u); err != nil {
+				return err
+			}
+			return enqueueUserEvent(ctx, tx, models.EventUserUpdated, u.ID)
+		})
+	}
+	switch {
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		err = &scim.Error{Status: http.StatusConflict, ScimType: scim.ErrUniqueness, Detail: "userName already exists"}
+	case errors.Is(err, store.ErrVersionConflict):
+		err = &scim.Error{Status: http.StatusPreconditionFailed, Detail: "resource has been modified"}
+	}
+	if err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	h.writeSCIMUser(w, r, http.StatusOK, u.ID)
+}
+
+// SCIMDeleteUser serves DELETE /scim/v2/Users/{id}; the user is soft
+// deleted, as by an admin
+func (h *Handler) SCIMDeleteUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	u, _, err := h.loadSCIMUser(ctx, r)
+	if err == nil {
+		err = checkSCIMIfMatch(r, u.Version)
+	}
+	if err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	ev := newSCIMAuditEvent(r, AuditUserDelete, "user", u.ID)
+	err = h.store.Audited(ctx, ev, func(tx store.Repository) error {
+		if err := tx.DeleteUser(ctx, u.ID); err != nil {
+			return err
+		}
+		return tx.EnqueueWebhookEvent(ctx, models.EventUserDeleted, u.ID, map[string]interface{}{"id": u.ID, "email": u.Email})
+	})
+	if err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	log.Printf("scim user deleted: userID=%d", u.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseSCIMMembers reads the user IDs of the members of a Group resource
+func parseSCIMMembers(res map[string]interface{}) ([]uint, error) {
+	v, ok := scimLookup(res, "members")
+	if !ok || v == nil {
+		return nil, nil
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, scim.Errorf(scim.ErrInvalidValue, "members must be an array")
+	}
+	ids := make([]uint, 0, len(list))
+	for _, el := range list {
+		m, ok := el.(map[string]interface{})
+		if !ok {
+			return nil, scim.Errorf(scim.ErrInvalidValue, "members must be objects")
+		}
+		raw, _ := scimLookup(m, "value")
+		id, err := strconv.ParseUint(fmt.Sprint(raw), 10, 32)
+		if err != nil || id == 0 {
+			return nil, scim.Errorf(scim.ErrInvalidValue, "invalid member %v", raw)
+		}
+		ids = append(ids, uint(id))
+	}
+	return ids, nil
+}
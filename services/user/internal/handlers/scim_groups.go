@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"services/user/internal/models"
+	"services/user/internal/scim"
+	"services/user/internal/store"
+)
+
+type scimGroup struct {
+	Schemas     []string  `json:"schemas"`
+	ID          string    `json:"id"`
+	ExternalID  string    `json:"externalId,omitempty"`
+	DisplayName string    `json:"displayName"`
+	Members     []scimRef `json:"members"`
+	Meta        scim.Meta `json:"meta"`
+}
+
+// scimGroupResource builds the Group resource of a role; members is nil
+// when they were not loaded
+func scimGroupResource(base string, role *models.Role, members []models.User) map[string]interface{} {
+	id := strconv.FormatUint(uint64(role.ID), 10)
+	sg := scimGroup{
+		Schemas:     []string{scim.SchemaGroup},
+		ID:          id,
+		ExternalID:  role.ExternalID,
+		DisplayName: role.Name,
+		Members:     []scimRef{},
+		Meta:        scim.Meta{ResourceType: "Group", Location: base + "/Groups/" + id},
+	}
+	for _, u := range members {
+		uid := strconv.FormatUint(uint64(u.ID), 10)
+		sg.Members = append(sg.Members, scimRef{Value: uid, Display: u.Email, Ref: base + "/Users/" + uid, Type: "User"})
+	}
+	res, _ := scim.ToMap(sg)
+	if members == nil {
+		delete(res, "members")
+	}
+	return res
+}
+
+// applySCIMGroup sets the fields of role from a Group resource and returns
+// the user IDs of its members
+func applySCIMGroup(role *models.Role, res map[string]interface{}) ([]uint, error) {
+	name, err := scimString(res, "displayName")
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, scim.Errorf(scim.ErrInvalidValue, "displayName is required")
+	}
+	if len(name) > 100 {
+		return nil, scim.Errorf(scim.ErrInvalidValue, "displayName is longer than 100 bytes")
+	}
+	if role.ID != 0 && builtinRoles[role.Name] && name != role.Name {
+		return nil, scim.Errorf(scim.ErrMutability, "role %s cannot be renamed", role.Name)
+	}
+	role.Name = name
+	if role.ExternalID, err = scimString(res, "externalId"); err != nil {
+		return nil, err
+	}
+	return parseSCIMMembers(res)
+}
+
+// setSCIMMembers replaces the direct holders of role in tx and notifies
+// webhooks of every user gaining or losing it
+func setSCIMMembers(ctx context.Context, tx store.Repository, role *models.Role, members []uint, ev *models.AuditEvent) error {
+	added, removed, err := tx.SetRoleMembers(ctx, role.ID, members)
+	if errors.Is(err, store.ErrNotFound) {
+		return scim.Errorf(scim.ErrInvalidValue, "members must be existing users")
+	}
+	if err != nil {
+		return err
+	}
+	if len(added) > 0 {
+		ev.Diff["members_added"] = added
+	}
+	if len(removed) > 0 {
+		ev.Diff["members_removed"] = removed
+	}
+	for _, uid := range append(added, removed...) {
+		if err := enqueueUserEvent(ctx, tx, models.EventUserRolesChanged, uid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SCIMListGroups serves GET /scim/v2/Groups. Members are left out of the
+// query when excluded and not filtered on, as many clients ask.
+func (h *Handler) SCIMListGroups(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	p, err := parseSCIMList(r)
+	if err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	roles, err := h.store.ListRoles(ctx)
+	if err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	filter := strings.ToLower(r.URL.Query().Get("filter"))
+	withMembers := !strings.Contains(strings.ToLower(p.excluded), "members") || strings.Contains(filter, "members")
+	base := scimBase(r)
+	var found []interface{}
+	for i := range roles {
+		var members []models.User
+		if withMembers {
+			if members, err = h.store.GetRoleMembers(ctx, roles[i].ID); err != nil {
+				writeSCIMError(w, r, err)
+				return
+			}
+		}
+		if res := scimGroupResource(base, &roles[i], members); p.match(res) {
+			found = append(found, scim.Project(res, p.attributes, p.excluded))
+		}
+	}
+	writeSCIM(w, http.StatusOK, scim.NewListResponse(found, p.startIndex, p.count))
+}
+
+// loadSCIMGroup loads the role of the id URL parameter and its resource
+func (h *Handler) loadSCIMGroup(ctx context.Context, r *http.Request) (*models.Role, map[string]interface{}, error) {
+	id, ok := scimID(r)
+	if !ok {
+		return nil, nil, scimNotFound("group")
+	}
+	role, err := h.store.GetRoleByID(ctx, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil, scimNotFound("group")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	members, err := h.store.GetRoleMembers(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return role, scimGroupResource(scimBase(r), role, members), nil
+}
+
+// writeSCIMGroup answers with the current resource of the role
+func (h *Handler) writeSCIMGroup(w http.ResponseWriter, r *http.Request, code int, role *models.Role) {
+	members, err := h.store.GetRoleMembers(r.Context(), role.ID)
+	if err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	res := scimGroupResource(scimBase(r), role, members)
+	if code == http.StatusCreated {
+		w.Header().Set("Location", res["meta"].(map[string]interface{})["location"].(string))
+	}
+	q := r.URL.Query()
+	writeSCIM(w, code, scim.Project(res, q.Get("attributes"), q.Get("excludedAttributes")))
+}
+
+// SCIMGetGroup serves GET /scim/v2/Groups/{id}
+func (h *Handler) SCIMGetGroup(w http.ResponseWriter, r *http.Request) {
+	_, res, err := h.loadSCIMGroup(r.Context(), r)
+	if err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	q := r.URL.Query()
+	writeSCIM(w, http.StatusOK, scim.Project(res, q.Get("attributes"), q.Get("excludedAttributes")))
+}
+
+// SCIMCreateGroup serves POST /scim/v2/Groups, creating a role
+func (h *Handler) SCIMCreateGroup(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var res map[string]interface{}
+	if err := decodeSCIM(r, &res); err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	role := &models.Role{}
+	members, err := applySCIMGroup(role, res)
+	if err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	ev := newSCIMAuditEvent(r, AuditRoleCreate, "role", 0)
+	ev.Diff = map[string]interface{}{"name": role.Name, "external_id": role.ExternalID}
+	err = h.store.Audited(ctx, ev, func(tx store.Repository) error {
+		if err := tx.CreateRole(ctx, role); err != nil {
+			return err
+		}
+		ev.TargetID = strconv.FormatUint(uint64(role.ID), 10)
+		return setSCIMMembers(ctx, tx, role, members, ev)
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		writeSCIMError(w, r, &scim.Error{Status: http.StatusConflict, ScimType: scim.ErrUniqueness, Detail: "displayName already exists"})
+		return
+	}
+	if err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	log.Printf("scim group created: roleID=%d, name=%s", role.ID, role.Name)
+	h.writeSCIMGroup(w, r, http.StatusCreated, role)
+}
+
+// SCIMReplaceGroup serves PUT /scim/v2/Groups/{id}
+func (h *Handler) SCIMReplaceGroup(w http.ResponseWriter, r *http.Request) {
+	var res map[string]interface{}
+	if err := decodeSCIM(r, &res); err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	role, _, err := h.loadSCIMGroup(r.Context(), r)
+	if err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	h.saveSCIMGroup(w, r, role, res)
+}
+
+// SCIMPatchGroup serves PATCH /scim/v2/Groups/{id}; this is how most
+// identity providers add and remove members
+func (h *Handler) SCIMPatchGroup(w http.ResponseWriter, r *http.Request) {
+	var op scim.PatchOp
+	if err := decodeSCIM(r, &op); err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	role, res, err := h.loadSCIMGroup(r.Context(), r)
+	if err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	if err := op.Apply(res); err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	h.saveSCIMGroup(w, r, role, res)
+}
+
+// saveSCIMGroup updates role and its members to the resource res
+func (h *Handler) saveSCIMGroup(w http.ResponseWriter, r *http.Request, role *models.Role, res map[string]interface{}) {
+	ctx := r.Context()
+	before := *role
+	members, err := applySCIMGroup(role, res)
+	if err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	ev := newSCIMAuditEvent(r, AuditRoleUpdate, "role", role.ID)
+	ev.Diff = map[string]interface{}{}
+	if role.Name != before.Name {
+		ev.Diff["name"] = map[string]interface{}{"old": before.Name, "new": role.Name}
+	}
+	if role.ExternalID != before.ExternalID {
+		ev.Diff["external_id"] = map[string]interface{}{"old": before.ExternalID, "new": role.ExternalID}
+	}
+	err = h.store.Audited(ctx, ev, func(tx store.Repository) error {
+		if err := tx.UpdateRole(ctx, role); err != nil {
+			return err
+		}
+		return setSCIMMembers(ctx, tx, role, members, ev)
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		err = &scim.Error{Status: http.StatusConflict, ScimType: scim.ErrUniqueness, Detail: "displayName already exists"}
+	}
+	if err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	h.writeSCIMGroup(w, r, http.StatusOK, role)
+}
+
+// SCIMDeleteGroup serves DELETE /scim/v2/Groups/{id}, deleting the role
+// and revoking it from everyone holding it
+func (h *Handler) SCIMDeleteGroup(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	role, _, err := h.loadSCIMGroup(ctx, r)
+	if err == nil && builtinRoles[role.Name] {
+		err = scim.Errorf(scim.ErrMutability, "role %s cannot be deleted", role.Name)
+	}
+	if err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	holders, err := h.store.GetRoleMembers(ctx, role.ID)
+	if err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	ev := newSCIMAuditEvent(r, AuditRoleDelete, "role", role.ID)
+	ev.Diff = map[string]interface{}{"name": role.Name}
+	err = h.store.Audited(ctx, ev, func(tx store.Repository) error {
+		if err := tx.DeleteRole(ctx, role.ID); err != nil {
+			return err
+		}
+		for _, u := range holders {
+			if err := enqueueUserEvent(ctx, tx, models.EventUserRolesChanged, u.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		writeSCIMError(w, r, err)
+		return
+	}
+	log.Printf("scim group deleted: roleID=%d, name=%s", role.ID, role.Name)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"services/user/internal/search"
+	"services/user/internal/store"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// SearchUsers - admin only. Finds live users whose email or full name has
+// words starting with every word of q, tolerating typos unless
+// fuzzy=false, and returns at most limit of them best first, each with its
+// score and the email and full name as HTML with the matches in <mark>.
+// index names the index searched; see store.Store.SearchUsers.
+func (h *Handler) SearchUsers(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "admin only")
+		return
+	}
+	ctx := r.Context()
+	q := r.URL.Query()
+	s := store.UserSearch{Query: q.Get("q"), Limit: defaultSearchLimit, Fuzzy: q.Get("fuzzy") != "false"}
+	if len(search.Terms(s.Query)) == 0 {
+		writeError(w, http.StatusBadRequest, "q must contain a letter or digit")
+		return
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		s.Limit = min(n, maxSearchLimit)
+	}
+	hits, terms, err := h.store.SearchUsers(ctx, s)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ids := make([]uint, 0, len(hits))
+	for _, hit := range hits {
+		ids = append(ids, hit.User.ID)
+	}
+	roles, err := h.roles.GetRolesForUsers(ctx, ids)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	out := make([]map[string]interface{}, 0, len(hits))
+	for _, hit := range hits {
+		u := hit.User
+		marked := search.Highlight(terms, u.Email, u.FullName)
+		names := []string{}
+		for _, rr := range roles[u.ID] {
+			names = append(names, rr.Name)
+		}
+		item := map[string]interface{}{
+			"id": u.ID, "email": u.Email, "full_name": u.FullName, "created_at": u.CreatedAt, "roles": names,
+			"score": hit.Score,
+			"highlight": map[string]string{
+				"email":     marked[0],
+				"full_name": marked[1],
+			},
+		}
+		if u.DisabledAt != nil {
+			item["disabled_at"] = u.DisabledAt
+		}
+		out = append(out, item)
+	}
+	index := h.store.UserSearchIndex()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"users": out, "index": index})
+	log.Printf("user search returned: count=%d, terms=%d, index=%s", len(out), len(terms), index)
+}
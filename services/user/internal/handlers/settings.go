@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"services/user/internal/models"
+	"services/user/internal/store"
+)
+
+const (
+	// maxSettingsRetries bounds how often a settings write is merged again
+	// after losing a race with another device
+	maxSettingsRetries = 5
+	// settingsHeartbeat keeps idle settings streams alive through proxies
+	settingsHeartbeat = 25 * time.Second
+)
+
+// settingsFields are the JSON names of the settings, for validating modified_at
+var settingsFields = map[string]bool{"push_enabled": true, "email_digest": true, "dark_mode": true, "language_code": true}
+
+// settingsView renders a settings document as served and broadcast
+func settingsView(us *models.UserSettings) map[string]interface{} {
+	return map[string]interface{}{"schema_version": models.SettingsSchemaVersion, "version": us.Version, "updated_at": us.UpdatedAt, "settings": us.Settings}
+}
+
+// PutSettingsReq writes some or all settings
+type PutSettingsReq struct {
+	// SchemaVersion is the document layout the device was built for; 0 means the current one
+	SchemaVersion int                  `json:"schema_version"`
+	Settings      models.SettingsPatch `json:"settings"`
+	// ModifiedAt optionally gives, per field, when the device changed it, so
+	// changes made offline merge in the order they were made. Fields without
+	// one are written at the time the server receives them.
+	ModifiedAt map[string]time.Time `json:"modified_at"`
+}
+
+// GetSettings returns the caller's settings, or the defaults at version 0
+func (h *Handler) GetSettings(w http.ResponseWriter, r *http.Request) {
+	us, err := h.store.GetUserSettings(r.Context(), GetClaims(r).UserID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load settings")
+		return
+	}
+	w.Header().Set("ETag", etag(us.Version))
+	writeJSON(w, http.StatusOK, settingsView(us))
+}
+
+// PutSettings merges the given settings into the caller's document, field by
+// field with the last writer winning, and pushes the result to the caller's
+// other sessions. The sending device names itself with X-Session-ID so the
+// change is not echoed back to it.
+func (h *Handler) PutSettings(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := GetClaims(r)
+	var req PutSettingsReq
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.SchemaVersion > models.SettingsSchemaVersion {
+		writeError(w, http.StatusBadRequest, "unsupported schema_version")
+		return
+	}
+	for f := range req.ModifiedAt {
+		if !settingsFields[f] {
+			writeError(w, http.StatusBadRequest, "unknown field in modified_at: "+f)
+			return
+		}
+	}
+	if err := req.Settings.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var us *models.UserSettings
+	changed := false
+	for attempt := 0; ; attempt++ {
+		var err error
+		if us, err = h.store.GetUserSettings(ctx, claims.UserID); err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to load settings")
+			return
+		}
+		before := us.Settings
+		if !us.Merge(&req.Settings, req.ModifiedAt, time.Now()) {
+			break
+		}
+		err = h.store.SaveUserSettings(ctx, us)
+		if errors.Is(err, store.ErrVersionConflict) && attempt < maxSettingsRetries {
+			continue
+		}
+		if err != nil {
+			log.Printf("save settings failed: userID=%d, err=%v", claims.UserID, err)
+			writeError(w, http.StatusInternalServerError, "failed to save settings")
+			return
+		}
+		changed = us.Settings != before
+		break
+	}
+	if changed {
+		msg, _ := json.Marshal(settingsView(us))
+		h.settingsHub.Publish(claims.UserID, r.Header.Get("X-Session-ID"), msg)
+		log.Printf("settings changed: userID=%d, version=%d", claims.UserID, us.Version)
+	}
+	w.Header().Set("ETag", etag(us.Version))
+	writeJSON(w, http.StatusOK, settingsView(us))
+}
+
+// SettingsEvents streams the caller's settings as server-sent events: the
+// current document first, then every change made from another session. A
+// device sends its X-Session-ID to skip its own changes.
+func (h *Handler) SettingsEvents(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := GetClaims(r)
+	// subscribe before reading so no change falls in between
+	sub := h.settingsHub.Subscribe(claims.UserID, r.Header.Get("X-Session-ID"))
+	defer sub.Close()
+	us, err := h.store.GetUserSettings(ctx, claims.UserID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load settings")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	send := func(version uint, data []byte) error {
+		fmt.Fprintf(w, "id: %d\nevent: settings\ndata: %s\n\n", version, data)
+		return rc.Flush()
+	}
+	first, _ := json.Marshal(settingsView(us))
+	if send(us.Version, first) != nil {
+		return
+	}
+	log.Printf("settings stream opened: userID=%d", claims.UserID)
+	heartbeat := time.NewTicker(settingsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("settings stream closed: userID=%d", claims.UserID)
+			return
+		case msg, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			var v struct {
+				Version uint `json:"version"`
+			}
+			json.Unmarshal(msg, &v)
+			if send(v.Version, msg) != nil {
+				return
+			}
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			if rc.Flush() != nil {
+				return
+			}
+		}
+	}
+}
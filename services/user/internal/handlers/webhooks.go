@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"services/user/internal/models"
+	"services/user/internal/store"
+)
+
+// webhookEvents lists the events a webhook may subscribe to
+var webhookEvents = []string{models.EventUserCreated, models.EventUserUpdated, models.EventUserRolesChanged, models.EventUserDeleted, models.EventUserRestored, models.EventUserPurged}
+
+// WebhookReq is used to create or update a webhook
+type WebhookReq struct {
+	URL         string   `json:"url"`
+	Description string   `json:"description"`
+	Events      []string `json:"events"`
+	Secret      string   `json:"secret"`
+	Active      *bool    `json:"active"`
+}
+
+// webhookUser is the data of user lifecycle events
+func webhookUser(u *models.User, roles []string) map[string]interface{} {
+	return map[string]interface{}{"id": u.ID, "email": u.Email, "full_name": u.FullName, "profile": u.Profile, "roles": roles}
+}
+
+// enqueueUserEvent queues event for a user with their current effective roles
+func enqueueUserEvent(ctx context.Context, tx store.Repository, event string, userID uint) error {
+	u, err := tx.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	roles, err := tx.GetUserRoles(ctx, userID)
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	for _, rr := range roles {
+		names = append(names, rr.Name)
+	}
+	return tx.EnqueueWebhookEvent(ctx, event, u.ID, webhookUser(u, names))
+}
+
+// enqueueGroupRolesChanged queues user.roles_changed for every member of a group
+func enqueueGroupRolesChanged(ctx context.Context, tx store.Repository, groupID uint) error {
+	members, err := tx.GetGroupMembers(ctx, groupID)
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		if err := enqueueUserEvent(ctx, tx, models.EventUserRolesChanged, m.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validWebhookURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func validWebhookEvents(events []string) bool {
+next:
+	for _, e := range events {
+		for _, known := range webhookEvents {
+			if e == known {
+				continue next
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// webhookFromURL loads the webhook referenced by the {id} URL param, writing an error response if it fails
+func (h *Handler) webhookFromURL(w http.ResponseWriter, r *http.Request) (*models.Webhook, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid webhook id")
+		return nil, false
+	}
+	wh, err := h.store.GetWebhookByID(r.Context(), uint(id))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "webhook not found")
+		return nil, false
+	}
+	return wh, true
+}
+
+// ListWebhooks - admin only
+func (h *Handler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "admin only")
+		return
+	}
+	whs, err := h.store.ListWebhooks(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, whs)
+}
+
+// CreateWebhook - admin only. The signing secret is only returned here.
+func (h *Handler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "admin only")
+		return
+	}
+	var req WebhookReq
+	if err := parseBody(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid")
+		return
+	}
+	if !validWebhookURL(req.URL) {
+		writeError(w, http.StatusBadRequest, "url must be an absolute http(s) URL")
+		return
+	}
+	if !validWebhookEvents(req.Events) {
+		writeError(w, http.StatusBadRequest, "unknown event")
+		return
+	}
+	if req.Secret == "" {
+		secret, err := newWebhookSecret()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to generate secret")
+			return
+		}
+		req.Secret = secret
+	}
+	wh := &models.Webhook{URL: req.URL, Description: req.Description, Events: req.Events, Secret: req.Secret, Active: req.Active == nil || *req.Active}
+	if err := h.store.CreateWebhook(r.Context(), wh); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	claims := GetClaims(r)
+	if claims != nil {
+		log.Printf("create webhook success: id=%d, url=%s, requestedBy=%d", wh.ID, wh.URL, claims.UserID)
+	}
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"webhook": wh, "secret": wh.Secret})
+}
+
+// GetWebhook - admin only
+func (h *Handler) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "admin only")
+		return
+	}
+	wh, ok := h.webhookFromURL(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, wh)
+}
+
+// UpdateWebhook - admin only. Empty fields are left unchanged.
+func (h *Handler) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "admin only")
+		return
+	}
+	wh, ok := h.webhookFromURL(w, r)
+	if !ok {
+		return
+	}
+	var req WebhookReq
+	if err := parseBody(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid")
+		return
+	}
+	if req.URL != "" {
+		if !validWebhookURL(req.URL) {
+			writeError(w, http.StatusBadRequest, "url must be an absolute http(s) URL")
+			return
+		}
+		wh.URL = req.URL
+	}
+	if req.Events != nil {
+		if !validWebhookEvents(req.Events) {
+			writeError(w, http.StatusBadRequest, "unknown event")
+			return
+		}
+		wh.Events = req.Events
+	}
+	if req.Description != "" {
+		wh.Description = req.Description
+	}
+	if req.Secret != "" {
+		wh.Secret = req.Secret
+	}
+	if req.Active != nil {
+		wh.Active = *req.Active
+	}
+	if err := h.store.UpdateWebhook(r.Context(), wh); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, wh)
+}
+
+// DeleteWebhook - admin only
+func (h *Handler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "admin only")
+		return
+	}
+	wh, ok := h.webhookFromURL(w, r)
+	if !ok {
+		return
+	}
+	if err := h.store.DeleteWebhook(r.Context(), wh.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	claims := GetClaims(r)
+	if claims != nil {
+		log.Printf("delete webhook success: id=%d, requestedBy=%d", wh.ID, claims.UserID)
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// ListWebhookDeliveries - admin only. Optional status and limit query params.
+func (h *Handler) ListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "admin only")
+		return
+	}
+	wh, ok := h.webhookFromURL(w, r)
+	if !ok {
+		return
+	}
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 500 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = n
+	}
+	ds, err := h.store.ListWebhookDeliveries(r.Context(), wh.ID, r.URL.Query().Get("status"), limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, ds)
+}
+
+// RetryWebhookDelivery - admin only. Requeues a delivery, typically a dead-lettered one.
+func (h *Handler) RetryWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		writeError(w, http.StatusForbidden, "admin only")
+		return
+	}
+	wh, ok := h.webhookFromURL(w, r)
+	if !ok {
+		return
+	}
+	did, err := strconv.Atoi(chi.URLParam(r, "deliveryID"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid delivery id")
+		return
+	}
+	d, err := h.store.GetWebhookDelivery(r.Context(), wh.ID, uint(did))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "delivery not found")
+		return
+	}
+	d.Status = models.DeliveryPending
+	d.Attempts = 0
+	d.NextAttemptAt = time.Now().UTC()
+	if err := h.store.UpdateWebhookDelivery(r.Context(), d); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, d)
+}
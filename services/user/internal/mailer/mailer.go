@@ -0,0 +1,63 @@
+// Package mailer sends the transactional emails of the service.
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages. Send returns once the message has been handed
+// over for delivery.
+type Mailer interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// SMTP sends messages through an SMTP relay, using STARTTLS when the server
+// offers it
+type SMTP struct {
+	// Addr is the host:port of the relay
+	Addr string
+	From string
+	// Username and Password enable PLAIN auth when Username is set
+	Username string
+	Password string
+}
+
+func (s *SMTP) Send(ctx context.Context, m Message) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, _ := net.SplitHostPort(s.Addr)
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s",
+		s.From, m.To, m.Subject, time.Now().Format(time.RFC1123Z), strings.ReplaceAll(m.Body, "\n", "\r\n"))
+	done := make(chan error, 1)
+	go func() { done <- smtp.SendMail(s.Addr, auth, s.From, []string{m.To}, []byte(msg)) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Log writes messages to the log instead of sending them; it is the default
+// when no SMTP relay is configured, for development
+type Log struct{}
+
+func (Log) Send(ctx context.Context, m Message) error {
+	log.Printf("mail: to=%s, subject=%q\n%s", m.To, m.Subject, m.Body)
+	return nil
+}
@@ -0,0 +1,259 @@
+// Package migrations applies the versioned SQL schema embedded in the binary.
+//
+// Migrations live in sql/<dialect>/ as pairs of NNNN_name.up.sql and
+// NNNN_name.down.sql files. Applied versions are recorded in the
+// schema_migrations table; every migration runs in its own transaction.
+// MySQL commits each DDL statement as it runs, though, so there a migration
+// that fails midway leaves its earlier statements applied without being
+// recorded, and has to be repaired by hand before it can run again, as the
+// README describes.
+package migrations
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//go:embed sql
+var files embed.FS
+
+// ErrSchemaMismatch is returned by Check when the database schema does not
+// match the migrations compiled into the binary
+var ErrSchemaMismatch = errors.New("database schema does not match this build")
+
+// Migration is one versioned schema change
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status describes a migration known to the binary or recorded in the database
+type Status struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt *time.Time
+	// Unknown is set for versions recorded in the database but missing from this build
+	Unknown bool
+}
+
+// schemaMigration is a row of the schema_migrations table
+type schemaMigration struct {
+	Version   int `gorm:"primaryKey"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Migrator applies the migrations of one SQL dialect to a database
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+// New loads the migrations for the dialect of db
+func New(db *gorm.DB) (*Migrator, error) {
+	ms, err := load(db.Dialector.Name())
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: ms}, nil
+}
+
+func load(dialect string) ([]Migration, error) {
+	dir := path.Join("sql", dialect)
+	entries, err := fs.ReadDir(files, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for dialect %q", dialect)
+	}
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		name := e.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		num, label, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(num)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("migration %s: name must look like NNNN_name.%s.sql", name, direction)
+		}
+		body, err := fs.ReadFile(files, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: label}
+			byVersion[version] = m
+		} else if m.Name != label {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, m.Name, label)
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+	ms := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		ms = append(ms, *m)
+	}
+	sort.Slice(ms, func(i, j int) bool { return ms[i].Version < ms[j].Version })
+	return ms, nil
+}
+
+// createTable is portable across every supported dialect
+const createTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+  version INTEGER PRIMARY KEY,
+  name VARCHAR(255) NOT NULL,
+  applied_at TIMESTAMP NOT NULL
+)`
+
+// applied returns the recorded migrations keyed by version
+func (m *Migrator) applied() (map[int]schemaMigration, error) {
+	if err := m.db.Exec(createTable).Error; err != nil {
+		return nil, err
+	}
+	var rows []schemaMigration
+	if err := m.db.Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	out := make(map[int]schemaMigration, len(rows))
+	for _, r := range rows {
+		out[r.Version] = r
+	}
+	return out, nil
+}
+
+// Status lists every known migration plus any unknown version found in the database
+func (m *Migrator) Status() ([]Status, error) {
+	done, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	var out []Status
+	for _, mig := range m.migrations {
+		st := Status{Version: mig.Version, Name: mig.Name}
+		if r, ok := done[mig.Version]; ok {
+			at := r.AppliedAt
+			st.Applied, st.AppliedAt = true, &at
+			delete(done, mig.Version)
+		}
+		out = append(out, st)
+	}
+	for _, r := range done {
+		at := r.AppliedAt
+		out = append(out, Status{Version: r.Version, Name: r.Name, Applied: true, AppliedAt: &at, Unknown: true})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// Check returns ErrSchemaMismatch when migrations are pending or the
+// database carries versions this build does not know about
+func (m *Migrator) Check() error {
+	sts, err := m.Status()
+	if err != nil {
+		return err
+	}
+	var pending, unknown []string
+	for _, st := range sts {
+		switch {
+		case st.Unknown:
+			unknown = append(unknown, fmt.Sprintf("%04d_%s", st.Version, st.Name))
+		case !st.Applied:
+			pending = append(pending, fmt.Sprintf("%04d_%s", st.Version, st.Name))
+		}
+	}
+	switch {
+	case len(unknown) > 0:
+		return fmt.Errorf("%w: database has migrations newer than this build: %s", ErrSchemaMismatch, strings.Join(unknown, ", "))
+	case len(pending) > 0:
+		return fmt.Errorf("%w: pending migrations: %s", ErrSchemaMismatch, strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+// Up applies every pending migration in version order and returns the ones applied
+func (m *Migrator) Up() ([]Migration, error) {
+	done, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	var ran []Migration
+	for _, mig := range m.migrations {
+		if _, ok := done[mig.Version]; ok {
+			continue
+		}
+		err := m.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(mig.Up).Error; err != nil {
+				return err
+			}
+			return tx.Create(&schemaMigration{Version: mig.Version, Name: mig.Name, AppliedAt: time.Now().UTC()}).Error
+		})
+		if err != nil {
+			return ran, fmt.Errorf("migration %04d_%s up: %w%s", mig.Version, mig.Name, err, m.partialHint())
+		}
+		ran = append(ran, mig)
+	}
+	return ran, nil
+}
+
+// partialHint is appended to the error of a failed migration on databases
+// that cannot roll back its DDL
+func (m *Migrator) partialHint() string {
+	if m.db.Dialector.Name() != "mysql" {
+		return ""
+	}
+	return " (MySQL does not roll back DDL: statements before the failing one stay applied and must be reverted or completed by hand, see \"Repairing a failed MySQL migration\" in the README)"
+}
+
+// Down reverts the latest steps applied migrations and returns the ones reverted
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	done, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	var ran []Migration
+	for i := len(m.migrations) - 1; i >= 0 && len(ran) < steps; i-- {
+		mig := m.migrations[i]
+		if _, ok := done[mig.Version]; !ok {
+			continue
+		}
+		err := m.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(mig.Down).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&schemaMigration{Version: mig.Version}).Error
+		})
+		if err != nil {
+			return ran, fmt.Errorf("migration %04d_%s down: %w%s", mig.Version, mig.Name, err, m.partialHint())
+		}
+		ran = append(ran, mig)
+	}
+	return ran, nil
+}
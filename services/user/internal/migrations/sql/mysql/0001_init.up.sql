@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS `users` (
+  `id` BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+  `created_at` DATETIME(6) NULL,
+  `updated_at` DATETIME(6) NULL,
+  `deleted_at` DATETIME(6) NULL,
+  `email` VARCHAR(255),
+  `password` LONGTEXT,
+  `full_name` LONGTEXT,
+  UNIQUE INDEX `idx_users_email` (`email`),
+  INDEX `idx_users_deleted_at` (`deleted_at`)
+);
+
+CREATE TABLE IF NOT EXISTS `roles` (
+  `id` BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+  `name` VARCHAR(100),
+  UNIQUE INDEX `idx_roles_name` (`name`)
+);
+
+CREATE TABLE IF NOT EXISTS `user_roles` (
+  `id` BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+  `user_id` BIGINT UNSIGNED,
+  `role_id` BIGINT UNSIGNED,
+  INDEX `idx_user_roles_user_id` (`user_id`),
+  INDEX `idx_user_roles_role_id` (`role_id`)
+);
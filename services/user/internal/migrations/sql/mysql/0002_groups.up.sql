@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS `groups` (
+  `id` BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+  `created_at` DATETIME(6) NULL,
+  `updated_at` DATETIME(6) NULL,
+  `name` VARCHAR(100),
+  `description` LONGTEXT,
+  UNIQUE INDEX `idx_groups_name` (`name`)
+);
+
+CREATE TABLE IF NOT EXISTS `group_members` (
+  `id` BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+  `group_id` BIGINT UNSIGNED,
+  `user_id` BIGINT UNSIGNED,
+  UNIQUE INDEX `idx_group_member` (`group_id`,`user_id`),
+  INDEX `idx_group_members_user_id` (`user_id`)
+);
+
+CREATE TABLE IF NOT EXISTS `group_roles` (
+  `id` BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+  `group_id` BIGINT UNSIGNED,
+  `role_id` BIGINT UNSIGNED,
+  UNIQUE INDEX `idx_group_role` (`group_id`,`role_id`),
+  INDEX `idx_group_roles_role_id` (`role_id`)
+);
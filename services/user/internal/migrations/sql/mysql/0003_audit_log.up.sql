@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS `audit_events` (
+  `id` BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+  `created_at` DATETIME(6) NULL,
+  `actor_id` BIGINT UNSIGNED,
+  `actor_email` VARCHAR(255),
+  `impersonator_id` BIGINT UNSIGNED,
+  `action` VARCHAR(64),
+  `target_type` VARCHAR(32),
+  `target_id` VARCHAR(64),
+  `ip` VARCHAR(64),
+  `user_agent` LONGTEXT,
+  `result` VARCHAR(16),
+  `detail` LONGTEXT,
+  `diff` LONGTEXT,
+  `prev_hash` VARCHAR(64),
+  `hash` VARCHAR(64),
+  INDEX `idx_audit_events_created_at` (`created_at`),
+  INDEX `idx_audit_events_actor_id` (`actor_id`),
+  INDEX `idx_audit_events_impersonator_id` (`impersonator_id`),
+  INDEX `idx_audit_events_action` (`action`),
+  INDEX `idx_audit_events_target_id` (`target_id`),
+  INDEX `idx_audit_events_result` (`result`),
+  INDEX `idx_audit_events_hash` (`hash`)
+);
+
+CREATE TABLE IF NOT EXISTS `audit_checkpoints` (
+  `id` BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+  `created_at` DATETIME(6) NULL,
+  `event_id` BIGINT UNSIGNED,
+  `hash` VARCHAR(64),
+  `signature` VARCHAR(64),
+  INDEX `idx_audit_checkpoints_event_id` (`event_id`)
+);
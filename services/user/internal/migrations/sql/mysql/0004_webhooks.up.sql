@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS `webhooks` (
+  `id` BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+  `created_at` DATETIME(6) NULL,
+  `updated_at` DATETIME(6) NULL,
+  `url` LONGTEXT,
+  `description` LONGTEXT,
+  `secret` LONGTEXT,
+  `events` LONGTEXT,
+  `active` BOOLEAN
+);
+
+CREATE TABLE IF NOT EXISTS `webhook_deliveries` (
+  `id` BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+  `created_at` DATETIME(6) NULL,
+  `updated_at` DATETIME(6) NULL,
+  `webhook_id` BIGINT UNSIGNED,
+  `event_id` VARCHAR(32),
+  `event` VARCHAR(64),
+  `payload` LONGTEXT,
+  `status` VARCHAR(16),
+  `next_attempt_at` DATETIME(6) NULL,
+  `attempts` BIGINT,
+  `last_attempt_at` DATETIME(6) NULL,
+  `response_status` BIGINT,
+  `response_body` LONGTEXT,
+  `last_error` LONGTEXT,
+  INDEX `idx_webhook_deliveries_webhook_id` (`webhook_id`),
+  INDEX `idx_delivery_due` (`status`,`next_attempt_at`)
+);
@@ -0,0 +1,6 @@
+-- Fails while a live and a deleted user share an email; purge one of them first.
+ALTER TABLE `users`
+  DROP INDEX `idx_users_live_email`,
+  DROP COLUMN `live_email`,
+  DROP INDEX `idx_users_email`,
+  ADD UNIQUE INDEX `idx_users_email` (`email`);
@@ -0,0 +1,8 @@
+-- Emails only need to be unique among users that are not soft deleted, so a
+-- deleted user's address can be registered again. MySQL has no partial
+-- indexes: live_email is NULL for deleted users and NULLs never collide.
+ALTER TABLE `users`
+  ADD COLUMN `live_email` VARCHAR(255) GENERATED ALWAYS AS (IF(`deleted_at` IS NULL, `email`, NULL)) VIRTUAL,
+  DROP INDEX `idx_users_email`,
+  ADD INDEX `idx_users_email` (`email`),
+  ADD UNIQUE INDEX `idx_users_live_email` (`live_email`);
@@ -0,0 +1,2 @@
+-- Version backs optimistic concurrency control on user updates.
+ALTER TABLE `users` ADD COLUMN `version` BIGINT UNSIGNED NOT NULL DEFAULT 1;
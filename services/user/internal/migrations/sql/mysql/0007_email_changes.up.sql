@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS `email_changes` (
+  `id` BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+  `created_at` DATETIME(6) NULL,
+  `user_id` BIGINT UNSIGNED,
+  `new_email` VARCHAR(255),
+  `token_hash` VARCHAR(64),
+  `expires_at` DATETIME(6) NULL,
+  INDEX `idx_email_changes_user_id` (`user_id`),
+  UNIQUE INDEX `idx_email_changes_token_hash` (`token_hash`),
+  INDEX `idx_email_changes_expires_at` (`expires_at`)
+);
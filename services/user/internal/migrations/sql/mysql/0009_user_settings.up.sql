@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS `user_settings` (
+  `user_id` BIGINT UNSIGNED PRIMARY KEY,
+  `updated_at` DATETIME(6) NULL,
+  `version` BIGINT UNSIGNED NOT NULL DEFAULT 0,
+  `settings` LONGTEXT,
+  `field_times` LONGTEXT
+);
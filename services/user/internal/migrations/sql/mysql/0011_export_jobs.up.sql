@@ -0,0 +1,17 @@
+-- Data exports requested by users, built in the background into a ZIP archive.
+CREATE TABLE IF NOT EXISTS `export_jobs` (
+  `id` BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+  `created_at` DATETIME(6) NULL,
+  `updated_at` DATETIME(6) NULL,
+  `user_id` BIGINT UNSIGNED,
+  `status` VARCHAR(16),
+  `progress` INT NOT NULL DEFAULT 0,
+  `attempts` INT NOT NULL DEFAULT 0,
+  `error` TEXT,
+  `blob_key` VARCHAR(255),
+  `size` BIGINT NOT NULL DEFAULT 0,
+  `finished_at` DATETIME(6) NULL,
+  `expires_at` DATETIME(6) NULL,
+  INDEX `idx_export_jobs_user_id` (`user_id`),
+  INDEX `idx_export_jobs_status` (`status`)
+);
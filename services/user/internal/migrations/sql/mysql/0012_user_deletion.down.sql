@@ -0,0 +1,4 @@
+ALTER TABLE `users`
+  DROP INDEX `idx_users_deletion_due_at`,
+  DROP COLUMN `anonymized_at`,
+  DROP COLUMN `deletion_due_at`;
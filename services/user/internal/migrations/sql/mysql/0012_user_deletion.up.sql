@@ -0,0 +1,5 @@
+-- Self-service account deletion: when it takes effect, and when the user's personal data was erased.
+ALTER TABLE `users`
+  ADD COLUMN `deletion_due_at` DATETIME(6) NULL,
+  ADD COLUMN `anonymized_at` DATETIME(6) NULL,
+  ADD INDEX `idx_users_deletion_due_at` (`deletion_due_at`);
@@ -0,0 +1,12 @@
+-- Fails while values are encrypted: only databases that never enabled FIELD_KEYS_DIR can be reverted.
+ALTER TABLE `users`
+  DROP INDEX `idx_users_email_index`,
+  DROP COLUMN `live_email_index`,
+  DROP COLUMN `email_index`,
+  MODIFY `email` VARCHAR(255),
+  MODIFY `phone` VARCHAR(16) NOT NULL DEFAULT '',
+  MODIFY `birthday` VARCHAR(10) NOT NULL DEFAULT '';
+ALTER TABLE `users`
+  ADD COLUMN `live_email` VARCHAR(255) GENERATED ALWAYS AS (IF(`deleted_at` IS NULL, `email`, NULL)) VIRTUAL,
+  ADD INDEX `idx_users_email` (`email`),
+  ADD UNIQUE INDEX `idx_users_live_email` (`live_email`);
@@ -0,0 +1,16 @@
+-- Personal data may be encrypted at rest, so the encrypted columns are
+-- widened, and emails are looked up and kept unique among live users through
+-- email_index, a blind index of the email. As in 0005, the unique index is on
+-- a generated column that is NULL for deleted users. The service fills in
+-- email_index for existing users when it starts.
+ALTER TABLE `users`
+  DROP INDEX `idx_users_live_email`,
+  DROP COLUMN `live_email`,
+  DROP INDEX `idx_users_email`,
+  MODIFY `email` VARCHAR(1024),
+  MODIFY `phone` VARCHAR(255) NOT NULL DEFAULT '',
+  MODIFY `birthday` VARCHAR(255) NOT NULL DEFAULT '',
+  ADD COLUMN `email_index` VARCHAR(64);
+ALTER TABLE `users`
+  ADD COLUMN `live_email_index` VARCHAR(64) GENERATED ALWAYS AS (IF(`deleted_at` IS NULL, `email_index`, NULL)) VIRTUAL,
+  ADD UNIQUE INDEX `idx_users_email_index` (`live_email_index`);
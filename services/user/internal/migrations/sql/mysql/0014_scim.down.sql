@@ -0,0 +1,6 @@
+ALTER TABLE `roles`
+  DROP COLUMN `external_id`;
+ALTER TABLE `users`
+  DROP INDEX `idx_users_external_id`,
+  DROP COLUMN `disabled_at`,
+  DROP COLUMN `external_id`;
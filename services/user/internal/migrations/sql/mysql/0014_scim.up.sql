@@ -0,0 +1,7 @@
+-- SCIM provisioning: the identity provider's ID for users and roles, and accounts it has deactivated.
+ALTER TABLE `users`
+  ADD COLUMN `external_id` VARCHAR(255) NOT NULL DEFAULT '',
+  ADD COLUMN `disabled_at` DATETIME(6) NULL,
+  ADD INDEX `idx_users_external_id` (`external_id`);
+ALTER TABLE `roles`
+  ADD COLUMN `external_id` VARCHAR(255) NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+-- Full-text user search over the email and full name.
+ALTER TABLE `users`
+  ADD FULLTEXT INDEX `idx_users_search` (`email`, `full_name`);
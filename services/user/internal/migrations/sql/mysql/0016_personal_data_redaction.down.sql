@@ -0,0 +1,7 @@
+ALTER TABLE `webhook_deliveries`
+  DROP INDEX `idx_webhook_deliveries_user_id`,
+  DROP COLUMN `user_id`;
+ALTER TABLE `audit_events`
+  DROP COLUMN `redacted_at`,
+  DROP COLUMN `personal_digest`,
+  DROP COLUMN `personal_salt`;
@@ -0,0 +1,10 @@
+-- Erasable personal data: audit events hash a salted digest of their personal data, which
+-- is erased with the salt when the user is anonymized or purged; deliveries record whom
+-- their payload is about.
+ALTER TABLE `audit_events`
+  ADD COLUMN `personal_salt` VARCHAR(64),
+  ADD COLUMN `personal_digest` VARCHAR(64),
+  ADD COLUMN `redacted_at` DATETIME(6) NULL;
+ALTER TABLE `webhook_deliveries`
+  ADD COLUMN `user_id` BIGINT UNSIGNED,
+  ADD INDEX `idx_webhook_deliveries_user_id` (`user_id`);
@@ -0,0 +1,6 @@
+-- Fails while values are encrypted: only databases that never enabled FIELD_KEYS_DIR can be reverted.
+ALTER TABLE `email_changes`
+  MODIFY `new_email` VARCHAR(255);
+ALTER TABLE `audit_events`
+  MODIFY `ip` VARCHAR(64),
+  MODIFY `actor_email` VARCHAR(255);
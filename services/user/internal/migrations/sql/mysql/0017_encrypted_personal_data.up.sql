@@ -0,0 +1,8 @@
+-- Audit events, webhook payloads and pending email changes may be encrypted at rest,
+-- so the encrypted columns are widened. The service encrypts existing values when it
+-- starts with FIELD_KEYS_DIR set.
+ALTER TABLE `audit_events`
+  MODIFY `actor_email` VARCHAR(1024),
+  MODIFY `ip` VARCHAR(255);
+ALTER TABLE `email_changes`
+  MODIFY `new_email` VARCHAR(1024);
@@ -0,0 +1,2 @@
+-- TokenVersion is bumped by every email change, revoking the tokens issued before it.
+ALTER TABLE `users` ADD COLUMN `token_version` BIGINT UNSIGNED NOT NULL DEFAULT 0;
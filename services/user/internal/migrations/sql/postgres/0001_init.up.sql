@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS "users" (
+  "id" BIGSERIAL PRIMARY KEY,
+  "created_at" TIMESTAMPTZ,
+  "updated_at" TIMESTAMPTZ,
+  "deleted_at" TIMESTAMPTZ,
+  "email" VARCHAR(255),
+  "password" TEXT,
+  "full_name" TEXT
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_users_email" ON "users"("email");
+CREATE INDEX IF NOT EXISTS "idx_users_deleted_at" ON "users"("deleted_at");
+
+CREATE TABLE IF NOT EXISTS "roles" (
+  "id" BIGSERIAL PRIMARY KEY,
+  "name" VARCHAR(100)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_roles_name" ON "roles"("name");
+
+CREATE TABLE IF NOT EXISTS "user_roles" (
+  "id" BIGSERIAL PRIMARY KEY,
+  "user_id" BIGINT,
+  "role_id" BIGINT
+);
+CREATE INDEX IF NOT EXISTS "idx_user_roles_user_id" ON "user_roles"("user_id");
+CREATE INDEX IF NOT EXISTS "idx_user_roles_role_id" ON "user_roles"("role_id");
@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS "groups" (
+  "id" BIGSERIAL PRIMARY KEY,
+  "created_at" TIMESTAMPTZ,
+  "updated_at" TIMESTAMPTZ,
+  "name" VARCHAR(100),
+  "description" TEXT
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_groups_name" ON "groups"("name");
+
+CREATE TABLE IF NOT EXISTS "group_members" (
+  "id" BIGSERIAL PRIMARY KEY,
+  "group_id" BIGINT,
+  "user_id" BIGINT
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_group_member" ON "group_members"("group_id","user_id");
+CREATE INDEX IF NOT EXISTS "idx_group_members_user_id" ON "group_members"("user_id");
+
+CREATE TABLE IF NOT EXISTS "group_roles" (
+  "id" BIGSERIAL PRIMARY KEY,
+  "group_id" BIGINT,
+  "role_id" BIGINT
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_group_role" ON "group_roles"("group_id","role_id");
+CREATE INDEX IF NOT EXISTS "idx_group_roles_role_id" ON "group_roles"("role_id");
@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS "audit_events" (
+  "id" BIGSERIAL PRIMARY KEY,
+  "created_at" TIMESTAMPTZ,
+  "actor_id" BIGINT,
+  "actor_email" VARCHAR(255),
+  "impersonator_id" BIGINT,
+  "action" VARCHAR(64),
+  "target_type" VARCHAR(32),
+  "target_id" VARCHAR(64),
+  "ip" VARCHAR(64),
+  "user_agent" TEXT,
+  "result" VARCHAR(16),
+  "detail" TEXT,
+  "diff" TEXT,
+  "prev_hash" VARCHAR(64),
+  "hash" VARCHAR(64)
+);
+CREATE INDEX IF NOT EXISTS "idx_audit_events_created_at" ON "audit_events"("created_at");
+CREATE INDEX IF NOT EXISTS "idx_audit_events_actor_id" ON "audit_events"("actor_id");
+CREATE INDEX IF NOT EXISTS "idx_audit_events_impersonator_id" ON "audit_events"("impersonator_id");
+CREATE INDEX IF NOT EXISTS "idx_audit_events_action" ON "audit_events"("action");
+CREATE INDEX IF NOT EXISTS "idx_audit_events_target_id" ON "audit_events"("target_id");
+CREATE INDEX IF NOT EXISTS "idx_audit_events_result" ON "audit_events"("result");
+CREATE INDEX IF NOT EXISTS "idx_audit_events_hash" ON "audit_events"("hash");
+
+CREATE TABLE IF NOT EXISTS "audit_checkpoints" (
+  "id" BIGSERIAL PRIMARY KEY,
+  "created_at" TIMESTAMPTZ,
+  "event_id" BIGINT,
+  "hash" VARCHAR(64),
+  "signature" VARCHAR(64)
+);
+CREATE INDEX IF NOT EXISTS "idx_audit_checkpoints_event_id" ON "audit_checkpoints"("event_id");
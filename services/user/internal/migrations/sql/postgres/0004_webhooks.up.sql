@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS "webhooks" (
+  "id" BIGSERIAL PRIMARY KEY,
+  "created_at" TIMESTAMPTZ,
+  "updated_at" TIMESTAMPTZ,
+  "url" TEXT,
+  "description" TEXT,
+  "secret" TEXT,
+  "events" TEXT,
+  "active" BOOLEAN
+);
+
+CREATE TABLE IF NOT EXISTS "webhook_deliveries" (
+  "id" BIGSERIAL PRIMARY KEY,
+  "created_at" TIMESTAMPTZ,
+  "updated_at" TIMESTAMPTZ,
+  "webhook_id" BIGINT,
+  "event_id" VARCHAR(32),
+  "event" VARCHAR(64),
+  "payload" TEXT,
+  "status" VARCHAR(16),
+  "next_attempt_at" TIMESTAMPTZ,
+  "attempts" BIGINT,
+  "last_attempt_at" TIMESTAMPTZ,
+  "response_status" BIGINT,
+  "response_body" TEXT,
+  "last_error" TEXT
+);
+CREATE INDEX IF NOT EXISTS "idx_webhook_deliveries_webhook_id" ON "webhook_deliveries"("webhook_id");
+CREATE INDEX IF NOT EXISTS "idx_delivery_due" ON "webhook_deliveries"("status","next_attempt_at");
@@ -0,0 +1,4 @@
+-- Emails only need to be unique among users that are not soft deleted, so a
+-- deleted user's address can be registered again.
+DROP INDEX IF EXISTS "idx_users_email";
+CREATE UNIQUE INDEX "idx_users_email" ON "users"("email") WHERE "deleted_at" IS NULL;
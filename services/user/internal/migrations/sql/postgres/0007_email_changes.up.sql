@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS "email_changes" (
+  "id" BIGSERIAL PRIMARY KEY,
+  "created_at" TIMESTAMPTZ,
+  "user_id" BIGINT,
+  "new_email" VARCHAR(255),
+  "token_hash" VARCHAR(64),
+  "expires_at" TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS "idx_email_changes_user_id" ON "email_changes"("user_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_email_changes_token_hash" ON "email_changes"("token_hash");
+CREATE INDEX IF NOT EXISTS "idx_email_changes_expires_at" ON "email_changes"("expires_at");
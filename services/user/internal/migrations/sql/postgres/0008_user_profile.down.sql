@@ -0,0 +1,8 @@
+ALTER TABLE "users"
+  DROP COLUMN "birthday",
+  DROP COLUMN "phone",
+  DROP COLUMN "timezone",
+  DROP COLUMN "locale",
+  DROP COLUMN "plan",
+  DROP COLUMN "avatar_url",
+  DROP COLUMN "display_name";
@@ -0,0 +1,9 @@
+-- The profile shown by the apps; empty strings mean unset.
+ALTER TABLE "users"
+  ADD COLUMN "display_name" VARCHAR(100) NOT NULL DEFAULT '',
+  ADD COLUMN "avatar_url" VARCHAR(2048) NOT NULL DEFAULT '',
+  ADD COLUMN "plan" VARCHAR(32) NOT NULL DEFAULT 'standard',
+  ADD COLUMN "locale" VARCHAR(35) NOT NULL DEFAULT '',
+  ADD COLUMN "timezone" VARCHAR(64) NOT NULL DEFAULT '',
+  ADD COLUMN "phone" VARCHAR(16) NOT NULL DEFAULT '',
+  ADD COLUMN "birthday" VARCHAR(10) NOT NULL DEFAULT '';
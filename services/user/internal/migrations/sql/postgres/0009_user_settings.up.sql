@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS "user_settings" (
+  "user_id" BIGINT PRIMARY KEY,
+  "updated_at" TIMESTAMPTZ,
+  "version" BIGINT NOT NULL DEFAULT 0,
+  "settings" TEXT,
+  "field_times" TEXT
+);
@@ -0,0 +1,17 @@
+-- Data exports requested by users, built in the background into a ZIP archive.
+CREATE TABLE IF NOT EXISTS "export_jobs" (
+  "id" BIGSERIAL PRIMARY KEY,
+  "created_at" TIMESTAMPTZ,
+  "updated_at" TIMESTAMPTZ,
+  "user_id" BIGINT,
+  "status" VARCHAR(16),
+  "progress" INTEGER NOT NULL DEFAULT 0,
+  "attempts" INTEGER NOT NULL DEFAULT 0,
+  "error" TEXT,
+  "blob_key" VARCHAR(255),
+  "size" BIGINT NOT NULL DEFAULT 0,
+  "finished_at" TIMESTAMPTZ,
+  "expires_at" TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS "idx_export_jobs_user_id" ON "export_jobs"("user_id");
+CREATE INDEX IF NOT EXISTS "idx_export_jobs_status" ON "export_jobs"("status");
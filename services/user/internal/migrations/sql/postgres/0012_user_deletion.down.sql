@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS "idx_users_deletion_due_at";
+ALTER TABLE "users"
+  DROP COLUMN "anonymized_at",
+  DROP COLUMN "deletion_due_at";
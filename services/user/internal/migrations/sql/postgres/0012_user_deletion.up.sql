@@ -0,0 +1,5 @@
+-- Self-service account deletion: when it takes effect, and when the user's personal data was erased.
+ALTER TABLE "users"
+  ADD COLUMN "deletion_due_at" TIMESTAMPTZ,
+  ADD COLUMN "anonymized_at" TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS "idx_users_deletion_due_at" ON "users"("deletion_due_at");
@@ -0,0 +1,8 @@
+-- Fails while values are encrypted: only databases that never enabled FIELD_KEYS_DIR can be reverted.
+DROP INDEX IF EXISTS "idx_users_email_index";
+ALTER TABLE "users"
+  DROP COLUMN "email_index",
+  ALTER COLUMN "email" TYPE VARCHAR(255),
+  ALTER COLUMN "phone" TYPE VARCHAR(16),
+  ALTER COLUMN "birthday" TYPE VARCHAR(10);
+CREATE UNIQUE INDEX "idx_users_email" ON "users"("email") WHERE "deleted_at" IS NULL;
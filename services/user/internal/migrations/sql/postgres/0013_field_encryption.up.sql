@@ -0,0 +1,11 @@
+-- Personal data may be encrypted at rest, so the encrypted columns are
+-- widened, and emails are looked up and kept unique among live users through
+-- email_index, a blind index of the email. The service fills it in for
+-- existing users when it starts.
+ALTER TABLE "users"
+  ALTER COLUMN "email" TYPE TEXT,
+  ALTER COLUMN "phone" TYPE TEXT,
+  ALTER COLUMN "birthday" TYPE TEXT,
+  ADD COLUMN "email_index" VARCHAR(64);
+DROP INDEX IF EXISTS "idx_users_email";
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_users_email_index" ON "users"("email_index") WHERE "deleted_at" IS NULL;
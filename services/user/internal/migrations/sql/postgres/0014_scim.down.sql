@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS "idx_users_external_id";
+ALTER TABLE "roles"
+  DROP COLUMN "external_id";
+ALTER TABLE "users"
+  DROP COLUMN "disabled_at",
+  DROP COLUMN "external_id";
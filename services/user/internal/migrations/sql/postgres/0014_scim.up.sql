@@ -0,0 +1,7 @@
+-- SCIM provisioning: the identity provider's ID for users and roles, and accounts it has deactivated.
+ALTER TABLE "users"
+  ADD COLUMN "external_id" VARCHAR(255) NOT NULL DEFAULT '',
+  ADD COLUMN "disabled_at" TIMESTAMPTZ;
+ALTER TABLE "roles"
+  ADD COLUMN "external_id" VARCHAR(255) NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS "idx_users_external_id" ON "users"("external_id");
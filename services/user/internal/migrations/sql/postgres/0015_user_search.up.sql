@@ -0,0 +1,7 @@
+-- Full-text user search: the words of the email and full name, split on anything but letters and digits.
+ALTER TABLE "users"
+  ADD COLUMN "search" tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', regexp_replace(coalesce("email", ''), '[^[:alnum:]]+', ' ', 'g')), 'A') ||
+    setweight(to_tsvector('simple', regexp_replace(coalesce("full_name", ''), '[^[:alnum:]]+', ' ', 'g')), 'B')
+  ) STORED;
+CREATE INDEX IF NOT EXISTS "idx_users_search" ON "users" USING GIN ("search");
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS "idx_webhook_deliveries_user_id";
+ALTER TABLE "webhook_deliveries"
+  DROP COLUMN "user_id";
+ALTER TABLE "audit_events"
+  DROP COLUMN "redacted_at",
+  DROP COLUMN "personal_digest",
+  DROP COLUMN "personal_salt";
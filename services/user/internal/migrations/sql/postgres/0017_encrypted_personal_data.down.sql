@@ -0,0 +1,6 @@
+-- Fails while values are encrypted: only databases that never enabled FIELD_KEYS_DIR can be reverted.
+ALTER TABLE "email_changes"
+  ALTER COLUMN "new_email" TYPE VARCHAR(255);
+ALTER TABLE "audit_events"
+  ALTER COLUMN "ip" TYPE VARCHAR(64),
+  ALTER COLUMN "actor_email" TYPE VARCHAR(255);
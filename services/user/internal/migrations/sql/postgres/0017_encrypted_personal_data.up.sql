@@ -0,0 +1,8 @@
+-- Audit events, webhook payloads and pending email changes may be encrypted at rest,
+-- so the encrypted columns are widened. The service encrypts existing values when it
+-- starts with FIELD_KEYS_DIR set.
+ALTER TABLE "audit_events"
+  ALTER COLUMN "actor_email" TYPE TEXT,
+  ALTER COLUMN "ip" TYPE TEXT;
+ALTER TABLE "email_changes"
+  ALTER COLUMN "new_email" TYPE TEXT;
@@ -0,0 +1,27 @@
+-- Tables created by the AutoMigrate-based releases. IF NOT EXISTS lets
+-- databases created by those releases adopt the migration history.
+CREATE TABLE IF NOT EXISTS `users` (
+  `id` integer PRIMARY KEY AUTOINCREMENT,
+  `created_at` datetime,
+  `updated_at` datetime,
+  `deleted_at` datetime,
+  `email` text,
+  `password` text,
+  `full_name` text
+);
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_users_email` ON `users`(`email`);
+CREATE INDEX IF NOT EXISTS `idx_users_deleted_at` ON `users`(`deleted_at`);
+
+CREATE TABLE IF NOT EXISTS `roles` (
+  `id` integer PRIMARY KEY AUTOINCREMENT,
+  `name` text
+);
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_roles_name` ON `roles`(`name`);
+
+CREATE TABLE IF NOT EXISTS `user_roles` (
+  `id` integer PRIMARY KEY AUTOINCREMENT,
+  `user_id` integer,
+  `role_id` integer
+);
+CREATE INDEX IF NOT EXISTS `idx_user_roles_user_id` ON `user_roles`(`user_id`);
+CREATE INDEX IF NOT EXISTS `idx_user_roles_role_id` ON `user_roles`(`role_id`);
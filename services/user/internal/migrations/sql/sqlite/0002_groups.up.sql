@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS `groups` (
+  `id` integer PRIMARY KEY AUTOINCREMENT,
+  `created_at` datetime,
+  `updated_at` datetime,
+  `name` text,
+  `description` text
+);
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_groups_name` ON `groups`(`name`);
+
+CREATE TABLE IF NOT EXISTS `group_members` (
+  `id` integer PRIMARY KEY AUTOINCREMENT,
+  `group_id` integer,
+  `user_id` integer
+);
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_group_member` ON `group_members`(`group_id`,`user_id`);
+CREATE INDEX IF NOT EXISTS `idx_group_members_user_id` ON `group_members`(`user_id`);
+
+CREATE TABLE IF NOT EXISTS `group_roles` (
+  `id` integer PRIMARY KEY AUTOINCREMENT,
+  `group_id` integer,
+  `role_id` integer
+);
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_group_role` ON `group_roles`(`group_id`,`role_id`);
+CREATE INDEX IF NOT EXISTS `idx_group_roles_role_id` ON `group_roles`(`role_id`);
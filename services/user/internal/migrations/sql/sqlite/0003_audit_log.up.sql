@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS `audit_events` (
+  `id` integer PRIMARY KEY AUTOINCREMENT,
+  `created_at` datetime,
+  `actor_id` integer,
+  `actor_email` text,
+  `impersonator_id` integer,
+  `action` text,
+  `target_type` text,
+  `target_id` text,
+  `ip` text,
+  `user_agent` text,
+  `result` text,
+  `detail` text,
+  `diff` text,
+  `prev_hash` text,
+  `hash` text
+);
+CREATE INDEX IF NOT EXISTS `idx_audit_events_created_at` ON `audit_events`(`created_at`);
+CREATE INDEX IF NOT EXISTS `idx_audit_events_actor_id` ON `audit_events`(`actor_id`);
+CREATE INDEX IF NOT EXISTS `idx_audit_events_impersonator_id` ON `audit_events`(`impersonator_id`);
+CREATE INDEX IF NOT EXISTS `idx_audit_events_action` ON `audit_events`(`action`);
+CREATE INDEX IF NOT EXISTS `idx_audit_events_target_id` ON `audit_events`(`target_id`);
+CREATE INDEX IF NOT EXISTS `idx_audit_events_result` ON `audit_events`(`result`);
+CREATE INDEX IF NOT EXISTS `idx_audit_events_hash` ON `audit_events`(`hash`);
+
+CREATE TABLE IF NOT EXISTS `audit_checkpoints` (
+  `id` integer PRIMARY KEY AUTOINCREMENT,
+  `created_at` datetime,
+  `event_id` integer,
+  `hash` text,
+  `signature` text
+);
+CREATE INDEX IF NOT EXISTS `idx_audit_checkpoints_event_id` ON `audit_checkpoints`(`event_id`);
@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS `webhooks` (
+  `id` integer PRIMARY KEY AUTOINCREMENT,
+  `created_at` datetime,
+  `updated_at` datetime,
+  `url` text,
+  `description` text,
+  `secret` text,
+  `events` text,
+  `active` numeric
+);
+
+CREATE TABLE IF NOT EXISTS `webhook_deliveries` (
+  `id` integer PRIMARY KEY AUTOINCREMENT,
+  `created_at` datetime,
+  `updated_at` datetime,
+  `webhook_id` integer,
+  `event_id` text,
+  `event` text,
+  `payload` text,
+  `status` text,
+  `next_attempt_at` datetime,
+  `attempts` integer,
+  `last_attempt_at` datetime,
+  `response_status` integer,
+  `response_body` text,
+  `last_error` text
+);
+CREATE INDEX IF NOT EXISTS `idx_webhook_deliveries_webhook_id` ON `webhook_deliveries`(`webhook_id`);
+CREATE INDEX IF NOT EXISTS `idx_delivery_due` ON `webhook_deliveries`(`status`,`next_attempt_at`);
@@ -0,0 +1,3 @@
+-- Fails while a live and a deleted user share an email; purge one of them first.
+DROP INDEX IF EXISTS `idx_users_email`;
+CREATE UNIQUE INDEX `idx_users_email` ON `users`(`email`);
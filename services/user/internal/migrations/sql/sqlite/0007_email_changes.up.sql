@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS `email_changes` (
+  `id` integer PRIMARY KEY AUTOINCREMENT,
+  `created_at` datetime,
+  `user_id` integer,
+  `new_email` text,
+  `token_hash` text,
+  `expires_at` datetime
+);
+CREATE INDEX IF NOT EXISTS `idx_email_changes_user_id` ON `email_changes`(`user_id`);
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_email_changes_token_hash` ON `email_changes`(`token_hash`);
+CREATE INDEX IF NOT EXISTS `idx_email_changes_expires_at` ON `email_changes`(`expires_at`);
@@ -0,0 +1,8 @@
+-- The profile shown by the apps; empty strings mean unset.
+ALTER TABLE `users` ADD COLUMN `display_name` text NOT NULL DEFAULT '';
+ALTER TABLE `users` ADD COLUMN `avatar_url` text NOT NULL DEFAULT '';
+ALTER TABLE `users` ADD COLUMN `plan` text NOT NULL DEFAULT 'standard';
+ALTER TABLE `users` ADD COLUMN `locale` text NOT NULL DEFAULT '';
+ALTER TABLE `users` ADD COLUMN `timezone` text NOT NULL DEFAULT '';
+ALTER TABLE `users` ADD COLUMN `phone` text NOT NULL DEFAULT '';
+ALTER TABLE `users` ADD COLUMN `birthday` text NOT NULL DEFAULT '';
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS `user_settings` (
+  `user_id` integer PRIMARY KEY,
+  `updated_at` datetime,
+  `version` integer NOT NULL DEFAULT 0,
+  `settings` text,
+  `field_times` text
+);
@@ -0,0 +1,2 @@
+-- Uploaded avatar images live in blob storage under this prefix; empty means none.
+ALTER TABLE `users` ADD COLUMN `avatar_key` text NOT NULL DEFAULT '';
@@ -0,0 +1,17 @@
+-- Data exports requested by users, built in the background into a ZIP archive.
+CREATE TABLE IF NOT EXISTS `export_jobs` (
+  `id` integer PRIMARY KEY AUTOINCREMENT,
+  `created_at` datetime,
+  `updated_at` datetime,
+  `user_id` integer,
+  `status` text,
+  `progress` integer NOT NULL DEFAULT 0,
+  `attempts` integer NOT NULL DEFAULT 0,
+  `error` text,
+  `blob_key` text,
+  `size` integer NOT NULL DEFAULT 0,
+  `finished_at` datetime,
+  `expires_at` datetime
+);
+CREATE INDEX IF NOT EXISTS `idx_export_jobs_user_id` ON `export_jobs`(`user_id`);
+CREATE INDEX IF NOT EXISTS `idx_export_jobs_status` ON `export_jobs`(`status`);
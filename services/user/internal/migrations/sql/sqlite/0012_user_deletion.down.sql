@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS `idx_users_deletion_due_at`;
+ALTER TABLE `users` DROP COLUMN `anonymized_at`;
+ALTER TABLE `users` DROP COLUMN `deletion_due_at`;
@@ -0,0 +1,4 @@
+-- Self-service account deletion: when it takes effect, and when the user's personal data was erased.
+ALTER TABLE `users` ADD COLUMN `deletion_due_at` datetime;
+ALTER TABLE `users` ADD COLUMN `anonymized_at` datetime;
+CREATE INDEX IF NOT EXISTS `idx_users_deletion_due_at` ON `users`(`deletion_due_at`);
@@ -0,0 +1,5 @@
+-- Only databases that never enabled FIELD_KEYS_DIR can be reverted: encrypted
+-- emails can no longer be looked up without email_index.
+DROP INDEX IF EXISTS `idx_users_email_index`;
+ALTER TABLE `users` DROP COLUMN `email_index`;
+CREATE UNIQUE INDEX `idx_users_email` ON `users`(`email`) WHERE `deleted_at` IS NULL;
@@ -0,0 +1,6 @@
+-- Personal data may be encrypted at rest, so emails are looked up and kept
+-- unique among live users through email_index, a blind index of the email.
+-- The service fills it in for existing users when it starts.
+ALTER TABLE `users` ADD COLUMN `email_index` text;
+DROP INDEX IF EXISTS `idx_users_email`;
+CREATE UNIQUE INDEX `idx_users_email_index` ON `users`(`email_index`) WHERE `deleted_at` IS NULL;
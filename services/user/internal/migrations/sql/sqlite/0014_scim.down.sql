@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS `idx_users_external_id`;
+ALTER TABLE `roles` DROP COLUMN `external_id`;
+ALTER TABLE `users` DROP COLUMN `disabled_at`;
+ALTER TABLE `users` DROP COLUMN `external_id`;
@@ -0,0 +1,5 @@
+-- SCIM provisioning: the identity provider's ID for users and roles, and accounts it has deactivated.
+ALTER TABLE `users` ADD COLUMN `external_id` text NOT NULL DEFAULT '';
+ALTER TABLE `users` ADD COLUMN `disabled_at` datetime;
+ALTER TABLE `roles` ADD COLUMN `external_id` text NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS `idx_users_external_id` ON `users`(`external_id`);
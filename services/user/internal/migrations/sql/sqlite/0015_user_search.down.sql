@@ -0,0 +1,5 @@
+-- Only the triggers are dropped: SQLite cannot drop the FTS5 tables in builds without
+-- FTS5, and with the triggers gone nothing reads or writes them.
+DROP TRIGGER IF EXISTS `users_fts_insert`;
+DROP TRIGGER IF EXISTS `users_fts_delete`;
+DROP TRIGGER IF EXISTS `users_fts_update`;
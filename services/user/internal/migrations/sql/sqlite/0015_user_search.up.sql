@@ -0,0 +1,3 @@
+-- Full-text user search. The FTS5 index and the triggers feeding it are created by the
+-- service at startup (store.PrepareUserSearch), as FTS5 is only compiled into builds
+-- tagged sqlite_fts5; without it search scans the users table.
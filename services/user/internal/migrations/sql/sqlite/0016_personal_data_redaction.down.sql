@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS `idx_webhook_deliveries_user_id`;
+ALTER TABLE `webhook_deliveries` DROP COLUMN `user_id`;
+ALTER TABLE `audit_events` DROP COLUMN `redacted_at`;
+ALTER TABLE `audit_events` DROP COLUMN `personal_digest`;
+ALTER TABLE `audit_events` DROP COLUMN `personal_salt`;
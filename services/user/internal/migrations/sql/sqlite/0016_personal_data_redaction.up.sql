@@ -0,0 +1,8 @@
+-- Erasable personal data: audit events hash a salted digest of their personal data, which
+-- is erased with the salt when the user is anonymized or purged; deliveries record whom
+-- their payload is about.
+ALTER TABLE `audit_events` ADD COLUMN `personal_salt` text;
+ALTER TABLE `audit_events` ADD COLUMN `personal_digest` text;
+ALTER TABLE `audit_events` ADD COLUMN `redacted_at` datetime;
+ALTER TABLE `webhook_deliveries` ADD COLUMN `user_id` integer;
+CREATE INDEX IF NOT EXISTS `idx_webhook_deliveries_user_id` ON `webhook_deliveries`(`user_id`);
@@ -0,0 +1,2 @@
+-- Audit events, webhook payloads and pending email changes may be encrypted at rest.
+-- SQLite columns hold values of any length, so there is nothing to widen.
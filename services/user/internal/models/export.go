@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// Export job states
+const (
+	ExportPending = "pending"
+	ExportRunning = "running"
+	ExportDone    = "done"
+	ExportFailed  = "failed"
+	// ExportExpired jobs had their archive deleted after the download window
+	ExportExpired = "expired"
+)
+
+// ExportJob is a user's request for an archive of everything held about them
+type ExportJob struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	UserID    uint      `gorm:"index" json:"user_id"`
+	Status    string    `gorm:"index;size:16" json:"status"`
+	// Progress is the percentage of the archive built so far
+	Progress int `gorm:"not null;default:0" json:"progress"`
+	// Attempts counts how often a worker picked the job up
+	Attempts int    `gorm:"not null;default:0" json:"-"`
+	Error    string `json:"error,omitempty"`
+	// BlobKey is where the finished archive is stored
+	BlobKey    string     `gorm:"size:255" json:"-"`
+	Size       int64      `gorm:"not null;default:0" json:"size"`
+	FinishedAt *time.Time `json:"finished_at"`
+	// ExpiresAt is when the archive is deleted
+	ExpiresAt *time.Time `json:"expires_at"`
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"net/url"
+	"regexp"
+	"time"
+	_ "time/tzdata" // validate time zones on hosts without a zoneinfo database
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/language"
+)
+
+// Subscription plans
+const (
+	PlanFree     = "free"
+	PlanStandard = "standard"
+	PlanPremium  = "premium"
+)
+
+// DefaultPlan is the plan of newly registered users
+const DefaultPlan = PlanStandard
+
+// Plans lists every valid plan
+var Plans = []string{PlanFree, PlanStandard, PlanPremium}
+
+// Profile is the presentational part of a user, shown by the apps. Empty
+// fields are unset. It is stored in the users table.
+type Profile struct {
+	DisplayName string `gorm:"size:100" json:"display_name"`
+	AvatarURL   string `gorm:"size:2048" json:"avatar_url"`
+	Plan        string `gorm:"size:32" json:"plan"`
+	// Locale is a BCP 47 language tag such as vi or en-US
+	Locale string `gorm:"size:35" json:"locale"`
+	// Timezone is an IANA time zone name such as Asia/Ho_Chi_Minh
+	Timezone string `gorm:"size:64" json:"timezone"`
+	// Phone is in E.164 format, e.g. +84901234567; it is encrypted at rest
+	Phone string `gorm:"serializer:encrypted" json:"phone"`
+	// Birthday is a calendar date formatted as 2006-01-02; it is encrypted at rest
+	Birthday string `gorm:"serializer:encrypted" json:"birthday"`
+}
+
+// FieldError reports the field of a profile or settings document that failed validation
+type FieldError struct {
+	Field  string
+	Reason string
+}
+
+func (e *FieldError) Error() string {
+	return "invalid " + e.Field + ": " + e.Reason
+}
+
+var phonePattern = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// Normalize canonicalizes the locale so equivalent tags compare equal. It
+// leaves invalid values alone for Validate to report.
+func (p *Profile) Normalize() {
+	if tag, err := language.Parse(p.Locale); err == nil && p.Locale != "" {
+		p.Locale = tag.String()
+	}
+}
+
+// Validate checks every field, returning a *FieldError for the first invalid one
+func (p *Profile) Validate() error {
+	if utf8.RuneCountInString(p.DisplayName) > 100 {
+		return &FieldError{"display_name", "longer than 100 characters"}
+	}
+	for _, r := range p.DisplayName {
+		if unicode.IsControl(r) {
+			return &FieldError{"display_name", "contains control characters"}
+		}
+	}
+	if p.AvatarURL != "" {
+		u, err := url.Parse(p.AvatarURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || len(p.AvatarURL) > 2048 {
+			return &FieldError{"avatar_url", "must be an http or https URL"}
+		}
+	}
+	if !validPlan(p.Plan) {
+		return &FieldError{"plan", "unknown plan"}
+	}
+	if p.Locale != "" {
+		if _, err := language.Parse(p.Locale); err != nil || len(p.Locale) > 35 {
+			return &FieldError{"locale", "must be a BCP 47 language tag"}
+		}
+	}
+	if p.Timezone != "" {
+		if _, err := time.LoadLocation(p.Timezone); err != nil || p.Timezone == "Local" {
+			return &FieldError{"timezone", "must be an IANA time zone"}
+		}
+	}
+	if p.Phone != "" && !phonePattern.MatchString(p.Phone) {
+		return &FieldError{"phone", "must be in E.164 format"}
+	}
+	if p.Birthday != "" {
+		d, err := time.Parse(time.DateOnly, p.Birthday)
+		if err != nil {
+			return &FieldError{"birthday", "must be a date like 2006-01-02"}
+		}
+		if d.Year() < 1900 || d.After(time.Now()) {
+			return &FieldError{"birthday", "out of range"}
+		}
+	}
+	return nil
+}
+
+func validPlan(plan string) bool {
+	for _, p := range Plans {
+		if p == plan {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package models
+
+import (
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+// SettingsSchemaVersion is the version of the Settings document layout.
+// Bump it when fields change meaning so older clients can be told apart.
+const SettingsSchemaVersion = 1
+
+// Settings is the typed settings document synced across a user's devices
+type Settings struct {
+	PushEnabled bool `json:"push_enabled"`
+	EmailDigest bool `json:"email_digest"`
+	DarkMode    bool `json:"dark_mode"`
+	// LanguageCode is a BCP 47 language tag
+	LanguageCode string `json:"language_code"`
+}
+
+// DefaultSettings are the settings of users who never saved any
+func DefaultSettings() Settings {
+	return Settings{PushEnabled: true, LanguageCode: "vi"}
+}
+
+// SettingsPatch carries the settings a device changed; nil fields are left alone
+type SettingsPatch struct {
+	PushEnabled  *bool   `json:"push_enabled"`
+	EmailDigest  *bool   `json:"email_digest"`
+	DarkMode     *bool   `json:"dark_mode"`
+	LanguageCode *string `json:"language_code"`
+}
+
+// Validate checks the fields being set and canonicalizes the language tag
+func (p *SettingsPatch) Validate() error {
+	if p.LanguageCode != nil {
+		tag, err := language.Parse(*p.LanguageCode)
+		if err != nil || *p.LanguageCode == "" || len(*p.LanguageCode) > 35 {
+			return &FieldError{"language_code", "must be a BCP 47 language tag"}
+		}
+		code := tag.String()
+		p.LanguageCode = &code
+	}
+	return nil
+}
+
+// UserSettings is a user's settings document with the time each field was
+// last written, so concurrent writes from several devices merge field by
+// field with the last writer winning
+type UserSettings struct {
+	UserID    uint      `gorm:"primaryKey;autoIncrement:false" json:"-"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Version is bumped by every saved change; 0 means nothing was saved yet
+	Version  uint     `json:"version"`
+	Settings Settings `gorm:"serializer:json" json:"settings"`
+	// FieldTimes maps the JSON name of each written field to its write time
+	FieldTimes map[string]time.Time `gorm:"serializer:json" json:"-"`
+}
+
+// Merge applies every field of p whose write time, from times or else now,
+// is not older than the stored one. Write times after now are clamped to now
+// so a device with a fast clock cannot pin a value. It reports whether any
+// field was applied.
+func (us *UserSettings) Merge(p *SettingsPatch, times map[string]time.Time, now time.Time) bool {
+	if us.FieldTimes == nil {
+		us.FieldTimes = map[string]time.Time{}
+	}
+	applied := false
+	write := func(name string, set func()) {
+		at, ok := times[name]
+		if !ok || at.After(now) {
+			at = now
+		}
+		if prev, ok := us.FieldTimes[name]; ok && prev.After(at) {
+			return
+		}
+		us.FieldTimes[name] = at
+		set()
+		applied = true
+	}
+	if p.PushEnabled != nil {
+		write("push_enabled", func() { us.Settings.PushEnabled = *p.PushEnabled })
+	}
+	if p.EmailDigest != nil {
+		write("email_digest", func() { us.Settings.EmailDigest = *p.EmailDigest })
+	}
+	if p.DarkMode != nil {
+		write("dark_mode", func() { us.Settings.DarkMode = *p.DarkMode })
+	}
+	if p.LanguageCode != nil {
+		write("language_code", func() { us.Settings.LanguageCode = *p.LanguageCode })
+	}
+	return applied
+}
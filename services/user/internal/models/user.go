@@ -1,10 +1,19 @@
 package models
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+
+	"services/user/internal/fieldcrypt"
 )
 
 // User is the main user model
@@ -14,9 +23,56 @@ type User struct {
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 
-	Email    string `gorm:"uniqueIndex;size:255" json:"email"`
-	Password string `json:"-"`
-	FullName string `json:"full_name"`
+	// Email and FullName, like the phone and birthday of the profile, are
+	// encrypted at rest when a field encryption keyring is configured
+	Email string `gorm:"serializer:encrypted" json:"email"`
+	// EmailIndex is the blind index of Email, which lookups and the
+	// uniqueness of emails among users that are not soft deleted rely on.
+	// BeforeSave keeps it current.
+	EmailIndex string  `gorm:"size:64;index:idx_users_email_index,unique,where:deleted_at IS NULL" json:"-"`
+	Password   string  `json:"-"`
+	FullName   string  `gorm:"serializer:encrypted" json:"full_name"`
+	Profile    Profile `gorm:"embedded" json:"profile"`
+	// AvatarKey is the blob storage prefix of the uploaded avatar's
+	// thumbnails; when set it takes precedence over Profile.AvatarURL
+	AvatarKey string `gorm:"size:255" json:"-"`
+	// DeletionDueAt is when a deletion the user asked for takes effect;
+	// logging in before then cancels it
+	DeletionDueAt *time.Time `gorm:"index" json:"-"`
+	// AnonymizedAt is when the user's personal data was erased
+	AnonymizedAt *time.Time `json:"-"`
+	// ExternalID is the identity provider's ID of a user provisioned via SCIM
+	ExternalID string `gorm:"size:255;index" json:"-"`
+	// DisabledAt is when the user was deactivated, e.g. through SCIM; disabled
+	// users cannot log in and their tokens are rejected
+	DisabledAt *time.Time `json:"disabled_at,omitempty"`
+	// Version starts at 1 and is bumped by every update, so writers can detect
+	// that the row changed since they read it
+	Version uint `gorm:"not null;default:1" json:"version"`
+	// TokenVersion is carried by the user's tokens and bumped by SetEmail,
+	// revoking every token issued for an earlier email
+	TokenVersion uint `gorm:"not null;default:0" json:"-"`
+}
+
+// SetEmail changes the email, revoking the tokens issued before
+func (u *User) SetEmail(email string) {
+	if email != u.Email {
+		u.Email = email
+		u.TokenVersion++
+	}
+}
+
+// BeforeSave computes the blind index of the email
+func (u *User) BeforeSave(tx *gorm.DB) error {
+	u.EmailIndex = fieldcrypt.BlindIndex(u.Email)
+	return nil
+}
+
+// ValidEmail accepts a bare address like alice@example.com, without a
+// display name or anything that could spill into mail headers
+func ValidEmail(email string) bool {
+	a, err := mail.ParseAddress(email)
+	return err == nil && a.Address == email
 }
 
 func (u *User) SetPassword(raw string) error {
@@ -39,6 +95,8 @@ func (u *User) CheckPassword(raw string) bool {
 type Role struct {
 	ID   uint   `gorm:"primaryKey" json:"id"`
 	Name string `gorm:"uniqueIndex;size:100" json:"name"`
+	// ExternalID is the identity provider's ID of a role managed via SCIM
+	ExternalID string `gorm:"size:255" json:"-"`
 }
 
 // UserRole join table
@@ -47,3 +105,234 @@ type UserRole struct {
 	UserID uint `gorm:"index"`
 	RoleID uint `gorm:"index"`
 }
+
+// Group is a named set of users that can be granted roles in bulk
+type Group struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Name        string    `gorm:"uniqueIndex;size:100" json:"name"`
+	Description string    `json:"description"`
+}
+
+// GroupMember join table
+type GroupMember struct {
+	ID      uint `gorm:"primaryKey" json:"id"`
+	GroupID uint `gorm:"uniqueIndex:idx_group_member"`
+	UserID  uint `gorm:"uniqueIndex:idx_group_member;index"`
+}
+
+// GroupRole join table
+type GroupRole struct {
+	ID      uint `gorm:"primaryKey" json:"id"`
+	GroupID uint `gorm:"uniqueIndex:idx_group_role"`
+	RoleID  uint `gorm:"uniqueIndex:idx_group_role;index"`
+}
+
+// EmailChange is a pending change of a user's email. It is applied once the
+// new address is confirmed with the token sent to it. NewEmail is encrypted
+// at rest like the user's email.
+type EmailChange struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    uint      `gorm:"index" json:"user_id"`
+	NewEmail  string    `gorm:"serializer:encrypted" json:"new_email"`
+	// TokenHash is the hex SHA-256 of the confirmation token, which is only
+	// ever sent to the new address
+	TokenHash string    `gorm:"uniqueIndex;size:64" json:"-"`
+	ExpiresAt time.Time `gorm:"index" json:"expires_at"`
+}
+
+// Audit event results
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+	AuditDenied  = "denied"
+)
+
+// AuditEvent is an append-only record of a security-relevant action. Its
+// personal data, ActorEmail, IP, UserAgent and Diff, is encrypted at rest
+// when a field encryption keyring is configured; the hash covers the
+// plaintext.
+type AuditEvent struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+	// ActorID is the authenticated user, nil for anonymous actions like register or failed login
+	ActorID    *uint  `gorm:"index" json:"actor_id"`
+	ActorEmail string `gorm:"serializer:encrypted" json:"actor_email"`
+	// ImpersonatorID is set when the actor's token was issued through impersonation
+	ImpersonatorID *uint                  `gorm:"index" json:"impersonator_id,omitempty"`
+	Action         string                 `gorm:"index;size:64" json:"action"`
+	TargetType     string                 `gorm:"size:32" json:"target_type"`
+	TargetID       string                 `gorm:"index;size:64" json:"target_id"`
+	IP             string                 `gorm:"serializer:encrypted" json:"ip"`
+	UserAgent      string                 `gorm:"serializer:encrypted" json:"user_agent"`
+	Result         string                 `gorm:"index;size:16" json:"result"`
+	Detail         string                 `json:"detail,omitempty"`
+	Diff           map[string]interface{} `gorm:"serializer:encryptedjson" json:"diff,omitempty"`
+	// PersonalSalt and PersonalDigest stand in for the personal data of the
+	// event (ActorEmail, IP, UserAgent and Diff) in its hash, so that data
+	// can be erased without breaking the chain; see ComputePersonalDigest.
+	// Events recorded before they existed hash the personal data itself.
+	PersonalSalt   string `gorm:"size:64" json:"personal_salt,omitempty"`
+	PersonalDigest string `gorm:"size:64" json:"personal_digest,omitempty"`
+	// RedactedAt is when the personal data and its salt were erased
+	RedactedAt *time.Time `json:"redacted_at,omitempty"`
+	// PrevHash and Hash chain every event to its predecessor, see ComputeHash
+	PrevHash string `gorm:"size:64" json:"prev_hash"`
+	Hash     string `gorm:"index;size:64" json:"hash"`
+}
+
+// ComputePersonalDigest returns the hex HMAC-SHA256, keyed with
+// PersonalSalt, of a canonical encoding of the event's personal data. The
+// salt keeps an erased email or IP from being recovered by guessing.
+func (e *AuditEvent) ComputePersonalDigest() string {
+	content, _ := json.Marshal(struct {
+		ActorEmail string                 `json:"actor_email"`
+		IP         string                 `json:"ip"`
+		UserAgent  string                 `json:"user_agent"`
+		Diff       map[string]interface{} `json:"diff"`
+	}{e.ActorEmail, e.IP, e.UserAgent, e.Diff})
+	mac := hmac.New(sha256.New, []byte(e.PersonalSalt))
+	mac.Write(content)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// HasPersonalData reports whether any of the fields covered by
+// ComputePersonalDigest are set
+func (e *AuditEvent) HasPersonalData() bool {
+	return e.ActorEmail != "" || e.IP != "" || e.UserAgent != "" || e.Diff != nil
+}
+
+// ComputeHash returns the hex SHA-256 of PrevHash followed by a canonical
+// encoding of the event content. The database ID is not part of the hash;
+// ordering is carried by the PrevHash links. When the event has a
+// PersonalDigest it is hashed in place of the personal data.
+func (e *AuditEvent) ComputeHash() string {
+	h := sha256.New()
+	h.Write([]byte(e.PrevHash))
+	if e.PersonalDigest != "" {
+		content, _ := json.Marshal(struct {
+			CreatedAt      string `json:"created_at"`
+			ActorID        *uint  `json:"actor_id"`
+			ImpersonatorID *uint  `json:"impersonator_id"`
+			Action         string `json:"action"`
+			TargetType     string `json:"target_type"`
+			TargetID       string `json:"target_id"`
+			Result         string `json:"result"`
+			Detail         string `json:"detail"`
+			PersonalDigest string `json:"personal_digest"`
+		}{e.CreatedAt.UTC().Format(time.RFC3339Nano), e.ActorID, e.ImpersonatorID, e.Action, e.TargetType, e.TargetID, e.Result, e.Detail, e.PersonalDigest})
+		h.Write(content)
+		return hex.EncodeToString(h.Sum(nil))
+	}
+	content, _ := json.Marshal(struct {
+		CreatedAt      string                 `json:"created_at"`
+		ActorID        *uint                  `json:"actor_id"`
+		ActorEmail     string                 `json:"actor_email"`
+		ImpersonatorID *uint                  `json:"impersonator_id"`
+		Action         string                 `json:"action"`
+		TargetType     string                 `json:"target_type"`
+		TargetID       string                 `json:"target_id"`
+		IP             string                 `json:"ip"`
+		UserAgent      string                 `json:"user_agent"`
+		Result         string                 `json:"result"`
+		Detail         string                 `json:"detail"`
+		Diff           map[string]interface{} `json:"diff"`
+	}{e.CreatedAt.UTC().Format(time.RFC3339Nano), e.ActorID, e.ActorEmail, e.ImpersonatorID, e.Action, e.TargetType, e.TargetID, e.IP, e.UserAgent, e.Result, e.Detail, e.Diff})
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ErrAuditImmutable is returned when something tries to modify a stored audit event
+var ErrAuditImmutable = errors.New("audit events are append-only")
+
+func (e *AuditEvent) BeforeUpdate(tx *gorm.DB) error {
+	return ErrAuditImmutable
+}
+
+func (e *AuditEvent) BeforeDelete(tx *gorm.DB) error {
+	return ErrAuditImmutable
+}
+
+// AuditCheckpoint is a signed statement of the audit chain head at a point in time
+type AuditCheckpoint struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	EventID   uint      `gorm:"index" json:"event_id"`
+	Hash      string    `gorm:"size:64" json:"hash"`
+	Signature string    `gorm:"size:64" json:"signature"`
+}
+
+// Sign returns the hex HMAC-SHA256 of the checkpoint under key
+func (c *AuditCheckpoint) Sign(key []byte) string {
+	m := hmac.New(sha256.New, key)
+	fmt.Fprintf(m, "%d:%s:%d", c.EventID, c.Hash, c.CreatedAt.Unix())
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// Webhook lifecycle events
+const (
+	EventUserCreated      = "user.created"
+	EventUserUpdated      = "user.updated"
+	EventUserRolesChanged = "user.roles_changed"
+	EventUserDeleted      = "user.deleted"
+	EventUserRestored     = "user.restored"
+	EventUserPurged       = "user.purged"
+)
+
+// Webhook delivery states
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// Webhook is a subscription that receives signed event payloads
+type Webhook struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	URL         string    `json:"url"`
+	Description string    `json:"description"`
+	// Secret keys the HMAC signature of every payload
+	Secret string `json:"-"`
+	// Events the webhook subscribes to; empty means all events
+	Events []string `gorm:"serializer:json" json:"events"`
+	Active bool     `json:"active"`
+}
+
+// Subscribed reports whether the webhook wants event
+func (wh *Webhook) Subscribed(event string) bool {
+	if len(wh.Events) == 0 {
+		return true
+	}
+	for _, e := range wh.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is one queued or attempted delivery of an event to a
+// webhook. UserID is the user the payload is about; their personal data is
+// erased from it when they are anonymized or purged. The payload is
+// encrypted at rest when a field encryption keyring is configured.
+type WebhookDelivery struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	WebhookID      uint       `gorm:"index" json:"webhook_id"`
+	EventID        string     `gorm:"size:32" json:"event_id"`
+	Event          string     `gorm:"size:64" json:"event"`
+	UserID         uint       `gorm:"index" json:"user_id"`
+	Payload        string     `gorm:"serializer:encrypted" json:"payload"`
+	Status         string     `gorm:"index:idx_delivery_due;size:16" json:"status"`
+	NextAttemptAt  time.Time  `gorm:"index:idx_delivery_due" json:"next_attempt_at"`
+	Attempts       int        `json:"attempts"`
+	LastAttemptAt  *time.Time `json:"last_attempt_at"`
+	ResponseStatus int        `json:"response_status"`
+	ResponseBody   string     `json:"response_body"`
+	LastError      string     `json:"last_error"`
+}
@@ -0,0 +1,245 @@
+// Package patch applies JSON Merge Patch (RFC 7396) and JSON Patch
+// (RFC 6902) documents to JSON values decoded into interface{}.
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MergePatchType is the media type of RFC 7396 merge patches
+	MergePatchType = "application/merge-patch+json"
+	// JSONPatchType is the media type of RFC 6902 JSON patches
+	JSONPatchType = "application/json-patch+json"
+)
+
+// ErrTestFailed is returned when a JSON Patch "test" operation does not match
+var ErrTestFailed = errors.New("patch test failed")
+
+// Merge applies an RFC 7396 merge patch to doc in place: null members are
+// removed, objects are merged recursively and every other value replaces the
+// member of the same name.
+func Merge(doc, patch map[string]interface{}) {
+	for k, v := range patch {
+		switch pv := v.(type) {
+		case nil:
+			delete(doc, k)
+		case map[string]interface{}:
+			dv, ok := doc[k].(map[string]interface{})
+			if !ok {
+				dv = map[string]interface{}{}
+			}
+			Merge(dv, pv)
+			doc[k] = dv
+		default:
+			doc[k] = v
+		}
+	}
+}
+
+// Operation is one step of an RFC 6902 JSON patch
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+	// From is the source location of move and copy
+	From string `json:"from"`
+}
+
+// Apply runs the operations of a JSON patch against doc in order, stopping at
+// the first that fails. Operations before the failing one stay applied, so
+// callers patch a copy when the patch must apply as a whole.
+func Apply(doc map[string]interface{}, ops []Operation) error {
+	for i, op := range ops {
+		if err := apply(doc, op); err != nil {
+			return fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func apply(doc map[string]interface{}, op Operation) error {
+	tokens, err := parsePointer(op.Path)
+	if err != nil {
+		return err
+	}
+	if len(tokens) == 0 {
+		return errors.New("cannot patch the whole document")
+	}
+	var val interface{}
+	switch op.Op {
+	case "add", "replace", "test":
+		if len(op.Value) == 0 {
+			return fmt.Errorf("%s requires a value", op.Op)
+		}
+		if err := json.Unmarshal(op.Value, &val); err != nil {
+			return err
+		}
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return err
+		}
+		if len(from) == 0 {
+			return fmt.Errorf("%s requires a from path", op.Op)
+		}
+		v, ok := lookup(doc, from)
+		if !ok {
+			return fmt.Errorf("from %s not found", op.From)
+		}
+		if op.Op == "copy" {
+			_, err := patchValue(doc, tokens, "add", clone(v))
+			return err
+		}
+		if len(from) < len(tokens) && slices.Equal(from, tokens[:len(from)]) {
+			return fmt.Errorf("cannot move %s into its own child %s", op.From, op.Path)
+		}
+		if _, err := patchValue(doc, from, "remove", nil); err != nil {
+			return err
+		}
+		_, err = patchValue(doc, tokens, "add", v)
+		return err
+	case "remove":
+	default:
+		return fmt.Errorf("unsupported op %q", op.Op)
+	}
+	if op.Op == "test" {
+		cur, ok := lookup(doc, tokens)
+		if !ok || !reflect.DeepEqual(cur, val) {
+			return fmt.Errorf("%w at %s", ErrTestFailed, op.Path)
+		}
+		return nil
+	}
+	_, err = patchValue(doc, tokens, op.Op, val)
+	return err
+}
+
+// clone deep copies a decoded JSON value, so a copy can be patched apart
+// from its source
+func clone(v interface{}) interface{} {
+	switch c := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(c))
+		for k, e := range c {
+			m[k] = clone(e)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(c))
+		for i, e := range c {
+			a[i] = clone(e)
+		}
+		return a
+	}
+	return v
+}
+
+// parsePointer splits an RFC 6901 JSON pointer into unescaped reference tokens
+func parsePointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(p, "/") {
+		return nil, fmt.Errorf("invalid path %q", p)
+	}
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// index resolves an array reference token; "-" names the end of the array
+// and is only valid when appending
+func index(token string, n int, appending bool) (int, error) {
+	if token == "-" && appending {
+		return n, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	if i > n || i == n && !appending {
+		return 0, fmt.Errorf("array index %d out of range", i)
+	}
+	return i, nil
+}
+
+func lookup(v interface{}, tokens []string) (interface{}, bool) {
+	for _, t := range tokens {
+		switch c := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = c[t]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := index(t, len(c), false)
+			if err != nil {
+				return nil, false
+			}
+			v = c[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// patchValue returns v with op applied at the location tokens point to below
+// it. Arrays may be reallocated, so callers store the result back.
+func patchValue(v interface{}, tokens []string, op string, val interface{}) (interface{}, error) {
+	t, rest := tokens[0], tokens[1:]
+	switch c := v.(type) {
+	case map[string]interface{}:
+		cur, exists := c[t]
+		if len(rest) > 0 {
+			if !exists {
+				return nil, fmt.Errorf("member %q not found", t)
+			}
+			nv, err := patchValue(cur, rest, op, val)
+			if err != nil {
+				return nil, err
+			}
+			c[t] = nv
+			return c, nil
+		}
+		if !exists && op != "add" {
+			return nil, fmt.Errorf("member %q not found", t)
+		}
+		if op == "remove" {
+			delete(c, t)
+		} else {
+			c[t] = val
+		}
+		return c, nil
+	case []interface{}:
+		i, err := index(t, len(c), op == "add" && len(rest) == 0)
+		if err != nil {
+			return nil, err
+		}
+		if len(rest) > 0 {
+			nv, err := patchValue(c[i], rest, op, val)
+			if err != nil {
+				return nil, err
+			}
+			c[i] = nv
+			return c, nil
+		}
+		switch op {
+		case "add":
+			return append(c[:i], append([]interface{}{val}, c[i:]...)...), nil
+		case "remove":
+			return append(c[:i], c[i+1:]...), nil
+		}
+		c[i] = val
+		return c, nil
+	}
+	return nil, fmt.Errorf("cannot reference %q in a scalar", t)
+}
@@ -0,0 +1,166 @@
+package patch_test
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"services/user/internal/patch"
+)
+
+func decode(t *testing.T, s string, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal([]byte(s), v); err != nil {
+		t.Fatalf("decode %s: %v", s, err)
+	}
+}
+
+// TestMerge runs the examples of RFC 7396 appendix A whose target and patch
+// are objects, which is all Merge takes
+func TestMerge(t *testing.T) {
+	tests := []struct{ doc, patch, want string }{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+		// removing a member that is not there
+		{`{"a":"b"}`, `{"c":null}`, `{"a":"b"}`},
+	}
+	for _, tc := range tests {
+		var doc, p, want map[string]interface{}
+		decode(t, tc.doc, &doc)
+		decode(t, tc.patch, &p)
+		decode(t, tc.want, &want)
+		patch.Merge(doc, p)
+		if !reflect.DeepEqual(doc, want) {
+			got, _ := json.Marshal(doc)
+			t.Errorf("Merge(%s, %s) = %s; want %s", tc.doc, tc.patch, got, tc.want)
+		}
+	}
+}
+
+// TestApply runs the examples of RFC 6902 appendix A, but A.13, which is
+// about duplicate members that encoding/json cannot see, and more
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name, doc, ops string
+		// want is the patched document, or empty when the patch fails
+		want string
+		// testFailed is set when the patch fails on a test operation
+		testFailed bool
+	}{
+		{name: "A.1 add an object member", doc: `{"foo":"bar"}`,
+			ops:  `[{"op":"add","path":"/baz","value":"qux"}]`,
+			want: `{"baz":"qux","foo":"bar"}`},
+		{name: "A.2 add an array element", doc: `{"foo":["bar","baz"]}`,
+			ops:  `[{"op":"add","path":"/foo/1","value":"qux"}]`,
+			want: `{"foo":["bar","qux","baz"]}`},
+		{name: "A.3 remove an object member", doc: `{"baz":"qux","foo":"bar"}`,
+			ops:  `[{"op":"remove","path":"/baz"}]`,
+			want: `{"foo":"bar"}`},
+		{name: "A.4 remove an array element", doc: `{"foo":["bar","qux","baz"]}`,
+			ops:  `[{"op":"remove","path":"/foo/1"}]`,
+			want: `{"foo":["bar","baz"]}`},
+		{name: "A.5 replace a value", doc: `{"baz":"qux","foo":"bar"}`,
+			ops:  `[{"op":"replace","path":"/baz","value":"boo"}]`,
+			want: `{"baz":"boo","foo":"bar"}`},
+		{name: "A.6 move a value", doc: `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			ops:  `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			want: `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{name: "A.7 move an array element", doc: `{"foo":["all","grass","cows","eat"]}`,
+			ops:  `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`,
+			want: `{"foo":["all","cows","eat","grass"]}`},
+		{name: "A.8 test a value", doc: `{"baz":"qux","foo":["a",2,"c"]}`,
+			ops:  `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			want: `{"baz":"qux","foo":["a",2,"c"]}`},
+		{name: "A.9 test a value, error", doc: `{"baz":"qux"}`,
+			ops:        `[{"op":"test","path":"/baz","value":"bar"}]`,
+			testFailed: true},
+		{name: "A.10 add a nested member object", doc: `{"foo":"bar"}`,
+			ops:  `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`,
+			want: `{"foo":"bar","child":{"grandchild":{}}}`},
+		{name: "A.11 ignore unrecognized elements", doc: `{"foo":"bar"}`,
+			ops:  `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`,
+			want: `{"foo":"bar","baz":"qux"}`},
+		{name: "A.12 add to a nonexistent target", doc: `{"foo":"bar"}`,
+			ops: `[{"op":"add","path":"/baz/bat","value":"qux"}]`},
+		{name: "A.14 ~ escape ordering", doc: `{"/":9,"~1":10}`,
+			ops:  `[{"op":"test","path":"/~01","value":10}]`,
+			want: `{"/":9,"~1":10}`},
+		{name: "A.15 compare strings and numbers", doc: `{"/":9,"~1":10}`,
+			ops:        `[{"op":"test","path":"/~01","value":"10"}]`,
+			testFailed: true},
+		{name: "A.16 add an array value", doc: `{"foo":["bar"]}`,
+			ops:  `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`,
+			want: `{"foo":["bar",["abc","def"]]}`},
+		{name: "~1 escapes a slash", doc: `{"a/b":1}`,
+			ops:  `[{"op":"replace","path":"/a~1b","value":2},{"op":"add","path":"/~0","value":3}]`,
+			want: `{"a/b":2,"~":3}`},
+		{name: "- only appends", doc: `{"foo":["bar"]}`,
+			ops: `[{"op":"replace","path":"/foo/-","value":"baz"}]`},
+		{name: "- is not an index to test", doc: `{"foo":["bar"]}`,
+			ops:        `[{"op":"test","path":"/foo/-","value":"bar"}]`,
+			testFailed: true},
+		{name: "index past the end", doc: `{"foo":["bar"]}`,
+			ops: `[{"op":"add","path":"/foo/2","value":"baz"}]`},
+		{name: "index with a leading zero", doc: `{"foo":["bar","baz"]}`,
+			ops: `[{"op":"remove","path":"/foo/01"}]`},
+		{name: "move into its own child", doc: `{"a":{"b":{}}}`,
+			ops: `[{"op":"move","from":"/a","path":"/a/b/c"}]`},
+		{name: "move to a sibling with a common prefix", doc: `{"a":1}`,
+			ops:  `[{"op":"move","from":"/a","path":"/ab"}]`,
+			want: `{"ab":1}`},
+		{name: "move onto itself", doc: `{"a":{"b":1}}`,
+			ops:  `[{"op":"move","from":"/a","path":"/a"}]`,
+			want: `{"a":{"b":1}}`},
+		{name: "move from a missing location", doc: `{"a":1}`,
+			ops: `[{"op":"move","from":"/b","path":"/c"}]`},
+		{name: "copy is independent of its source", doc: `{"a":{"b":[1]}}`,
+			ops:  `[{"op":"copy","from":"/a","path":"/c"},{"op":"add","path":"/c/b/-","value":2}]`,
+			want: `{"a":{"b":[1]},"c":{"b":[1,2]}}`},
+		{name: "replace a missing member", doc: `{"a":1}`,
+			ops: `[{"op":"replace","path":"/b","value":2}]`},
+		{name: "patch the whole document", doc: `{"a":1}`,
+			ops: `[{"op":"replace","path":"","value":{}}]`},
+		{name: "unknown op", doc: `{"a":1}`,
+			ops: `[{"op":"increment","path":"/a"}]`},
+		{name: "later operations see earlier ones", doc: `{"a":1}`,
+			ops:  `[{"op":"add","path":"/b","value":[]},{"op":"add","path":"/b/-","value":"x"},{"op":"test","path":"/b/0","value":"x"}]`,
+			want: `{"a":1,"b":["x"]}`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var doc map[string]interface{}
+			var ops []patch.Operation
+			decode(t, tc.doc, &doc)
+			decode(t, tc.ops, &ops)
+			err := patch.Apply(doc, ops)
+			if errors.Is(err, patch.ErrTestFailed) != tc.testFailed {
+				t.Fatalf("Apply error = %v; want ErrTestFailed: %v", err, tc.testFailed)
+			}
+			if tc.want == "" {
+				if err == nil {
+					got, _ := json.Marshal(doc)
+					t.Fatalf("Apply succeeded with %s; want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+			var want map[string]interface{}
+			decode(t, tc.want, &want)
+			if !reflect.DeepEqual(doc, want) {
+				got, _ := json.Marshal(doc)
+				t.Errorf("patched = %s; want %s", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,418 @@
+package scim
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Filter is a parsed filter expression, see RFC 7644 section 3.4.2.2
+type Filter interface {
+	// Match reports whether a resource, as a generic JSON object, matches
+	Match(res map[string]interface{}) bool
+}
+
+// caseExact lists the attributes compared case-sensitively; all others are
+// compared ignoring case. Emails, and userName with them, are unique as
+// written in this service, so they are case-exact too.
+var caseExact = map[string]bool{"id": true, "externalid": true, "username": true, "emails.value": true, "meta.version": true, "password": true}
+
+type logical struct {
+	and         bool
+	left, right Filter
+}
+
+func (f *logical) Match(res map[string]interface{}) bool {
+	if f.and {
+		return f.left.Match(res) && f.right.Match(res)
+	}
+	return f.left.Match(res) || f.right.Match(res)
+}
+
+type not struct{ f Filter }
+
+func (f *not) Match(res map[string]interface{}) bool { return !f.f.Match(res) }
+
+// compare is attrPath op value, or attrPath pr
+type compare struct {
+	path  string
+	op    string
+	value interface{}
+}
+
+func (f *compare) Match(res map[string]interface{}) bool {
+	vals := resolve(res, f.path)
+	if f.op == "pr" {
+		for _, v := range vals {
+			if present(v) {
+				return true
+			}
+		}
+		return false
+	}
+	if f.op == "ne" {
+		return !(&compare{path: f.path, op: "eq", value: f.value}).Match(res)
+	}
+	exact := caseExact[f.path]
+	for _, v := range vals {
+		if compareValue(v, f.op, f.value, exact) {
+			return true
+		}
+	}
+	return false
+}
+
+// valuePath is attr[filter]: some element of the multi-valued attr matches
+type valuePath struct {
+	attr   string
+	filter Filter
+}
+
+func (f *valuePath) Match(res map[string]interface{}) bool {
+	return len(matchElements(res, f.attr, f.filter)) > 0
+}
+
+// matchElements returns the indexes of the elements of the multi-valued
+// attribute attr of res that match f
+func matchElements(res map[string]interface{}, attr string, f Filter) []int {
+	v, _ := lookup(res, attr)
+	list, _ := v.([]interface{})
+	var idx []int
+	for i, el := range list {
+		obj, ok := el.(map[string]interface{})
+		if !ok {
+			obj = map[string]interface{}{"value": el}
+		}
+		if f.Match(obj) {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+// resolve returns the values at a dotted path, flattening multi-valued
+// attributes; a complex value of a multi-valued attribute stands for its
+// "value" sub-attribute
+func resolve(res map[string]interface{}, path string) []interface{} {
+	cur := []interface{}{res}
+	for _, part := range strings.Split(path, ".") {
+		var next []interface{}
+		for _, c := range cur {
+			obj, ok := c.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			v, k := lookup(obj, part)
+			if k == "" {
+				continue
+			}
+			if list, ok := v.([]interface{}); ok {
+				next = append(next, list...)
+			} else {
+				next = append(next, v)
+			}
+		}
+		cur = next
+	}
+	for i, c := range cur {
+		if obj, ok := c.(map[string]interface{}); ok {
+			cur[i], _ = lookup(obj, "value")
+		}
+	}
+	return cur
+}
+
+func present(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case string:
+		return v != ""
+	case []interface{}:
+		return len(v) > 0
+	case map[string]interface{}:
+		return len(v) > 0
+	}
+	return true
+}
+
+func compareValue(v interface{}, op string, want interface{}, exact bool) bool {
+	switch want := want.(type) {
+	case nil:
+		return op == "eq" && v == nil
+	case bool:
+		got, ok := v.(bool)
+		return ok && op == "eq" && got == want
+	case float64:
+		got, ok := v.(float64)
+		if !ok {
+			return false
+		}
+		return ordered(op, cmpFloat(got, want))
+	case string:
+		got, ok := v.(string)
+		if !ok {
+			return false
+		}
+		// dates order as instants, before lowercasing spoils their T and Z
+		if op != "eq" && op != "co" && op != "sw" && op != "ew" {
+			if gt, err := time.Parse(time.RFC3339Nano, got); err == nil {
+				if wt, err := time.Parse(time.RFC3339Nano, want); err == nil {
+					return ordered(op, gt.Compare(wt))
+				}
+			}
+		}
+		if !exact {
+			got, want = strings.ToLower(got), strings.ToLower(want)
+		}
+		switch op {
+		case "eq":
+			return got == want
+		case "co":
+			return strings.Contains(got, want)
+		case "sw":
+			return strings.HasPrefix(got, want)
+		case "ew":
+			return strings.HasSuffix(got, want)
+		}
+		return ordered(op, strings.Compare(got, want))
+	}
+	return false
+}
+
+func cmpFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func ordered(op string, c int) bool {
+	switch op {
+	case "eq":
+		return c == 0
+	case "gt":
+		return c > 0
+	case "ge":
+		return c >= 0
+	case "lt":
+		return c < 0
+	case "le":
+		return c <= 0
+	}
+	return false
+}
+
+// EqualValue returns the value v when f requires attr eq v, on its own or
+// as part of a conjunction, so callers can narrow the candidates before
+// matching
+func EqualValue(f Filter, attr string) (string, bool) {
+	switch f := f.(type) {
+	case *compare:
+		if s, ok := f.value.(string); ok && f.op == "eq" && f.path == normalizeAttr(attr) {
+			return s, true
+		}
+	case *logical:
+		if f.and {
+			if v, ok := EqualValue(f.left, attr); ok {
+				return v, true
+			}
+			return EqualValue(f.right, attr)
+		}
+	}
+	return "", false
+}
+
+// ParseFilter parses a filter expression
+func ParseFilter(s string) (Filter, error) {
+	toks, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	f, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, Errorf(ErrInvalidFilter, "unexpected %q", p.toks[p.pos].text)
+	}
+	return f, nil
+}
+
+type token struct {
+	text   string
+	quoted bool
+}
+
+func tokenize(s string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')' || c == '[' || c == ']':
+			toks = append(toks, token{text: string(c)})
+			i++
+		case c == '"':
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return nil, Errorf(ErrInvalidFilter, "unterminated string")
+			}
+			var str string
+			if err := json.Unmarshal([]byte(s[i:j+1]), &str); err != nil {
+				return nil, Errorf(ErrInvalidFilter, "invalid string %s", s[i:j+1])
+			}
+			toks = append(toks, token{text: str, quoted: true})
+			i = j + 1
+		default:
+			j := i
+			for j < len(s) && !strings.ContainsRune(" \t\n\r()[]\"", rune(s[j])) {
+				j++
+			}
+			toks = append(toks, token{text: s[i:j]})
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func (p *parser) peek() (token, bool) {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos], true
+	}
+	return token{}, false
+}
+
+// keyword consumes the next token if it is the unquoted word kw
+func (p *parser) keyword(kw string) bool {
+	if t, ok := p.peek(); ok && !t.quoted && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) or() (Filter, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) and() (Filter, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) unary() (Filter, error) {
+	if p.keyword("not") {
+		if !p.keyword("(") {
+			return nil, Errorf(ErrInvalidFilter, "not must be followed by (")
+		}
+		return p.group(func(f Filter) Filter { return &not{f} })
+	}
+	if p.keyword("(") {
+		return p.group(func(f Filter) Filter { return f })
+	}
+	return p.attrExp()
+}
+
+// group parses the rest of a parenthesized filter
+func (p *parser) group(wrap func(Filter) Filter) (Filter, error) {
+	f, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if !p.keyword(")") {
+		return nil, Errorf(ErrInvalidFilter, "missing )")
+	}
+	return wrap(f), nil
+}
+
+var compareOps = map[string]bool{"eq": true, "ne": true, "co": true, "sw": true, "ew": true, "gt": true, "ge": true, "lt": true, "le": true}
+
+func (p *parser) attrExp() (Filter, error) {
+	t, ok := p.peek()
+	if !ok || t.quoted || strings.ContainsAny(t.text, "()[]") {
+		return nil, Errorf(ErrInvalidFilter, "attribute path expected")
+	}
+	p.pos++
+	path := normalizeAttr(t.text)
+	if p.keyword("[") {
+		f, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.keyword("]") {
+			return nil, Errorf(ErrInvalidFilter, "missing ]")
+		}
+		return &valuePath{attr: path, filter: f}, nil
+	}
+	opTok, ok := p.peek()
+	if !ok || opTok.quoted {
+		return nil, Errorf(ErrInvalidFilter, "operator expected after %s", t.text)
+	}
+	op := strings.ToLower(opTok.text)
+	p.pos++
+	if op == "pr" {
+		return &compare{path: path, op: op}, nil
+	}
+	if !compareOps[op] {
+		return nil, Errorf(ErrInvalidFilter, "unknown operator %q", opTok.text)
+	}
+	vt, ok := p.peek()
+	if !ok {
+		return nil, Errorf(ErrInvalidFilter, "value expected after %s", opTok.text)
+	}
+	p.pos++
+	var value interface{} = vt.text
+	if !vt.quoted {
+		if err := json.Unmarshal([]byte(strings.ToLower(vt.text)), &value); err != nil {
+			return nil, Errorf(ErrInvalidFilter, "invalid value %q", vt.text)
+		}
+		if _, isStr := value.(string); isStr {
+			return nil, Errorf(ErrInvalidFilter, "invalid value %q", vt.text)
+		}
+	}
+	if _, isStr := value.(string); !isStr && op != "eq" && op != "ne" && !isNumber(value) {
+		return nil, Errorf(ErrInvalidFilter, "%s needs a string or number", op)
+	}
+	return &compare{path: path, op: op, value: value}, nil
+}
+
+func isNumber(v interface{}) bool {
+	_, ok := v.(float64)
+	return ok
+}
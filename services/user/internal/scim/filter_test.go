@@ -0,0 +1,166 @@
+package scim_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"services/user/internal/scim"
+)
+
+// bjensen is the user of the examples of RFC 7643 section 8, trimmed, with
+// a few attributes to compare against
+const bjensen = `{
+	"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+	"id": "2819c223-7f76-453a-919d-413861904646",
+	"userName": "bjensen",
+	"name": {"familyName": "Jensen", "givenName": "Barbara"},
+	"displayName": "Babs \"BJ\" Jensen",
+	"nickName": "Bébé",
+	"title": "Tour Guide",
+	"userType": "Employee",
+	"preferredLanguage": "",
+	"active": true,
+	"loginCount": 3,
+	"emails": [
+		{"value": "bjensen@example.com", "type": "work", "primary": true},
+		{"value": "babs@jensen.org", "type": "home"}
+	],
+	"meta": {"lastModified": "2011-05-13T04:42:34Z"}
+}`
+
+func decode(t *testing.T, s string) map[string]interface{} {
+	t.Helper()
+	var v map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("decode %s: %v", s, err)
+	}
+	return v
+}
+
+func TestFilterMatch(t *testing.T) {
+	res := decode(t, bjensen)
+	for _, tc := range []struct {
+		filter string
+		want   bool
+	}{
+		{`userName eq "bjensen"`, true},
+		{`USERNAME Eq "bjensen"`, true},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName eq "bjensen"`, true},
+		// userName is case-exact, title is not
+		{`userName eq "BJensen"`, false},
+		{`title eq "tour guide"`, true},
+		{`title ne "Tour Guide"`, false},
+		{`nickName ne "Babs"`, true},
+		{`name.familyName co "ens"`, true},
+		{`userName sw "bj"`, true},
+		{`emails.value ew "jensen.org"`, true},
+		{`emails.type eq "other"`, false},
+		{`active eq true`, true},
+		{`active eq false`, false},
+		{`loginCount ge 3`, true},
+		{`loginCount gt 3`, false},
+		{`loginCount lt 3.5`, true},
+		// dates compare as instants
+		{`meta.lastModified gt "2011-05-13T04:42:34.000Z"`, false},
+		{`meta.lastModified ge "2011-05-13T04:42:34.000Z"`, true},
+		{`meta.lastModified lt "2011-05-13T06:00:00+02:00"`, false},
+		{`meta.lastModified lt "2011-05-13T07:00:00+02:00"`, true},
+
+		{`title pr`, true},
+		{`emails pr`, true},
+		{`nickname pr`, true},
+		{`preferredLanguage pr`, false},
+		{`locale pr`, false},
+		{`name.middleName pr`, false},
+
+		// and binds tighter than or
+		{`userName eq "nobody" and title pr or active eq true`, true},
+		{`active eq true or userName eq "nobody" and title eq "none"`, true},
+		{`(active eq true or userName eq "nobody") and title eq "none"`, false},
+		{`userName eq "nobody" and (title pr or active eq true)`, false},
+		{`((userName eq "bjensen"))`, true},
+
+		{`not (title pr)`, false},
+		{`not (userType eq "Contractor") and active eq true`, true},
+		{`not (active eq true or title pr)`, false},
+		{`not (active eq false) or userName eq "nobody"`, true},
+		{`not (not (active eq true))`, true},
+
+		{`emails[type eq "work" and value co "@example.com"]`, true},
+		{`emails[type eq "home" and primary eq true]`, false},
+		{`emails[not (type eq "work")]`, true},
+		{`emails[type eq "work"] and not (emails[type eq "other"])`, true},
+
+		// quoted strings are JSON strings, and never keywords
+		{`displayName eq "Babs \"BJ\" Jensen"`, true},
+		{`nickName eq "Bébé"`, true},
+		{`title co "\\"`, false},
+		{`title eq "and" or title eq "or"`, false},
+		{`userName eq "a) or (b[c]"`, false},
+	} {
+		f, err := scim.ParseFilter(tc.filter)
+		if err != nil {
+			t.Errorf("ParseFilter(%q): %v", tc.filter, err)
+			continue
+		}
+		if got := f.Match(res); got != tc.want {
+			t.Errorf("ParseFilter(%q).Match = %v; want %v", tc.filter, got, tc.want)
+		}
+	}
+}
+
+func TestParseFilterInvalid(t *testing.T) {
+	for _, filter := range []string{
+		``,
+		`userName`,
+		`userName eq`,
+		`userName zz "bjensen"`,
+		`userName eq bjensen`,
+		`userName eq "bjensen`,
+		`userName eq "\q"`,
+		`"userName" eq "bjensen"`,
+		`userName co true`,
+		`userName gt null`,
+		`(userName eq "bjensen"`,
+		`userName eq "bjensen")`,
+		`userName eq "bjensen" and`,
+		`userName eq "bjensen" userName eq "bjensen"`,
+		`not userName eq "bjensen"`,
+		`not (userName eq "bjensen"`,
+		`emails[type eq "work"`,
+		`emails[]`,
+		`and`,
+	} {
+		f, err := scim.ParseFilter(filter)
+		var serr *scim.Error
+		if !errors.As(err, &serr) || serr.ScimType != scim.ErrInvalidFilter || serr.Status != http.StatusBadRequest {
+			t.Errorf("ParseFilter(%q) = %v, %v; want an invalidFilter error", filter, f, err)
+		}
+	}
+}
+
+func TestEqualValue(t *testing.T) {
+	for _, tc := range []struct {
+		filter string
+		want   string
+		ok     bool
+	}{
+		{`userName eq "bjensen"`, "bjensen", true},
+		{`UserName eq "bjensen" and active eq true`, "bjensen", true},
+		{`active eq true and (title pr and userName eq "bjensen")`, "bjensen", true},
+		{`userName eq "bjensen" or active eq true`, "", false},
+		{`not (userName eq "bjensen")`, "", false},
+		{`userName ne "bjensen"`, "", false},
+		{`userName sw "bjensen"`, "", false},
+	} {
+		f, err := scim.ParseFilter(tc.filter)
+		if err != nil {
+			t.Fatalf("ParseFilter(%q): %v", tc.filter, err)
+		}
+		if got, ok := scim.EqualValue(f, "userName"); got != tc.want || ok != tc.ok {
+			t.Errorf("EqualValue(%q) = %q, %v; want %q, %v", tc.filter, got, ok, tc.want, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package scim
+
+import (
+	"reflect"
+	"strings"
+)
+
+// PatchOp is a PATCH request, see RFC 7644 section 3.5.2
+type PatchOp struct {
+	Schemas    []string    `json:"schemas"`
+	Operations []Operation `json:"Operations"`
+}
+
+// Operation is one add, replace or remove of a PatchOp
+type Operation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Path is the target of an operation: an attribute, optionally narrowed to
+// the elements matching a filter, optionally followed by a sub-attribute, as
+// in emails[type eq "work"].value
+type Path struct {
+	Attr   string
+	Filter Filter
+	Sub    string
+}
+
+// ParsePath parses the path of an operation
+func ParsePath(s string) (*Path, error) {
+	s = strings.TrimSpace(s)
+	p := &Path{}
+	if i := strings.IndexByte(s, '['); i >= 0 {
+		j := strings.LastIndexByte(s, ']')
+		if j < i {
+			return nil, Errorf(ErrInvalidPath, "missing ] in %q", s)
+		}
+		f, err := ParseFilter(s[i+1 : j])
+		if err != nil {
+			return nil, Errorf(ErrInvalidPath, "%s", err.(*Error).Detail)
+		}
+		p.Filter = f
+		rest := s[j+1:]
+		if rest != "" {
+			if !strings.HasPrefix(rest, ".") || len(rest) == 1 {
+				return nil, Errorf(ErrInvalidPath, "invalid path %q", s)
+			}
+			p.Sub = strings.ToLower(rest[1:])
+		}
+		s = s[:i]
+	}
+	attr := normalizeAttr(s)
+	if p.Filter == nil {
+		attr, p.Sub, _ = strings.Cut(attr, ".")
+	}
+	if attr == "" || strings.ContainsAny(attr, " .") || strings.Contains(p.Sub, ".") {
+		return nil, Errorf(ErrInvalidPath, "invalid path %q", s)
+	}
+	p.Attr = attr
+	return p, nil
+}
+
+// Apply runs the operations in order on res, a resource as a generic JSON
+// object. Attributes the resource does not support are set like any other;
+// callers map the result back and ignore what they do not know.
+func (p *PatchOp) Apply(res map[string]interface{}) error {
+	ok := false
+	for _, s := range p.Schemas {
+		ok = ok || s == SchemaPatchOp
+	}
+	if !ok {
+		return Errorf(ErrInvalidSyntax, "schemas must be [%q]", SchemaPatchOp)
+	}
+	if len(p.Operations) == 0 {
+		return Errorf(ErrInvalidSyntax, "no Operations")
+	}
+	for _, op := range p.Operations {
+		if err := applyOp(res, strings.ToLower(op.Op), op.Path, op.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyOp(res map[string]interface{}, op, rawPath string, value interface{}) error {
+	switch op {
+	case "add", "replace", "remove":
+	default:
+		return Errorf(ErrInvalidSyntax, "unknown op %q", op)
+	}
+	if rawPath == "" {
+		if op == "remove" {
+			return Errorf(ErrNoTarget, "remove needs a path")
+		}
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return Errorf(ErrInvalidValue, "%s without a path needs an object value", op)
+		}
+		for k, v := range obj {
+			if err := applyOp(res, op, k, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	path, err := ParsePath(rawPath)
+	if err != nil {
+		return err
+	}
+	if op != "remove" && value == nil {
+		return Errorf(ErrInvalidValue, "%s %s needs a value", op, rawPath)
+	}
+	cur, key := lookup(res, path.Attr)
+	if key == "" {
+		key = path.Attr
+	}
+	if path.Filter != nil {
+		return applyFiltered(res, key, path, op, value)
+	}
+	if path.Sub != "" {
+		obj, ok := cur.(map[string]interface{})
+		if cur != nil && !ok {
+			return Errorf(ErrInvalidPath, "%s has no sub-attributes", path.Attr)
+		}
+		if obj == nil {
+			if op == "remove" {
+				return nil
+			}
+			obj = map[string]interface{}{}
+			res[key] = obj
+		}
+		_, sk := lookup(obj, path.Sub)
+		if sk == "" {
+			sk = path.Sub
+		}
+		if op == "remove" {
+			delete(obj, sk)
+		} else {
+			obj[sk] = value
+		}
+		return nil
+	}
+	switch op {
+	case "remove":
+		list, isList := cur.([]interface{})
+		values, hasValues := value.([]interface{})
+		if !isList || !hasValues {
+			delete(res, key)
+			return nil
+		}
+		// remove the listed elements only, as some providers send
+		// {"op":"remove","path":"members","value":[{"value":"42"}]}
+		kept := []interface{}{}
+		for _, el := range list {
+			if !containsElement(values, el) {
+				kept = append(kept, el)
+			}
+		}
+		res[key] = kept
+	case "add":
+		list, isList := cur.([]interface{})
+		values, hasValues := value.([]interface{})
+		switch {
+		case isList || hasValues:
+			if !hasValues {
+				values = []interface{}{value}
+			}
+			for _, v := range values {
+				if !containsElement(list, v) {
+					list = append(list, v)
+				}
+			}
+			res[key] = list
+		default:
+			res[key] = merge(cur, value)
+		}
+	case "replace":
+		res[key] = merge(cur, value)
+	}
+	return nil
+}
+
+// applyFiltered applies an operation to the elements of a multi-valued
+// attribute that match the filter of path
+func applyFiltered(res map[string]interface{}, key string, path *Path, op string, value interface{}) error {
+	idx := matchElements(res, key, path.Filter)
+	if len(idx) == 0 {
+		if op == "remove" {
+			return nil
+		}
+		return Errorf(ErrNoTarget, "no %s match the filter", path.Attr)
+	}
+	list := res[key].([]interface{})
+	if op == "remove" && path.Sub == "" {
+		kept := []interface{}{}
+		next := 0
+		for i, el := range list {
+			if next < len(idx) && idx[next] == i {
+				next++
+				continue
+			}
+			kept = append(kept, el)
+		}
+		res[key] = kept
+		return nil
+	}
+	for _, i := range idx {
+		if path.Sub == "" {
+			list[i] = merge(list[i], value)
+			continue
+		}
+		el, ok := list[i].(map[string]interface{})
+		if !ok {
+			return Errorf(ErrInvalidPath, "%s has no sub-attributes", path.Attr)
+		}
+		_, sk := lookup(el, path.Sub)
+		if sk == "" {
+			sk = path.Sub
+		}
+		if op == "remove" {
+			delete(el, sk)
+		} else {
+			el[sk] = value
+		}
+	}
+	return nil
+}
+
+// merge returns value, with the sub-attributes of a complex cur that value
+// does not set kept
+func merge(cur, value interface{}) interface{} {
+	obj, ok := cur.(map[string]interface{})
+	next, ok2 := value.(map[string]interface{})
+	if !ok || !ok2 {
+		return value
+	}
+	for k, v := range next {
+		if _, ck := lookup(obj, k); ck != "" {
+			delete(obj, ck)
+		}
+		obj[k] = v
+	}
+	return obj
+}
+
+// containsElement reports whether list holds v; complex values with a
+// "value" sub-attribute are compared by it
+func containsElement(list []interface{}, v interface{}) bool {
+	vv, hasValue := elementValue(v)
+	for _, el := range list {
+		if ev, ok := elementValue(el); ok && hasValue {
+			if reflect.DeepEqual(ev, vv) {
+				return true
+			}
+		} else if reflect.DeepEqual(el, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func elementValue(v interface{}) (interface{}, bool) {
+	if obj, ok := v.(map[string]interface{}); ok {
+		if ev, k := lookup(obj, "value"); k != "" {
+			return ev, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,167 @@
+package scim_test
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"services/user/internal/scim"
+)
+
+func TestParsePath(t *testing.T) {
+	for _, tc := range []struct {
+		path, attr, sub string
+		filtered        bool
+	}{
+		{`title`, "title", "", false},
+		{`name.givenName`, "name", "givenname", false},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:name.familyName`, "name", "familyname", false},
+		{`emails[type eq "work"]`, "emails", "", true},
+		{`emails[type eq "work"].value`, "emails", "value", true},
+		{`Emails[Type eq "work" and value ew ".org"].Primary`, "emails", "primary", true},
+		{`members[value eq "2819c223"]`, "members", "", true},
+	} {
+		p, err := scim.ParsePath(tc.path)
+		if err != nil {
+			t.Errorf("ParsePath(%q): %v", tc.path, err)
+			continue
+		}
+		if p.Attr != tc.attr || p.Sub != tc.sub || (p.Filter != nil) != tc.filtered {
+			t.Errorf("ParsePath(%q) = %+v; want attr %s, sub %q, filtered %v", tc.path, p, tc.attr, tc.sub, tc.filtered)
+		}
+	}
+
+	for _, path := range []string{
+		``,
+		`name.givenName.first`,
+		`emails[type eq "work"`,
+		`emails[type eq ]`,
+		`emails[type eq "work"].`,
+		`emails[type eq "work"]value`,
+		`emails[type eq "work"].value.x`,
+		`user name`,
+	} {
+		var serr *scim.Error
+		if _, err := scim.ParsePath(path); !errors.As(err, &serr) || serr.ScimType != scim.ErrInvalidPath {
+			t.Errorf("ParsePath(%q) error = %v; want invalidPath", path, err)
+		}
+	}
+}
+
+func TestPatchOpApply(t *testing.T) {
+	work := `{"value":"bjensen@example.com","type":"work","primary":true}`
+	home := `{"value":"babs@jensen.org","type":"home"}`
+	doc := `{"userName":"bjensen","name":{"familyName":"Jensen","givenName":"Barbara"},"title":"Tour Guide",` +
+		`"emails":[` + work + `,` + home + `],"members":[{"value":"1"},{"value":"2"}],"tags":["a","b"]}`
+	tests := []struct {
+		name, ops string
+		// want is the patched resource, or empty when the patch fails
+		want string
+		// scimType is the type of the error of a failing patch
+		scimType string
+	}{
+		{name: "replace the sub-attribute of filtered elements",
+			ops:  `[{"op":"replace","path":"emails[type eq \"work\"].value","value":"new@example.com"}]`,
+			want: `{"emails":[{"value":"new@example.com","type":"work","primary":true},` + home + `]}`},
+		{name: "add a sub-attribute to filtered elements",
+			ops:  `[{"op":"add","path":"emails[type eq \"home\"].primary","value":false}]`,
+			want: `{"emails":[` + work + `,{"value":"babs@jensen.org","type":"home","primary":false}]}`},
+		{name: "filter matching several elements",
+			ops:  `[{"op":"replace","path":"emails[value ew \"example.com\" or type eq \"home\"].display","value":"x"}]`,
+			want: `{"emails":[{"value":"bjensen@example.com","type":"work","primary":true,"display":"x"},{"value":"babs@jensen.org","type":"home","display":"x"}]}`},
+		{name: "remove filtered elements",
+			ops:  `[{"op":"remove","path":"emails[type eq \"home\"]"}]`,
+			want: `{"emails":[` + work + `]}`},
+		{name: "remove the sub-attribute of filtered elements",
+			ops:  `[{"op":"remove","path":"emails[primary eq true].type"}]`,
+			want: `{"emails":[{"value":"bjensen@example.com","primary":true},` + home + `]}`},
+		{name: "replace filtered elements, keeping what the value does not set",
+			ops:  `[{"op":"replace","path":"emails[type eq \"work\"]","value":{"value":"new@example.com","Primary":false}}]`,
+			want: `{"emails":[{"value":"new@example.com","type":"work","Primary":false},` + home + `]}`},
+		{name: "path in other case",
+			ops:  `[{"op":"replace","path":"EMAILS[TYPE eq \"work\"].VALUE","value":"new@example.com"}]`,
+			want: `{"emails":[{"value":"new@example.com","type":"work","primary":true},` + home + `]}`},
+		{name: "filter on primitive values",
+			ops:  `[{"op":"remove","path":"tags[value eq \"a\"]"}]`,
+			want: `{"tags":["b"]}`},
+		{name: "replace without a match",
+			ops:      `[{"op":"replace","path":"emails[type eq \"other\"].value","value":"x@example.com"}]`,
+			scimType: scim.ErrNoTarget},
+		{name: "remove without a match",
+			ops:  `[{"op":"remove","path":"emails[type eq \"other\"]"}]`,
+			want: `{}`},
+		{name: "sub-attribute of primitive values",
+			ops:      `[{"op":"replace","path":"tags[value eq \"a\"].x","value":"y"}]`,
+			scimType: scim.ErrInvalidPath},
+
+		{name: "replace a sub-attribute",
+			ops:  `[{"op":"replace","path":"name.givenName","value":"Babs"}]`,
+			want: `{"name":{"familyName":"Jensen","givenName":"Babs"}}`},
+		{name: "remove a sub-attribute",
+			ops:  `[{"op":"remove","path":"urn:ietf:params:scim:schemas:core:2.0:User:name.familyName"}]`,
+			want: `{"name":{"givenName":"Barbara"}}`},
+		{name: "replace without a path",
+			ops:  `[{"op":"Replace","value":{"Title":"Boss","name":{"familyName":"J"}}}]`,
+			want: `{"title":"Boss","name":{"familyName":"J","givenName":"Barbara"}}`},
+		{name: "add to a multi-valued attribute",
+			ops:  `[{"op":"add","path":"members","value":[{"value":"2"},{"value":"3"}]},{"op":"add","path":"tags","value":"c"}]`,
+			want: `{"members":[{"value":"1"},{"value":"2"},{"value":"3"}],"tags":["a","b","c"]}`},
+		{name: "remove listed elements",
+			ops:  `[{"op":"remove","path":"members","value":[{"value":"1"}]}]`,
+			want: `{"members":[{"value":"2"}]}`},
+		{name: "remove an attribute",
+			ops:  `[{"op":"remove","path":"title"}]`,
+			want: `{"title":null}`},
+
+		{name: "unknown op", ops: `[{"op":"move","path":"title","value":"x"}]`, scimType: scim.ErrInvalidSyntax},
+		{name: "no operations", ops: `[]`, scimType: scim.ErrInvalidSyntax},
+		{name: "remove without a path", ops: `[{"op":"remove"}]`, scimType: scim.ErrNoTarget},
+		{name: "replace without a value", ops: `[{"op":"replace","path":"title"}]`, scimType: scim.ErrInvalidValue},
+		{name: "no path and no object", ops: `[{"op":"add","value":"x"}]`, scimType: scim.ErrInvalidValue},
+		{name: "invalid filter", ops: `[{"op":"replace","path":"emails[type eq].value","value":"x"}]`, scimType: scim.ErrInvalidPath},
+		{name: "sub-attribute of a simple attribute", ops: `[{"op":"replace","path":"title.x","value":"y"}]`, scimType: scim.ErrInvalidPath},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res := decode(t, doc)
+			var p scim.PatchOp
+			if err := json.Unmarshal([]byte(`{"schemas":["`+scim.SchemaPatchOp+`"],"Operations":`+tc.ops+`}`), &p); err != nil {
+				t.Fatalf("decode %s: %v", tc.ops, err)
+			}
+			err := p.Apply(res)
+			if tc.want == "" {
+				var serr *scim.Error
+				if !errors.As(err, &serr) || serr.ScimType != tc.scimType {
+					t.Fatalf("Apply error = %v; want %s", err, tc.scimType)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+			// want lists the attributes that change, null for removed
+			want := decode(t, doc)
+			for k, v := range decode(t, tc.want) {
+				if v == nil {
+					delete(want, k)
+				} else {
+					want[k] = v
+				}
+			}
+			if !reflect.DeepEqual(res, want) {
+				got, _ := json.Marshal(res)
+				t.Errorf("patched = %s; want %s", got, tc.want)
+			}
+		})
+	}
+
+	var p scim.PatchOp
+	if err := json.Unmarshal([]byte(`{"schemas":["urn:other"],"Operations":[{"op":"remove","path":"title"}]}`), &p); err != nil {
+		t.Fatal(err)
+	}
+	var serr *scim.Error
+	if err := p.Apply(decode(t, doc)); !errors.As(err, &serr) || serr.ScimType != scim.ErrInvalidSyntax {
+		t.Errorf("Apply with another schema error = %v; want invalidSyntax", err)
+	}
+}
@@ -0,0 +1,132 @@
+package scim
+
+// MaxResults is the most resources a list or query returns at once
+const MaxResults = 1000
+
+// ServiceProviderConfig describes the supported features; base is the URL
+// the SCIM endpoints are served under
+func ServiceProviderConfig(base string) map[string]interface{} {
+	unsupported := map[string]interface{}{"supported": false}
+	return map[string]interface{}{
+		"schemas":          []string{SchemaServiceProvider},
+		"documentationUri": "",
+		"patch":            map[string]interface{}{"supported": true},
+		"bulk":             map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":           map[string]interface{}{"supported": true, "maxResults": MaxResults},
+		"changePassword":   map[string]interface{}{"supported": true},
+		"sort":             unsupported,
+		"etag":             map[string]interface{}{"supported": true},
+		"authenticationSchemes": []interface{}{map[string]interface{}{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer token",
+			"description": "The token configured with SCIM_TOKEN",
+			"primary":     true,
+		}},
+		"meta": map[string]interface{}{"resourceType": "ServiceProviderConfig", "location": base + "/ServiceProviderConfig"},
+	}
+}
+
+// ResourceTypes lists the resource types served
+func ResourceTypes(base string) []interface{} {
+	return []interface{}{
+		resourceType(base, "User", "/Users", SchemaUser),
+		resourceType(base, "Group", "/Groups", SchemaGroup),
+	}
+}
+
+func resourceType(base, name, endpoint, schema string) map[string]interface{} {
+	return map[string]interface{}{
+		"schemas":     []string{SchemaResourceType},
+		"id":          name,
+		"name":        name,
+		"endpoint":    endpoint,
+		"description": name,
+		"schema":      schema,
+		"meta":        map[string]interface{}{"resourceType": "ResourceType", "location": base + "/ResourceTypes/" + name},
+	}
+}
+
+// attr describes an attribute of a schema
+func attr(name, typ, mutability string, required, multi bool, sub ...map[string]interface{}) map[string]interface{} {
+	a := map[string]interface{}{
+		"name":        name,
+		"type":        typ,
+		"multiValued": multi,
+		"required":    required,
+		"caseExact":   caseExact[normalizeAttr(name)],
+		"mutability":  mutability,
+		"returned":    "default",
+		"uniqueness":  "none",
+	}
+	switch name {
+	case "password":
+		a["returned"] = "never"
+	case "id":
+		a["returned"] = "always"
+		a["uniqueness"] = "server"
+	}
+	if len(sub) > 0 {
+		a["subAttributes"] = sub
+	}
+	return a
+}
+
+func unique(a map[string]interface{}) map[string]interface{} {
+	a["uniqueness"] = "server"
+	return a
+}
+
+// exact marks a sub-attribute case-exact, as caseExact only lists paths
+func exact(a map[string]interface{}) map[string]interface{} {
+	a["caseExact"] = true
+	return a
+}
+
+// Schemas describes the attributes of the User and Group resources as this
+// service maps them
+func Schemas(base string) []interface{} {
+	member := []map[string]interface{}{
+		attr("value", "string", "immutable", false, false),
+		attr("display", "string", "readOnly", false, false),
+		attr("$ref", "reference", "immutable", false, false),
+		attr("type", "string", "immutable", false, false),
+	}
+	user := []map[string]interface{}{
+		attr("id", "string", "readOnly", false, false),
+		attr("externalId", "string", "readWrite", false, false),
+		unique(attr("userName", "string", "readWrite", true, false)),
+		attr("name", "complex", "readWrite", false, false,
+			attr("formatted", "string", "readWrite", false, false),
+			attr("givenName", "string", "readWrite", false, false),
+			attr("familyName", "string", "readWrite", false, false)),
+		attr("displayName", "string", "readWrite", false, false),
+		attr("emails", "complex", "readOnly", false, true,
+			exact(attr("value", "string", "readOnly", false, false)),
+			attr("type", "string", "readOnly", false, false),
+			attr("primary", "boolean", "readOnly", false, false)),
+		attr("active", "boolean", "readWrite", false, false),
+		attr("password", "string", "writeOnly", false, false),
+		attr("groups", "complex", "readOnly", false, true, member...),
+	}
+	group := []map[string]interface{}{
+		attr("id", "string", "readOnly", false, false),
+		attr("externalId", "string", "readWrite", false, false),
+		unique(attr("displayName", "string", "readWrite", true, false)),
+		attr("members", "complex", "readWrite", false, true, member...),
+	}
+	return []interface{}{
+		schema(base, SchemaUser, "User", "User Account", user),
+		schema(base, SchemaGroup, "Group", "Group, mapped onto a role", group),
+	}
+}
+
+func schema(base, id, name, description string, attrs []map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"schemas":     []string{SchemaSchema},
+		"id":          id,
+		"name":        name,
+		"description": description,
+		"attributes":  attrs,
+		"meta":        map[string]interface{}{"resourceType": "Schema", "location": base + "/Schemas/" + id},
+	}
+}
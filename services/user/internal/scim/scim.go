@@ -0,0 +1,201 @@
+// Package scim implements the protocol side of SCIM 2.0 (RFC 7643, RFC
+// 7644): resource filters, PATCH operations, list and error messages and
+// the discovery documents. It works on resources as generic JSON objects;
+// mapping them onto users and roles is left to the HTTP handlers.
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Schema and message URNs
+const (
+	SchemaUser            = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup           = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaListResponse    = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp         = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError           = "urn:ietf:params:scim:api:messages:2.0:Error"
+	SchemaServiceProvider = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SchemaResourceType    = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	SchemaSchema          = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+)
+
+// ContentType is the media type of SCIM messages
+const ContentType = "application/scim+json"
+
+// Error scimType values, see RFC 7644 section 3.12
+const (
+	ErrInvalidFilter = "invalidFilter"
+	ErrInvalidPath   = "invalidPath"
+	ErrInvalidSyntax = "invalidSyntax"
+	ErrInvalidValue  = "invalidValue"
+	ErrMutability    = "mutability"
+	ErrNoTarget      = "noTarget"
+	ErrTooMany       = "tooMany"
+	ErrUniqueness    = "uniqueness"
+)
+
+// Error is a SCIM error response
+type Error struct {
+	Status   int
+	ScimType string
+	Detail   string
+}
+
+func (e *Error) Error() string {
+	if e.ScimType == "" {
+		return e.Detail
+	}
+	return e.ScimType + ": " + e.Detail
+}
+
+// Errorf returns a 400 error of scimType
+func Errorf(scimType, format string, args ...interface{}) *Error {
+	return &Error{Status: http.StatusBadRequest, ScimType: scimType, Detail: fmt.Sprintf(format, args...)}
+}
+
+// MarshalJSON encodes the error message, whose status is a string
+func (e *Error) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Schemas  []string `json:"schemas"`
+		Status   string   `json:"status"`
+		ScimType string   `json:"scimType,omitempty"`
+		Detail   string   `json:"detail,omitempty"`
+	}{[]string{SchemaError}, fmt.Sprint(e.Status), e.ScimType, e.Detail})
+}
+
+// ListResponse is a page of query results
+type ListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int           `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+// NewListResponse returns the page of resources that starts at the 1-based
+// startIndex and holds at most count of them
+func NewListResponse(resources []interface{}, startIndex, count int) *ListResponse {
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	page := []interface{}{}
+	if from := startIndex - 1; from < len(resources) {
+		to := len(resources)
+		if count < to-from {
+			to = from + count
+		}
+		page = resources[from:to]
+	}
+	return NewPage(page, len(resources), startIndex)
+}
+
+// NewPage returns page as the results from the 1-based startIndex on, out
+// of total results
+func NewPage(page []interface{}, total, startIndex int) *ListResponse {
+	if page == nil {
+		page = []interface{}{}
+	}
+	return &ListResponse{Schemas: []string{SchemaListResponse}, TotalResults: total, StartIndex: startIndex, ItemsPerPage: len(page), Resources: page}
+}
+
+// Meta is the metadata of a resource
+type Meta struct {
+	ResourceType string `json:"resourceType"`
+	Created      string `json:"created,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	Location     string `json:"location"`
+	Version      string `json:"version,omitempty"`
+}
+
+// Attribute names are case-insensitive and may be qualified with the URN of
+// their schema
+func normalizeAttr(name string) string {
+	lower := strings.ToLower(name)
+	for _, urn := range []string{SchemaUser, SchemaGroup} {
+		if u := strings.ToLower(urn) + ":"; strings.HasPrefix(lower, u) {
+			return lower[len(u):]
+		}
+	}
+	return lower
+}
+
+// lookup returns the value of obj's key matching name case-insensitively,
+// and the key as spelled in obj
+func lookup(obj map[string]interface{}, name string) (interface{}, string) {
+	for k, v := range obj {
+		if strings.EqualFold(k, name) {
+			return v, k
+		}
+	}
+	return nil, ""
+}
+
+// Project applies the attributes and excludedAttributes query parameters,
+// comma-separated lists of attribute paths, to a resource. The id, schemas
+// and meta are always returned.
+func Project(res map[string]interface{}, attributes, excluded string) map[string]interface{} {
+	always := map[string]bool{"id": true, "schemas": true, "meta": true}
+	if attributes != "" {
+		out := map[string]interface{}{}
+		for k, v := range res {
+			if always[strings.ToLower(k)] {
+				out[k] = v
+			}
+		}
+		for _, a := range strings.Split(attributes, ",") {
+			top, sub, _ := strings.Cut(normalizeAttr(strings.TrimSpace(a)), ".")
+			v, k := lookup(res, top)
+			if k == "" {
+				continue
+			}
+			if sub == "" {
+				out[k] = v
+				continue
+			}
+			if obj, ok := v.(map[string]interface{}); ok {
+				if sv, sk := lookup(obj, sub); sk != "" {
+					dst, _ := out[k].(map[string]interface{})
+					if dst == nil {
+						dst = map[string]interface{}{}
+						out[k] = dst
+					}
+					dst[sk] = sv
+				}
+			}
+		}
+		res = out
+	}
+	for _, a := range strings.Split(excluded, ",") {
+		top, sub, _ := strings.Cut(normalizeAttr(strings.TrimSpace(a)), ".")
+		if top == "" || always[top] {
+			continue
+		}
+		_, k := lookup(res, top)
+		if k == "" {
+			continue
+		}
+		if sub == "" {
+			delete(res, k)
+		} else if obj, ok := res[k].(map[string]interface{}); ok {
+			if _, sk := lookup(obj, sub); sk != "" {
+				delete(obj, sk)
+			}
+		}
+	}
+	return res
+}
+
+// ToMap converts a resource struct to a generic JSON object
+func ToMap(v interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	err = json.Unmarshal(b, &m)
+	return m, err
+}
@@ -0,0 +1,52 @@
+package scim
+
+// Column translates comparisons on one attribute into SQL conditions. Given
+// a comparison operator and its value, or "pr" and nil, it returns the
+// condition and its arguments, or false when it cannot select exactly the
+// resources Filter.Match would. Conditions must never be NULL, so that
+// negating them is exact.
+type Column func(op string, value interface{}) (string, []interface{}, bool)
+
+// SQL translates f into an SQL condition using the columns of attributes,
+// keyed by lower-case attribute path without schema URN. A value path such
+// as emails[value eq "x"] uses the column of emails.value. It reports false
+// when f compares an attribute that has no column, or in a way its column
+// cannot translate; the caller then has to match resources one by one.
+func SQL(f Filter, columns map[string]Column) (string, []interface{}, bool) {
+	return toSQL(f, "", columns)
+}
+
+func toSQL(f Filter, prefix string, columns map[string]Column) (string, []interface{}, bool) {
+	switch f := f.(type) {
+	case *logical:
+		left, largs, ok := toSQL(f.left, prefix, columns)
+		if !ok {
+			return "", nil, false
+		}
+		right, rargs, ok := toSQL(f.right, prefix, columns)
+		if !ok {
+			return "", nil, false
+		}
+		op := " OR "
+		if f.and {
+			op = " AND "
+		}
+		return "(" + left + op + right + ")", append(largs, rargs...), true
+	case *not:
+		cond, args, ok := toSQL(f.f, prefix, columns)
+		return "(NOT " + cond + ")", args, ok
+	case *compare:
+		col, ok := columns[prefix+f.path]
+		if !ok {
+			return "", nil, false
+		}
+		if f.op == "ne" {
+			cond, args, ok := col("eq", f.value)
+			return "(NOT " + cond + ")", args, ok
+		}
+		return col(f.op, f.value)
+	case *valuePath:
+		return toSQL(f.filter, f.attr+".", columns)
+	}
+	return "", nil, false
+}
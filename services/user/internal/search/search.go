@@ -0,0 +1,312 @@
+// Package search holds the parts of user search that do not depend on the
+// database: splitting a query into terms, tolerating typos by expanding
+// terms with similar indexed words, and scoring and highlighting the fields
+// of the users found. The indexes themselves live in the store.
+package search
+
+import (
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// MaxTerms bounds the words of a query that are searched for
+	MaxTerms     = 8
+	maxTermRunes = 64
+	// maxFuzzy bounds the similar words a term is expanded with
+	maxFuzzy = 16
+)
+
+// Term is a word of a query
+type Term struct {
+	// Text is the word in lower case; indexes match words starting with it
+	Text string
+	// Fuzzy lists indexed words a few typos away from Text, which indexes
+	// match exactly
+	Fuzzy []string
+	// folded is Text without diacritics, which fields are compared by
+	folded string
+}
+
+// fold lowercases s and strips its diacritics, so "Nguyễn" compares equal
+// to "nguyen" as it does in the SQLite index
+func fold(s string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	out, _, err := transform.String(t, strings.ToLower(s))
+	if err != nil {
+		return strings.ToLower(s)
+	}
+	return out
+}
+
+// span is the byte range of a word in a text
+type span struct{ start, end int }
+
+// words returns the runs of letters and digits in s, which is how the
+// indexes split emails and names into words
+func words(s string) []span {
+	var out []span
+	start := -1
+	for i, r := range s {
+		inWord := unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+		switch {
+		case inWord && start < 0:
+			start = i
+		case !inWord && start >= 0:
+			out = append(out, span{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		out = append(out, span{start, len(s)})
+	}
+	return out
+}
+
+// Terms splits a query into the terms to search for, dropping repeats
+func Terms(q string) []Term {
+	var terms []Term
+	seen := map[string]bool{}
+	for _, w := range words(q) {
+		text := strings.ToLower(q[w.start:w.end])
+		if utf8.RuneCountInString(text) > maxTermRunes {
+			text = string([]rune(text)[:maxTermRunes])
+		}
+		f := fold(text)
+		if seen[f] {
+			continue
+		}
+		seen[f] = true
+		terms = append(terms, Term{Text: text, folded: f})
+		if len(terms) == MaxTerms {
+			break
+		}
+	}
+	return terms
+}
+
+// MaxDistance is the number of typos tolerated in term: none in words of
+// up to three letters, which would match too much, one up to seven and two
+// in longer ones
+func MaxDistance(term string) int {
+	switch n := utf8.RuneCountInString(term); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	}
+	return 2
+}
+
+// Distance is the optimal string alignment distance between a and b: the
+// number of runes inserted, deleted, substituted or swapped with their
+// neighbour to turn one into the other
+func Distance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
+
+// Expand sets the fuzzy alternatives of each term to the words of vocab,
+// the words in the index, that are within MaxDistance of it once folded.
+// Words starting with the term are left out, as they match already; those
+// differing only in diacritics are kept for indexes that do not fold them.
+func Expand(terms []Term, vocab []string) {
+	for i := range terms {
+		t := &terms[i]
+		maxd := MaxDistance(t.folded)
+		if maxd == 0 {
+			continue
+		}
+		type candidate struct {
+			word string
+			d    int
+		}
+		var found []candidate
+		seen := map[string]bool{}
+		for _, w := range vocab {
+			fw := fold(w)
+			if seen[w] || strings.HasPrefix(strings.ToLower(w), t.Text) {
+				continue
+			}
+			if d := Distance(t.folded, fw); d <= maxd {
+				seen[w] = true
+				found = append(found, candidate{w, d})
+			}
+		}
+		sort.Slice(found, func(a, b int) bool {
+			if found[a].d != found[b].d {
+				return found[a].d < found[b].d
+			}
+			return found[a].word < found[b].word
+		})
+		t.Fuzzy = t.Fuzzy[:0]
+		for j := 0; j < len(found) && j < maxFuzzy; j++ {
+			t.Fuzzy = append(t.Fuzzy, found[j].word)
+		}
+	}
+}
+
+// Lengths returns the shortest and longest words that Expand could accept
+// for terms, to narrow the vocabulary read from the index
+func Lengths(terms []Term) (lo, hi int) {
+	lo = -1
+	for _, t := range terms {
+		d := MaxDistance(t.folded)
+		if d == 0 {
+			continue
+		}
+		n := utf8.RuneCountInString(t.folded)
+		if lo < 0 || n-d < lo {
+			lo = n - d
+		}
+		hi = max(hi, n+d)
+	}
+	return max(lo, 0), hi
+}
+
+// HasFuzzy reports whether any term was expanded
+func HasFuzzy(terms []Term) bool {
+	for _, t := range terms {
+		if len(t.Fuzzy) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// substringScore is the score of a word merely containing a term
+const substringScore = 0.2
+
+// match is how well the folded word w matches t: 1 when equal, between 0.5
+// and 1 for a prefix, less for a fuzzy alternative and least for a
+// substring. from and to delimit the runes of w that matched.
+func (t *Term) match(w string) (score float64, from, to int) {
+	n := utf8.RuneCountInString(w)
+	tn := utf8.RuneCountInString(t.folded)
+	switch {
+	case w == t.folded:
+		return 1, 0, n
+	case strings.HasPrefix(w, t.folded):
+		return 0.5 + 0.5*float64(tn)/float64(n), 0, tn
+	}
+	for _, f := range t.Fuzzy {
+		if fold(f) == w {
+			return 0.6 - 0.2*float64(Distance(t.folded, w)), 0, n
+		}
+	}
+	// only the LIKE fallback finds words that merely contain a term
+	if i := strings.Index(w, t.folded); i >= 0 {
+		from := utf8.RuneCountInString(w[:i])
+		return substringScore, from, from + tn
+	}
+	return 0, 0, 0
+}
+
+// Score ranks a user by how well fields, such as the email and full name,
+// match terms: for each term its best match in any field, plus a little
+// for matching the first word of a field
+func Score(terms []Term, fields ...string) float64 {
+	total := 0.0
+	for i := range terms {
+		best := 0.0
+		for _, field := range fields {
+			for k, w := range words(field) {
+				s, _, _ := terms[i].match(fold(field[w.start:w.end]))
+				if s > 0 && k == 0 {
+					s += 0.1
+				}
+				best = max(best, s)
+			}
+		}
+		total += best
+	}
+	return total
+}
+
+// Highlight returns fields, such as the email and full name, HTML escaped
+// with the parts matching terms wrapped in <mark> elements. Words merely
+// containing a term are only marked when no word of any field starts with
+// it, as only then were they what the user was found by.
+func Highlight(terms []Term, fields ...string) []string {
+	ws := make([][]span, len(fields))
+	folded := make([][]string, len(fields))
+	partial := make([]bool, len(terms))
+	for i := range partial {
+		partial[i] = true
+	}
+	for f, field := range fields {
+		ws[f] = words(field)
+		for _, w := range ws[f] {
+			fw := fold(field[w.start:w.end])
+			folded[f] = append(folded[f], fw)
+			for i := range terms {
+				if s, _, _ := terms[i].match(fw); s > substringScore {
+					partial[i] = false
+				}
+			}
+		}
+	}
+	out := make([]string, len(fields))
+	for f, text := range fields {
+		var b strings.Builder
+		last := 0
+		for k, w := range ws[f] {
+			best, from, to := 0.0, 0, 0
+			for i := range terms {
+				s, start, end := terms[i].match(folded[f][k])
+				if s == substringScore && !partial[i] {
+					continue
+				}
+				if s > best {
+					best, from, to = s, start, end
+				}
+			}
+			if best == 0 {
+				continue
+			}
+			rs := []rune(text[w.start:w.end])
+			// folding keeps one rune per letter of composed text; mark the
+			// whole word when it did not
+			if utf8.RuneCountInString(folded[f][k]) != len(rs) {
+				from, to = 0, len(rs)
+			}
+			start := w.start + len(string(rs[:from]))
+			end := w.start + len(string(rs[:to]))
+			b.WriteString(html.EscapeString(text[last:start]))
+			b.WriteString("<mark>")
+			b.WriteString(html.EscapeString(text[start:end]))
+			b.WriteString("</mark>")
+			last = end
+		}
+		b.WriteString(html.EscapeString(text[last:]))
+		out[f] = b.String()
+	}
+	return out
+}
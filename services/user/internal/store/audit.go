@@ -0,0 +1,348 @@
+package store
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm/clause"
+
+	"services/user/internal/models"
+)
+
+// AuditFilter narrows ListAuditEvents. Zero values are ignored.
+type AuditFilter struct {
+	ActorID    uint
+	Action     string
+	TargetType string
+	TargetID   string
+	Result     string
+	Since      time.Time
+	Until      time.Time
+	// Before is a cursor: only events with a smaller ID are returned
+	Before uint
+	Limit  int
+}
+
+// AppendAuditEvent stores ev at the head of the audit chain. Audit events
+// are never updated or deleted; only their personal data can be erased, by
+// redactAuditEvents.
+func (s *Store) AppendAuditEvent(ctx context.Context, ev *models.AuditEvent) error {
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+	return s.transaction(ctx, func(tx *Store) error { return tx.appendAuditEvent(ctx, ev) })
+}
+
+// auditLockKey identifies the Postgres advisory lock guarding the chain head
+const auditLockKey = 0x61756469
+
+// appendAuditEvent links ev to the current chain head and inserts it. s must
+// be bound to a transaction and the caller must hold auditMu, which
+// serializes appends within this process; the database lock taken here
+// serializes appends across replicas sharing a Postgres or MySQL database.
+func (s *Store) appendAuditEvent(ctx context.Context, ev *models.AuditEvent) error {
+	tx, cancel := s.conn(ctx)
+	defer cancel()
+	q := tx.Select("id", "hash").Order("id desc").Limit(1)
+	switch tx.Dialector.Name() {
+	case "postgres":
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", auditLockKey).Error; err != nil {
+			return err
+		}
+	case "mysql":
+		// a locking read sees the latest committed head and blocks concurrent appends
+		q = q.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+	var head models.AuditEvent
+	if err := q.Find(&head).Error; err != nil {
+		return err
+	}
+	if ev.CreatedAt.IsZero() {
+		ev.CreatedAt = time.Now()
+	}
+	// keep the precision every supported database can round-trip
+	ev.CreatedAt = ev.CreatedAt.UTC().Truncate(time.Microsecond)
+	var salt [16]byte
+	if _, err := rand.Read(salt[:]); err != nil {
+		return err
+	}
+	ev.PersonalSalt = hex.EncodeToString(salt[:])
+	ev.PersonalDigest = ev.ComputePersonalDigest()
+	ev.PrevHash = head.Hash
+	ev.Hash = ev.ComputeHash()
+	return tx.Create(ev).Error
+}
+
+// ListAuditEvents returns events newest first
+func (s *Store) ListAuditEvents(ctx context.Context, f AuditFilter) ([]models.AuditEvent, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	q := db.Model(&models.AuditEvent{})
+	if f.ActorID != 0 {
+		q = q.Where("actor_id = ?", f.ActorID)
+	}
+	if f.Action != "" {
+		q = q.Where("action = ?", f.Action)
+	}
+	if f.TargetType != "" {
+		q = q.Where("target_type = ?", f.TargetType)
+	}
+	if f.TargetID != "" {
+		q = q.Where("target_id = ?", f.TargetID)
+	}
+	if f.Result != "" {
+		q = q.Where("result = ?", f.Result)
+	}
+	if !f.Since.IsZero() {
+		q = q.Where("created_at >= ?", f.Since)
+	}
+	if !f.Until.IsZero() {
+		q = q.Where("created_at < ?", f.Until)
+	}
+	if f.Before != 0 {
+		q = q.Where("id < ?", f.Before)
+	}
+	if f.Limit > 0 {
+		q = q.Limit(f.Limit)
+	}
+	var evs []models.AuditEvent
+	if err := q.Order("id desc").Find(&evs).Error; err != nil {
+		return nil, err
+	}
+	return evs, nil
+}
+
+// Audited runs fn in a transaction and appends ev in that same transaction,
+// so the change and its audit record are committed together. If fn fails the
+// change is rolled back and ev is recorded on its own with a failure result.
+//
+// The failure is recorded even when ctx has been cancelled, since the
+// cancellation is often what made fn fail.
+func (s *Store) Audited(ctx context.Context, ev *models.AuditEvent, fn func(tx Repository) error) error {
+	s.auditMu.Lock()
+	err := s.transaction(ctx, func(tx *Store) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		ev.Result = models.AuditSuccess
+		return tx.appendAuditEvent(ctx, ev)
+	})
+	s.auditMu.Unlock()
+	if err != nil {
+		ev.ID = 0
+		ev.Result = models.AuditFailure
+		ev.Detail = err.Error()
+		if aerr := s.AppendAuditEvent(context.WithoutCancel(ctx), ev); aerr != nil {
+			log.Printf("failed to append audit event: action=%s, err=%v", ev.Action, aerr)
+		}
+	}
+	return err
+}
+
+// BackfillAuditChain hashes events recorded before the chain existed, in ID order
+func (s *Store) BackfillAuditChain(ctx context.Context) (int, error) {
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+	var missing int64
+	db, cancel := s.conn(ctx)
+	err := db.Model(&models.AuditEvent{}).Where("hash = '' OR hash IS NULL").Count(&missing).Error
+	cancel()
+	if err != nil || missing == 0 {
+		return 0, err
+	}
+	n := 0
+	err = s.transaction(ctx, func(tx *Store) error {
+		prev := ""
+		return tx.walkAuditEvents(ctx, func(ev *models.AuditEvent) error {
+			if ev.Hash == "" {
+				ev.PrevHash = prev
+				ev.Hash = ev.ComputeHash()
+				db, cancel := tx.conn(ctx)
+				defer cancel()
+				// UpdateColumns skips the append-only hooks
+				if err := db.Model(ev).UpdateColumns(map[string]interface{}{"prev_hash": ev.PrevHash, "hash": ev.Hash}).Error; err != nil {
+					return err
+				}
+				n++
+			}
+			prev = ev.Hash
+			return nil
+		})
+	})
+	return n, err
+}
+
+// walkAuditEvents calls fn for every event in ID order, loading them in
+// batches; the query timeout applies to each batch
+func (s *Store) walkAuditEvents(ctx context.Context, fn func(ev *models.AuditEvent) error) error {
+	var after uint
+	for {
+		var batch []models.AuditEvent
+		db, cancel := s.conn(ctx)
+		err := db.Where("id > ?", after).Order("id").Limit(500).Find(&batch).Error
+		cancel()
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		for i := range batch {
+			if err := fn(&batch[i]); err != nil {
+				return err
+			}
+		}
+		after = batch[len(batch)-1].ID
+	}
+}
+
+// CheckpointAudit signs the current chain head, unless it is already covered
+// by the latest checkpoint. It returns nil when there was nothing to sign.
+func (s *Store) CheckpointAudit(ctx context.Context, key []byte) (*models.AuditCheckpoint, error) {
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	var head models.AuditEvent
+	if err := db.Select("id", "hash").Order("id desc").Limit(1).Find(&head).Error; err != nil || head.ID == 0 {
+		return nil, err
+	}
+	var last models.AuditCheckpoint
+	if err := db.Order("id desc").Limit(1).Find(&last).Error; err != nil {
+		return nil, err
+	}
+	if last.EventID == head.ID {
+		return nil, nil
+	}
+	cp := &models.AuditCheckpoint{CreatedAt: time.Now().UTC().Truncate(time.Second), EventID: head.ID, Hash: head.Hash}
+	cp.Signature = cp.Sign(key)
+	if err := db.Create(cp).Error; err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// AuditVerification is the outcome of VerifyAuditChain
+type AuditVerification struct {
+	Events      int
+	Checkpoints int
+	// Redacted is how many of the events had their personal data erased
+	Redacted int
+	Head     string
+	// BrokenEventID is the first event whose link or content does not verify, 0 if none
+	BrokenEventID uint
+	// BrokenCheckpointID is the first checkpoint that does not verify, 0 if none
+	BrokenCheckpointID uint
+	Reason             string
+}
+
+// OK reports whether the whole chain and all checkpoints verified
+func (v *AuditVerification) OK() bool {
+	return v.BrokenEventID == 0 && v.BrokenCheckpointID == 0
+}
+
+// VerifyAuditChain walks the audit log in ID order recomputing every hash,
+// and checks each checkpoint signature against key and the event it covers.
+// It stops at the first broken link. The personal data of redacted events
+// is gone, so only their digest is verified, as part of the hash.
+func (s *Store) VerifyAuditChain(ctx context.Context, key []byte) (*AuditVerification, error) {
+	var cps []models.AuditCheckpoint
+	db, cancel := s.conn(ctx)
+	err := db.Order("id").Find(&cps).Error
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	v := &AuditVerification{}
+	byEvent := map[uint][]*models.AuditCheckpoint{}
+	for i := range cps {
+		cp := &cps[i]
+		if !hmac.Equal([]byte(cp.Sign(key)), []byte(cp.Signature)) {
+			v.BrokenCheckpointID = cp.ID
+			v.Reason = "checkpoint signature is invalid"
+			return v, nil
+		}
+		byEvent[cp.EventID] = append(byEvent[cp.EventID], cp)
+	}
+	errBroken := errors.New("broken")
+	err = s.walkAuditEvents(ctx, func(ev *models.AuditEvent) error {
+		switch {
+		case ev.PrevHash != v.Head:
+			v.Reason = fmt.Sprintf("prev_hash %q does not match the hash of the preceding event %q", ev.PrevHash, v.Head)
+		case ev.ComputeHash() != ev.Hash:
+			v.Reason = "content does not match its hash"
+		case ev.RedactedAt != nil && (ev.HasPersonalData() || ev.PersonalDigest == ""):
+			v.Reason = "redacted event still has personal data, or had none to erase"
+		case ev.RedactedAt == nil && ev.PersonalDigest != "" && ev.ComputePersonalDigest() != ev.PersonalDigest:
+			v.Reason = "personal data does not match its digest"
+		default:
+			if ev.RedactedAt != nil {
+				v.Redacted++
+			}
+			for _, cp := range byEvent[ev.ID] {
+				if cp.Hash != ev.Hash {
+					v.BrokenCheckpointID = cp.ID
+					v.Reason = fmt.Sprintf("checkpoint hash does not match event %d", ev.ID)
+					return errBroken
+				}
+				v.Checkpoints++
+			}
+			delete(byEvent, ev.ID)
+			v.Events++
+			v.Head = ev.Hash
+			return nil
+		}
+		v.BrokenEventID = ev.ID
+		return errBroken
+	})
+	if errors.Is(err, errBroken) {
+		return v, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// checkpoints left over cover events that no longer exist, e.g. a truncated tail
+	for _, cp := range cps {
+		if _, missing := byEvent[cp.EventID]; missing {
+			v.BrokenCheckpointID = cp.ID
+			v.Reason = fmt.Sprintf("checkpoint covers event %d which is missing", cp.EventID)
+			break
+		}
+	}
+	return v, nil
+}
+
+// redactAuditEvents erases the personal data of the users ids from the
+// audit events they performed or that are about them, with the salt of its
+// digest, so the data cannot be recovered while the chain still verifies.
+// Events recorded before personal data was digested cannot be changed
+// without breaking the chain; ListAudit hides their personal data instead.
+func (s *Store) redactAuditEvents(ctx context.Context, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	targets := make([]string, len(ids))
+	for i, id := range ids {
+		targets[i] = strconv.FormatUint(uint64(id), 10)
+	}
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	// UpdateColumns skips the append-only hooks
+	return db.Model(&models.AuditEvent{}).
+		Where("redacted_at IS NULL AND personal_digest <> ''").
+		Where("actor_id IN ? OR (target_type = 'user' AND target_id IN ?)", ids, targets).
+		UpdateColumns(map[string]interface{}{
+			"actor_email":   "",
+			"ip":            "",
+			"user_agent":    "",
+			"diff":          nil,
+			"personal_salt": "",
+			"redacted_at":   time.Now(),
+		}).Error
+}
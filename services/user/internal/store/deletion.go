@@ -0,0 +1,111 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"services/user/internal/fieldcrypt"
+	"services/user/internal/models"
+)
+
+// DueUserDeletions returns users whose requested deletion is due at now
+func (s *Store) DueUserDeletions(ctx context.Context, now time.Time, limit int) ([]models.User, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	var us []models.User
+	if err := db.Where("deletion_due_at <= ?", now).Order("deletion_due_at").Limit(limit).Find(&us).Error; err != nil {
+		return nil, err
+	}
+	return us, nil
+}
+
+// AnonymizeUser erases the personal data of user id and soft deletes them.
+// Their role assignments, group memberships, pending email changes, settings
+// and exports are deleted; the row itself is kept with a placeholder email so
+// audit events and other references still resolve. Their personal data is
+// also erased from audit events and webhook deliveries. It returns the blob
+// keys of the deleted exports, which the caller removes from blob storage.
+func (s *Store) AnonymizeUser(ctx context.Context, id uint) ([]string, error) {
+	var keys []string
+	err := s.transaction(ctx, func(tx *Store) error {
+		db, cancel := tx.conn(ctx)
+		defer cancel()
+		for _, m := range []interface{}{&models.UserRole{}, &models.GroupMember{}, &models.EmailChange{}, &models.UserSettings{}} {
+			if err := db.Where("user_id = ?", id).Delete(m).Error; err != nil {
+				return err
+			}
+		}
+		if err := db.Model(&models.ExportJob{}).Where("user_id = ? AND blob_key <> ''", id).Pluck("blob_key", &keys).Error; err != nil {
+			return err
+		}
+		if err := db.Where("user_id = ?", id).Delete(&models.ExportJob{}).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		// map values bypass the encrypted serializer and BeforeSave
+		email := fmt.Sprintf("deleted-%d@invalid", id)
+		sealed, err := fieldcrypt.Seal("email", email)
+		if err != nil {
+			return err
+		}
+		// a map, so zero values are written too
+		res := db.Model(&models.User{}).Where("id = ? AND anonymized_at IS NULL", id).Updates(map[string]interface{}{
+			"email":           sealed,
+			"email_index":     fieldcrypt.BlindIndex(email),
+			"password":        "",
+			"full_name":       "",
+			"display_name":    "",
+			"avatar_url":      "",
+			"plan":            models.DefaultPlan,
+			"locale":          "",
+			"timezone":        "",
+			"phone":           "",
+			"birthday":        "",
+			"avatar_key":      "",
+			"external_id":     "",
+			"deletion_due_at": nil,
+			"anonymized_at":   now,
+			"deleted_at":      now,
+			"version":         gorm.Expr("version + 1"),
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		if err := tx.redactAuditEvents(ctx, []uint{id}); err != nil {
+			return err
+		}
+		return tx.redactWebhookDeliveries(ctx, []uint{id})
+	})
+	return keys, err
+}
+
+// RedactedUserIDs returns those of ids that no longer belong to a user with
+// personal data, because the user was anonymized or purged
+func (s *Store) RedactedUserIDs(ctx context.Context, ids []uint) (map[uint]bool, error) {
+	out := map[uint]bool{}
+	if len(ids) == 0 {
+		return out, nil
+	}
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	var known []uint
+	if err := db.Unscoped().Model(&models.User{}).Where("id IN ? AND anonymized_at IS NULL", ids).Pluck("id", &known).Error; err != nil {
+		return nil, err
+	}
+	live := map[uint]bool{}
+	for _, id := range known {
+		live[id] = true
+	}
+	for _, id := range ids {
+		if !live[id] {
+			out[id] = true
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,69 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+
+	"services/user/internal/models"
+)
+
+// hashToken returns the hex SHA-256 under which a confirmation token is stored
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// CreateEmailChange records a pending change of userID's email to newEmail
+// that expires after ttl, and returns the confirmation token. Any earlier
+// pending change of the user is replaced, and expired changes of every user
+// are cleaned up.
+func (s *Store) CreateEmailChange(ctx context.Context, userID uint, newEmail string, ttl time.Duration) (*models.EmailChange, string, error) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return nil, "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b[:])
+	now := time.Now()
+	ec := &models.EmailChange{UserID: userID, NewEmail: newEmail, TokenHash: hashToken(token), ExpiresAt: now.Add(ttl)}
+	err := s.transaction(ctx, func(tx *Store) error {
+		db, cancel := tx.conn(ctx)
+		defer cancel()
+		if err := db.Where("user_id = ? OR expires_at < ?", userID, now).Delete(&models.EmailChange{}).Error; err != nil {
+			return err
+		}
+		return db.Create(ec).Error
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return ec, token, nil
+}
+
+// GetEmailChangeByToken returns the pending change confirmed by token. Unknown
+// and expired tokens both return ErrNotFound.
+func (s *Store) GetEmailChangeByToken(ctx context.Context, token string) (*models.EmailChange, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	var ec models.EmailChange
+	if err := db.Where("token_hash = ? AND expires_at > ?", hashToken(token), time.Now()).First(&ec).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &ec, nil
+}
+
+// DeleteEmailChanges drops every pending email change of a user
+func (s *Store) DeleteEmailChanges(ctx context.Context, userID uint) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	return db.Where("user_id = ?", userID).Delete(&models.EmailChange{}).Error
+}
@@ -0,0 +1,176 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"services/user/internal/fieldcrypt"
+	"services/user/internal/models"
+)
+
+// reencryptBatch bounds how many users one ReencryptUsers query selects
+const reencryptBatch = 500
+
+// ReencryptUsers rewrites the encrypted fields and blind index of users,
+// including soft-deleted ones, with the current keyring and returns how many
+// rows it rewrote. Without rotate it only fills in missing blind indexes and,
+// with encryption enabled, encrypts values still stored in plaintext, which
+// is cheap enough to run at every start. With rotate it also re-encrypts
+// every value sealed with another key than the active one, after which older
+// keys can be removed.
+//
+// Rows are rewritten as they are, without bumping their version, and only if
+// their version is unchanged since they were read; a user updated meanwhile
+// has already been saved with the current keyring.
+func (s *Store) ReencryptUsers(ctx context.Context, rotate bool) (int, error) {
+	cond := "email_index IS NULL"
+	var args []interface{}
+	switch {
+	case fieldcrypt.Enabled() && rotate:
+		r := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+		cond += " OR email NOT LIKE ? ESCAPE '!'"
+		args = append(args, r.Replace(fieldcrypt.SealedPrefix())+"%")
+	case fieldcrypt.Enabled():
+		cond += " OR email NOT LIKE ?"
+		args = append(args, fieldcrypt.Prefix+"%")
+	}
+	var n int
+	var after uint
+	for {
+		db, cancel := s.conn(ctx)
+		var ids []uint
+		err := db.Unscoped().Model(&models.User{}).Where("id > ?", after).Where(cond, args...).Order("id").Limit(reencryptBatch).Pluck("id", &ids).Error
+		cancel()
+		if err != nil || len(ids) == 0 {
+			return n, err
+		}
+		for _, id := range ids {
+			ok, err := s.reencryptUser(ctx, id)
+			if err != nil {
+				return n, err
+			}
+			if ok {
+				n++
+			}
+		}
+		after = ids[len(ids)-1]
+	}
+}
+
+// reencryptUser rewrites one user and reports whether it was still unchanged
+func (s *Store) reencryptUser(ctx context.Context, id uint) (bool, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	var u models.User
+	if err := db.Unscoped().First(&u, id).Error; err != nil {
+		return false, err
+	}
+	// UpdateColumns skips hooks and leaves updated_at alone
+	u.EmailIndex = fieldcrypt.BlindIndex(u.Email)
+	res := db.Unscoped().Model(&u).Where("version = ?", u.Version).Select("*").Omit("id", "created_at", "updated_at", "deleted_at", "version").UpdateColumns(&u)
+	return res.RowsAffected == 1, res.Error
+}
+
+// sealedColumns are the encrypted columns of tables other than users
+var sealedColumns = []struct {
+	table   string
+	columns []string
+}{
+	{"audit_events", []string{"actor_email", "ip", "user_agent", "diff"}},
+	{"webhook_deliveries", []string{"payload"}},
+	{"email_changes", []string{"new_email"}},
+}
+
+// ReencryptColumns is ReencryptUsers for the encrypted columns of audit
+// events, webhook deliveries and pending email changes. It does nothing
+// while encryption is disabled. Values are rewritten as they are, without
+// running hooks, and only if unchanged since they were read, so an audit
+// event redacted meanwhile keeps its personal data erased.
+func (s *Store) ReencryptColumns(ctx context.Context, rotate bool) (int, error) {
+	if !fieldcrypt.Enabled() {
+		return 0, nil
+	}
+	pattern, escape := fieldcrypt.Prefix+"%", ""
+	if rotate {
+		r := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+		pattern, escape = r.Replace(fieldcrypt.SealedPrefix())+"%", " ESCAPE '!'"
+	}
+	var n int
+	for _, t := range sealedColumns {
+		conds := make([]string, len(t.columns))
+		args := make([]interface{}, len(t.columns))
+		for i, c := range t.columns {
+			conds[i] = fmt.Sprintf("(%s <> '' AND %s NOT LIKE ?%s)", c, c, escape)
+			args[i] = pattern
+		}
+		cond := strings.Join(conds, " OR ")
+		var after uint
+		for {
+			db, cancel := s.conn(ctx)
+			var ids []uint
+			err := db.Table(t.table).Where("id > ?", after).Where(cond, args...).Order("id").Limit(reencryptBatch).Pluck("id", &ids).Error
+			cancel()
+			if err != nil {
+				return n, err
+			}
+			if len(ids) == 0 {
+				break
+			}
+			for _, id := range ids {
+				ok, err := s.reencryptRow(ctx, t.table, t.columns, id)
+				if err != nil {
+					return n, fmt.Errorf("%s %d: %w", t.table, id, err)
+				}
+				if ok {
+					n++
+				}
+			}
+			after = ids[len(ids)-1]
+		}
+	}
+	return n, nil
+}
+
+// reencryptRow seals the columns of one row with the active key and reports
+// whether the row was still unchanged
+func (s *Store) reencryptRow(ctx context.Context, table string, columns []string, id uint) (bool, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	row := map[string]interface{}{}
+	err := db.Table(table).Select(columns).Where("id = ?", id).Take(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// deleted meanwhile, like a confirmed email change
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	q := db.Table(table).Where("id = ?", id)
+	set := map[string]interface{}{}
+	for _, c := range columns {
+		var old string
+		switch v := row[c].(type) {
+		case nil:
+			q = q.Where(c + " IS NULL")
+			continue
+		case string:
+			old = v
+		case []byte:
+			old = string(v)
+		}
+		q = q.Where(c+" = ?", old)
+		pt, err := fieldcrypt.Open(c, old)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", c, err)
+		}
+		if set[c], err = fieldcrypt.Seal(c, pt); err != nil {
+			return false, err
+		}
+	}
+	res := q.UpdateColumns(set)
+	return res.RowsAffected == 1, res.Error
+}
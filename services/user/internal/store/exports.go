@@ -0,0 +1,93 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+
+	"services/user/internal/models"
+)
+
+func (s *Store) CreateExportJob(ctx context.Context, j *models.ExportJob) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	return db.Create(j).Error
+}
+
+// GetExportJob returns job id of userID
+func (s *Store) GetExportJob(ctx context.Context, userID, id uint) (*models.ExportJob, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	var j models.ExportJob
+	if err := db.Where("user_id = ?", userID).First(&j, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &j, nil
+}
+
+// GetActiveExportJob returns the pending or running export of userID, if any
+func (s *Store) GetActiveExportJob(ctx context.Context, userID uint) (*models.ExportJob, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	var j models.ExportJob
+	if err := db.Where("user_id = ? AND status IN ?", userID, []string{models.ExportPending, models.ExportRunning}).Order("id").First(&j).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &j, nil
+}
+
+// ClaimExportJob marks the oldest pending export as running and returns it.
+// Running jobs untouched since staleBefore are taken over as well, as their
+// worker is assumed to have died. Claims are safe against other replicas:
+// a job is only claimed by whoever bumps its attempt count first. It returns
+// ErrNotFound when there is nothing to do.
+func (s *Store) ClaimExportJob(ctx context.Context, staleBefore time.Time) (*models.ExportJob, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	for {
+		var j models.ExportJob
+		err := db.Where("status = ? OR (status = ? AND updated_at < ?)", models.ExportPending, models.ExportRunning, staleBefore).Order("id").First(&j).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		res := db.Model(&models.ExportJob{}).Where("id = ? AND attempts = ?", j.ID, j.Attempts).Updates(map[string]interface{}{"status": models.ExportRunning, "attempts": j.Attempts + 1, "progress": 0, "updated_at": time.Now()})
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		if res.RowsAffected == 1 {
+			j.Status = models.ExportRunning
+			j.Attempts++
+			j.Progress = 0
+			return &j, nil
+		}
+		// another worker claimed it first
+	}
+}
+
+func (s *Store) UpdateExportJob(ctx context.Context, j *models.ExportJob) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	return db.Save(j).Error
+}
+
+// ExpiredExportJobs returns finished exports whose download window closed before now
+func (s *Store) ExpiredExportJobs(ctx context.Context, now time.Time, limit int) ([]models.ExportJob, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	var js []models.ExportJob
+	if err := db.Where("status = ? AND expires_at < ?", models.ExportDone, now).Order("id").Limit(limit).Find(&js).Error; err != nil {
+		return nil, err
+	}
+	return js, nil
+}
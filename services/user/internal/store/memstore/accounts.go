@@ -0,0 +1,103 @@
+package memstore
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"sort"
+	"time"
+
+	"services/user/internal/models"
+	"services/user/internal/store"
+)
+
+// hashToken returns the hex SHA-256 under which a confirmation token is kept
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// CreateEmailChange records a pending change of userID's email to newEmail
+// that expires after ttl, and returns the confirmation token. Any earlier
+// pending change of the user is replaced and expired changes are dropped.
+func (s *Store) CreateEmailChange(ctx context.Context, userID uint, newEmail string, ttl time.Duration) (*models.EmailChange, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return nil, "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b[:])
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	kept := s.emailChanges[:0]
+	var last uint
+	for _, ec := range s.emailChanges {
+		last = max(last, ec.ID)
+		if ec.UserID != userID && !ec.ExpiresAt.Before(now) {
+			kept = append(kept, ec)
+		}
+	}
+	ec := models.EmailChange{ID: last + 1, CreatedAt: now, UserID: userID, NewEmail: newEmail, TokenHash: hashToken(token), ExpiresAt: now.Add(ttl)}
+	s.emailChanges = append(kept, ec)
+	return &ec, token, nil
+}
+
+// GetEmailChangeByToken returns the pending change confirmed by token.
+// Unknown and expired tokens both return ErrNotFound.
+func (s *Store) GetEmailChangeByToken(ctx context.Context, token string) (*models.EmailChange, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hash, now := hashToken(token), time.Now()
+	for _, ec := range s.emailChanges {
+		if ec.TokenHash == hash && ec.ExpiresAt.After(now) {
+			return &ec, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+// DeleteEmailChanges drops every pending email change of a user
+func (s *Store) DeleteEmailChanges(ctx context.Context, userID uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.emailChanges[:0]
+	for _, ec := range s.emailChanges {
+		if ec.UserID != userID {
+			kept = append(kept, ec)
+		}
+	}
+	s.emailChanges = kept
+	return nil
+}
+
+// DueUserDeletions returns users whose requested deletion is due at now,
+// earliest first
+func (s *Store) DueUserDeletions(ctx context.Context, now time.Time, limit int) ([]models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	us := []models.User{}
+	for _, u := range s.users {
+		if !u.DeletedAt.Valid && u.DeletionDueAt != nil && !u.DeletionDueAt.After(now) {
+			us = append(us, u)
+		}
+	}
+	sort.Slice(us, func(i, j int) bool { return us[i].DeletionDueAt.Before(*us[j].DeletionDueAt) })
+	if limit > 0 && len(us) > limit {
+		us = us[:limit]
+	}
+	return us, nil
+}
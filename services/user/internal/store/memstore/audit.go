@@ -0,0 +1,70 @@
+package memstore
+
+import (
+	"context"
+	"time"
+
+	"services/user/internal/models"
+	"services/user/internal/store"
+)
+
+// AppendAuditEvent stores ev. Events are not hash chained, so the chain
+// fields are left empty.
+func (s *Store) AppendAuditEvent(ctx context.Context, ev *models.AuditEvent) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ev.ID = uint(len(s.events) + 1)
+	if ev.CreatedAt.IsZero() {
+		ev.CreatedAt = time.Now()
+	}
+	s.events = append(s.events, *ev)
+	return nil
+}
+
+// ListAuditEvents returns events newest first
+func (s *Store) ListAuditEvents(ctx context.Context, f store.AuditFilter) ([]models.AuditEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	evs := []models.AuditEvent{}
+	for i := len(s.events) - 1; i >= 0; i-- {
+		ev := s.events[i]
+		switch {
+		case f.ActorID != 0 && (ev.ActorID == nil || *ev.ActorID != f.ActorID):
+		case f.Action != "" && ev.Action != f.Action:
+		case f.TargetType != "" && ev.TargetType != f.TargetType:
+		case f.TargetID != "" && ev.TargetID != f.TargetID:
+		case f.Result != "" && ev.Result != f.Result:
+		case !f.Since.IsZero() && ev.CreatedAt.Before(f.Since):
+		case !f.Until.IsZero() && !ev.CreatedAt.Before(f.Until):
+		case f.Before != 0 && ev.ID >= f.Before:
+		default:
+			evs = append(evs, ev)
+		}
+		if f.Limit > 0 && len(evs) == f.Limit {
+			break
+		}
+	}
+	return evs, nil
+}
+
+// RedactedUserIDs returns those of ids that were purged or anonymized
+func (s *Store) RedactedUserIDs(ctx context.Context, ids []uint) (map[uint]bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := map[uint]bool{}
+	for _, id := range ids {
+		if u, ok := s.users[id]; !ok || u.AnonymizedAt != nil {
+			out[id] = true
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,599 @@
+// Package memstore is an in-memory implementation of the store repositories
+// for tests. It mirrors the behaviour of the GORM store: users are soft
+// deleted, emails and role names are unique, and reads return copies.
+// Groups, settings, exports and search are not modelled; see ErrNotModelled.
+package memstore
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"services/user/internal/models"
+	"services/user/internal/store"
+)
+
+// Store keeps users, roles, role assignments, audit events, email changes
+// and webhooks in maps and slices. Methods fail with the context's error
+// once it is done, like queries on a cancelled context. No groups exist,
+// so GetUserRoles only returns directly assigned roles.
+type Store struct {
+	mu sync.Mutex
+	data
+}
+
+// data is everything a Store holds, so a transaction can save and restore it
+type data struct {
+	users        map[uint]models.User
+	roles        map[uint]models.Role
+	userRoles    []models.UserRole
+	events       []models.AuditEvent
+	emailChanges []models.EmailChange
+	webhooks     map[uint]models.Webhook
+	deliveries   []models.WebhookDelivery
+	nextUser     uint
+	nextRole     uint
+	nextWebhook  uint
+	nextDelivery uint
+}
+
+var (
+	_ store.UserRepository = (*Store)(nil)
+	_ store.RoleRepository = (*Store)(nil)
+	_ store.Repository     = (*Store)(nil)
+)
+
+func New() *Store {
+	return &Store{data: data{users: map[uint]models.User{}, roles: map[uint]models.Role{}, webhooks: map[uint]models.Webhook{}}}
+}
+
+// emailTaken reports whether another live user has email; like the partial
+// unique index in the database, soft-deleted users do not count
+func (s *Store) emailTaken(email string, except uint) bool {
+	for id, u := range s.users {
+		if id != except && u.Email == email && !u.DeletedAt.Valid {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) CreateUser(ctx context.Context, u *models.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[u.ID]; ok && u.ID != 0 {
+		return gorm.ErrDuplicatedKey
+	}
+	if s.emailTaken(u.Email, 0) {
+		return gorm.ErrDuplicatedKey
+	}
+	if u.ID == 0 {
+		s.nextUser++
+		u.ID = s.nextUser
+	} else if u.ID > s.nextUser {
+		s.nextUser = u.ID
+	}
+	u.Version = 1
+	if u.Profile.Plan == "" {
+		u.Profile.Plan = models.DefaultPlan
+	}
+	now := time.Now()
+	if u.CreatedAt.IsZero() {
+		u.CreatedAt = now
+	}
+	if u.UpdatedAt.IsZero() {
+		u.UpdatedAt = now
+	}
+	s.users[u.ID] = *u
+	return nil
+}
+
+func (s *Store) GetUserByID(ctx context.Context, id uint) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok || u.DeletedAt.Valid {
+		return nil, store.ErrNotFound
+	}
+	return &u, nil
+}
+
+func (s *Store) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if u.Email == email && !u.DeletedAt.Valid {
+			return &u, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+// sortKey is the position of u in a user list ordered by sort
+func sortKey(sort string, u *models.User) store.UserCursor {
+	c := store.UserCursor{Sort: sort, ID: u.ID}
+	switch strings.TrimPrefix(sort, "-") {
+	case store.UserSortEmail:
+		c.Email = u.Email
+	case store.UserSortCreatedAt:
+		c.CreatedAt = u.CreatedAt
+	}
+	return c
+}
+
+// compareKeys orders two keys of the same sort ascending; only the field
+// of that sort is set, so comparing all of them is enough
+func compareKeys(a, b store.UserCursor) int {
+	if c := strings.Compare(a.Email, b.Email); c != 0 {
+		return c
+	}
+	if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.ID, b.ID)
+}
+
+// hasRole reports whether a role called name is assigned to the user
+func (s *Store) hasRole(userID uint, name string) bool {
+	for _, ur := range s.userRoles {
+		if ur.UserID == userID && s.roles[ur.RoleID].Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// matchUsers returns the live, or with f.Deleted the deleted, users matching f, ignoring its cursor and
+// limit, in f.Sort order
+func (s *Store) matchUsers(f store.UserFilter) []models.User {
+	q := strings.ToLower(f.Query)
+	us := []models.User{}
+	for _, u := range s.users {
+		switch {
+		case u.DeletedAt.Valid != f.Deleted:
+		case q != "" && !strings.Contains(strings.ToLower(u.Email), q) && !strings.Contains(strings.ToLower(u.FullName), q):
+		case f.Role != "" && !s.hasRole(u.ID, f.Role):
+		case f.ExternalID != "" && u.ExternalID != f.ExternalID:
+		case !f.CreatedAfter.IsZero() && !u.CreatedAt.After(f.CreatedAfter):
+		default:
+			us = append(us, u)
+		}
+	}
+	desc := strings.HasPrefix(f.Sort, "-")
+	sort.Slice(us, func(i, j int) bool {
+		c := compareKeys(sortKey(f.Sort, &us[i]), sortKey(f.Sort, &us[j]))
+		return desc && c > 0 || !desc && c < 0
+	})
+	return us
+}
+
+func (s *Store) ListUsers(ctx context.Context, f store.UserFilter) ([]models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	desc := strings.HasPrefix(f.Sort, "-")
+	us := []models.User{}
+	for _, u := range s.matchUsers(f) {
+		if f.After != nil {
+			c := compareKeys(sortKey(f.Sort, &u), *f.After)
+			if desc && c >= 0 || !desc && c <= 0 {
+				continue
+			}
+		}
+		if f.Limit > 0 && len(us) == f.Limit {
+			break
+		}
+		us = append(us, u)
+	}
+	return us, nil
+}
+
+func (s *Store) CountUsers(ctx context.Context, f store.UserFilter) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(len(s.matchUsers(f))), nil
+}
+
+// UpdateUser saves every field of u if the stored user is still at u.Version
+func (s *Store) UpdateUser(ctx context.Context, u *models.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.users[u.ID]
+	if !ok || cur.DeletedAt.Valid {
+		return store.ErrNotFound
+	}
+	if cur.Version != u.Version {
+		return store.ErrVersionConflict
+	}
+	if s.emailTaken(u.Email, u.ID) {
+		return gorm.ErrDuplicatedKey
+	}
+	u.Version++
+	u.UpdatedAt = time.Now()
+	u.CreatedAt, u.DeletedAt = cur.CreatedAt, cur.DeletedAt
+	s.users[u.ID] = *u
+	return nil
+}
+
+// DeleteUser soft deletes; deleting a missing user is not an error
+func (s *Store) DeleteUser(ctx context.Context, id uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok || u.DeletedAt.Valid {
+		return nil
+	}
+	u.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	s.users[id] = u
+	return nil
+}
+
+func (s *Store) GetDeletedUser(ctx context.Context, id uint) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok || !u.DeletedAt.Valid {
+		return nil, store.ErrNotFound
+	}
+	return &u, nil
+}
+
+func (s *Store) RestoreUser(ctx context.Context, id uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok || !u.DeletedAt.Valid || u.AnonymizedAt != nil {
+		return store.ErrNotFound
+	}
+	if s.emailTaken(u.Email, id) {
+		return gorm.ErrDuplicatedKey
+	}
+	u.DeletedAt = gorm.DeletedAt{}
+	s.users[id] = u
+	return nil
+}
+
+func (s *Store) PurgeUser(ctx context.Context, id uint) (store.PurgedBlobs, error) {
+	if err := ctx.Err(); err != nil {
+		return store.PurgedBlobs{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok || !u.DeletedAt.Valid {
+		return store.PurgedBlobs{}, store.ErrNotFound
+	}
+	var blobs store.PurgedBlobs
+	if u.AvatarKey != "" {
+		blobs.AvatarKeys = append(blobs.AvatarKeys, u.AvatarKey)
+	}
+	s.purge(id)
+	return blobs, nil
+}
+
+func (s *Store) PurgeDeletedUsers(ctx context.Context, cutoff time.Time) (int64, store.PurgedBlobs, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, store.PurgedBlobs{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	var blobs store.PurgedBlobs
+	for id, u := range s.users {
+		if u.DeletedAt.Valid && u.DeletedAt.Time.Before(cutoff) {
+			if u.AvatarKey != "" {
+				blobs.AvatarKeys = append(blobs.AvatarKeys, u.AvatarKey)
+			}
+			s.purge(id)
+			n++
+		}
+	}
+	return n, blobs, nil
+}
+
+// purge removes a user and their role assignments
+func (s *Store) purge(id uint) {
+	delete(s.users, id)
+	kept := s.userRoles[:0]
+	for _, ur := range s.userRoles {
+		if ur.UserID != id {
+			kept = append(kept, ur)
+		}
+	}
+	s.userRoles = kept
+}
+
+func (s *Store) CreateRole(ctx context.Context, r *models.Role) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.roles {
+		if existing.Name == r.Name {
+			return gorm.ErrDuplicatedKey
+		}
+	}
+	s.nextRole++
+	r.ID = s.nextRole
+	s.roles[r.ID] = *r
+	return nil
+}
+
+func (s *Store) GetRoleByName(ctx context.Context, name string) (*models.Role, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.roles {
+		if r.Name == name {
+			return &r, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (s *Store) EnsureRole(ctx context.Context, name string) (*models.Role, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if r, err := s.GetRoleByName(ctx, name); err == nil {
+		return r, nil
+	}
+	r := &models.Role{Name: name}
+	if err := s.CreateRole(ctx, r); err != nil && !errors.Is(err, gorm.ErrDuplicatedKey) {
+		return nil, err
+	}
+	return s.GetRoleByName(ctx, name)
+}
+
+// AssignRoleToUser records the assignment as-is; like the user_roles table
+// it neither checks references nor rejects duplicates
+func (s *Store) AssignRoleToUser(ctx context.Context, userID, roleID uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.userRoles = append(s.userRoles, models.UserRole{ID: uint(len(s.userRoles) + 1), UserID: userID, RoleID: roleID})
+	return nil
+}
+
+// RemoveRoleFromUser drops every assignment of roleID to userID
+func (s *Store) RemoveRoleFromUser(ctx context.Context, userID, roleID uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.userRoles[:0]
+	for _, ur := range s.userRoles {
+		if ur.UserID != userID || ur.RoleID != roleID {
+			kept = append(kept, ur)
+		}
+	}
+	s.userRoles = kept
+	return nil
+}
+
+func (s *Store) GetRolesForUsers(ctx context.Context, userIDs []uint) (map[uint][]models.Role, error) {
+	out := map[uint][]models.Role{}
+	for _, id := range userIDs {
+		roles, err := s.GetUserRoles(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if len(roles) > 0 {
+			out[id] = roles
+		}
+	}
+	return out, nil
+}
+
+// GetUserRoles returns the distinct roles assigned to a user ordered by ID
+func (s *Store) GetUserRoles(ctx context.Context, userID uint) ([]models.Role, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen := map[uint]bool{}
+	roles := []models.Role{}
+	for _, ur := range s.userRoles {
+		r, ok := s.roles[ur.RoleID]
+		if ur.UserID != userID || !ok || seen[r.ID] {
+			continue
+		}
+		seen[r.ID] = true
+		roles = append(roles, r)
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].ID < roles[j].ID })
+	return roles, nil
+}
+
+// GetDirectUserRoles is GetUserRoles, since every role is assigned directly
+func (s *Store) GetDirectUserRoles(ctx context.Context, userID uint) ([]models.Role, error) {
+	return s.GetUserRoles(ctx, userID)
+}
+
+// GetDirectRolesForUsers is GetRolesForUsers, since every role is assigned
+// directly
+func (s *Store) GetDirectRolesForUsers(ctx context.Context, userIDs []uint) (map[uint][]models.Role, error) {
+	return s.GetRolesForUsers(ctx, userIDs)
+}
+
+func (s *Store) GetRoleByID(ctx context.Context, id uint) (*models.Role, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.roles[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return &r, nil
+}
+
+// ListRoles returns all roles in ID order
+func (s *Store) ListRoles(ctx context.Context) ([]models.Role, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	roles := make([]models.Role, 0, len(s.roles))
+	for _, r := range s.roles {
+		roles = append(roles, r)
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].ID < roles[j].ID })
+	return roles, nil
+}
+
+// UpdateRole saves the name and external ID of a role
+func (s *Store) UpdateRole(ctx context.Context, r *models.Role) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.roles[r.ID]
+	if !ok {
+		return store.ErrNotFound
+	}
+	for id, existing := range s.roles {
+		if id != r.ID && existing.Name == r.Name {
+			return gorm.ErrDuplicatedKey
+		}
+	}
+	cur.Name, cur.ExternalID = r.Name, r.ExternalID
+	s.roles[r.ID] = cur
+	return nil
+}
+
+// DeleteRole deletes a role along with its assignments
+func (s *Store) DeleteRole(ctx context.Context, id uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.roles[id]; !ok {
+		return store.ErrNotFound
+	}
+	delete(s.roles, id)
+	kept := s.userRoles[:0]
+	for _, ur := range s.userRoles {
+		if ur.RoleID != id {
+			kept = append(kept, ur)
+		}
+	}
+	s.userRoles = kept
+	return nil
+}
+
+// roleHolders returns the IDs of the live users holding roleID, ordered
+func (s *Store) roleHolders(roleID uint) []uint {
+	seen := map[uint]bool{}
+	var ids []uint
+	for _, ur := range s.userRoles {
+		u, ok := s.users[ur.UserID]
+		if ur.RoleID != roleID || !ok || u.DeletedAt.Valid || seen[u.ID] {
+			continue
+		}
+		seen[u.ID] = true
+		ids = append(ids, u.ID)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// GetRoleMembers returns the live users holding the role in ID order
+func (s *Store) GetRoleMembers(ctx context.Context, roleID uint) ([]models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	us := []models.User{}
+	for _, id := range s.roleHolders(roleID) {
+		us = append(us, s.users[id])
+	}
+	return us, nil
+}
+
+// SetRoleMembers makes userIDs the users holding the role and returns those
+// it added and removed. Users that do not exist, or are deleted, are
+// reported with ErrNotFound; deleted users keep the role.
+func (s *Store) SetRoleMembers(ctx context.Context, roleID uint, userIDs []uint) (added, removed []uint, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	want := map[uint]bool{}
+	for _, id := range userIDs {
+		if u, ok := s.users[id]; !ok || u.DeletedAt.Valid {
+			return nil, nil, store.ErrNotFound
+		}
+		want[id] = true
+	}
+	have := map[uint]bool{}
+	for _, id := range s.roleHolders(roleID) {
+		have[id] = true
+		if !want[id] {
+			removed = append(removed, id)
+		}
+	}
+	for _, id := range userIDs {
+		if !have[id] {
+			have[id] = true
+			added = append(added, id)
+		}
+	}
+	kept := s.userRoles[:0]
+	for _, ur := range s.userRoles {
+		if ur.RoleID != roleID || !slices.Contains(removed, ur.UserID) {
+			kept = append(kept, ur)
+		}
+	}
+	s.userRoles = kept
+	for _, id := range added {
+		s.userRoles = append(s.userRoles, models.UserRole{ID: uint(len(s.userRoles) + 1), UserID: id, RoleID: roleID})
+	}
+	return added, removed, nil
+}
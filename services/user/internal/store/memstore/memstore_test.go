@@ -0,0 +1,59 @@
+package memstore_test
+
+import (
+	"errors"
+	"testing"
+
+	"services/user/internal/models"
+	"services/user/internal/store"
+	"services/user/internal/store/memstore"
+	"services/user/internal/store/storetest"
+)
+
+func TestMemStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) storetest.Repos {
+		s := memstore.New()
+		return storetest.Repos{Users: s, Roles: s}
+	})
+}
+
+func TestAuditedRollsBack(t *testing.T) {
+	ctx := t.Context()
+	s := memstore.New()
+	fail := errors.New("fail")
+	ev := &models.AuditEvent{Action: "user.register"}
+	err := s.Audited(ctx, ev, func(tx store.Repository) error {
+		if err := tx.CreateUser(ctx, &models.User{Email: "a@example.com"}); err != nil {
+			return err
+		}
+		return tx.WithTx(ctx, func(tx store.Repository) error {
+			if _, err := tx.EnsureRole(ctx, "user"); err != nil {
+				return err
+			}
+			return fail
+		})
+	})
+	if !errors.Is(err, fail) {
+		t.Fatalf("Audited = %v; want the callback's error", err)
+	}
+	if _, err := s.GetUserByEmail(ctx, "a@example.com"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("user created in a failed transaction: %v", err)
+	}
+	if _, err := s.GetRoleByName(ctx, "user"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("role created in a failed nested transaction: %v", err)
+	}
+	evs, err := s.ListAuditEvents(ctx, store.AuditFilter{})
+	if err != nil || len(evs) != 1 || evs[0].Result != models.AuditFailure || evs[0].Detail != "fail" {
+		t.Errorf("audit events = %+v, %v; want the failure alone", evs, err)
+	}
+
+	err = s.Audited(ctx, &models.AuditEvent{Action: "user.register"}, func(tx store.Repository) error {
+		return tx.CreateUser(ctx, &models.User{Email: "a@example.com"})
+	})
+	if err != nil {
+		t.Fatalf("Audited: %v", err)
+	}
+	if evs, _ := s.ListAuditEvents(ctx, store.AuditFilter{Result: models.AuditSuccess}); len(evs) != 1 {
+		t.Errorf("success events = %+v; want one", evs)
+	}
+}
@@ -0,0 +1,72 @@
+package memstore
+
+import (
+	"context"
+	"maps"
+	"slices"
+
+	"services/user/internal/models"
+	"services/user/internal/store"
+)
+
+// clone copies d so that changes to either leave the other alone
+func (d *data) clone() data {
+	c := *d
+	c.users = maps.Clone(d.users)
+	c.roles = maps.Clone(d.roles)
+	c.webhooks = maps.Clone(d.webhooks)
+	c.userRoles = slices.Clone(d.userRoles)
+	c.events = slices.Clone(d.events)
+	c.emailChanges = slices.Clone(d.emailChanges)
+	c.deliveries = slices.Clone(d.deliveries)
+	return c
+}
+
+// WithTx runs fn on the store itself and puts back everything it held before
+// if fn fails or panics. Unlike a database transaction it is not isolated:
+// other callers see the changes of fn while it runs, and a rollback undoes
+// theirs too, so tests should not run transactions concurrently.
+func (s *Store) WithTx(ctx context.Context, fn func(tx store.Repository) error) (err error) {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	saved := s.data.clone()
+	s.mu.Unlock()
+	committed := false
+	defer func() {
+		if !committed {
+			s.mu.Lock()
+			s.data = saved
+			s.mu.Unlock()
+		}
+	}()
+	if err := fn(s); err != nil {
+		return err
+	}
+	// like a commit on a cancelled context
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
+
+// Audited runs fn in a transaction and appends ev with it. If fn fails the
+// change is rolled back and ev is appended on its own with a failure result.
+func (s *Store) Audited(ctx context.Context, ev *models.AuditEvent, fn func(tx store.Repository) error) error {
+	err := s.WithTx(ctx, func(tx store.Repository) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		ev.Result = models.AuditSuccess
+		return tx.AppendAuditEvent(ctx, ev)
+	})
+	if err != nil {
+		ev.ID = 0
+		ev.Result = models.AuditFailure
+		ev.Detail = err.Error()
+		s.AppendAuditEvent(context.WithoutCancel(ctx), ev)
+	}
+	return err
+}
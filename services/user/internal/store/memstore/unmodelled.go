@@ -0,0 +1,103 @@
+package memstore
+
+import (
+	"context"
+	"errors"
+
+	"services/user/internal/models"
+	"services/user/internal/scim"
+	"services/user/internal/search"
+	"services/user/internal/store"
+)
+
+// ErrNotModelled is returned by the methods of what the in-memory store
+// does not keep: creating groups and changing them, settings, exports,
+// search and anonymization. Reads of groups and exports behave as if there
+// are none.
+var ErrNotModelled = errors.New("not modelled by the in-memory store")
+
+func (s *Store) CreateGroup(ctx context.Context, g *models.Group) error {
+	return ErrNotModelled
+}
+
+func (s *Store) GetGroupByID(ctx context.Context, id uint) (*models.Group, error) {
+	return nil, store.ErrNotFound
+}
+
+func (s *Store) ListGroups(ctx context.Context) ([]models.Group, error) {
+	return []models.Group{}, ctx.Err()
+}
+
+func (s *Store) UpdateGroup(ctx context.Context, g *models.Group) error {
+	return ErrNotModelled
+}
+
+func (s *Store) DeleteGroup(ctx context.Context, id uint) error {
+	return ErrNotModelled
+}
+
+func (s *Store) AddUserToGroup(ctx context.Context, groupID, userID uint) error {
+	return ErrNotModelled
+}
+
+func (s *Store) RemoveUserFromGroup(ctx context.Context, groupID, userID uint) error {
+	return ErrNotModelled
+}
+
+func (s *Store) GetGroupMembers(ctx context.Context, groupID uint) ([]models.User, error) {
+	return []models.User{}, ctx.Err()
+}
+
+func (s *Store) GetUserGroups(ctx context.Context, userID uint) ([]models.Group, error) {
+	return []models.Group{}, ctx.Err()
+}
+
+func (s *Store) AssignRoleToGroup(ctx context.Context, groupID, roleID uint) error {
+	return ErrNotModelled
+}
+
+func (s *Store) RemoveRoleFromGroup(ctx context.Context, groupID, roleID uint) error {
+	return ErrNotModelled
+}
+
+func (s *Store) GetGroupRoles(ctx context.Context, groupID uint) ([]models.Role, error) {
+	return []models.Role{}, ctx.Err()
+}
+
+func (s *Store) GetUserSettings(ctx context.Context, userID uint) (*models.UserSettings, error) {
+	return nil, ErrNotModelled
+}
+
+func (s *Store) SaveUserSettings(ctx context.Context, us *models.UserSettings) error {
+	return ErrNotModelled
+}
+
+func (s *Store) CreateExportJob(ctx context.Context, j *models.ExportJob) error {
+	return ErrNotModelled
+}
+
+func (s *Store) GetExportJob(ctx context.Context, userID, id uint) (*models.ExportJob, error) {
+	return nil, store.ErrNotFound
+}
+
+func (s *Store) GetActiveExportJob(ctx context.Context, userID uint) (*models.ExportJob, error) {
+	return nil, store.ErrNotFound
+}
+
+func (s *Store) AnonymizeUser(ctx context.Context, id uint) ([]string, error) {
+	return nil, ErrNotModelled
+}
+
+func (s *Store) SearchUsers(ctx context.Context, q store.UserSearch) ([]store.SearchHit, []search.Term, error) {
+	return nil, nil, ErrNotModelled
+}
+
+// UserSearchIndex names no index, as SearchUsers is not modelled
+func (s *Store) UserSearchIndex() string {
+	return "none"
+}
+
+// ListSCIMUsers leaves every filter to the caller's in-memory fallback
+func (s *Store) ListSCIMUsers(ctx context.Context, f scim.Filter, offset, limit int) ([]models.User, int64, error) {
+	return nil, 0, store.ErrUnsupportedFilter
+}
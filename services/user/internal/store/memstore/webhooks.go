@@ -0,0 +1,169 @@
+package memstore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"services/user/internal/models"
+	"services/user/internal/store"
+)
+
+func (s *Store) CreateWebhook(ctx context.Context, wh *models.Webhook) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextWebhook++
+	wh.ID = s.nextWebhook
+	now := time.Now()
+	wh.CreatedAt, wh.UpdatedAt = now, now
+	s.webhooks[wh.ID] = *wh
+	return nil
+}
+
+func (s *Store) GetWebhookByID(ctx context.Context, id uint) (*models.Webhook, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	wh, ok := s.webhooks[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return &wh, nil
+}
+
+func (s *Store) ListWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	whs := make([]models.Webhook, 0, len(s.webhooks))
+	for _, wh := range s.webhooks {
+		whs = append(whs, wh)
+	}
+	sort.Slice(whs, func(i, j int) bool { return whs[i].ID < whs[j].ID })
+	return whs, nil
+}
+
+// UpdateWebhook saves every field of wh, creating it if it does not exist
+// like the GORM store's Save
+func (s *Store) UpdateWebhook(ctx context.Context, wh *models.Webhook) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	wh.UpdatedAt = time.Now()
+	s.webhooks[wh.ID] = *wh
+	return nil
+}
+
+// DeleteWebhook removes a webhook and its delivery log
+func (s *Store) DeleteWebhook(ctx context.Context, id uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.webhooks, id)
+	kept := s.deliveries[:0]
+	for _, d := range s.deliveries {
+		if d.WebhookID != id {
+			kept = append(kept, d)
+		}
+	}
+	s.deliveries = kept
+	return nil
+}
+
+// EnqueueWebhookEvent queues a delivery of event, about user userID, to
+// every active webhook subscribed to it
+func (s *Store) EnqueueWebhookEvent(ctx context.Context, event string, userID uint, data interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	env := store.WebhookEnvelope{ID: hex.EncodeToString(id[:]), Event: event, CreatedAt: now, Data: data}
+	payload, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var whIDs []uint
+	for _, wh := range s.webhooks {
+		if wh.Active && wh.Subscribed(event) {
+			whIDs = append(whIDs, wh.ID)
+		}
+	}
+	sort.Slice(whIDs, func(i, j int) bool { return whIDs[i] < whIDs[j] })
+	for _, whID := range whIDs {
+		s.nextDelivery++
+		s.deliveries = append(s.deliveries, models.WebhookDelivery{
+			ID: s.nextDelivery, CreatedAt: now, UpdatedAt: now, WebhookID: whID, EventID: env.ID, Event: event,
+			UserID: userID, Payload: string(payload), Status: models.DeliveryPending, NextAttemptAt: now,
+		})
+	}
+	return nil
+}
+
+func (s *Store) GetWebhookDelivery(ctx context.Context, webhookID, id uint) (*models.WebhookDelivery, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range s.deliveries {
+		if d.ID == id && d.WebhookID == webhookID {
+			return &d, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+// ListWebhookDeliveries returns the delivery log of a webhook, newest first
+func (s *Store) ListWebhookDeliveries(ctx context.Context, webhookID uint, status string, limit int) ([]models.WebhookDelivery, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ds := []models.WebhookDelivery{}
+	for i := len(s.deliveries) - 1; i >= 0 && (limit <= 0 || len(ds) < limit); i-- {
+		if d := s.deliveries[i]; d.WebhookID == webhookID && (status == "" || d.Status == status) {
+			ds = append(ds, d)
+		}
+	}
+	return ds, nil
+}
+
+// UpdateWebhookDelivery saves every field of d but its payload
+func (s *Store) UpdateWebhookDelivery(ctx context.Context, d *models.WebhookDelivery) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, cur := range s.deliveries {
+		if cur.ID == d.ID {
+			d.UpdatedAt = time.Now()
+			next := *d
+			next.Payload = cur.Payload
+			s.deliveries[i] = next
+			return nil
+		}
+	}
+	return store.ErrNotFound
+}
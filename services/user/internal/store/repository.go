@@ -0,0 +1,130 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"services/user/internal/models"
+	"services/user/internal/scim"
+	"services/user/internal/search"
+)
+
+// UserRepository persists users. Store implements it with GORM and
+// memstore.Store in memory, so handlers can run without a database. Every
+// method takes the caller's context, so a cancelled request or an expired
+// deadline aborts the query.
+type UserRepository interface {
+	CreateUser(ctx context.Context, u *models.User) error
+	GetUserByID(ctx context.Context, id uint) (*models.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	ListUsers(ctx context.Context, f UserFilter) ([]models.User, error)
+	CountUsers(ctx context.Context, f UserFilter) (int64, error)
+	UpdateUser(ctx context.Context, u *models.User) error
+	DeleteUser(ctx context.Context, id uint) error
+	GetDeletedUser(ctx context.Context, id uint) (*models.User, error)
+	RestoreUser(ctx context.Context, id uint) error
+	PurgeUser(ctx context.Context, id uint) (PurgedBlobs, error)
+	PurgeDeletedUsers(ctx context.Context, cutoff time.Time) (int64, PurgedBlobs, error)
+}
+
+// RoleRepository persists roles and their assignment to users
+type RoleRepository interface {
+	CreateRole(ctx context.Context, r *models.Role) error
+	GetRoleByName(ctx context.Context, name string) (*models.Role, error)
+	EnsureRole(ctx context.Context, name string) (*models.Role, error)
+	AssignRoleToUser(ctx context.Context, userID, roleID uint) error
+	RemoveRoleFromUser(ctx context.Context, userID, roleID uint) error
+	GetUserRoles(ctx context.Context, userID uint) ([]models.Role, error)
+	GetDirectUserRoles(ctx context.Context, userID uint) ([]models.Role, error)
+	GetRolesForUsers(ctx context.Context, userIDs []uint) (map[uint][]models.Role, error)
+	GetDirectRolesForUsers(ctx context.Context, userIDs []uint) (map[uint][]models.Role, error)
+	GetRoleByID(ctx context.Context, id uint) (*models.Role, error)
+	ListRoles(ctx context.Context) ([]models.Role, error)
+	UpdateRole(ctx context.Context, r *models.Role) error
+	DeleteRole(ctx context.Context, id uint) error
+	GetRoleMembers(ctx context.Context, roleID uint) ([]models.User, error)
+	SetRoleMembers(ctx context.Context, roleID uint, userIDs []uint) (added, removed []uint, err error)
+}
+
+// GroupRepository persists groups, their members and the roles they grant
+type GroupRepository interface {
+	CreateGroup(ctx context.Context, g *models.Group) error
+	GetGroupByID(ctx context.Context, id uint) (*models.Group, error)
+	ListGroups(ctx context.Context) ([]models.Group, error)
+	UpdateGroup(ctx context.Context, g *models.Group) error
+	DeleteGroup(ctx context.Context, id uint) error
+	AddUserToGroup(ctx context.Context, groupID, userID uint) error
+	RemoveUserFromGroup(ctx context.Context, groupID, userID uint) error
+	GetGroupMembers(ctx context.Context, groupID uint) ([]models.User, error)
+	GetUserGroups(ctx context.Context, userID uint) ([]models.Group, error)
+	AssignRoleToGroup(ctx context.Context, groupID, roleID uint) error
+	RemoveRoleFromGroup(ctx context.Context, groupID, roleID uint) error
+	GetGroupRoles(ctx context.Context, groupID uint) ([]models.Role, error)
+}
+
+// WebhookRepository persists webhooks and the deliveries queued for them
+type WebhookRepository interface {
+	CreateWebhook(ctx context.Context, wh *models.Webhook) error
+	GetWebhookByID(ctx context.Context, id uint) (*models.Webhook, error)
+	ListWebhooks(ctx context.Context) ([]models.Webhook, error)
+	UpdateWebhook(ctx context.Context, wh *models.Webhook) error
+	DeleteWebhook(ctx context.Context, id uint) error
+	EnqueueWebhookEvent(ctx context.Context, event string, userID uint, data interface{}) error
+	GetWebhookDelivery(ctx context.Context, webhookID, id uint) (*models.WebhookDelivery, error)
+	ListWebhookDeliveries(ctx context.Context, webhookID uint, status string, limit int) ([]models.WebhookDelivery, error)
+	UpdateWebhookDelivery(ctx context.Context, d *models.WebhookDelivery) error
+}
+
+// AuditRepository appends to and reads the audit log
+type AuditRepository interface {
+	AppendAuditEvent(ctx context.Context, ev *models.AuditEvent) error
+	ListAuditEvents(ctx context.Context, f AuditFilter) ([]models.AuditEvent, error)
+	RedactedUserIDs(ctx context.Context, ids []uint) (map[uint]bool, error)
+}
+
+// AccountRepository persists what users manage about their own accounts:
+// pending email changes, settings, data exports and scheduled deletion
+type AccountRepository interface {
+	CreateEmailChange(ctx context.Context, userID uint, newEmail string, ttl time.Duration) (*models.EmailChange, string, error)
+	GetEmailChangeByToken(ctx context.Context, token string) (*models.EmailChange, error)
+	DeleteEmailChanges(ctx context.Context, userID uint) error
+	GetUserSettings(ctx context.Context, userID uint) (*models.UserSettings, error)
+	SaveUserSettings(ctx context.Context, us *models.UserSettings) error
+	CreateExportJob(ctx context.Context, j *models.ExportJob) error
+	GetExportJob(ctx context.Context, userID, id uint) (*models.ExportJob, error)
+	GetActiveExportJob(ctx context.Context, userID uint) (*models.ExportJob, error)
+	DueUserDeletions(ctx context.Context, now time.Time, limit int) ([]models.User, error)
+	AnonymizeUser(ctx context.Context, id uint) ([]string, error)
+}
+
+// SearchRepository finds users by free text and by SCIM filter
+type SearchRepository interface {
+	SearchUsers(ctx context.Context, q UserSearch) ([]SearchHit, []search.Term, error)
+	UserSearchIndex() string
+	ListSCIMUsers(ctx context.Context, f scim.Filter, offset, limit int) ([]models.User, int64, error)
+}
+
+// Repository is every repository the handlers use, together with the units
+// of work that span them
+type Repository interface {
+	UserRepository
+	RoleRepository
+	GroupRepository
+	WebhookRepository
+	AuditRepository
+	AccountRepository
+	SearchRepository
+
+	// WithTx runs fn on a Repository bound to one transaction, committed
+	// when fn returns nil and rolled back otherwise. Calls nest.
+	WithTx(ctx context.Context, fn func(tx Repository) error) error
+	// Audited runs fn like WithTx and records ev in the same transaction,
+	// or on its own with a failure result when fn fails.
+	Audited(ctx context.Context, ev *models.AuditEvent, fn func(tx Repository) error) error
+}
+
+var (
+	_ UserRepository = (*Store)(nil)
+	_ RoleRepository = (*Store)(nil)
+	_ Repository     = (*Store)(nil)
+)
@@ -0,0 +1,141 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	"gorm.io/gorm"
+
+	"services/user/internal/models"
+)
+
+// GetRoleByID returns ErrNotFound when there is no such role
+func (s *Store) GetRoleByID(ctx context.Context, id uint) (*models.Role, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	var r models.Role
+	if err := db.First(&r, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &r, nil
+}
+
+// ListRoles returns all roles in ID order
+func (s *Store) ListRoles(ctx context.Context) ([]models.Role, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	var roles []models.Role
+	if err := db.Order("id").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// UpdateRole saves the name and external ID of a role
+func (s *Store) UpdateRole(ctx context.Context, r *models.Role) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	res := db.Model(r).Select("name", "external_id").Updates(r)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteRole deletes a role along with its assignments to users and groups
+func (s *Store) DeleteRole(ctx context.Context, id uint) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("role_id = ?", id).Delete(&models.UserRole{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("role_id = ?", id).Delete(&models.GroupRole{}).Error; err != nil {
+			return err
+		}
+		res := tx.Delete(&models.Role{}, id)
+		if res.Error == nil && res.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return res.Error
+	})
+}
+
+// GetRoleMembers returns the users that are not deleted and hold the role
+// directly, leaving out those granted it through groups
+func (s *Store) GetRoleMembers(ctx context.Context, roleID uint) ([]models.User, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	var us []models.User
+	direct := db.Model(&models.UserRole{}).Select("user_id").Where("role_id = ?", roleID)
+	if err := db.Where("id IN (?)", direct).Order("id").Find(&us).Error; err != nil {
+		return nil, err
+	}
+	return us, nil
+}
+
+// SetRoleMembers makes userIDs the users holding the role directly and
+// returns those it added and removed. Users that do not exist, or are
+// deleted, are reported with ErrNotFound; deleted users keep the role.
+func (s *Store) SetRoleMembers(ctx context.Context, roleID uint, userIDs []uint) (added, removed []uint, err error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	want := map[uint]bool{}
+	for _, id := range userIDs {
+		want[id] = true
+	}
+	if len(want) > 0 {
+		var n int64
+		ids := make([]uint, 0, len(want))
+		for id := range want {
+			ids = append(ids, id)
+		}
+		if err := db.Model(&models.User{}).Where("id IN ?", ids).Count(&n).Error; err != nil {
+			return nil, nil, err
+		}
+		if int(n) != len(ids) {
+			return nil, nil, ErrNotFound
+		}
+	}
+	var current []uint
+	live := db.Model(&models.User{}).Select("id")
+	if err := db.Model(&models.UserRole{}).Where("role_id = ? AND user_id IN (?)", roleID, live).Distinct().Order("user_id").Pluck("user_id", &current).Error; err != nil {
+		return nil, nil, err
+	}
+	have := map[uint]bool{}
+	for _, id := range current {
+		have[id] = true
+		if !want[id] {
+			removed = append(removed, id)
+		}
+	}
+	for _, id := range userIDs {
+		if !have[id] {
+			have[id] = true
+			added = append(added, id)
+		}
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if len(removed) > 0 {
+			if err := tx.Where("role_id = ? AND user_id IN ?", roleID, removed).Delete(&models.UserRole{}).Error; err != nil {
+				return err
+			}
+		}
+		for _, id := range added {
+			if err := tx.Create(&models.UserRole{UserID: id, RoleID: roleID}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return added, removed, nil
+}
@@ -0,0 +1,216 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"services/user/internal/fieldcrypt"
+	"services/user/internal/models"
+	"services/user/internal/scim"
+)
+
+// ErrUnsupportedFilter is returned by ListSCIMUsers for filters it cannot
+// run in the database
+var ErrUnsupportedFilter = errors.New("filter cannot be run in the database")
+
+// ListSCIMUsers returns the live users whose SCIM resource matches f, nil
+// for all, in ID order, skipping the first offset and returning at most
+// limit of them, and how many match in all. Attributes derived from other
+// data, such as name.givenName and groups, cannot be compared in SQL, nor
+// can encrypted columns except by equality of the email; for filters using
+// them it returns ErrUnsupportedFilter.
+func (s *Store) ListSCIMUsers(ctx context.Context, f scim.Filter, offset, limit int) ([]models.User, int64, error) {
+	var cond string
+	var args []interface{}
+	if f != nil {
+		var ok bool
+		if cond, args, ok = scim.SQL(f, scimUserColumns()); !ok {
+			return nil, 0, ErrUnsupportedFilter
+		}
+	}
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	q := db.Model(&models.User{})
+	if cond != "" {
+		q = q.Where(cond, args...)
+	}
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if limit <= 0 || int64(offset) >= total {
+		return nil, total, nil
+	}
+	var us []models.User
+	if err := q.Order("users.id").Offset(offset).Limit(limit).Find(&us).Error; err != nil {
+		return nil, 0, err
+	}
+	return us, total, nil
+}
+
+// scimUserColumns maps the attributes of the SCIM user resource onto the
+// users table, comparing as scim.Filter does
+func scimUserColumns() map[string]scim.Column {
+	email := exactColumn("users.email")
+	if fieldcrypt.Enabled() {
+		email = blindColumn
+	}
+	cols := map[string]scim.Column{
+		"id":                idColumn,
+		"externalid":        exactColumn("users.external_id"),
+		"username":          email,
+		"emails":            email,
+		"emails.value":      email,
+		"displayname":       caselessColumn("users.display_name"),
+		"active":            activeColumn,
+		"meta.created":      timeColumn("users.created_at"),
+		"meta.lastmodified": timeColumn("users.updated_at"),
+	}
+	if !fieldcrypt.Enabled() {
+		cols["name.formatted"] = caselessColumn("users.full_name")
+	}
+	return cols
+}
+
+// exactColumn compares a string column case-sensitively
+func exactColumn(col string) scim.Column {
+	return func(op string, value interface{}) (string, []interface{}, bool) {
+		v, isString := value.(string)
+		switch {
+		case op == "pr":
+			return "COALESCE(" + col + ", '') <> ''", nil, true
+		case op == "eq" && v == "" && isString:
+			// empty values are left out of the resource
+			return "1 = 0", nil, true
+		case op == "eq" && isString:
+			return "COALESCE(" + col + ", '') = ?", []interface{}{v}, true
+		}
+		return "", nil, false
+	}
+}
+
+// caselessColumn compares a string column ignoring case
+func caselessColumn(col string) scim.Column {
+	lower := "LOWER(COALESCE(" + col + ", ''))"
+	r := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+	return func(op string, value interface{}) (string, []interface{}, bool) {
+		if op == "pr" {
+			return lower + " <> ''", nil, true
+		}
+		v, ok := value.(string)
+		if !ok {
+			return "", nil, false
+		}
+		v = strings.ToLower(v)
+		if v == "" && (op == "eq" || op == "co" || op == "sw" || op == "ew") {
+			// empty values are left out of the resource, anything else
+			// contains, starts and ends with ""
+			if op == "eq" {
+				return "1 = 0", nil, true
+			}
+			return lower + " <> ''", nil, true
+		}
+		switch op {
+		case "eq":
+			return lower + " = ?", []interface{}{v}, true
+		case "co":
+			return lower + " LIKE ? ESCAPE '!'", []interface{}{"%" + r.Replace(v) + "%"}, true
+		case "sw":
+			return lower + " LIKE ? ESCAPE '!'", []interface{}{r.Replace(v) + "%"}, true
+		case "ew":
+			return lower + " LIKE ? ESCAPE '!'", []interface{}{"%" + r.Replace(v)}, true
+		}
+		// ordering depends on the collation
+		return "", nil, false
+	}
+}
+
+// blindColumn compares the encrypted email through its blind index
+func blindColumn(op string, value interface{}) (string, []interface{}, bool) {
+	v, isString := value.(string)
+	switch {
+	case op == "pr":
+		// only empty emails are stored empty
+		return "COALESCE(users.email, '') <> ''", nil, true
+	case op == "eq" && isString:
+		return "COALESCE(users.email_index, '') = ?", []interface{}{fieldcrypt.BlindIndex(v)}, true
+	}
+	return "", nil, false
+}
+
+// idColumn compares the ID, which the resource holds as a string
+func idColumn(op string, value interface{}) (string, []interface{}, bool) {
+	v, isString := value.(string)
+	switch {
+	case op == "pr":
+		return "1 = 1", nil, true
+	case op == "eq" && isString:
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || strconv.FormatUint(id, 10) != v {
+			return "1 = 0", nil, true
+		}
+		return "users.id = ?", []interface{}{id}, true
+	}
+	return "", nil, false
+}
+
+// activeColumn compares active, which is whether the user is not disabled
+func activeColumn(op string, value interface{}) (string, []interface{}, bool) {
+	v, isBool := value.(bool)
+	switch {
+	case op == "pr":
+		return "1 = 1", nil, true
+	case op == "eq" && isBool && v:
+		return "users.disabled_at IS NULL", nil, true
+	case op == "eq" && isBool:
+		return "users.disabled_at IS NOT NULL", nil, true
+	}
+	return "", nil, false
+}
+
+// timeColumn compares a timestamp, which the resource holds in whole
+// seconds, with a date
+func timeColumn(col string) scim.Column {
+	return func(op string, value interface{}) (string, []interface{}, bool) {
+		if op == "pr" {
+			return "1 = 1", nil, true
+		}
+		v, ok := value.(string)
+		if !ok {
+			return "", nil, false
+		}
+		w, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return "", nil, false
+		}
+		// timestamps are written in local time, which SQLite compares as text
+		w = w.Local()
+		// the resource holds t truncated to the second, which is after w
+		// exactly when t is at or after the first whole second after w
+		floor := w.Truncate(time.Second)
+		next := floor.Add(time.Second)
+		ceil := floor
+		if !w.Equal(floor) {
+			ceil = next
+		}
+		switch op {
+		case "eq":
+			if !w.Equal(floor) {
+				return "1 = 0", nil, true
+			}
+			return "(" + col + " >= ? AND " + col + " < ?)", []interface{}{floor, next}, true
+		case "gt":
+			return col + " >= ?", []interface{}{next}, true
+		case "ge":
+			return col + " >= ?", []interface{}{ceil}, true
+		case "lt":
+			return col + " < ?", []interface{}{ceil}, true
+		case "le":
+			return col + " < ?", []interface{}{next}, true
+		}
+		return "", nil, false
+	}
+}
@@ -0,0 +1,81 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"services/user/internal/models"
+	"services/user/internal/scim"
+	"services/user/internal/store"
+)
+
+func TestListSCIMUsers(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewStore(openTestDB(t), 5*time.Second)
+	disabled := time.Now()
+	us := []*models.User{
+		{Email: "ann@example.com", FullName: "Ann Lee", ExternalID: "ext-a", Profile: models.Profile{DisplayName: "Annie"}},
+		{Email: "bob@example.com", FullName: "Bob Stone", DisabledAt: &disabled},
+		{Email: "cy@example.com", FullName: "Cy Twombly", ExternalID: "ext-c"},
+	}
+	for _, u := range us {
+		if err := s.CreateUser(ctx, u); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+	}
+	ann, bob, cy := us[0].Email, us[1].Email, us[2].Email
+	for _, tc := range []struct {
+		filter string
+		want   []string
+	}{
+		{"", []string{ann, bob, cy}},
+		{`userName eq "bob@example.com"`, []string{bob}},
+		{`emails[value eq "cy@example.com"]`, []string{cy}},
+		{`externalId pr`, []string{ann, cy}},
+		{`not (externalId pr)`, []string{bob}},
+		{`externalId ne "ext-a"`, []string{bob, cy}},
+		{`active eq false`, []string{bob}},
+		{`displayName co "NNI"`, []string{ann}},
+		{`displayName eq ""`, nil},
+		{`name.formatted sw "cy"`, []string{cy}},
+		{`userName eq "ann@example.com" or active eq false`, []string{ann, bob}},
+		{`meta.created gt "2000-01-01T00:00:00Z"`, []string{ann, bob, cy}},
+		{`meta.lastModified lt "2000-01-01T00:00:00Z"`, nil},
+		{`id eq "` + strconv.FormatUint(uint64(us[2].ID), 10) + `"`, []string{cy}},
+	} {
+		var f scim.Filter
+		if tc.filter != "" {
+			var err error
+			if f, err = scim.ParseFilter(tc.filter); err != nil {
+				t.Fatalf("ParseFilter(%q): %v", tc.filter, err)
+			}
+		}
+		found, total, err := s.ListSCIMUsers(ctx, f, 0, 10)
+		var got []string
+		for _, u := range found {
+			got = append(got, u.Email)
+		}
+		if err != nil || !reflect.DeepEqual(got, tc.want) || total != int64(len(tc.want)) {
+			t.Errorf("ListSCIMUsers(%q) = %v, %d, %v; want %v", tc.filter, got, total, err, tc.want)
+		}
+	}
+
+	page, total, err := s.ListSCIMUsers(ctx, nil, 1, 1)
+	if err != nil || len(page) != 1 || page[0].Email != bob || total != 3 {
+		t.Errorf("ListSCIMUsers(offset 1, limit 1) = %v, %d, %v; want [%s] of 3", page, total, err, bob)
+	}
+
+	for _, filter := range []string{`name.givenName eq "Ann"`, `emails[type eq "work"]`, `groups pr`} {
+		f, err := scim.ParseFilter(filter)
+		if err != nil {
+			t.Fatalf("ParseFilter(%q): %v", filter, err)
+		}
+		if _, _, err := s.ListSCIMUsers(ctx, f, 0, 10); !errors.Is(err, store.ErrUnsupportedFilter) {
+			t.Errorf("ListSCIMUsers(%q) error = %v; want ErrUnsupportedFilter", filter, err)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"services/user/internal/fieldcrypt"
+	"services/user/internal/models"
+	"services/user/internal/search"
+)
+
+// SearchHit is a user found by SearchUsers
+type SearchHit struct {
+	User models.User
+	// Score is how well the user matched; higher is better
+	Score float64
+}
+
+// UserSearch is a full-text search for users by email and full name
+type UserSearch struct {
+	// Query is the text to search for; users must match each of its words
+	Query string
+	Limit int
+	// Fuzzy also matches words a few typos away from those of the query
+	// when prefixes alone find fewer than Limit users
+	Fuzzy bool
+}
+
+// searchCandidates is how many users per requested hit are read from the
+// index, to be ranked again by search.Score
+const searchCandidates = 4
+
+// userIndex is a full-text index of the words in users' emails and full
+// names. The database keeps it in step with the users table.
+type userIndex interface {
+	// name identifies the index in logs and responses
+	name() string
+	// match returns up to limit live users that have, for every term, a
+	// word starting with the term or equal to one of its fuzzy
+	// alternatives, best first
+	match(db *gorm.DB, terms []search.Term, limit int) ([]models.User, error)
+	// vocabulary returns the indexed words of lo to hi runes, or nil when
+	// the index cannot list its words
+	vocabulary(db *gorm.DB, lo, hi int) ([]string, error)
+}
+
+// indexFor returns the index of db's dialect as created by the migrations.
+// The SQLite index is only used once PrepareUserSearch has found it.
+func indexFor(db *gorm.DB) userIndex {
+	switch db.Dialector.Name() {
+	case "postgres":
+		return tsvectorIndex{}
+	case "mysql":
+		return fulltextIndex{}
+	}
+	return likeIndex{}
+}
+
+// fts5Index is a SQLite FTS5 table, users_fts, fed by triggers on users
+type fts5Index struct{}
+
+func (fts5Index) name() string { return "fts5" }
+
+func (fts5Index) match(db *gorm.DB, terms []search.Term, limit int) ([]models.User, error) {
+	quote := func(s string) string { return `"` + strings.ReplaceAll(s, `"`, `""`) + `"` }
+	groups := make([]string, len(terms))
+	for i, t := range terms {
+		alts := []string{quote(t.Text) + "*"}
+		for _, f := range t.Fuzzy {
+			alts = append(alts, quote(f))
+		}
+		groups[i] = "(" + strings.Join(alts, " OR ") + ")"
+	}
+	var us []models.User
+	err := db.Joins("JOIN users_fts ON users_fts.rowid = users.id").
+		Where("users_fts MATCH ?", strings.Join(groups, " AND ")).
+		Order("bm25(users_fts)").Limit(limit).Find(&us).Error
+	return us, err
+}
+
+func (fts5Index) vocabulary(db *gorm.DB, lo, hi int) ([]string, error) {
+	var words []string
+	err := db.Raw("SELECT term FROM users_fts_vocab WHERE length(term) BETWEEN ? AND ?", lo, hi).Scan(&words).Error
+	return words, err
+}
+
+// tsvectorIndex is the generated users.search column of Postgres
+type tsvectorIndex struct{}
+
+func (tsvectorIndex) name() string { return "tsvector" }
+
+func (tsvectorIndex) match(db *gorm.DB, terms []search.Term, limit int) ([]models.User, error) {
+	groups := make([]string, len(terms))
+	for i, t := range terms {
+		// terms are letters and digits only, so need no quoting
+		alts := []string{t.Text + ":*"}
+		alts = append(alts, t.Fuzzy...)
+		groups[i] = "(" + strings.Join(alts, " | ") + ")"
+	}
+	q := strings.Join(groups, " & ")
+	var us []models.User
+	err := db.Where("users.search @@ to_tsquery('simple', ?)", q).
+		Order(clause.OrderBy{Expression: clause.Expr{SQL: "ts_rank(users.search, to_tsquery('simple', ?)) DESC", Vars: []interface{}{q}, WithoutParentheses: true}}).
+		Limit(limit).Find(&us).Error
+	return us, err
+}
+
+func (tsvectorIndex) vocabulary(db *gorm.DB, lo, hi int) ([]string, error) {
+	var words []string
+	err := db.Raw("SELECT word FROM ts_stat('SELECT search FROM users WHERE deleted_at IS NULL') WHERE length(word) BETWEEN ? AND ?", lo, hi).Scan(&words).Error
+	return words, err
+}
+
+// fulltextIndex is the FULLTEXT index of MySQL. InnoDB does not expose its
+// words, so it matches prefixes only.
+type fulltextIndex struct{}
+
+func (fulltextIndex) name() string { return "fulltext" }
+
+func (fulltextIndex) match(db *gorm.DB, terms []search.Term, limit int) ([]models.User, error) {
+	groups := make([]string, len(terms))
+	for i, t := range terms {
+		// every word is searched as a prefix, which InnoDB also applies to
+		// words shorter than its minimum token size and to stopwords
+		groups[i] = "+(" + t.Text + "*)"
+	}
+	q := strings.Join(groups, " ")
+	var us []models.User
+	err := db.Where("MATCH(users.email, users.full_name) AGAINST (? IN BOOLEAN MODE)", q).
+		Order(clause.OrderBy{Expression: clause.Expr{SQL: "MATCH(users.email, users.full_name) AGAINST (? IN BOOLEAN MODE) DESC", Vars: []interface{}{q}, WithoutParentheses: true}}).
+		Limit(limit).Find(&us).Error
+	return us, err
+}
+
+func (fulltextIndex) vocabulary(*gorm.DB, int, int) ([]string, error) { return nil, nil }
+
+// likeIndex scans users for substrings when no index is available
+type likeIndex struct{}
+
+func (likeIndex) name() string { return "like" }
+
+func (likeIndex) match(db *gorm.DB, terms []search.Term, limit int) ([]models.User, error) {
+	q := db.Model(&models.User{})
+	for _, t := range terms {
+		p := likePattern(t.Text)
+		q = q.Where("(LOWER(users.email) LIKE ? ESCAPE '!' OR LOWER(users.full_name) LIKE ? ESCAPE '!')", p, p)
+	}
+	var us []models.User
+	err := q.Order("users.id").Limit(limit).Find(&us).Error
+	return us, err
+}
+
+func (likeIndex) vocabulary(*gorm.DB, int, int) ([]string, error) { return nil, nil }
+
+// The SQLite index and the triggers that keep it in step with users. The
+// vocabulary table lists the indexed words for fuzzy matching.
+const createFTS5 = `CREATE VIRTUAL TABLE IF NOT EXISTS users_fts USING fts5(email, full_name, content='users', content_rowid='id', tokenize='unicode61 remove_diacritics 2');
+CREATE VIRTUAL TABLE IF NOT EXISTS users_fts_vocab USING fts5vocab('users_fts', 'row');`
+
+var fts5Triggers = map[string]string{
+	"users_fts_insert": `CREATE TRIGGER IF NOT EXISTS users_fts_insert AFTER INSERT ON users BEGIN
+  INSERT INTO users_fts(rowid, email, full_name) VALUES (new.id, new.email, new.full_name);
+END`,
+	"users_fts_delete": `CREATE TRIGGER IF NOT EXISTS users_fts_delete AFTER DELETE ON users BEGIN
+  INSERT INTO users_fts(users_fts, rowid, email, full_name) VALUES ('delete', old.id, old.email, old.full_name);
+END`,
+	"users_fts_update": `CREATE TRIGGER IF NOT EXISTS users_fts_update AFTER UPDATE OF email, full_name ON users BEGIN
+  INSERT INTO users_fts(users_fts, rowid, email, full_name) VALUES ('delete', old.id, old.email, old.full_name);
+  INSERT INTO users_fts(rowid, email, full_name) VALUES (new.id, new.email, new.full_name);
+END`,
+}
+
+// PrepareUserSearch sets up the SQLite index of SearchUsers. FTS5 is only
+// compiled into builds tagged sqlite_fts5, so rather than in a migration
+// the index is created here when the driver supports it, and rebuilt
+// whenever its triggers were missing. Without FTS5 the triggers are
+// dropped so writes never depend on the module, and search falls back to
+// scanning. While field encryption is enabled the index tables are dropped
+// too, since they hold the words of plaintext emails and names; that needs
+// a build with FTS5 if an earlier one created them. Call it before users
+// are written; Postgres and MySQL indexes come from the migrations.
+func (s *Store) PrepareUserSearch(ctx context.Context) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	if db.Dialector.Name() != "sqlite" {
+		return nil
+	}
+	if fieldcrypt.Enabled() {
+		// the index would hold ciphertext; encrypted search uses the blind index
+		if err := dropFTS5Triggers(db); err != nil {
+			return err
+		}
+		if err := db.Exec("DROP TABLE IF EXISTS users_fts_vocab; DROP TABLE IF EXISTS users_fts").Error; err != nil {
+			return fmt.Errorf("drop user search index: %w", err)
+		}
+		return nil
+	}
+	// creating the existing table would succeed even without the module
+	var fts5 bool
+	if err := db.Raw("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5).Error; err != nil {
+		return err
+	}
+	if !fts5 {
+		log.Printf("user search falls back to LIKE: SQLite was built without FTS5 (build with -tags sqlite_fts5)")
+		return dropFTS5Triggers(db)
+	}
+	if err := db.Exec(createFTS5).Error; err != nil {
+		return fmt.Errorf("create user search index: %w", err)
+	}
+	names := make([]string, 0, len(fts5Triggers))
+	for name := range fts5Triggers {
+		names = append(names, name)
+	}
+	var n int64
+	if err := db.Raw("SELECT count(*) FROM sqlite_master WHERE type = 'trigger' AND name IN ?", names).Scan(&n).Error; err != nil {
+		return err
+	}
+	if int(n) < len(fts5Triggers) {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			for _, ddl := range fts5Triggers {
+				if err := tx.Exec(ddl).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Exec("INSERT INTO users_fts(users_fts) VALUES ('rebuild')").Error
+		})
+		if err != nil {
+			return fmt.Errorf("build user search index: %w", err)
+		}
+		log.Printf("user search index built")
+	}
+	s.index = fts5Index{}
+	return nil
+}
+
+func dropFTS5Triggers(db *gorm.DB) error {
+	for name := range fts5Triggers {
+		if err := db.Exec("DROP TRIGGER IF EXISTS " + name).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UserSearchIndex names the index SearchUsers runs on: fts5, tsvector,
+// fulltext, like, or blind while field encryption is enabled
+func (s *Store) UserSearchIndex() string {
+	if fieldcrypt.Enabled() {
+		return "blind"
+	}
+	return s.index.name()
+}
+
+// SearchUsers finds live users whose email or full name has words starting
+// with each word of the query, best matches first, and returns them with
+// the terms searched for, including the fuzzy alternatives tried. While
+// field encryption is enabled only the whole email can be matched.
+func (s *Store) SearchUsers(ctx context.Context, q UserSearch) ([]SearchHit, []search.Term, error) {
+	terms := search.Terms(q.Query)
+	if len(terms) == 0 {
+		return nil, nil, nil
+	}
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	if fieldcrypt.Enabled() {
+		var us []models.User
+		err := db.Where("users.email_index = ?", fieldcrypt.BlindIndex(strings.TrimSpace(q.Query))).Limit(1).Find(&us).Error
+		if err != nil {
+			return nil, nil, err
+		}
+		hits := make([]SearchHit, len(us))
+		for i, u := range us {
+			hits[i] = SearchHit{User: u, Score: search.Score(terms, u.Email, u.FullName)}
+		}
+		return hits, terms, nil
+	}
+	n := q.Limit * searchCandidates
+	us, err := s.index.match(db, terms, n)
+	if err != nil {
+		return nil, nil, err
+	}
+	if q.Fuzzy && len(us) < q.Limit {
+		if lo, hi := search.Lengths(terms); hi > 0 {
+			vocab, err := s.index.vocabulary(db, lo, hi)
+			if err != nil {
+				return nil, nil, err
+			}
+			search.Expand(terms, vocab)
+		}
+		if search.HasFuzzy(terms) {
+			more, err := s.index.match(db, terms, n)
+			if err != nil {
+				return nil, nil, err
+			}
+			us = append(us, more...)
+		}
+	}
+	hits := make([]SearchHit, 0, len(us))
+	seen := map[uint]bool{}
+	for i, u := range us {
+		if seen[u.ID] {
+			continue
+		}
+		seen[u.ID] = true
+		// the index's own order breaks ties
+		score := search.Score(terms, u.Email, u.FullName) + 0.01*float64(len(us)-i)/float64(len(us))
+		hits = append(hits, SearchHit{User: u, Score: score})
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+	if len(hits) > q.Limit {
+		hits = hits[:q.Limit]
+	}
+	return hits, terms, nil
+}
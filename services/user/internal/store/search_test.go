@@ -0,0 +1,53 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"services/user/internal/models"
+	"services/user/internal/store"
+)
+
+// TestEncryptionDropsSearchIndex needs a build with FTS5:
+//
+//	go test -tags sqlite_fts5 -run SearchIndex ./internal/store/
+func TestEncryptionDropsSearchIndex(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	if db.Dialector.Name() != "sqlite" {
+		t.Skip("the search index is only created on SQLite")
+	}
+	s := store.NewStore(db, 5*time.Second)
+	if err := s.PrepareUserSearch(ctx); err != nil {
+		t.Fatalf("PrepareUserSearch: %v", err)
+	}
+	if s.UserSearchIndex() != "fts5" {
+		t.Skip("SQLite was built without FTS5; run with -tags sqlite_fts5")
+	}
+	if err := s.CreateUser(ctx, &models.User{Email: "indexed@example.com", FullName: "Plain Text"}); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	searchObjects := func() []string {
+		var names []string
+		if err := db.Raw("SELECT name FROM sqlite_master WHERE name LIKE 'users_fts%' AND type IN ('table', 'trigger') ORDER BY name").Scan(&names).Error; err != nil {
+			t.Fatalf("read sqlite_master: %v", err)
+		}
+		return names
+	}
+	if names := searchObjects(); len(names) < 5 {
+		t.Fatalf("search index objects = %v; want the tables and triggers", names)
+	}
+
+	useTestKeyring(t)
+	if err := s.PrepareUserSearch(ctx); err != nil {
+		t.Fatalf("PrepareUserSearch with encryption: %v", err)
+	}
+	if names := searchObjects(); len(names) != 0 {
+		t.Errorf("search index objects with encryption = %v; want none", names)
+	}
+	// and again once they are gone
+	if err := s.PrepareUserSearch(ctx); err != nil {
+		t.Errorf("PrepareUserSearch without an index: %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	"gorm.io/gorm"
+
+	"services/user/internal/models"
+)
+
+// GetUserSettings returns a user's settings, or the defaults at version 0 if
+// they never saved any
+func (s *Store) GetUserSettings(ctx context.Context, userID uint) (*models.UserSettings, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	var us models.UserSettings
+	if err := db.Where("user_id = ?", userID).First(&us).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &models.UserSettings{UserID: userID, Settings: models.DefaultSettings()}, nil
+		}
+		return nil, err
+	}
+	return &us, nil
+}
+
+// SaveUserSettings stores us provided nobody saved since it was read at
+// us.Version, and bumps us.Version. It returns ErrVersionConflict otherwise.
+func (s *Store) SaveUserSettings(ctx context.Context, us *models.UserSettings) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	read := us.Version
+	us.Version++
+	var err error
+	if read == 0 {
+		if err = db.Create(us).Error; errors.Is(err, gorm.ErrDuplicatedKey) {
+			err = ErrVersionConflict
+		}
+	} else {
+		res := db.Model(us).Where("version = ?", read).Select("*").Updates(us)
+		if err = res.Error; err == nil && res.RowsAffected == 0 {
+			err = ErrVersionConflict
+		}
+	}
+	if err != nil {
+		us.Version = read
+	}
+	return err
+}
@@ -1,32 +1,85 @@
 package store
 
 import (
+	"context"
 	"errors"
+	"sync"
+	"time"
 
+	"services/user/internal/fieldcrypt"
 	"services/user/internal/models"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var (
 	ErrNotFound = errors.New("record not found")
+	// ErrVersionConflict means the row was updated by someone else since it was read
+	ErrVersionConflict = errors.New("version conflict")
 )
 
 type Store struct {
 	db *gorm.DB
+	// auditMu serializes audit appends so each event links to the current chain head
+	auditMu *sync.Mutex
+	// queryTimeout bounds every query on top of the caller's context; 0 disables it
+	queryTimeout time.Duration
+	// index serves SearchUsers; see PrepareUserSearch
+	index userIndex
 }
 
-func NewStore(db *gorm.DB) *Store {
-	return &Store{db: db}
+func NewStore(db *gorm.DB, queryTimeout time.Duration) *Store {
+	return &Store{db: db, auditMu: &sync.Mutex{}, queryTimeout: queryTimeout, index: indexFor(db)}
 }
 
-func (s *Store) CreateUser(u *models.User) error {
-	return s.db.Create(u).Error
+// conn returns the database bound to ctx and the query timeout. Call cancel
+// once the query has finished.
+func (s *Store) conn(ctx context.Context) (*gorm.DB, context.CancelFunc) {
+	if s.queryTimeout <= 0 {
+		return s.db.WithContext(ctx), func() {}
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.queryTimeout)
+	return s.db.WithContext(ctx), cancel
+}
+
+// bind returns a Store that runs its queries on tx
+func (s *Store) bind(tx *gorm.DB) *Store {
+	return &Store{db: tx, auditMu: s.auditMu, queryTimeout: s.queryTimeout, index: s.index}
+}
+
+// WithTx runs fn as a unit of work on a Store bound to one transaction. The
+// transaction commits when fn returns nil and rolls back on error, panic or
+// when ctx is done; calling WithTx on a transaction-bound Store nests it with
+// a savepoint. The query timeout applies to each statement, not to the whole
+// transaction.
+func (s *Store) WithTx(ctx context.Context, fn func(tx Repository) error) error {
+	return s.transaction(ctx, func(tx *Store) error { return fn(tx) })
+}
+
+// transaction is WithTx for the store's own methods, which need the bound
+// Store rather than the Repository it implements
+func (s *Store) transaction(ctx context.Context, fn func(tx *Store) error) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(s.bind(tx))
+	})
+}
+
+func (s *Store) CreateUser(ctx context.Context, u *models.User) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	u.Version = 1
+	if u.Profile.Plan == "" {
+		u.Profile.Plan = models.DefaultPlan
+	}
+	return db.Create(u).Error
 }
 
-func (s *Store) GetUserByID(id uint) (*models.User, error) {
+func (s *Store) GetUserByID(ctx context.Context, id uint) (*models.User, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
 	var u models.User
-	if err := s.db.First(&u, id).Error; err != nil {
+	if err := db.First(&u, id).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, ErrNotFound
 		}
@@ -35,9 +88,12 @@ func (s *Store) GetUserByID(id uint) (*models.User, error) {
 	return &u, nil
 }
 
-func (s *Store) GetUserByEmail(email string) (*models.User, error) {
+func (s *Store) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
 	var u models.User
-	if err := s.db.Where("email = ?", email).First(&u).Error; err != nil {
+	// emails may be encrypted, so they are looked up by their blind index
+	if err := db.Where("email_index = ?", fieldcrypt.BlindIndex(email)).First(&u).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, ErrNotFound
 		}
@@ -46,29 +102,45 @@ func (s *Store) GetUserByEmail(email string) (*models.User, error) {
 	return &u, nil
 }
 
-func (s *Store) ListUsers() ([]models.User, error) {
-	var us []models.User
-	if err := s.db.Find(&us).Error; err != nil {
-		return nil, err
+// UpdateUser saves every field of u provided the stored row is still at
+// u.Version, and bumps u.Version. It returns ErrVersionConflict when the row
+// has been updated since u was read and ErrNotFound when it no longer exists.
+func (s *Store) UpdateUser(ctx context.Context, u *models.User) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	read := u.Version
+	u.Version++
+	res := db.Model(u).Where("version = ?", read).Select("*").Omit("id", "created_at", "deleted_at").Updates(u)
+	if res.Error == nil && res.RowsAffected == 1 {
+		return nil
 	}
-	return us, nil
-}
-
-func (s *Store) UpdateUser(u *models.User) error {
-	return s.db.Save(u).Error
+	u.Version = read
+	if res.Error != nil {
+		return res.Error
+	}
+	if _, err := s.GetUserByID(ctx, u.ID); err != nil {
+		return err
+	}
+	return ErrVersionConflict
 }
 
-func (s *Store) DeleteUser(id uint) error {
-	return s.db.Delete(&models.User{}, id).Error
+func (s *Store) DeleteUser(ctx context.Context, id uint) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	return db.Delete(&models.User{}, id).Error
 }
 
-func (s *Store) CreateRole(r *models.Role) error {
-	return s.db.Create(r).Error
+func (s *Store) CreateRole(ctx context.Context, r *models.Role) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	return db.Create(r).Error
 }
 
-func (s *Store) GetRoleByName(name string) (*models.Role, error) {
+func (s *Store) GetRoleByName(ctx context.Context, name string) (*models.Role, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
 	var r models.Role
-	if err := s.db.Where("name = ?", name).First(&r).Error; err != nil {
+	if err := db.Where("name = ?", name).First(&r).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, ErrNotFound
 		}
@@ -77,14 +149,165 @@ func (s *Store) GetRoleByName(name string) (*models.Role, error) {
 	return &r, nil
 }
 
-func (s *Store) AssignRoleToUser(userID, roleID uint) error {
+// EnsureRole returns the role called name, creating it if needed. It is safe
+// against concurrent callers creating the same role.
+func (s *Store) EnsureRole(ctx context.Context, name string) (*models.Role, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.Role{Name: name}).Error; err != nil {
+		return nil, err
+	}
+	return s.GetRoleByName(ctx, name)
+}
+
+func (s *Store) AssignRoleToUser(ctx context.Context, userID, roleID uint) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
 	ur := models.UserRole{UserID: userID, RoleID: roleID}
-	return s.db.Create(&ur).Error
+	return db.Create(&ur).Error
+}
+
+// RemoveRoleFromUser revokes a directly assigned role; roles granted through
+// groups are unaffected. Removing a role the user lacks is not an error.
+func (s *Store) RemoveRoleFromUser(ctx context.Context, userID, roleID uint) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	return db.Where("user_id = ? AND role_id = ?", userID, roleID).Delete(&models.UserRole{}).Error
+}
+
+// GetDirectUserRoles returns the roles assigned to a user directly, leaving
+// out those granted through groups
+func (s *Store) GetDirectUserRoles(ctx context.Context, userID uint) ([]models.Role, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	var roles []models.Role
+	direct := db.Model(&models.UserRole{}).Select("role_id").Where("user_id = ?", userID)
+	if err := db.Where("id IN (?)", direct).Order("id").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// GetUserRoles returns the effective roles of a user: the union of roles
+// assigned directly and roles granted through group membership.
+func (s *Store) GetUserRoles(ctx context.Context, userID uint) ([]models.Role, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	var roles []models.Role
+	direct := db.Model(&models.UserRole{}).Select("role_id").Where("user_id = ?", userID)
+	viaGroups := db.Model(&models.GroupRole{}).Select("group_roles.role_id").
+		Joins("join group_members on group_members.group_id = group_roles.group_id").
+		Where("group_members.user_id = ?", userID)
+	if err := db.Where("id IN (?)", direct).Or("id IN (?)", viaGroups).Order("id").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (s *Store) CreateGroup(ctx context.Context, g *models.Group) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	return db.Create(g).Error
+}
+
+func (s *Store) GetGroupByID(ctx context.Context, id uint) (*models.Group, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	var g models.Group
+	if err := db.First(&g, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &g, nil
+}
+
+func (s *Store) ListGroups(ctx context.Context) ([]models.Group, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	var gs []models.Group
+	if err := db.Order("id").Find(&gs).Error; err != nil {
+		return nil, err
+	}
+	return gs, nil
+}
+
+func (s *Store) UpdateGroup(ctx context.Context, g *models.Group) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	return db.Save(g).Error
+}
+
+// DeleteGroup removes a group together with its memberships and role grants.
+func (s *Store) DeleteGroup(ctx context.Context, id uint) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("group_id = ?", id).Delete(&models.GroupMember{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("group_id = ?", id).Delete(&models.GroupRole{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Group{}, id).Error
+	})
+}
+
+// AddUserToGroup is idempotent: adding an existing member is not an error.
+func (s *Store) AddUserToGroup(ctx context.Context, groupID, userID uint) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	gm := models.GroupMember{GroupID: groupID, UserID: userID}
+	return db.Where(gm).FirstOrCreate(&gm).Error
+}
+
+func (s *Store) RemoveUserFromGroup(ctx context.Context, groupID, userID uint) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	return db.Where("group_id = ? AND user_id = ?", groupID, userID).Delete(&models.GroupMember{}).Error
+}
+
+func (s *Store) GetGroupMembers(ctx context.Context, groupID uint) ([]models.User, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	var us []models.User
+	if err := db.Joins("join group_members on group_members.user_id = users.id").Where("group_members.group_id = ?", groupID).Order("users.id").Find(&us).Error; err != nil {
+		return nil, err
+	}
+	return us, nil
+}
+
+// GetUserGroups returns the groups userID is a member of
+func (s *Store) GetUserGroups(ctx context.Context, userID uint) ([]models.Group, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	var gs []models.Group
+	if err := db.Joins("join group_members on group_members.group_id = groups.id").Where("group_members.user_id = ?", userID).Order("groups.id").Find(&gs).Error; err != nil {
+		return nil, err
+	}
+	return gs, nil
+}
+
+// AssignRoleToGroup is idempotent: granting a role twice is not an error.
+func (s *Store) AssignRoleToGroup(ctx context.Context, groupID, roleID uint) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	gr := models.GroupRole{GroupID: groupID, RoleID: roleID}
+	return db.Where(gr).FirstOrCreate(&gr).Error
+}
+
+func (s *Store) RemoveRoleFromGroup(ctx context.Context, groupID, roleID uint) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	return db.Where("group_id = ? AND role_id = ?", groupID, roleID).Delete(&models.GroupRole{}).Error
 }
 
-func (s *Store) GetUserRoles(userID uint) ([]models.Role, error) {
+func (s *Store) GetGroupRoles(ctx context.Context, groupID uint) ([]models.Role, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
 	var roles []models.Role
-	if err := s.db.Table("roles").Select("roles.*").Joins("join user_roles on user_roles.role_id = roles.id").Where("user_roles.user_id = ?", userID).Scan(&roles).Error; err != nil {
+	if err := db.Joins("join group_roles on group_roles.role_id = roles.id").Where("group_roles.group_id = ?", groupID).Order("roles.id").Find(&roles).Error; err != nil {
 		return nil, err
 	}
 	return roles, nil
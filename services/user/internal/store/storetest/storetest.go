@@ -0,0 +1,533 @@
+// Package storetest holds a contract suite for implementations of the store
+// repositories. Call it from a test with a factory returning empty
+// repositories, once per implementation; the GORM store must be opened with
+// TranslateError so duplicates surface as gorm.ErrDuplicatedKey:
+//
+//	func TestGormStore(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) storetest.Repos {
+//			s := store.NewStore(openTestDB(t), 5*time.Second)
+//			return storetest.Repos{Users: s, Roles: s}
+//		})
+//	}
+//
+//	func TestMemStore(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) storetest.Repos {
+//			s := memstore.New()
+//			return storetest.Repos{Users: s, Roles: s}
+//		})
+//	}
+package storetest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"services/user/internal/models"
+	"services/user/internal/store"
+)
+
+// Repos is the set of repositories under test, backed by one empty store
+type Repos struct {
+	Users store.UserRepository
+	Roles store.RoleRepository
+}
+
+// Run runs every contract test as a subtest, each against fresh repositories
+func Run(t *testing.T, newRepos func(t *testing.T) Repos) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, r Repos)
+	}{
+		{"CreateAndGetUser", testCreateAndGetUser},
+		{"DuplicateEmail", testDuplicateEmail},
+		{"UpdateUser", testUpdateUser},
+		{"DeleteUser", testDeleteUser},
+		{"SoftDeleteLifecycle", testSoftDeleteLifecycle},
+		{"ListUsers", testListUsers},
+		{"ListUsersPages", testListUsersPages},
+		{"ListUsersFilters", testListUsersFilters},
+		{"Roles", testRoles},
+		{"UserRoles", testUserRoles},
+		{"RolesForUsers", testRolesForUsers},
+		{"RoleMembers", testRoleMembers},
+		{"CancelledContext", testCancelledContext},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) { tc.fn(t, newRepos(t)) })
+	}
+}
+
+func mustCreateUser(t *testing.T, r Repos, email string) *models.User {
+	t.Helper()
+	u := &models.User{Email: email, FullName: "Test " + email}
+	if err := r.Users.CreateUser(t.Context(), u); err != nil {
+		t.Fatalf("CreateUser(%s): %v", email, err)
+	}
+	return u
+}
+
+func mustCreateRole(t *testing.T, r Repos, name string) *models.Role {
+	t.Helper()
+	role := &models.Role{Name: name}
+	if err := r.Roles.CreateRole(t.Context(), role); err != nil {
+		t.Fatalf("CreateRole(%s): %v", name, err)
+	}
+	return role
+}
+
+func testCreateAndGetUser(t *testing.T, r Repos) {
+	u := mustCreateUser(t, r, "a@example.com")
+	if u.ID == 0 {
+		t.Fatal("CreateUser did not assign an ID")
+	}
+	if u.CreatedAt.IsZero() || u.UpdatedAt.IsZero() {
+		t.Error("CreateUser did not set timestamps")
+	}
+	got, err := r.Users.GetUserByID(t.Context(), u.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if got.Email != u.Email || got.FullName != u.FullName {
+		t.Errorf("GetUserByID = %+v, want %+v", got, u)
+	}
+	got, err = r.Users.GetUserByEmail(t.Context(), "a@example.com")
+	if err != nil || got.ID != u.ID {
+		t.Errorf("GetUserByEmail = %v, %v; want user %d", got, err, u.ID)
+	}
+	if _, err := r.Users.GetUserByID(t.Context(), u.ID+1000); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetUserByID(missing) error = %v, want ErrNotFound", err)
+	}
+	if _, err := r.Users.GetUserByEmail(t.Context(), "missing@example.com"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetUserByEmail(missing) error = %v, want ErrNotFound", err)
+	}
+}
+
+func testDuplicateEmail(t *testing.T, r Repos) {
+	mustCreateUser(t, r, "dup@example.com")
+	if err := r.Users.CreateUser(t.Context(), &models.User{Email: "dup@example.com"}); err == nil {
+		t.Error("CreateUser with a taken email succeeded")
+	}
+	other := mustCreateUser(t, r, "other@example.com")
+	other.Email = "dup@example.com"
+	if err := r.Users.UpdateUser(t.Context(), other); err == nil {
+		t.Error("UpdateUser to a taken email succeeded")
+	}
+}
+
+func testUpdateUser(t *testing.T, r Repos) {
+	u := mustCreateUser(t, r, "b@example.com")
+	u.FullName = "Renamed"
+	u.Email = "b2@example.com"
+	if err := r.Users.UpdateUser(t.Context(), u); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	got, err := r.Users.GetUserByID(t.Context(), u.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if got.FullName != "Renamed" || got.Email != "b2@example.com" {
+		t.Errorf("after UpdateUser got %+v", got)
+	}
+	if _, err := r.Users.GetUserByEmail(t.Context(), "b@example.com"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("old email still resolves: %v", err)
+	}
+	if got.Version != 2 || u.Version != 2 {
+		t.Errorf("version after one update = %d (stored %d), want 2", u.Version, got.Version)
+	}
+
+	stale := *got
+	stale.Version = 1
+	stale.FullName = "Stale"
+	if err := r.Users.UpdateUser(t.Context(), &stale); !errors.Is(err, store.ErrVersionConflict) {
+		t.Errorf("UpdateUser(stale version) error = %v, want ErrVersionConflict", err)
+	}
+	if stale.Version != 1 {
+		t.Errorf("failed UpdateUser changed the version to %d", stale.Version)
+	}
+	missing := &models.User{ID: u.ID + 1000, Email: "missing@example.com", Version: 1}
+	if err := r.Users.UpdateUser(t.Context(), missing); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("UpdateUser(missing) error = %v, want ErrNotFound", err)
+	}
+}
+
+func testDeleteUser(t *testing.T, r Repos) {
+	u := mustCreateUser(t, r, "c@example.com")
+	if err := r.Users.DeleteUser(t.Context(), u.ID); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if _, err := r.Users.GetUserByID(t.Context(), u.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetUserByID after delete error = %v, want ErrNotFound", err)
+	}
+	if _, err := r.Users.GetUserByEmail(t.Context(), u.Email); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetUserByEmail after delete error = %v, want ErrNotFound", err)
+	}
+	if err := r.Users.DeleteUser(t.Context(), u.ID); err != nil {
+		t.Errorf("deleting twice: %v", err)
+	}
+}
+
+func testSoftDeleteLifecycle(t *testing.T, r Repos) {
+	ctx := t.Context()
+	old := mustCreateUser(t, r, "reuse@example.com")
+	role := mustCreateRole(t, r, "user")
+	if err := r.Roles.AssignRoleToUser(ctx, old.ID, role.ID); err != nil {
+		t.Fatalf("AssignRoleToUser: %v", err)
+	}
+	if err := r.Users.RestoreUser(ctx, old.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("RestoreUser(live) error = %v, want ErrNotFound", err)
+	}
+	if _, err := r.Users.PurgeUser(ctx, old.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("PurgeUser(live) error = %v, want ErrNotFound", err)
+	}
+	if err := r.Users.DeleteUser(ctx, old.ID); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if got, err := r.Users.GetDeletedUser(ctx, old.ID); err != nil || got.Email != old.Email {
+		t.Fatalf("GetDeletedUser = %v, %v", got, err)
+	}
+	deleted, err := r.Users.ListUsers(ctx, store.UserFilter{Deleted: true})
+	if err != nil || len(deleted) != 1 || deleted[0].ID != old.ID {
+		t.Errorf("ListUsers(Deleted) = %v, %v; want user %d", emails(deleted), err, old.ID)
+	}
+
+	// the email of a deleted user can be registered again, which blocks restoring it
+	reused := mustCreateUser(t, r, "reuse@example.com")
+	reused.AvatarKey = "avatars/reused"
+	if err := r.Users.UpdateUser(ctx, reused); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if err := r.Users.RestoreUser(ctx, old.ID); !errors.Is(err, gorm.ErrDuplicatedKey) {
+		t.Errorf("RestoreUser with the email taken error = %v, want ErrDuplicatedKey", err)
+	}
+	if err := r.Users.DeleteUser(ctx, reused.ID); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if err := r.Users.RestoreUser(ctx, old.ID); err != nil {
+		t.Fatalf("RestoreUser: %v", err)
+	}
+	if got, err := r.Users.GetUserByEmail(ctx, "reuse@example.com"); err != nil || got.ID != old.ID {
+		t.Errorf("GetUserByEmail after restore = %v, %v; want user %d", got, err, old.ID)
+	}
+	if roles, _ := r.Roles.GetUserRoles(ctx, old.ID); len(roles) != 1 {
+		t.Errorf("restored user roles = %v, want the role kept", roles)
+	}
+
+	// purge
+	if err := r.Users.DeleteUser(ctx, old.ID); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if blobs, err := r.Users.PurgeUser(ctx, old.ID); err != nil || len(blobs.AvatarKeys) != 0 {
+		t.Fatalf("PurgeUser = %v, %v; want no avatars", blobs, err)
+	}
+	if _, err := r.Users.GetDeletedUser(ctx, old.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetDeletedUser after purge error = %v, want ErrNotFound", err)
+	}
+	if roles, _ := r.Roles.GetUserRoles(ctx, old.ID); len(roles) != 0 {
+		t.Errorf("purged user still has roles %v", roles)
+	}
+	if n, _, err := r.Users.PurgeDeletedUsers(ctx, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("PurgeDeletedUsers(before deletion) = %d, %v; want 0", n, err)
+	}
+	n, blobs, err := r.Users.PurgeDeletedUsers(ctx, time.Now().Add(time.Hour))
+	if err != nil || n != 1 {
+		t.Errorf("PurgeDeletedUsers = %d, %v; want 1", n, err)
+	}
+	if len(blobs.AvatarKeys) != 1 || blobs.AvatarKeys[0] != reused.AvatarKey {
+		t.Errorf("PurgeDeletedUsers avatar keys = %v, want [%s]", blobs.AvatarKeys, reused.AvatarKey)
+	}
+	if _, err := r.Users.GetDeletedUser(ctx, reused.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetDeletedUser after PurgeDeletedUsers error = %v, want ErrNotFound", err)
+	}
+}
+
+func testListUsers(t *testing.T, r Repos) {
+	us, err := r.Users.ListUsers(t.Context(), store.UserFilter{})
+	if err != nil || len(us) != 0 {
+		t.Fatalf("ListUsers on empty store = %v, %v", us, err)
+	}
+	a := mustCreateUser(t, r, "d1@example.com")
+	b := mustCreateUser(t, r, "d2@example.com")
+	c := mustCreateUser(t, r, "d3@example.com")
+	if err := r.Users.DeleteUser(t.Context(), b.ID); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	us, err = r.Users.ListUsers(t.Context(), store.UserFilter{})
+	if err != nil {
+		t.Fatalf("ListUsers: %v", err)
+	}
+	if len(us) != 2 || us[0].ID != a.ID || us[1].ID != c.ID {
+		t.Errorf("ListUsers = %+v, want users %d and %d", us, a.ID, c.ID)
+	}
+}
+
+// seedUsers creates users with distinct creation times, one second apart,
+// and emails that sort in a different order than their IDs
+func seedUsers(t *testing.T, r Repos) []*models.User {
+	t.Helper()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var us []*models.User
+	for i, email := range []string{"carol@example.com", "alice@example.com", "erin@example.com", "bob@example.com", "dave@example.com"} {
+		u := &models.User{Email: email, FullName: strings.ToUpper(email[:1]) + email[1:strings.Index(email, "@")], CreatedAt: base.Add(time.Duration(i) * time.Second)}
+		if err := r.Users.CreateUser(t.Context(), u); err != nil {
+			t.Fatalf("CreateUser(%s): %v", email, err)
+		}
+		us = append(us, u)
+	}
+	return us
+}
+
+func emails(us []models.User) []string {
+	out := make([]string, 0, len(us))
+	for _, u := range us {
+		out = append(out, u.Email)
+	}
+	return out
+}
+
+func testListUsersPages(t *testing.T, r Repos) {
+	seedUsers(t, r)
+	want := map[string][]string{
+		store.UserSortID:              {"carol", "alice", "erin", "bob", "dave"},
+		"-" + store.UserSortID:        {"dave", "bob", "erin", "alice", "carol"},
+		store.UserSortCreatedAt:       {"carol", "alice", "erin", "bob", "dave"},
+		"-" + store.UserSortCreatedAt: {"dave", "bob", "erin", "alice", "carol"},
+		store.UserSortEmail:           {"alice", "bob", "carol", "dave", "erin"},
+		"-" + store.UserSortEmail:     {"erin", "dave", "carol", "bob", "alice"},
+	}
+	for sort, names := range want {
+		var got []string
+		f := store.UserFilter{Sort: sort, Limit: 2}
+		for pages := 0; ; pages++ {
+			if pages > len(names) {
+				t.Fatalf("sort %s: pagination does not terminate", sort)
+			}
+			us, err := r.Users.ListUsers(t.Context(), f)
+			if err != nil {
+				t.Fatalf("sort %s: ListUsers: %v", sort, err)
+			}
+			for _, e := range emails(us) {
+				got = append(got, e[:strings.Index(e, "@")])
+			}
+			if len(us) < f.Limit {
+				break
+			}
+			if f.After, err = store.ParseUserCursor(sort, store.NewUserCursor(sort, &us[len(us)-1])); err != nil {
+				t.Fatalf("sort %s: cursor round trip: %v", sort, err)
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(names, ",") {
+			t.Errorf("sort %s: pages = %v, want %v", sort, got, names)
+		}
+	}
+	if _, err := store.ParseUserCursor(store.UserSortEmail, store.NewUserCursor(store.UserSortID, &models.User{ID: 1})); !errors.Is(err, store.ErrInvalidCursor) {
+		t.Errorf("cursor for another sort error = %v, want ErrInvalidCursor", err)
+	}
+}
+
+func testListUsersFilters(t *testing.T, r Repos) {
+	us := seedUsers(t, r)
+	admin := mustCreateRole(t, r, "admin")
+	for _, u := range []*models.User{us[1], us[3]} {
+		if err := r.Roles.AssignRoleToUser(t.Context(), u.ID, admin.ID); err != nil {
+			t.Fatalf("AssignRoleToUser: %v", err)
+		}
+	}
+	if err := r.Users.DeleteUser(t.Context(), us[3].ID); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	tests := []struct {
+		name string
+		f    store.UserFilter
+		want []string
+	}{
+		{"query email", store.UserFilter{Query: "AR"}, []string{"carol@example.com"}},
+		{"query name", store.UserFilter{Query: "dav"}, []string{"dave@example.com"}},
+		{"query wildcard is literal", store.UserFilter{Query: "%"}, nil},
+		{"role", store.UserFilter{Role: "admin"}, []string{"alice@example.com"}},
+		{"created after", store.UserFilter{CreatedAfter: us[2].CreatedAt}, []string{"dave@example.com"}},
+		{"combined", store.UserFilter{Query: "e", CreatedAfter: us[0].CreatedAt, Sort: store.UserSortEmail}, []string{"alice@example.com", "dave@example.com", "erin@example.com"}},
+	}
+	for _, tc := range tests {
+		got, err := r.Users.ListUsers(t.Context(), tc.f)
+		if err != nil {
+			t.Fatalf("%s: ListUsers: %v", tc.name, err)
+		}
+		if strings.Join(emails(got), ",") != strings.Join(tc.want, ",") {
+			t.Errorf("%s: ListUsers = %v, want %v", tc.name, emails(got), tc.want)
+		}
+		n, err := r.Users.CountUsers(t.Context(), tc.f)
+		if err != nil || n != int64(len(tc.want)) {
+			t.Errorf("%s: CountUsers = %d, %v; want %d", tc.name, n, err, len(tc.want))
+		}
+	}
+}
+
+func testRoles(t *testing.T, r Repos) {
+	role := mustCreateRole(t, r, "admin")
+	if role.ID == 0 {
+		t.Fatal("CreateRole did not assign an ID")
+	}
+	got, err := r.Roles.GetRoleByName(t.Context(), "admin")
+	if err != nil || got.ID != role.ID {
+		t.Errorf("GetRoleByName = %v, %v; want role %d", got, err, role.ID)
+	}
+	if err := r.Roles.CreateRole(t.Context(), &models.Role{Name: "admin"}); err == nil {
+		t.Error("CreateRole with a taken name succeeded")
+	}
+	if _, err := r.Roles.GetRoleByName(t.Context(), "missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetRoleByName(missing) error = %v, want ErrNotFound", err)
+	}
+	ensured, err := r.Roles.EnsureRole(t.Context(), "admin")
+	if err != nil || ensured.ID != role.ID {
+		t.Errorf("EnsureRole(existing) = %v, %v; want role %d", ensured, err, role.ID)
+	}
+	created, err := r.Roles.EnsureRole(t.Context(), "auditor")
+	if err != nil || created.ID == 0 || created.Name != "auditor" {
+		t.Fatalf("EnsureRole(new) = %v, %v", created, err)
+	}
+	if got, err := r.Roles.GetRoleByName(t.Context(), "auditor"); err != nil || got.ID != created.ID {
+		t.Errorf("GetRoleByName after EnsureRole = %v, %v; want role %d", got, err, created.ID)
+	}
+}
+
+func testUserRoles(t *testing.T, r Repos) {
+	u := mustCreateUser(t, r, "e@example.com")
+	other := mustCreateUser(t, r, "f@example.com")
+	admin := mustCreateRole(t, r, "admin")
+	user := mustCreateRole(t, r, "user")
+	roles, err := r.Roles.GetUserRoles(t.Context(), u.ID)
+	if err != nil || len(roles) != 0 {
+		t.Fatalf("GetUserRoles without assignments = %v, %v", roles, err)
+	}
+	for _, id := range []uint{user.ID, admin.ID, user.ID} {
+		if err := r.Roles.AssignRoleToUser(t.Context(), u.ID, id); err != nil {
+			t.Fatalf("AssignRoleToUser: %v", err)
+		}
+	}
+	if err := r.Roles.AssignRoleToUser(t.Context(), other.ID, user.ID); err != nil {
+		t.Fatalf("AssignRoleToUser: %v", err)
+	}
+	roles, err = r.Roles.GetUserRoles(t.Context(), u.ID)
+	if err != nil {
+		t.Fatalf("GetUserRoles: %v", err)
+	}
+	if len(roles) != 2 || roles[0].ID != admin.ID || roles[1].ID != user.ID {
+		t.Errorf("GetUserRoles = %+v, want admin and user once each, ordered by ID", roles)
+	}
+
+	if err := r.Roles.RemoveRoleFromUser(t.Context(), u.ID, user.ID); err != nil {
+		t.Fatalf("RemoveRoleFromUser: %v", err)
+	}
+	if err := r.Roles.RemoveRoleFromUser(t.Context(), u.ID, user.ID); err != nil {
+		t.Errorf("RemoveRoleFromUser of a missing assignment: %v", err)
+	}
+	roles, err = r.Roles.GetDirectUserRoles(t.Context(), u.ID)
+	if err != nil {
+		t.Fatalf("GetDirectUserRoles: %v", err)
+	}
+	if len(roles) != 1 || roles[0].ID != admin.ID {
+		t.Errorf("GetDirectUserRoles after removing user = %+v, want only admin", roles)
+	}
+	if roles, _ := r.Roles.GetUserRoles(t.Context(), other.ID); len(roles) != 1 {
+		t.Errorf("RemoveRoleFromUser touched another user: %+v", roles)
+	}
+}
+
+func testCancelledContext(t *testing.T, r Repos) {
+	u := mustCreateUser(t, r, "g@example.com")
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if _, err := r.Users.GetUserByID(ctx, u.ID); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetUserByID on a cancelled context error = %v, want context.Canceled", err)
+	}
+	if err := r.Users.CreateUser(ctx, &models.User{Email: "h@example.com"}); err == nil {
+		t.Error("CreateUser on a cancelled context succeeded")
+	}
+	if _, err := r.Users.GetUserByEmail(t.Context(), "h@example.com"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("user created on a cancelled context: %v", err)
+	}
+}
+
+func testRolesForUsers(t *testing.T, r Repos) {
+	a := mustCreateUser(t, r, "i@example.com")
+	b := mustCreateUser(t, r, "j@example.com")
+	c := mustCreateUser(t, r, "k@example.com")
+	admin := mustCreateRole(t, r, "admin")
+	user := mustCreateRole(t, r, "user")
+	for _, ar := range [][2]uint{{a.ID, user.ID}, {a.ID, admin.ID}, {a.ID, user.ID}, {b.ID, user.ID}} {
+		if err := r.Roles.AssignRoleToUser(t.Context(), ar[0], ar[1]); err != nil {
+			t.Fatalf("AssignRoleToUser: %v", err)
+		}
+	}
+	got, err := r.Roles.GetRolesForUsers(t.Context(), []uint{a.ID, b.ID, c.ID})
+	if err != nil {
+		t.Fatalf("GetRolesForUsers: %v", err)
+	}
+	if len(got[a.ID]) != 2 || got[a.ID][0].Name != "admin" || got[a.ID][1].Name != "user" {
+		t.Errorf("roles of %d = %+v, want admin, user", a.ID, got[a.ID])
+	}
+	if len(got[b.ID]) != 1 || got[b.ID][0].Name != "user" {
+		t.Errorf("roles of %d = %+v, want user", b.ID, got[b.ID])
+	}
+	if len(got[c.ID]) != 0 {
+		t.Errorf("roles of %d = %+v, want none", c.ID, got[c.ID])
+	}
+	if got, err := r.Roles.GetRolesForUsers(t.Context(), nil); err != nil || len(got) != 0 {
+		t.Errorf("GetRolesForUsers(nil) = %v, %v", got, err)
+	}
+}
+
+func testRoleMembers(t *testing.T, r Repos) {
+	a := mustCreateUser(t, r, "l@example.com")
+	b := mustCreateUser(t, r, "m@example.com")
+	c := mustCreateUser(t, r, "n@example.com")
+	editor := mustCreateRole(t, r, "editor")
+	other := mustCreateRole(t, r, "other")
+	if err := r.Roles.AssignRoleToUser(t.Context(), a.ID, other.ID); err != nil {
+		t.Fatalf("AssignRoleToUser: %v", err)
+	}
+	added, removed, err := r.Roles.SetRoleMembers(t.Context(), editor.ID, []uint{a.ID, b.ID})
+	if err != nil || len(added) != 2 || len(removed) != 0 {
+		t.Fatalf("SetRoleMembers(a, b) = %v, %v, %v; want both added", added, removed, err)
+	}
+	added, removed, err = r.Roles.SetRoleMembers(t.Context(), editor.ID, []uint{b.ID, c.ID})
+	if err != nil || len(added) != 1 || added[0] != c.ID || len(removed) != 1 || removed[0] != a.ID {
+		t.Errorf("SetRoleMembers(b, c) = %v, %v, %v; want c added and a removed", added, removed, err)
+	}
+	members, err := r.Roles.GetRoleMembers(t.Context(), editor.ID)
+	if err != nil || len(members) != 2 || members[0].ID != b.ID || members[1].ID != c.ID {
+		t.Errorf("GetRoleMembers = %v, %v; want b and c", members, err)
+	}
+	if _, _, err := r.Roles.SetRoleMembers(t.Context(), editor.ID, []uint{b.ID, 9999}); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("SetRoleMembers with a missing user error = %v, want ErrNotFound", err)
+	}
+	if roles, _ := r.Roles.GetDirectUserRoles(t.Context(), a.ID); len(roles) != 1 || roles[0].ID != other.ID {
+		t.Errorf("roles of a = %+v, want only other", roles)
+	}
+
+	editor.Name = "writer"
+	if err := r.Roles.UpdateRole(t.Context(), editor); err != nil {
+		t.Fatalf("UpdateRole: %v", err)
+	}
+	if got, err := r.Roles.GetRoleByID(t.Context(), editor.ID); err != nil || got.Name != "writer" {
+		t.Errorf("GetRoleByID after rename = %v, %v; want writer", got, err)
+	}
+	if err := r.Roles.DeleteRole(t.Context(), editor.ID); err != nil {
+		t.Fatalf("DeleteRole: %v", err)
+	}
+	if err := r.Roles.DeleteRole(t.Context(), editor.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("DeleteRole twice error = %v, want ErrNotFound", err)
+	}
+	if roles, _ := r.Roles.GetUserRoles(t.Context(), b.ID); len(roles) != 0 {
+		t.Errorf("roles of b after DeleteRole = %+v, want none", roles)
+	}
+	roles, err := r.Roles.ListRoles(t.Context())
+	if err != nil || len(roles) != 1 || roles[0].ID != other.ID {
+		t.Errorf("ListRoles = %v, %v; want only other", roles, err)
+	}
+}
@@ -0,0 +1,377 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"services/user/internal/fieldcrypt"
+	"services/user/internal/models"
+)
+
+// User list sort orders. Prefix one with "-" to sort descending.
+const (
+	UserSortID        = "id"
+	UserSortCreatedAt = "created_at"
+	UserSortEmail     = "email"
+)
+
+// ErrInvalidCursor is returned by ParseUserCursor for a malformed cursor or
+// one issued for a different sort order
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ValidUserSort reports whether sort is a supported user list order.
+// Encrypted emails cannot be sorted, so the email order is only supported
+// while field encryption is disabled.
+func ValidUserSort(sort string) bool {
+	switch strings.TrimPrefix(sort, "-") {
+	case UserSortID, UserSortCreatedAt:
+		return true
+	case UserSortEmail:
+		return !fieldcrypt.Enabled()
+	}
+	return false
+}
+
+// UserFilter narrows and orders ListUsers and CountUsers. Zero values are ignored.
+type UserFilter struct {
+	// Query matches a case-insensitive substring of the email or full name,
+	// or, while field encryption is enabled, the exact email
+	Query string
+	// Role matches users holding the role directly or through a group
+	Role string
+	// ExternalID matches the identity provider ID of SCIM provisioned users
+	ExternalID   string
+	CreatedAfter time.Time
+	// Deleted lists soft-deleted users instead of live ones
+	Deleted bool
+	// Sort is one of the UserSort orders, optionally prefixed with "-"; defaults to id
+	Sort string
+	// After is a keyset cursor: only users ordered after it are returned
+	After *UserCursor
+	Limit int
+}
+
+// UserCursor is the position of the last user on a page: the sort key of
+// that user plus its ID to break ties
+type UserCursor struct {
+	Sort      string    `json:"s"`
+	Email     string    `json:"e,omitempty"`
+	CreatedAt time.Time `json:"c,omitzero"`
+	ID        uint      `json:"i"`
+}
+
+// NewUserCursor returns the opaque cursor of the page ending with u
+func NewUserCursor(sort string, u *models.User) string {
+	c := UserCursor{Sort: sort, ID: u.ID}
+	switch strings.TrimPrefix(sort, "-") {
+	case UserSortEmail:
+		c.Email = u.Email
+	case UserSortCreatedAt:
+		c.CreatedAt = u.CreatedAt
+	}
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ParseUserCursor decodes a cursor returned by NewUserCursor for sort
+func ParseUserCursor(sort, raw string) (*UserCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c UserCursor
+	if err := json.Unmarshal(b, &c); err != nil || c.Sort != sort || c.ID == 0 {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// likePattern escapes s for a LIKE ... ESCAPE '!' substring match
+func likePattern(s string) string {
+	r := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+	return "%" + r.Replace(strings.ToLower(s)) + "%"
+}
+
+// filterUsers applies the conditions of f except the cursor and limit
+func filterUsers(db *gorm.DB, f UserFilter) *gorm.DB {
+	q := db.Model(&models.User{})
+	if f.Deleted {
+		q = db.Unscoped().Model(&models.User{}).Where("users.deleted_at IS NOT NULL")
+	}
+	if f.Query != "" && fieldcrypt.Enabled() {
+		q = q.Where("users.email_index = ?", fieldcrypt.BlindIndex(strings.TrimSpace(f.Query)))
+	} else if f.Query != "" {
+		p := likePattern(f.Query)
+		q = q.Where("(LOWER(email) LIKE ? ESCAPE '!' OR LOWER(full_name) LIKE ? ESCAPE '!')", p, p)
+	}
+	if f.Role != "" {
+		direct := db.Model(&models.UserRole{}).Select("user_roles.user_id").
+			Joins("join roles on roles.id = user_roles.role_id").
+			Where("roles.name = ?", f.Role)
+		viaGroups := db.Model(&models.GroupMember{}).Select("group_members.user_id").
+			Joins("join group_roles on group_roles.group_id = group_members.group_id").
+			Joins("join roles on roles.id = group_roles.role_id").
+			Where("roles.name = ?", f.Role)
+		q = q.Where("(users.id IN (?) OR users.id IN (?))", direct, viaGroups)
+	}
+	if f.ExternalID != "" {
+		q = q.Where("users.external_id = ?", f.ExternalID)
+	}
+	if !f.CreatedAfter.IsZero() {
+		q = q.Where("users.created_at > ?", f.CreatedAfter)
+	}
+	return q
+}
+
+// ListUsers returns one page of users matching f in f.Sort order
+func (s *Store) ListUsers(ctx context.Context, f UserFilter) ([]models.User, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	q := filterUsers(db, f)
+	desc := strings.HasPrefix(f.Sort, "-")
+	cmp, dir := ">", "asc"
+	if desc {
+		cmp, dir = "<", "desc"
+	}
+	col := "users.id"
+	var key interface{}
+	switch strings.TrimPrefix(f.Sort, "-") {
+	case UserSortCreatedAt:
+		col = "users.created_at"
+		if f.After != nil {
+			key = f.After.CreatedAt
+		}
+	case UserSortEmail:
+		col = "users.email"
+		if f.After != nil {
+			key = f.After.Email
+		}
+	}
+	if f.After != nil {
+		if key == nil {
+			q = q.Where("users.id "+cmp+" ?", f.After.ID)
+		} else {
+			q = q.Where("("+col+" "+cmp+" ? OR ("+col+" = ? AND users.id "+cmp+" ?))", key, key, f.After.ID)
+		}
+	}
+	if col != "users.id" {
+		q = q.Order(col + " " + dir)
+	}
+	q = q.Order("users.id " + dir)
+	if f.Limit > 0 {
+		q = q.Limit(f.Limit)
+	}
+	var us []models.User
+	if err := q.Find(&us).Error; err != nil {
+		return nil, err
+	}
+	return us, nil
+}
+
+// CountUsers returns how many users match f, ignoring its cursor and limit
+func (s *Store) CountUsers(ctx context.Context, f UserFilter) (int64, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	var n int64
+	if err := filterUsers(db, f).Count(&n).Error; err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// RestoreUser undeletes a soft-deleted user. It returns ErrNotFound unless
+// the user exists, is deleted and was not anonymized, and
+// gorm.ErrDuplicatedKey when a live user
+// has taken the email in the meantime.
+func (s *Store) RestoreUser(ctx context.Context, id uint) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	// anonymized users have nothing left to restore
+	res := db.Unscoped().Model(&models.User{}).Where("id = ? AND deleted_at IS NOT NULL AND anonymized_at IS NULL", id).Update("deleted_at", nil)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetDeletedUser returns a soft-deleted user, or ErrNotFound
+func (s *Store) GetDeletedUser(ctx context.Context, id uint) (*models.User, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	var u models.User
+	if err := db.Unscoped().Where("deleted_at IS NOT NULL").First(&u, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &u, nil
+}
+
+// PurgeUser permanently removes a soft-deleted user with their role
+// assignments and group memberships, erases their personal data from audit
+// events and webhook deliveries, and returns the blobs they left behind.
+// Live users must be deleted first; for them, as for unknown IDs, it
+// returns ErrNotFound.
+func (s *Store) PurgeUser(ctx context.Context, id uint) (PurgedBlobs, error) {
+	n, blobs, err := s.purgeUsers(ctx, "id = ?", id)
+	if err == nil && n == 0 {
+		return PurgedBlobs{}, ErrNotFound
+	}
+	return blobs, err
+}
+
+// PurgedBlobs are the stored objects of purged users. Nothing references
+// them once the purge has committed, so the caller deletes them afterwards.
+type PurgedBlobs struct {
+	// AvatarKeys are the keys of the users' avatars, each naming one object
+	// per thumbnail size
+	AvatarKeys []string
+	// ExportKeys are the archives of their data exports
+	ExportKeys []string
+}
+
+// PurgeDeletedUsers permanently removes every user soft deleted before
+// cutoff and returns how many were removed and the blobs they left behind
+func (s *Store) PurgeDeletedUsers(ctx context.Context, cutoff time.Time) (int64, PurgedBlobs, error) {
+	var total int64
+	var blobs PurgedBlobs
+	for {
+		n, b, err := s.purgeUsers(ctx, "deleted_at < ?", cutoff)
+		total += n
+		if err == nil {
+			blobs.AvatarKeys = append(blobs.AvatarKeys, b.AvatarKeys...)
+			blobs.ExportKeys = append(blobs.ExportKeys, b.ExportKeys...)
+		}
+		if err != nil || n < purgeBatch {
+			return total, blobs, err
+		}
+	}
+}
+
+// purgeBatch bounds how many users one purge transaction removes
+const purgeBatch = 500
+
+// purgeUsers hard-deletes up to purgeBatch soft-deleted users matching cond
+// together with the rows referencing them
+func (s *Store) purgeUsers(ctx context.Context, cond string, args ...interface{}) (int64, PurgedBlobs, error) {
+	var n int64
+	var blobs PurgedBlobs
+	err := s.transaction(ctx, func(tx *Store) error {
+		db, cancel := tx.conn(ctx)
+		defer cancel()
+		blobs = PurgedBlobs{}
+		var found []models.User
+		q := db.Unscoped().Model(&models.User{}).Select("id", "avatar_key").Where("deleted_at IS NOT NULL").Where(cond, args...).Order("id").Limit(purgeBatch)
+		if err := q.Find(&found).Error; err != nil || len(found) == 0 {
+			return err
+		}
+		us := make([]uint, len(found))
+		for i, u := range found {
+			us[i] = u.ID
+			if u.AvatarKey != "" {
+				blobs.AvatarKeys = append(blobs.AvatarKeys, u.AvatarKey)
+			}
+		}
+		if err := db.Where("user_id IN ?", us).Delete(&models.UserRole{}).Error; err != nil {
+			return err
+		}
+		if err := db.Where("user_id IN ?", us).Delete(&models.GroupMember{}).Error; err != nil {
+			return err
+		}
+		if err := db.Where("user_id IN ?", us).Delete(&models.EmailChange{}).Error; err != nil {
+			return err
+		}
+		if err := db.Where("user_id IN ?", us).Delete(&models.UserSettings{}).Error; err != nil {
+			return err
+		}
+		if err := db.Model(&models.ExportJob{}).Where("user_id IN ? AND blob_key <> ''", us).Pluck("blob_key", &blobs.ExportKeys).Error; err != nil {
+			return err
+		}
+		if err := db.Where("user_id IN ?", us).Delete(&models.ExportJob{}).Error; err != nil {
+			return err
+		}
+		if err := tx.redactAuditEvents(ctx, us); err != nil {
+			return err
+		}
+		if err := tx.redactWebhookDeliveries(ctx, us); err != nil {
+			return err
+		}
+		res := db.Unscoped().Where("id IN ?", us).Delete(&models.User{})
+		n = res.RowsAffected
+		return res.Error
+	})
+	if err != nil {
+		return 0, PurgedBlobs{}, err
+	}
+	return n, blobs, nil
+}
+
+// GetRolesForUsers returns the effective roles of several users in one query,
+// keyed by user ID. Users without roles are absent from the map.
+func (s *Store) GetRolesForUsers(ctx context.Context, userIDs []uint) (map[uint][]models.Role, error) {
+	out := map[uint][]models.Role{}
+	if len(userIDs) == 0 {
+		return out, nil
+	}
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	var rows []struct {
+		UserID uint
+		RoleID uint
+		Name   string
+	}
+	err := db.Raw(`SELECT user_roles.user_id AS user_id, roles.id AS role_id, roles.name AS name
+FROM user_roles JOIN roles ON roles.id = user_roles.role_id
+WHERE user_roles.user_id IN ?
+UNION
+SELECT group_members.user_id, roles.id, roles.name
+FROM group_members
+JOIN group_roles ON group_roles.group_id = group_members.group_id
+JOIN roles ON roles.id = group_roles.role_id
+WHERE group_members.user_id IN ?
+ORDER BY user_id, role_id`, userIDs, userIDs).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		out[r.UserID] = append(out[r.UserID], models.Role{ID: r.RoleID, Name: r.Name})
+	}
+	return out, nil
+}
+
+// GetDirectRolesForUsers returns the roles assigned directly to several
+// users, leaving out those granted through groups, keyed by user ID
+func (s *Store) GetDirectRolesForUsers(ctx context.Context, userIDs []uint) (map[uint][]models.Role, error) {
+	out := map[uint][]models.Role{}
+	if len(userIDs) == 0 {
+		return out, nil
+	}
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	var rows []struct {
+		UserID uint
+		RoleID uint
+		Name   string
+	}
+	err := db.Model(&models.UserRole{}).Select("user_roles.user_id AS user_id, roles.id AS role_id, roles.name AS name").
+		Joins("JOIN roles ON roles.id = user_roles.role_id").
+		Where("user_roles.user_id IN ?", userIDs).
+		Order("user_roles.user_id, roles.id").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		out[r.UserID] = append(out[r.UserID], models.Role{ID: r.RoleID, Name: r.Name})
+	}
+	return out, nil
+}
@@ -0,0 +1,227 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"services/user/internal/fieldcrypt"
+	"services/user/internal/models"
+)
+
+// WebhookEnvelope is the JSON body POSTed to webhook subscribers
+type WebhookEnvelope struct {
+	ID        string      `json:"id"`
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+func (s *Store) CreateWebhook(ctx context.Context, wh *models.Webhook) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	return db.Create(wh).Error
+}
+
+func (s *Store) GetWebhookByID(ctx context.Context, id uint) (*models.Webhook, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	var wh models.Webhook
+	if err := db.First(&wh, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &wh, nil
+}
+
+func (s *Store) ListWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	var whs []models.Webhook
+	if err := db.Order("id").Find(&whs).Error; err != nil {
+		return nil, err
+	}
+	return whs, nil
+}
+
+func (s *Store) UpdateWebhook(ctx context.Context, wh *models.Webhook) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	return db.Save(wh).Error
+}
+
+// DeleteWebhook removes a webhook and its delivery log
+func (s *Store) DeleteWebhook(ctx context.Context, id uint) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("webhook_id = ?", id).Delete(&models.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Webhook{}, id).Error
+	})
+}
+
+// EnqueueWebhookEvent queues a delivery of event, about user userID, to
+// every active webhook subscribed to it. Call it on a transaction-bound
+// Store so the deliveries are committed together with the change they
+// describe.
+func (s *Store) EnqueueWebhookEvent(ctx context.Context, event string, userID uint, data interface{}) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	var whs []models.Webhook
+	if err := db.Where("active = ?", true).Find(&whs).Error; err != nil {
+		return err
+	}
+	var targets []models.Webhook
+	for _, wh := range whs {
+		if wh.Subscribed(event) {
+			targets = append(targets, wh)
+		}
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	env := WebhookEnvelope{ID: hex.EncodeToString(id[:]), Event: event, CreatedAt: now, Data: data}
+	payload, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	ds := make([]models.WebhookDelivery, 0, len(targets))
+	for _, wh := range targets {
+		ds = append(ds, models.WebhookDelivery{WebhookID: wh.ID, EventID: env.ID, Event: event, UserID: userID, Payload: string(payload), Status: models.DeliveryPending, NextAttemptAt: now})
+	}
+	return db.Create(&ds).Error
+}
+
+// redactWebhookDeliveries replaces the data of the deliveries about the
+// users ids with just the user's ID. The envelope is kept, so pending
+// deliveries still tell receivers which user an event was about.
+func (s *Store) redactWebhookDeliveries(ctx context.Context, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	var ds []models.WebhookDelivery
+	if err := db.Select("id", "event_id", "user_id", "payload").Where("user_id IN ?", ids).Find(&ds).Error; err != nil {
+		return err
+	}
+	// the deliveries of an event to each webhook share its payload
+	done := map[string]bool{}
+	for _, d := range ds {
+		if done[d.EventID] {
+			continue
+		}
+		done[d.EventID] = true
+		var env WebhookEnvelope
+		if err := json.Unmarshal([]byte(d.Payload), &env); err != nil {
+			return fmt.Errorf("redact webhook delivery %d: %w", d.ID, err)
+		}
+		env.Data = map[string]interface{}{"id": d.UserID}
+		payload, err := json.Marshal(env)
+		if err != nil {
+			return err
+		}
+		// a single column bypasses the encrypted serializer
+		sealed, err := fieldcrypt.Seal("payload", string(payload))
+		if err != nil {
+			return err
+		}
+		err = db.Model(&models.WebhookDelivery{}).Where("event_id = ? AND user_id = ?", d.EventID, d.UserID).UpdateColumn("payload", sealed).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// activeWebhooks selects the IDs of the webhooks that are active. Deliveries
+// to the others stay pending until they are activated again.
+const activeWebhooks = "SELECT id FROM webhooks WHERE active = ?"
+
+// DueWebhookDeliveries returns pending deliveries to active webhooks whose
+// next attempt is due. Other replicas may load the same ones, so each must
+// be claimed with ClaimWebhookDelivery before it is sent.
+func (s *Store) DueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	var ds []models.WebhookDelivery
+	err := db.Where("status = ? AND next_attempt_at <= ? AND webhook_id IN ("+activeWebhooks+")", models.DeliveryPending, now, true).
+		Order("next_attempt_at").Limit(limit).Find(&ds).Error
+	if err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+// ClaimWebhookDelivery takes the due delivery d for one attempt: it counts
+// the attempt and moves the next one to leaseUntil, so other replicas skip d
+// while it is being sent and retry it should this one die before recording
+// the outcome. Like ClaimExportJob, only whoever bumps the attempt count
+// first wins; it returns ErrNotFound when d was claimed elsewhere, is no
+// longer due or its webhook has been deactivated since d was loaded.
+func (s *Store) ClaimWebhookDelivery(ctx context.Context, d *models.WebhookDelivery, now, leaseUntil time.Time) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	res := db.Model(&models.WebhookDelivery{}).
+		Where("id = ? AND status = ? AND attempts = ? AND next_attempt_at <= ? AND webhook_id IN ("+activeWebhooks+")", d.ID, models.DeliveryPending, d.Attempts, now, true).
+		Updates(map[string]interface{}{"attempts": d.Attempts + 1, "next_attempt_at": leaseUntil})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	d.Attempts++
+	d.NextAttemptAt = leaseUntil
+	return nil
+}
+
+func (s *Store) UpdateWebhookDelivery(ctx context.Context, d *models.WebhookDelivery) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	// the payload never changes once queued, except when it is redacted
+	return db.Omit("payload").Save(d).Error
+}
+
+func (s *Store) GetWebhookDelivery(ctx context.Context, webhookID, id uint) (*models.WebhookDelivery, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	var d models.WebhookDelivery
+	if err := db.Where("webhook_id = ?", webhookID).First(&d, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &d, nil
+}
+
+// ListWebhookDeliveries returns the delivery log of a webhook, newest first
+func (s *Store) ListWebhookDeliveries(ctx context.Context, webhookID uint, status string, limit int) ([]models.WebhookDelivery, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+	q := db.Where("webhook_id = ?", webhookID)
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	var ds []models.WebhookDelivery
+	if err := q.Order("id desc").Limit(limit).Find(&ds).Error; err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
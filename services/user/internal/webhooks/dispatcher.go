@@ -0,0 +1,203 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"services/user/internal/models"
+	"services/user/internal/store"
+)
+
+// Headers sent with every delivery
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// maxResponseBody bounds how much of a receiver response is kept in the delivery log
+const maxResponseBody = 2048
+
+type Options struct {
+	PollInterval time.Duration // how often due deliveries are picked up
+	Timeout      time.Duration // per-request timeout
+	MaxAttempts  int           // attempts before a delivery is dead-lettered
+	BaseBackoff  time.Duration // delay after the first failure, doubled on every retry
+	MaxBackoff   time.Duration
+	BatchSize    int
+}
+
+// Dispatcher delivers queued webhook events with retries and exponential backoff
+type Dispatcher struct {
+	store  *store.Store
+	client *http.Client
+	opts   Options
+}
+
+func NewDispatcher(s *store.Store, opts Options) *Dispatcher {
+	if opts.PollInterval == 0 {
+		opts.PollInterval = 5 * time.Second
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.MaxAttempts == 0 {
+		opts.MaxAttempts = 8
+	}
+	if opts.BaseBackoff == 0 {
+		opts.BaseBackoff = 10 * time.Second
+	}
+	if opts.MaxBackoff == 0 {
+		opts.MaxBackoff = time.Hour
+	}
+	if opts.BatchSize == 0 {
+		opts.BatchSize = 50
+	}
+	return &Dispatcher{store: s, client: &http.Client{Timeout: opts.Timeout}, opts: opts}
+}
+
+// Sign returns the signature header value for a payload sent at ts
+func Sign(secret string, ts int64, payload []byte) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(m, "%d.", ts)
+	m.Write(payload)
+	return "sha256=" + hex.EncodeToString(m.Sum(nil))
+}
+
+// Verify checks a signature header produced by Sign; receivers can use it
+func Verify(secret string, ts int64, payload []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, ts, payload)), []byte(signature))
+}
+
+// Run polls for due deliveries until ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	log.Printf("webhook dispatcher started: poll=%s, maxAttempts=%d", d.opts.PollInterval, d.opts.MaxAttempts)
+	t := time.NewTicker(d.opts.PollInterval)
+	defer t.Stop()
+	for {
+		d.RunOnce(ctx)
+		select {
+		case <-ctx.Done():
+			log.Printf("webhook dispatcher shutting down")
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// RunOnce attempts every delivery that is currently due and not claimed by
+// another replica
+func (d *Dispatcher) RunOnce(ctx context.Context) {
+	due, err := d.store.DueWebhookDeliveries(ctx, time.Now().UTC(), d.opts.BatchSize)
+	if err != nil {
+		log.Printf("webhook dispatcher: failed to load due deliveries: %v", err)
+		return
+	}
+	hooks := map[uint]*models.Webhook{}
+	for i := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		dl := &due[i]
+		wh, ok := hooks[dl.WebhookID]
+		if !ok {
+			if wh, err = d.store.GetWebhookByID(ctx, dl.WebhookID); err != nil {
+				log.Printf("webhook dispatcher: webhook %d for delivery %d: %v", dl.WebhookID, dl.ID, err)
+				continue
+			}
+			hooks[dl.WebhookID] = wh
+		}
+		// claimed right before sending, as the batch may take a while and the
+		// webhook may be deactivated meanwhile; the lease outlasts the
+		// request, after which a crashed attempt is retried
+		now := time.Now().UTC()
+		if err := d.store.ClaimWebhookDelivery(ctx, dl, now, now.Add(2*d.opts.Timeout)); err != nil {
+			if !errors.Is(err, store.ErrNotFound) {
+				log.Printf("webhook dispatcher: failed to claim delivery %d: %v", dl.ID, err)
+			}
+			continue
+		}
+		d.attempt(ctx, wh, dl)
+	}
+}
+
+// attempt sends one claimed delivery and records the outcome
+func (d *Dispatcher) attempt(ctx context.Context, wh *models.Webhook, dl *models.WebhookDelivery) {
+	now := time.Now().UTC()
+	dl.LastAttemptAt = &now
+	dl.ResponseStatus = 0
+	dl.ResponseBody = ""
+	dl.LastError = ""
+
+	status, body, err := d.send(ctx, wh, dl, now)
+	dl.ResponseStatus = status
+	dl.ResponseBody = body
+	switch {
+	case err != nil:
+		dl.LastError = err.Error()
+	case status < 200 || status > 299:
+		dl.LastError = "unexpected status " + strconv.Itoa(status)
+	}
+	switch {
+	case dl.LastError == "":
+		dl.Status = models.DeliveryDelivered
+		log.Printf("webhook delivered: webhook=%d, delivery=%d, event=%s, status=%d", wh.ID, dl.ID, dl.Event, status)
+	case dl.Attempts >= d.opts.MaxAttempts:
+		dl.Status = models.DeliveryDead
+		log.Printf("webhook dead-lettered: webhook=%d, delivery=%d, event=%s, attempts=%d, err=%s", wh.ID, dl.ID, dl.Event, dl.Attempts, dl.LastError)
+	default:
+		dl.NextAttemptAt = now.Add(d.backoff(dl.Attempts))
+		log.Printf("webhook delivery failed: webhook=%d, delivery=%d, event=%s, attempt=%d, retryAt=%s, err=%s", wh.ID, dl.ID, dl.Event, dl.Attempts, dl.NextAttemptAt.Format(time.RFC3339), dl.LastError)
+	}
+	// record the outcome even if the dispatcher is shutting down
+	if err := d.store.UpdateWebhookDelivery(context.WithoutCancel(ctx), dl); err != nil {
+		log.Printf("webhook dispatcher: failed to save delivery %d: %v", dl.ID, err)
+	}
+}
+
+func (d *Dispatcher) send(ctx context.Context, wh *models.Webhook, dl *models.WebhookDelivery, now time.Time) (int, string, error) {
+	payload := []byte(dl.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, "", err
+	}
+	ts := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "user-service-webhooks/1")
+	req.Header.Set(HeaderEvent, dl.Event)
+	req.Header.Set(HeaderDelivery, strconv.FormatUint(uint64(dl.ID), 10))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(ts, 10))
+	req.Header.Set(HeaderSignature, Sign(wh.Secret, ts, payload))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	return resp.StatusCode, string(body), nil
+}
+
+// backoff returns the delay before the next attempt: BaseBackoff doubled per
+// failed attempt, capped at MaxBackoff, with up to 10% jitter
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.opts.BaseBackoff
+	for i := 1; i < attempts && delay < d.opts.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.opts.MaxBackoff {
+		delay = d.opts.MaxBackoff
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/10+1))
+}
@@ -0,0 +1,213 @@
+package webhooks_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"services/user/internal"
+	"services/user/internal/migrations"
+	"services/user/internal/models"
+	"services/user/internal/store"
+	"services/user/internal/webhooks"
+)
+
+// receiver is a webhook endpoint that records the requests it gets and
+// answers them with the given statuses in turn, repeating the last one
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	rc := &receiver{statuses: statuses}
+	rc.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rc.mu.Lock()
+		rc.requests = append(rc.requests, r)
+		rc.bodies = append(rc.bodies, body)
+		status := rc.statuses[0]
+		if len(rc.statuses) > 1 {
+			rc.statuses = rc.statuses[1:]
+		}
+		rc.mu.Unlock()
+		w.WriteHeader(status)
+		w.Write([]byte("status " + strconv.Itoa(status)))
+	}))
+	t.Cleanup(rc.Close)
+	return rc
+}
+
+func (rc *receiver) received() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return len(rc.requests)
+}
+
+// setup returns a store on a fresh SQLite database with a webhook
+// subscribed to all events at url and one event queued for it
+func setup(t *testing.T, url string) (*store.Store, *models.Webhook) {
+	t.Helper()
+	ctx := context.Background()
+	db, err := internal.Connect(&internal.Config{DBDriver: "sqlite", DBPath: filepath.Join(t.TempDir(), "user.db")})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	m, err := migrations.New(db)
+	if err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+	if _, err := m.Up(); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	s := store.NewStore(db, 5*time.Second)
+	wh := &models.Webhook{URL: url, Secret: "s3cret", Active: true}
+	if err := s.CreateWebhook(ctx, wh); err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	if err := s.EnqueueWebhookEvent(ctx, models.EventUserCreated, 1, map[string]interface{}{"id": 1}); err != nil {
+		t.Fatalf("EnqueueWebhookEvent: %v", err)
+	}
+	return s, wh
+}
+
+// delivery returns the only delivery to wh
+func delivery(t *testing.T, s *store.Store, wh *models.Webhook) *models.WebhookDelivery {
+	t.Helper()
+	ds, err := s.ListWebhookDeliveries(context.Background(), wh.ID, "", 10)
+	if err != nil || len(ds) != 1 {
+		t.Fatalf("ListWebhookDeliveries = %d deliveries, %v; want 1", len(ds), err)
+	}
+	return &ds[0]
+}
+
+func TestDispatcherSignsDeliveries(t *testing.T) {
+	rc := newReceiver(t, http.StatusNoContent)
+	s, wh := setup(t, rc.URL)
+	webhooks.NewDispatcher(s, webhooks.Options{}).RunOnce(context.Background())
+
+	if rc.received() != 1 {
+		t.Fatalf("receiver got %d requests; want 1", rc.received())
+	}
+	d := delivery(t, s, wh)
+	r, body := rc.requests[0], rc.bodies[0]
+	ts, err := strconv.ParseInt(r.Header.Get(webhooks.HeaderTimestamp), 10, 64)
+	if err != nil {
+		t.Fatalf("%s = %q: %v", webhooks.HeaderTimestamp, r.Header.Get(webhooks.HeaderTimestamp), err)
+	}
+	if sig := r.Header.Get(webhooks.HeaderSignature); !webhooks.Verify(wh.Secret, ts, body, sig) {
+		t.Errorf("%s = %q does not verify with the webhook's secret", webhooks.HeaderSignature, sig)
+	}
+	if webhooks.Verify("other", ts, body, r.Header.Get(webhooks.HeaderSignature)) {
+		t.Errorf("signature verifies with another secret")
+	}
+	if got := r.Header.Get(webhooks.HeaderEvent); got != models.EventUserCreated {
+		t.Errorf("%s = %q; want %q", webhooks.HeaderEvent, got, models.EventUserCreated)
+	}
+	if got := r.Header.Get(webhooks.HeaderDelivery); got != strconv.FormatUint(uint64(d.ID), 10) {
+		t.Errorf("%s = %q; want %d", webhooks.HeaderDelivery, got, d.ID)
+	}
+	if string(body) != d.Payload {
+		t.Errorf("body = %s; want the payload %s", body, d.Payload)
+	}
+	if d.Status != models.DeliveryDelivered || d.Attempts != 1 || d.ResponseStatus != http.StatusNoContent || d.LastError != "" {
+		t.Errorf("delivery = %+v; want delivered after 1 attempt", d)
+	}
+}
+
+func TestDispatcherRetriesWithBackoffAndDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	rc := newReceiver(t, http.StatusInternalServerError)
+	s, wh := setup(t, rc.URL)
+	disp := webhooks.NewDispatcher(s, webhooks.Options{MaxAttempts: 4, BaseBackoff: time.Minute, MaxBackoff: 3 * time.Minute})
+
+	for i, want := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute} {
+		disp.RunOnce(ctx)
+		d := delivery(t, s, wh)
+		if d.Status != models.DeliveryPending || d.Attempts != i+1 || d.ResponseStatus != http.StatusInternalServerError || d.LastError != "unexpected status 500" {
+			t.Fatalf("delivery after attempt %d = %+v; want pending with the failure recorded", i+1, d)
+		}
+		// up to 10% jitter is added to the delay
+		if delay := d.NextAttemptAt.Sub(*d.LastAttemptAt); delay < want || delay > want+want/10 {
+			t.Errorf("delay after attempt %d = %s; want %s plus jitter", i+1, delay, want)
+		}
+		// not due again until then
+		disp.RunOnce(ctx)
+		if rc.received() != i+1 {
+			t.Fatalf("receiver got %d requests before the retry was due; want %d", rc.received(), i+1)
+		}
+		d.NextAttemptAt = time.Now().UTC().Add(-time.Second)
+		if err := s.UpdateWebhookDelivery(ctx, d); err != nil {
+			t.Fatalf("UpdateWebhookDelivery: %v", err)
+		}
+	}
+
+	disp.RunOnce(ctx)
+	if d := delivery(t, s, wh); d.Status != models.DeliveryDead || d.Attempts != 4 {
+		t.Errorf("delivery after the last attempt = %+v; want dead after 4 attempts", d)
+	}
+	disp.RunOnce(ctx)
+	if rc.received() != 4 {
+		t.Errorf("receiver got %d requests; want 4, none after dead-lettering", rc.received())
+	}
+}
+
+func TestDispatcherRetrySucceeds(t *testing.T) {
+	ctx := context.Background()
+	rc := newReceiver(t, http.StatusServiceUnavailable, http.StatusOK)
+	s, wh := setup(t, rc.URL)
+	disp := webhooks.NewDispatcher(s, webhooks.Options{BaseBackoff: time.Millisecond})
+
+	disp.RunOnce(ctx)
+	time.Sleep(5 * time.Millisecond)
+	disp.RunOnce(ctx)
+	if d := delivery(t, s, wh); d.Status != models.DeliveryDelivered || d.Attempts != 2 || d.ResponseStatus != http.StatusOK || d.LastError != "" {
+		t.Errorf("delivery = %+v; want delivered on the second attempt", d)
+	}
+}
+
+func TestDispatcherHoldsBackInactiveWebhooks(t *testing.T) {
+	ctx := context.Background()
+	rc := newReceiver(t, http.StatusOK)
+	s, wh := setup(t, rc.URL)
+	disp := webhooks.NewDispatcher(s, webhooks.Options{})
+
+	wh.Active = false
+	if err := s.UpdateWebhook(ctx, wh); err != nil {
+		t.Fatalf("UpdateWebhook: %v", err)
+	}
+	disp.RunOnce(ctx)
+	if d := delivery(t, s, wh); rc.received() != 0 || d.Status != models.DeliveryPending || d.Attempts != 0 {
+		t.Fatalf("after deactivating, receiver got %d requests and delivery = %+v; want it held back", rc.received(), d)
+	}
+
+	// deactivated after the delivery was loaded, before it is claimed
+	d := delivery(t, s, wh)
+	if err := s.ClaimWebhookDelivery(ctx, d, time.Now().UTC(), time.Now().UTC().Add(time.Minute)); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("ClaimWebhookDelivery of an inactive webhook = %v; want ErrNotFound", err)
+	}
+
+	wh.Active = true
+	if err := s.UpdateWebhook(ctx, wh); err != nil {
+		t.Fatalf("UpdateWebhook: %v", err)
+	}
+	disp.RunOnce(ctx)
+	if d := delivery(t, s, wh); rc.received() != 1 || d.Status != models.DeliveryDelivered {
+		t.Errorf("after reactivating, receiver got %d requests and delivery = %+v; want it delivered", rc.received(), d)
+	}
+}
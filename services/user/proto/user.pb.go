@@ -22,11 +22,14 @@ const (
 )
 
 type User struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Id            uint64                 `protobuf:"varint,1,opt,name=Id,proto3" json:"Id,omitempty"`
-	Email         string                 `protobuf:"bytes,2,opt,name=Email,proto3" json:"Email,omitempty"`
-	FullName      string                 `protobuf:"bytes,3,opt,name=FullName,proto3" json:"FullName,omitempty"`
-	Roles         []string               `protobuf:"bytes,4,rep,name=Roles,proto3" json:"Roles,omitempty"`
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Id       uint64                 `protobuf:"varint,1,opt,name=Id,proto3" json:"Id,omitempty"`
+	Email    string                 `protobuf:"bytes,2,opt,name=Email,proto3" json:"Email,omitempty"`
+	FullName string                 `protobuf:"bytes,3,opt,name=FullName,proto3" json:"FullName,omitempty"`
+	Roles    []string               `protobuf:"bytes,4,rep,name=Roles,proto3" json:"Roles,omitempty"`
+	// Version increases on every update; send it back as If-Match to update the user
+	Version       uint64   `protobuf:"varint,5,opt,name=Version,proto3" json:"Version,omitempty"`
+	Profile       *Profile `protobuf:"bytes,6,opt,name=Profile,proto3" json:"Profile,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -89,6 +92,118 @@ func (x *User) GetRoles() []string {
 	return nil
 }
 
+func (x *User) GetVersion() uint64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *User) GetProfile() *Profile {
+	if x != nil {
+		return x.Profile
+	}
+	return nil
+}
+
+// Profile is what the apps show about a user; empty fields are unset
+type Profile struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	DisplayName string                 `protobuf:"bytes,1,opt,name=DisplayName,proto3" json:"DisplayName,omitempty"`
+	AvatarUrl   string                 `protobuf:"bytes,2,opt,name=AvatarUrl,proto3" json:"AvatarUrl,omitempty"`
+	// Plan is free, standard or premium
+	Plan string `protobuf:"bytes,3,opt,name=Plan,proto3" json:"Plan,omitempty"`
+	// Locale is a BCP 47 language tag
+	Locale string `protobuf:"bytes,4,opt,name=Locale,proto3" json:"Locale,omitempty"`
+	// Timezone is an IANA time zone name
+	Timezone string `protobuf:"bytes,5,opt,name=Timezone,proto3" json:"Timezone,omitempty"`
+	// Phone is in E.164 format
+	Phone string `protobuf:"bytes,6,opt,name=Phone,proto3" json:"Phone,omitempty"`
+	// Birthday is a date formatted as 2006-01-02
+	Birthday      string `protobuf:"bytes,7,opt,name=Birthday,proto3" json:"Birthday,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Profile) Reset() {
+	*x = Profile{}
+	mi := &file_user_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Profile) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Profile) ProtoMessage() {}
+
+func (x *Profile) ProtoReflect() protoreflect.Message {
+	mi := &file_user_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Profile.ProtoReflect.Descriptor instead.
+func (*Profile) Descriptor() ([]byte, []int) {
+	return file_user_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Profile) GetDisplayName() string {
+	if x != nil {
+		return x.DisplayName
+	}
+	return ""
+}
+
+func (x *Profile) GetAvatarUrl() string {
+	if x != nil {
+		return x.AvatarUrl
+	}
+	return ""
+}
+
+func (x *Profile) GetPlan() string {
+	if x != nil {
+		return x.Plan
+	}
+	return ""
+}
+
+func (x *Profile) GetLocale() string {
+	if x != nil {
+		return x.Locale
+	}
+	return ""
+}
+
+func (x *Profile) GetTimezone() string {
+	if x != nil {
+		return x.Timezone
+	}
+	return ""
+}
+
+func (x *Profile) GetPhone() string {
+	if x != nil {
+		return x.Phone
+	}
+	return ""
+}
+
+func (x *Profile) GetBirthday() string {
+	if x != nil {
+		return x.Birthday
+	}
+	return ""
+}
+
 type CreateUserRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Email         string                 `protobuf:"bytes,1,opt,name=Email,proto3" json:"Email,omitempty"`
@@ -100,7 +215,7 @@ type CreateUserRequest struct {
 
 func (x *CreateUserRequest) Reset() {
 	*x = CreateUserRequest{}
-	mi := &file_user_proto_msgTypes[1]
+	mi := &file_user_proto_msgTypes[2]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -112,7 +227,7 @@ func (x *CreateUserRequest) String() string {
 func (*CreateUserRequest) ProtoMessage() {}
 
 func (x *CreateUserRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_user_proto_msgTypes[1]
+	mi := &file_user_proto_msgTypes[2]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -125,7 +240,7 @@ func (x *CreateUserRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use CreateUserRequest.ProtoReflect.Descriptor instead.
 func (*CreateUserRequest) Descriptor() ([]byte, []int) {
-	return file_user_proto_rawDescGZIP(), []int{1}
+	return file_user_proto_rawDescGZIP(), []int{2}
 }
 
 func (x *CreateUserRequest) GetEmail() string {
@@ -159,7 +274,7 @@ type LoginRequest struct {
 
 func (x *LoginRequest) Reset() {
 	*x = LoginRequest{}
-	mi := &file_user_proto_msgTypes[2]
+	mi := &file_user_proto_msgTypes[3]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -171,7 +286,7 @@ func (x *LoginRequest) String() string {
 func (*LoginRequest) ProtoMessage() {}
 
 func (x *LoginRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_user_proto_msgTypes[2]
+	mi := &file_user_proto_msgTypes[3]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -184,7 +299,7 @@ func (x *LoginRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use LoginRequest.ProtoReflect.Descriptor instead.
 func (*LoginRequest) Descriptor() ([]byte, []int) {
-	return file_user_proto_rawDescGZIP(), []int{2}
+	return file_user_proto_rawDescGZIP(), []int{3}
 }
 
 func (x *LoginRequest) GetEmail() string {
@@ -211,7 +326,7 @@ type LoginResponse struct {
 
 func (x *LoginResponse) Reset() {
 	*x = LoginResponse{}
-	mi := &file_user_proto_msgTypes[3]
+	mi := &file_user_proto_msgTypes[4]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -223,7 +338,7 @@ func (x *LoginResponse) String() string {
 func (*LoginResponse) ProtoMessage() {}
 
 func (x *LoginResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_user_proto_msgTypes[3]
+	mi := &file_user_proto_msgTypes[4]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -236,7 +351,7 @@ func (x *LoginResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use LoginResponse.ProtoReflect.Descriptor instead.
 func (*LoginResponse) Descriptor() ([]byte, []int) {
-	return file_user_proto_rawDescGZIP(), []int{3}
+	return file_user_proto_rawDescGZIP(), []int{4}
 }
 
 func (x *LoginResponse) GetToken() string {
@@ -258,12 +373,22 @@ var File_user_proto protoreflect.FileDescriptor
 const file_user_proto_rawDesc = "" +
 	"\n" +
 	"\n" +
-	"user.proto\x12\x04user\"^\n" +
+	"user.proto\x12\x04user\"\xa1\x01\n" +
 	"\x04User\x12\x0e\n" +
 	"\x02Id\x18\x01 \x01(\x04R\x02Id\x12\x14\n" +
 	"\x05Email\x18\x02 \x01(\tR\x05Email\x12\x1a\n" +
 	"\bFullName\x18\x03 \x01(\tR\bFullName\x12\x14\n" +
-	"\x05Roles\x18\x04 \x03(\tR\x05Roles\"a\n" +
+	"\x05Roles\x18\x04 \x03(\tR\x05Roles\x12\x18\n" +
+	"\aVersion\x18\x05 \x01(\x04R\aVersion\x12'\n" +
+	"\aProfile\x18\x06 \x01(\v2\r.user.ProfileR\aProfile\"\xc3\x01\n" +
+	"\aProfile\x12 \n" +
+	"\vDisplayName\x18\x01 \x01(\tR\vDisplayName\x12\x1c\n" +
+	"\tAvatarUrl\x18\x02 \x01(\tR\tAvatarUrl\x12\x12\n" +
+	"\x04Plan\x18\x03 \x01(\tR\x04Plan\x12\x16\n" +
+	"\x06Locale\x18\x04 \x01(\tR\x06Locale\x12\x1a\n" +
+	"\bTimezone\x18\x05 \x01(\tR\bTimezone\x12\x14\n" +
+	"\x05Phone\x18\x06 \x01(\tR\x05Phone\x12\x1a\n" +
+	"\bBirthday\x18\a \x01(\tR\bBirthday\"a\n" +
 	"\x11CreateUserRequest\x12\x14\n" +
 	"\x05Email\x18\x01 \x01(\tR\x05Email\x12\x1a\n" +
 	"\bPassword\x18\x02 \x01(\tR\bPassword\x12\x1a\n" +
@@ -293,24 +418,26 @@ func file_user_proto_rawDescGZIP() []byte {
 	return file_user_proto_rawDescData
 }
 
-var file_user_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_user_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
 var file_user_proto_goTypes = []any{
 	(*User)(nil),              // 0: user.User
-	(*CreateUserRequest)(nil), // 1: user.CreateUserRequest
-	(*LoginRequest)(nil),      // 2: user.LoginRequest
-	(*LoginResponse)(nil),     // 3: user.LoginResponse
+	(*Profile)(nil),           // 1: user.Profile
+	(*CreateUserRequest)(nil), // 2: user.CreateUserRequest
+	(*LoginRequest)(nil),      // 3: user.LoginRequest
+	(*LoginResponse)(nil),     // 4: user.LoginResponse
 }
 var file_user_proto_depIdxs = []int32{
-	0, // 0: user.LoginResponse.User:type_name -> user.User
-	1, // 1: user.UserService.CreateUser:input_type -> user.CreateUserRequest
-	2, // 2: user.UserService.Login:input_type -> user.LoginRequest
-	0, // 3: user.UserService.CreateUser:output_type -> user.User
-	3, // 4: user.UserService.Login:output_type -> user.LoginResponse
-	3, // [3:5] is the sub-list for method output_type
-	1, // [1:3] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	1, // 0: user.User.Profile:type_name -> user.Profile
+	0, // 1: user.LoginResponse.User:type_name -> user.User
+	2, // 2: user.UserService.CreateUser:input_type -> user.CreateUserRequest
+	3, // 3: user.UserService.Login:input_type -> user.LoginRequest
+	0, // 4: user.UserService.CreateUser:output_type -> user.User
+	4, // 5: user.UserService.Login:output_type -> user.LoginResponse
+	4, // [4:6] is the sub-list for method output_type
+	2, // [2:4] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
 }
 
 func init() { file_user_proto_init() }
@@ -324,7 +451,7 @@ func file_user_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_user_proto_rawDesc), len(file_user_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   4,
+			NumMessages:   5,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -10,4 +10,4 @@ export USER_LISTEN_ADDR=${USER_LISTEN_ADDR:-:8443}
 
 mkdir -p data
 
-go run ./cmd/user-service
+go run -tags sqlite_fts5 ./cmd/user-service